@@ -0,0 +1,48 @@
+// Command mock-oidc runs a local OpenID Connect provider for trying out
+// OIDC login without an Okta or Keycloak tenant. Every sign-in succeeds as
+// the user given by the flags. Point shipit at it with:
+//
+//	OIDC_PROVIDERS=mock
+//	OIDC_MOCK_ISSUER=http://localhost:9999
+//	OIDC_MOCK_CLIENT_ID=shipit
+//	OIDC_MOCK_CLIENT_SECRET=secret
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/vigneshsubbiah/shipit/internal/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9999", "listen address")
+	clientID := flag.String("client-id", "shipit", "OAuth client ID")
+	clientSecret := flag.String("client-secret", "secret", "OAuth client secret")
+	email := flag.String("email", "dev@example.com", "email of the signed-in user")
+	name := flag.String("name", "Dev User", "name of the signed-in user")
+	groups := flag.String("groups", "", "comma-separated groups claim")
+	flag.Parse()
+
+	issuer := "http://" + *addr
+	server, err := oidctest.NewServer(issuer, *clientID, *clientSecret)
+	if err != nil {
+		log.Fatalf("Failed to create mock provider: %v", err)
+	}
+
+	claims := oidctest.Claims{
+		"sub":            *email,
+		"email":          *email,
+		"email_verified": true,
+		"name":           *name,
+	}
+	if *groups != "" {
+		claims["groups"] = strings.Split(*groups, ",")
+	}
+	server.SetUser(claims)
+
+	log.Printf("Mock OIDC provider at %s (client %s), signing in as %s", issuer, *clientID, *email)
+	log.Fatal(http.ListenAndServe(*addr, server.Handler()))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/vigneshsubbiah/shipit/internal/auth"
+	"github.com/vigneshsubbiah/shipit/internal/config"
+	"github.com/vigneshsubbiah/shipit/internal/db"
+)
+
+func runCommand(cfg *config.Config, keys *auth.Keyring, name string, args []string) {
+	switch name {
+	case "rotate-keys":
+		rotateKeys(cfg, keys, args)
+	case "fingerprint-key":
+		fingerprintKey(cfg)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\nCommands:\n", name)
+		fmt.Fprintln(os.Stderr, "  rotate-keys      re-encrypt stored kubeconfigs, secrets and notification credentials with the primary key")
+		fmt.Fprintln(os.Stderr, "  fingerprint-key  print the ENCRYPT_FINGERPRINT_KEY to set before retiring ENCRYPT_KEY")
+		os.Exit(2)
+	}
+}
+
+// rotateKeys moves every encrypted value to the primary KEK, a batch at a
+// time. Values already under it are left alone, so it can be re-run after
+// an interruption. Once it reports no failures, old KEKs (and ENCRYPT_KEY)
+// can be removed.
+func rotateKeys(cfg *config.Config, keys *auth.Keyring, args []string) {
+	flags := flag.NewFlagSet("rotate-keys", flag.ExitOnError)
+	batchSize := flags.Int("batch-size", 100, "rows read and updated per query")
+	dryRun := flags.Bool("dry-run", false, "report what would be re-encrypted without writing")
+	flags.Parse(args)
+	if *batchSize <= 0 {
+		log.Fatal("rotate-keys: -batch-size must be positive")
+	}
+
+	database, err := db.Connect(cfg.DatabaseURL)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.Close()
+
+	ctx := context.Background()
+	log.Printf("rotate-keys: re-encrypting with key %q", keys.PrimaryKeyID())
+
+	var failed int
+	for _, col := range db.EncryptedColumns {
+		var scanned, rotated, current, changed int
+		var afterID string
+		for {
+			values, err := database.ListEncryptedValues(ctx, col, afterID, *batchSize)
+			if err != nil {
+				log.Fatalf("rotate-keys: reading %s: %v", col, err)
+			}
+			for _, v := range values {
+				scanned++
+				rewrapped, ok, err := keys.Rewrap(v.Value)
+				if err != nil {
+					log.Printf("rotate-keys: %s %s: %v", col, v.ID, err)
+					failed++
+					continue
+				}
+				if !ok {
+					current++
+					continue
+				}
+				if *dryRun {
+					rotated++
+					continue
+				}
+				replaced, err := database.ReplaceEncryptedValue(ctx, col, v.ID, v.Value, rewrapped)
+				switch {
+				case err != nil:
+					log.Printf("rotate-keys: updating %s %s: %v", col, v.ID, err)
+					failed++
+				case replaced:
+					rotated++
+				default:
+					// Rewritten since it was read, so already under the primary key
+					changed++
+				}
+			}
+			if len(values) < *batchSize {
+				break
+			}
+			afterID = values[len(values)-1].ID
+		}
+		verb := "re-encrypted"
+		if *dryRun {
+			verb = "to re-encrypt"
+		}
+		log.Printf("rotate-keys: %s: %d values, %d %s, %d already current, %d changed concurrently", col, scanned, rotated, verb, current, changed)
+	}
+
+	if failed > 0 {
+		log.Fatalf("rotate-keys: %d values could not be decrypted or updated; keep the old keys until they are fixed", failed)
+	}
+	if cfg.EncryptKey != "" && cfg.EncryptFingerprintKey == "" {
+		log.Println("rotate-keys: ENCRYPT_KEY still derives secret fingerprints; before removing it set ENCRYPT_FINGERPRINT_KEY (see shipit-server fingerprint-key)")
+	}
+}
+
+// fingerprintKey prints the secret fingerprint key in effect, so it can be
+// pinned with ENCRYPT_FINGERPRINT_KEY before ENCRYPT_KEY is retired.
+func fingerprintKey(cfg *config.Config) {
+	if cfg.EncryptFingerprintKey != "" {
+		fmt.Println(cfg.EncryptFingerprintKey)
+		return
+	}
+	fmt.Println(hex.EncodeToString(auth.FingerprintKey(cfg.EncryptKey)))
+}
@@ -14,18 +14,33 @@ import (
 	"github.com/vigneshsubbiah/shipit/internal/auth"
 	"github.com/vigneshsubbiah/shipit/internal/config"
 	"github.com/vigneshsubbiah/shipit/internal/db"
+	"github.com/vigneshsubbiah/shipit/internal/logarchive"
+	"github.com/vigneshsubbiah/shipit/internal/notify"
 	"github.com/vigneshsubbiah/shipit/internal/porter"
+	"github.com/vigneshsubbiah/shipit/internal/registry"
+	"github.com/vigneshsubbiah/shipit/internal/secretref"
 )
 
 func main() {
 	cfg := config.Load()
 
-	// Validate encryption key
-	if cfg.EncryptKey == "" {
-		log.Println("WARNING: ENCRYPT_KEY not set. Generating a temporary key...")
-		key, _ := auth.GenerateKey()
-		cfg.EncryptKey = key
-		log.Printf("Generated key (save this!): %s", key)
+	// Refuse to start without a key: one made up here would leave
+	// everything encrypted with it unreadable after a restart.
+	keys, err := auth.LoadKeyring(cfg)
+	if err != nil {
+		log.Fatalf("Encryption keys: %v", err)
+	}
+
+	// Token IP allowlists depend on which proxies' X-Forwarded-For is
+	// believed; don't start with an entry that would be silently ignored.
+	if err := auth.ValidateAllowlist(cfg.TrustedProxies); err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
+	}
+
+	// Maintenance commands (shipit-server rotate-keys, ...)
+	if len(os.Args) > 1 {
+		runCommand(cfg, keys, os.Args[1], os.Args[2:])
+		return
 	}
 
 	// Connect to database
@@ -37,6 +52,23 @@ func main() {
 
 	log.Println("Connected to database")
 
+	// A project without members is only reachable by platform admins.
+	if len(cfg.AdminEmails) == 0 {
+		if n, err := database.CountProjectsWithoutMembers(context.Background()); err == nil && n > 0 {
+			log.Printf("Warning: %d projects have no members and ADMIN_EMAILS is empty, so nobody can reach them; set ADMIN_EMAILS to add members", n)
+		}
+	}
+
+	// Legacy api_tokens can't be scoped; they are refused unless
+	// LEGACY_API_TOKENS opts back in.
+	if n, err := database.CountAPITokens(context.Background()); err == nil && n > 0 {
+		if cfg.LegacyAPITokens {
+			log.Printf("Warning: LEGACY_API_TOKENS is set: %d legacy API tokens act as platform admins; replace them with scoped user tokens", n)
+		} else {
+			log.Printf("Warning: %d legacy API tokens are refused; replace them with scoped user tokens, or set LEGACY_API_TOKENS=true meanwhile", n)
+		}
+	}
+
 	// Create Porter discovery service
 	porterDiscovery := porter.NewDiscoveryService(database)
 
@@ -46,7 +78,7 @@ func main() {
 		log.Printf("Warning: Failed to load clusters for Porter discovery: %v", err)
 	} else {
 		for _, cluster := range clusters {
-			kubeconfig, err := auth.Decrypt(cluster.KubeconfigEncrypted, cfg.EncryptKey)
+			kubeconfig, err := keys.Decrypt(cluster.KubeconfigEncrypted)
 			if err != nil {
 				log.Printf("Warning: Failed to decrypt kubeconfig for cluster %s: %v", cluster.Name, err)
 				continue
@@ -60,8 +92,46 @@ func main() {
 	discoveryCtx, discoveryCancel := context.WithCancel(context.Background())
 	go porterDiscovery.Start(discoveryCtx)
 
-	// Create router with Porter discovery service
-	router := api.NewRouter(database, cfg, porterDiscovery)
+	// Registry credentials for resolving image tags to digests. Without
+	// them deploys still resolve public images and anything the cluster's
+	// imagePullSecrets cover.
+	registryAuth, err := registry.LoadDockerConfig(cfg.RegistryAuthFile)
+	if err != nil {
+		log.Printf("Warning: Failed to load REGISTRY_AUTH_FILE: %v", err)
+	}
+
+	// Archive for app logs; off unless LOG_ARCHIVE_URL is set.
+	logArchive, err := logarchive.FromConfig(cfg)
+	if err != nil {
+		log.Fatalf("Log archive: %v", err)
+	}
+
+	// Deploy notifications; webhooks can't reach internal addresses unless
+	// WEBHOOK_ALLOWED_NETWORKS lists them.
+	notifier, err := notify.FromConfig(cfg)
+	if err != nil {
+		log.Fatalf("Notifications: %v", err)
+	}
+
+	// Create API handler and start the deploy queue workers. Jobs queued
+	// before a restart are still in deploy_jobs and get picked up here.
+	handler := api.NewHandler(database, keys, cfg.AppBaseDomain, cfg.GitHubWebhookSecret, registryAuth, porterDiscovery, secretref.FromConfig(cfg), logArchive, notifier)
+	workersCtx, workersCancel := context.WithCancel(context.Background())
+	go handler.RunDeployWorkers(workersCtx, cfg.DeployWorkers)
+
+	// Settle apps a previous process left mid-deploy (verifying,
+	// rolling_back, ...) against live cluster state, then keep checking.
+	go handler.RunDeployReconciler(workersCtx)
+
+	// Expire audit log entries past the retention window.
+	go handler.RunAuditSweeper(workersCtx, time.Duration(cfg.AuditRetentionDays)*24*time.Hour)
+
+	// Archive app logs (one replica per cluster) and expire old chunks.
+	go handler.RunLogCollectors(workersCtx)
+	go handler.RunLogArchiveSweeper(workersCtx)
+
+	// Create router
+	router := api.NewRouter(handler, database, cfg)
 
 	// Create server
 	server := &http.Server{
@@ -87,6 +157,9 @@ func main() {
 
 	log.Println("Shutting down server...")
 
+	// Stop claiming new deploy jobs
+	workersCancel()
+
 	// Stop Porter discovery service
 	discoveryCancel()
 	porterDiscovery.Stop()
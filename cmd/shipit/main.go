@@ -3,20 +3,28 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/spf13/cobra"
+	"github.com/vigneshsubbiah/shipit/internal/secretexport"
 	"golang.org/x/term"
 )
 
@@ -38,12 +46,17 @@ func main() {
 	rootCmd.PersistentFlags().StringVar(&apiToken, "token", "", "API token")
 
 	rootCmd.AddCommand(configCmd())
+	rootCmd.AddCommand(loginCmd())
+	rootCmd.AddCommand(logoutCmd())
 	rootCmd.AddCommand(projectsCmd())
 	rootCmd.AddCommand(clustersCmd())
 	rootCmd.AddCommand(appsCmd())
 	rootCmd.AddCommand(deployCmd())
 	rootCmd.AddCommand(logsCmd())
 	rootCmd.AddCommand(secretsCmd())
+	rootCmd.AddCommand(notificationsCmd())
+	rootCmd.AddCommand(auditCmd())
+	rootCmd.AddCommand(tokensCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
@@ -74,6 +87,7 @@ func configCmd() *cobra.Command {
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			saveConfigValue("api_token", args[0])
+			deleteConfigValues("token_id") // not a shipit login token
 			fmt.Println("API token set successfully")
 		},
 	})
@@ -142,6 +156,137 @@ func projectsCmd() *cobra.Command {
 		},
 	})
 
+	retentionCmd := &cobra.Command{
+		Use:   "log-retention <project-id>",
+		Short: "Show or set how many days a project's archived logs are kept",
+		Long:  "Show or set how many days the log archive keeps a project's logs (default 7).\n--days 0 stops archiving the project's logs and deletes those already archived.",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			path := "/api/projects/" + args[0] + "/log-retention"
+			var resp []byte
+			var err error
+			if cmd.Flags().Changed("days") {
+				days, _ := cmd.Flags().GetInt("days")
+				resp, err = apiRequest("PUT", path, map[string]int{"days": days})
+			} else {
+				resp, err = apiRequest("GET", path, nil)
+			}
+			if err != nil {
+				fatal(err)
+			}
+			printJSON(resp)
+		},
+	}
+	retentionCmd.Flags().Int("days", 0, "Days to keep archived logs (0 turns archiving off)")
+	cmd.AddCommand(retentionCmd)
+
+	cmd.AddCommand(projectMembersCmd())
+	cmd.AddCommand(projectGroupsCmd())
+
+	return cmd
+}
+
+func projectMembersCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "members",
+		Short: "Manage who can access a project (roles: viewer, deployer, admin)",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list <project-id>",
+		Short: "List project members and their roles",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			resp, err := apiRequest("GET", "/api/projects/"+args[0]+"/members", nil)
+			if err != nil {
+				fatal(err)
+			}
+			printJSON(resp)
+		},
+	})
+
+	addCmd := &cobra.Command{
+		Use:   "add <project-id> <email>",
+		Short: "Add a member, or change an existing member's role",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			role, _ := cmd.Flags().GetString("role")
+			body := map[string]string{"email": args[1], "role": role}
+			resp, err := apiRequest("POST", "/api/projects/"+args[0]+"/members", body)
+			if err != nil {
+				fatal(err)
+			}
+			printJSON(resp)
+		},
+	}
+	addCmd.Flags().String("role", "viewer", "Role to grant: viewer, deployer or admin")
+	cmd.AddCommand(addCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "remove <project-id> <email>",
+		Short: "Remove a member from a project",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			_, err := apiRequest("DELETE", "/api/projects/"+args[0]+"/members/"+url.PathEscape(args[1]), nil)
+			if err != nil {
+				fatal(err)
+			}
+			fmt.Println("Member removed")
+		},
+	})
+
+	return cmd
+}
+
+func projectGroupsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "groups",
+		Short: "Grant project roles to SSO groups (e.g. okta:platform-team or github:acme/sre)",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list <project-id>",
+		Short: "List groups and the roles they grant",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			resp, err := apiRequest("GET", "/api/projects/"+args[0]+"/groups", nil)
+			if err != nil {
+				fatal(err)
+			}
+			printJSON(resp)
+		},
+	})
+
+	addCmd := &cobra.Command{
+		Use:   "add <project-id> <provider>:<group>",
+		Short: "Grant a role to everyone in a group, or change the group's role",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			role, _ := cmd.Flags().GetString("role")
+			body := map[string]string{"group": args[1], "role": role}
+			resp, err := apiRequest("POST", "/api/projects/"+args[0]+"/groups", body)
+			if err != nil {
+				fatal(err)
+			}
+			printJSON(resp)
+		},
+	}
+	addCmd.Flags().String("role", "viewer", "Role to grant: viewer, deployer or admin")
+	cmd.AddCommand(addCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "remove <project-id> <group>",
+		Short: "Revoke a group's role",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			_, err := apiRequest("DELETE", "/api/projects/"+args[0]+"/groups/"+url.PathEscape(args[1]), nil)
+			if err != nil {
+				fatal(err)
+			}
+			fmt.Println("Group removed")
+		},
+	})
+
 	return cmd
 }
 
@@ -352,31 +497,74 @@ func appsCmd() *cobra.Command {
 		},
 	})
 
-	cmd.AddCommand(&cobra.Command{
+	statusCmd := &cobra.Command{
 		Use:   "status <app-id>",
 		Short: "Get app deployment status",
+		Long:  "Get app deployment status. With --explain, say why pods aren't healthy instead: recent warning events,\ncontainers stuck in ImagePullBackOff, CrashLoopBackOff and the like, and what crashed containers last logged.",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			resp, err := apiRequest("GET", "/api/apps/"+args[0]+"/status", nil)
 			if err != nil {
 				fatal(err)
 			}
-			printJSON(resp)
+			if explain, _ := cmd.Flags().GetBool("explain"); !explain {
+				printJSON(resp)
+				return
+			}
+			events, err := apiRequest("GET", "/api/apps/"+args[0]+"/events", nil)
+			if err != nil {
+				fatal(err)
+			}
+			fmt.Print(formatDiagnosis(resp, events))
 		},
-	})
+	}
+	statusCmd.Flags().Bool("explain", false, "Explain unhealthy pods from Kubernetes events, container states and previous logs")
+	cmd.AddCommand(statusCmd)
 
-	cmd.AddCommand(&cobra.Command{
+	appDeployCmd := &cobra.Command{
 		Use:   "deploy <app-id>",
 		Short: "Deploy an existing app",
+		Long:  "Deploy the app as it is, or from CI with --image (and optionally --sha, --ref, --ci-url) to ship a\nfreshly pushed image without updating the app first. The commit is recorded on the new revision.\nWith --wait, follow the deploy's phases, pre-deploy hook output and pod readiness until it finishes,\nand exit non-zero unless it succeeded, so a CI job can gate on it.",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			_, err := apiRequest("POST", "/api/apps/"+args[0]+"/deploy", nil)
+			fields := map[string]string{}
+			for flag, field := range map[string]string{"image": "image", "sha": "sha", "ref": "ref", "ci-url": "ci_url"} {
+				if v, _ := cmd.Flags().GetString(flag); v != "" {
+					fields[field] = v
+				}
+			}
+			// No flags: plain redeploy with an empty body.
+			var body interface{}
+			if len(fields) > 0 {
+				body = fields
+			}
+
+			resp, err := apiRequest("POST", "/api/apps/"+args[0]+"/deploy", body)
 			if err != nil {
 				fatal(err)
 			}
-			fmt.Println("Deployment triggered. Use 'shipit apps status " + args[0] + "' to check status")
+			var result struct {
+				JobID    string `json:"job_id"`
+				Revision int    `json:"revision"`
+			}
+			json.Unmarshal(resp, &result)
+			if result.Revision > 0 {
+				fmt.Printf("Deployment queued as revision %d (job %s)\n", result.Revision, result.JobID)
+			} else {
+				fmt.Println("Deployment queued (job " + result.JobID + ")")
+			}
+			if wait, _ := cmd.Flags().GetBool("wait"); wait {
+				os.Exit(waitForDeploy(result.JobID))
+			}
+			fmt.Println("Use 'shipit deploy status " + result.JobID + "' to check progress")
 		},
-	})
+	}
+	appDeployCmd.Flags().String("image", "", "Image to deploy (updates the app's image)")
+	appDeployCmd.Flags().String("sha", "", "Commit SHA the image was built from")
+	appDeployCmd.Flags().String("ref", "", "Branch or ref the commit is on")
+	appDeployCmd.Flags().String("ci-url", "", "Link to the CI build")
+	appDeployCmd.Flags().Bool("wait", false, "Follow the deploy until it finishes; exit non-zero if it fails or is rolled back")
+	cmd.AddCommand(appDeployCmd)
 
 	cmd.AddCommand(&cobra.Command{
 		Use:   "delete <app-id>",
@@ -412,6 +600,60 @@ func appsCmd() *cobra.Command {
 	revisionsCmd.Flags().Int("limit", 10, "Number of revisions to show")
 	cmd.AddCommand(revisionsCmd)
 
+	timelineCmd := &cobra.Command{
+		Use:   "timeline <app-id> [revision]",
+		Short: "Show how long each phase of a deploy took",
+		Long:  "Show a revision's deploy timeline: revision, secret sync, pre-deploy hook, canary, apply, verifying and\nrollback, with each phase's duration and error, and when each new pod became ready. Defaults to the\nlatest revision.",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			revision := ""
+			if len(args) == 2 {
+				revision = args[1]
+			} else {
+				resp, err := apiRequest("GET", "/api/apps/"+args[0]+"/revisions?limit=1", nil)
+				if err != nil {
+					fatal(err)
+				}
+				var revisions []struct {
+					RevisionNumber int `json:"revision_number"`
+				}
+				if err := json.Unmarshal(resp, &revisions); err != nil {
+					fatal(err)
+				}
+				if len(revisions) == 0 {
+					fatal(fmt.Errorf("app has no revisions yet"))
+				}
+				revision = strconv.Itoa(revisions[0].RevisionNumber)
+			}
+			resp, err := apiRequest("GET", "/api/apps/"+args[0]+"/revisions/"+revision+"/timeline", nil)
+			if err != nil {
+				fatal(err)
+			}
+			if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+				printJSON(resp)
+				return
+			}
+			fmt.Print(formatTimeline(resp))
+		},
+	}
+	timelineCmd.Flags().Bool("json", false, "Print the raw timeline JSON")
+	cmd.AddCommand(timelineCmd)
+
+	historyCmd := &cobra.Command{
+		Use:   "history <app-id>",
+		Short: "Show who changed an app and what they changed",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			resp, err := apiRequest("GET", "/api/apps/"+args[0]+"/history"+auditQuery(cmd), nil)
+			if err != nil {
+				fatal(err)
+			}
+			printJSON(resp)
+		},
+	}
+	addAuditFlags(historyCmd)
+	cmd.AddCommand(historyCmd)
+
 	// Rollback subcommand
 	rollbackCmd := &cobra.Command{
 		Use:   "rollback <app-id>",
@@ -420,10 +662,14 @@ func appsCmd() *cobra.Command {
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			revision, _ := cmd.Flags().GetInt("revision")
+			force, _ := cmd.Flags().GetBool("force")
 
-			var body map[string]interface{}
+			body := map[string]interface{}{}
 			if revision > 0 {
-				body = map[string]interface{}{"revision": revision}
+				body["revision"] = revision
+			}
+			if force {
+				body["force"] = true
 			}
 
 			resp, err := apiRequest("POST", "/api/apps/"+args[0]+"/rollback", body)
@@ -434,14 +680,178 @@ func appsCmd() *cobra.Command {
 			var result map[string]interface{}
 			json.Unmarshal(resp, &result)
 
+			if result["status"] == "switched" {
+				// Blue/green: the previous color was still up, so traffic
+				// moved back with a Service selector flip.
+				fmt.Printf("Switched traffic back to revision %v (image: %v)\n",
+					result["target_revision"], result["target_image"])
+				return
+			}
 			fmt.Printf("Rolling back to revision %v (image: %v)\n",
 				result["target_revision"], result["target_image"])
-			fmt.Println("Use 'shipit apps status " + args[0] + "' to check status")
+			fmt.Printf("Use 'shipit deploy status %v' to check progress\n", result["job_id"])
 		},
 	}
 	rollbackCmd.Flags().Int("revision", 0, "Specific revision number to rollback to (default: previous)")
+	rollbackCmd.Flags().Bool("force", false, "Roll back even if secrets were deleted or changed since the target revision")
 	cmd.AddCommand(rollbackCmd)
 
+	// Deploy strategy subcommand
+	strategyCmd := &cobra.Command{
+		Use:   "strategy <app-id>",
+		Short: "Show or set the deploy strategy (rolling, canary or blue_green)",
+		Long:  "Show the app's deploy strategy, or change it with --strategy, --step, --interval and --retention.\nCanary deploys shift --step percent of traffic to the new revision every --interval seconds.\nBlue/green deploys keep the previous color running for --retention seconds so rollback is instant.",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			body := map[string]interface{}{}
+			if cmd.Flags().Changed("strategy") {
+				strategy, _ := cmd.Flags().GetString("strategy")
+				body["strategy"] = strategy
+			}
+			if cmd.Flags().Changed("step") {
+				step, _ := cmd.Flags().GetInt("step")
+				body["canary_step_percent"] = step
+			}
+			if cmd.Flags().Changed("interval") {
+				interval, _ := cmd.Flags().GetInt("interval")
+				body["canary_step_interval"] = interval
+			}
+			if cmd.Flags().Changed("retention") {
+				retention, _ := cmd.Flags().GetInt("retention")
+				body["blue_green_retention"] = retention
+			}
+
+			var resp []byte
+			var err error
+			if len(body) == 0 {
+				resp, err = apiRequest("GET", "/api/apps/"+args[0]+"/strategy", nil)
+			} else {
+				resp, err = apiRequest("PUT", "/api/apps/"+args[0]+"/strategy", body)
+			}
+			if err != nil {
+				fatal(err)
+			}
+			printJSON(resp)
+		},
+	}
+	strategyCmd.Flags().String("strategy", "", "Deploy strategy: rolling, canary or blue_green")
+	strategyCmd.Flags().Int("step", 0, "Canary traffic step in percent (1-99)")
+	strategyCmd.Flags().Int("interval", 0, "Seconds between canary steps")
+	strategyCmd.Flags().Int("retention", 0, "Seconds the previous blue/green color stays up for instant rollback")
+	cmd.AddCommand(strategyCmd)
+
+	// Post-rollout verification subcommand
+	verifyCmd := &cobra.Command{
+		Use:   "verification <app-id>",
+		Short: "Show or set post-rollout HTTP verification",
+		Long:  "Show the app's post-rollout verification settings, or change them with --successes, --path and --timeout.\nAfter pods are ready, shipit GETs the app's health path and each --path through its Service and requires\n--successes consecutive rounds of 2xx responses before marking the deploy successful; otherwise it rolls back.\n--successes 0 turns verification off.",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			body := map[string]interface{}{}
+			if cmd.Flags().Changed("successes") {
+				successes, _ := cmd.Flags().GetInt("successes")
+				body["verify_successes"] = successes
+			}
+			if cmd.Flags().Changed("path") {
+				pathFlags, _ := cmd.Flags().GetStringSlice("path")
+				paths := []string{}
+				for _, p := range pathFlags {
+					if p != "" {
+						paths = append(paths, p)
+					}
+				}
+				body["verify_paths"] = paths
+			}
+			if cmd.Flags().Changed("timeout") {
+				timeout, _ := cmd.Flags().GetInt("timeout")
+				body["verify_timeout"] = timeout
+			}
+
+			var resp []byte
+			var err error
+			if len(body) == 0 {
+				resp, err = apiRequest("GET", "/api/apps/"+args[0]+"/verification", nil)
+			} else {
+				resp, err = apiRequest("PUT", "/api/apps/"+args[0]+"/verification", body)
+			}
+			if err != nil {
+				fatal(err)
+			}
+			printJSON(resp)
+		},
+	}
+	verifyCmd.Flags().Int("successes", 0, "Consecutive successful check rounds required (0 disables verification)")
+	verifyCmd.Flags().StringSlice("path", nil, "Extra path to check besides the health path (repeatable; --path \"\" clears)")
+	verifyCmd.Flags().Int("timeout", 0, "Seconds allowed for verification before rolling back")
+	cmd.AddCommand(verifyCmd)
+
+	// Push-to-deploy branch tracking subcommand
+	trackCmd := &cobra.Command{
+		Use:   "track <app-id>",
+		Short: "Show or set the GitHub branch an app deploys from",
+		Long:  "Show the app's branch tracking, or change it with --repo, --branch and --tag-template.\nA push to the tracked branch delivered to /api/webhooks/github retags the app's image with the\nrendered template ({sha}, {short_sha}, {branch}) and deploys it. --repo \"\" stops tracking.",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			body := map[string]interface{}{}
+			if cmd.Flags().Changed("repo") {
+				repo, _ := cmd.Flags().GetString("repo")
+				body["repo_url"] = repo
+			}
+			if cmd.Flags().Changed("branch") {
+				branch, _ := cmd.Flags().GetString("branch")
+				body["tracked_branch"] = branch
+			}
+			if cmd.Flags().Changed("tag-template") {
+				template, _ := cmd.Flags().GetString("tag-template")
+				body["image_tag_template"] = template
+			}
+
+			var resp []byte
+			var err error
+			if len(body) == 0 {
+				resp, err = apiRequest("GET", "/api/apps/"+args[0]+"/tracking", nil)
+			} else {
+				resp, err = apiRequest("PUT", "/api/apps/"+args[0]+"/tracking", body)
+			}
+			if err != nil {
+				fatal(err)
+			}
+			printJSON(resp)
+		},
+	}
+	trackCmd.Flags().String("repo", "", "GitHub repository URL (e.g. https://github.com/org/repo)")
+	trackCmd.Flags().String("branch", "", "Branch whose pushes deploy the app")
+	trackCmd.Flags().String("tag-template", "", "Image tag template, e.g. {sha} or {branch}-{short_sha}")
+	cmd.AddCommand(trackCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "promote <app-id>",
+		Short: "Promote an in-progress canary to the full fleet",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			_, err := apiRequest("POST", "/api/apps/"+args[0]+"/canary/promote", nil)
+			if err != nil {
+				fatal(err)
+			}
+			fmt.Println("Canary promotion requested")
+			fmt.Println("Use 'shipit apps status " + args[0] + "' to check progress")
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "abort <app-id>",
+		Short: "Abort an in-progress canary and roll back",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			_, err := apiRequest("POST", "/api/apps/"+args[0]+"/canary/abort", nil)
+			if err != nil {
+				fatal(err)
+			}
+			fmt.Println("Canary abort requested")
+			fmt.Println("Use 'shipit apps status " + args[0] + "' to check progress")
+		},
+	})
+
 	cmd.AddCommand(runCmd())
 
 	return cmd
@@ -790,12 +1200,12 @@ func deployCmd() *cobra.Command {
 			fmt.Println("App created, deploying...")
 
 			// Trigger deploy
-			_, err = apiRequest("POST", "/api/apps/"+appID+"/deploy", nil)
+			resp, err = apiRequest("POST", "/api/apps/"+appID+"/deploy", nil)
 			if err != nil {
 				fatal(err)
 			}
 
-			fmt.Println("Deployment started. Use 'shipit apps status " + appID + "' to check status")
+			fmt.Println("Deployment queued. Use 'shipit deploy status " + deployJobID(resp) + "' to check progress")
 		},
 	}
 	deployCreateCmd.Flags().String("name", "", "App name")
@@ -821,79 +1231,515 @@ func deployCmd() *cobra.Command {
 		Short: "Trigger a deployment for an existing app",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			_, err := apiRequest("POST", "/api/apps/"+args[0]+"/deploy", nil)
+			resp, err := apiRequest("POST", "/api/apps/"+args[0]+"/deploy", nil)
 			if err != nil {
 				fatal(err)
 			}
-			fmt.Println("Deployment triggered")
+			fmt.Println("Deployment queued (job " + deployJobID(resp) + ")")
 		},
 	})
 
-	return cmd
-}
-
-// Logs
-
-func logsCmd() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "logs <app-id>",
-		Short: "Stream logs from an app",
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status <job-id>",
+		Short: "Show the status of a queued or running deployment",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			follow, _ := cmd.Flags().GetBool("follow")
-			tail, _ := cmd.Flags().GetString("tail")
-
-			url := apiURL + "/api/apps/" + args[0] + "/logs"
-			if follow {
-				url += "?follow=true"
-			}
-			if tail != "" {
-				if strings.Contains(url, "?") {
-					url += "&tail=" + tail
-				} else {
-					url += "?tail=" + tail
-				}
+			resp, err := apiRequest("GET", "/api/deploys/"+args[0], nil)
+			if err != nil {
+				fatal(err)
 			}
+			printJSON(resp)
+		},
+	})
 
-			req, _ := http.NewRequest("GET", url, nil)
-			req.Header.Set("Authorization", "Bearer "+apiToken)
+	return cmd
+}
 
-			client := &http.Client{Timeout: 0} // No timeout for streaming
-			resp, err := client.Do(req)
-			if err != nil {
+// waitForDeploy follows a deploy job's event stream, printing its progress
+// until it finishes, and returns the exit code for apps deploy --wait: 0
+// only if the deploy succeeded. A dropped stream is reopened; the server
+// replays the deploy from the start, and what was printed is skipped.
+func waitForDeploy(jobID string) int {
+	p := newDeployProgress()
+	failures := 0
+	for {
+		err := followDeploy(jobID, p)
+		if p.finished {
+			break
+		}
+		if err != nil {
+			failures++
+			if failures >= deployStreamRetries {
 				fatal(err)
 			}
-			defer resp.Body.Close()
+		} else {
+			failures = 0
+		}
+		time.Sleep(2 * time.Second)
+	}
+	if p.status != "succeeded" {
+		return 1
+	}
+	return 0
+}
 
-			if resp.StatusCode != http.StatusOK {
-				body, _ := io.ReadAll(resp.Body)
-				fatal(fmt.Errorf("API error: %s", string(body)))
-			}
+// deployStreamRetries is how many times in a row waitForDeploy tries to
+// reopen a deploy stream that failed before giving up.
+const deployStreamRetries = 5
 
-			scanner := bufio.NewScanner(resp.Body)
-			for scanner.Scan() {
-				fmt.Println(scanner.Text())
-			}
-		},
+// followDeploy reads one connection of GET /api/deploys/{id}/stream.
+func followDeploy(jobID string, p *deployProgress) error {
+	req, _ := http.NewRequest("GET", apiURL+"/api/deploys/"+jobID+"/stream", nil)
+	req.Header.Set("Authorization", "Bearer "+apiToken)
+
+	client := &http.Client{Timeout: 0} // No timeout for streaming
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API error: %s", string(body))
 	}
-	cmd.Flags().BoolP("follow", "f", false, "Follow log output")
-	cmd.Flags().String("tail", "", "Number of lines to show from the end")
 
-	return cmd
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		fmt.Print(p.render([]byte(data)))
+		if p.finished {
+			return nil
+		}
+	}
+	return scanner.Err()
 }
 
-// Secrets
+// deployProgress renders the events of a deploy stream as lines of text.
+// It remembers what it has printed, so events replayed after a reconnect
+// are not printed twice.
+type deployProgress struct {
+	status   string // latest job status; the final one once finished
+	revision int
+	finished bool
+
+	started map[string]time.Time // phase -> when its latest run started
+	seen    map[string]bool      // timeline events printed, by id
+	ready   string               // rollout counts last printed
+	logs    map[string]bool      // hook output lines printed
+}
 
-func secretsCmd() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:     "secrets",
-		Aliases: []string{"secret", "s"},
-		Short:   "Manage application secrets",
+func newDeployProgress() *deployProgress {
+	return &deployProgress{started: map[string]time.Time{}, seen: map[string]bool{}, logs: map[string]bool{}}
+}
+
+func (p *deployProgress) render(data []byte) string {
+	var e struct {
+		Type     string  `json:"type"`
+		Status   string  `json:"status"`
+		Revision int     `json:"revision"`
+		Error    *string `json:"error"`
+		Event    *struct {
+			ID         string    `json:"id"`
+			Phase      string    `json:"phase"`
+			Status     string    `json:"status"`
+			Message    *string   `json:"message"`
+			Pod        *string   `json:"pod"`
+			OccurredAt time.Time `json:"occurred_at"`
+		} `json:"event"`
+		Log *struct {
+			Timestamp time.Time `json:"timestamp"`
+			Message   string    `json:"message"`
+		} `json:"log"`
+		Rollout *struct {
+			Desired int32 `json:"desired"`
+			Updated int32 `json:"updated"`
+			Ready   int32 `json:"ready"`
+		} `json:"rollout"`
+	}
+	if err := json.Unmarshal(data, &e); err != nil {
+		return ""
 	}
 
-	cmd.AddCommand(&cobra.Command{
-		Use:   "list <app-id>",
-		Short: "List secrets for an app (keys only, values are never shown)",
+	switch e.Type {
+	case "job":
+		if e.Status == p.status && e.Revision == p.revision {
+			return ""
+		}
+		p.status, p.revision = e.Status, e.Revision
+		if e.Revision > 0 {
+			return fmt.Sprintf("Job %s (revision %d)\n", e.Status, e.Revision)
+		}
+		return "Job " + e.Status + "\n"
+
+	case "phase":
+		ev := e.Event
+		if ev == nil || p.seen[ev.ID] {
+			return ""
+		}
+		p.seen[ev.ID] = true
+		switch ev.Status {
+		case "started":
+			p.started[ev.Phase] = ev.OccurredAt
+			return ev.Phase + ": started\n"
+		case "ready":
+			if ev.Pod == nil {
+				return ""
+			}
+			return ev.Phase + ": pod " + *ev.Pod + " ready\n"
+		}
+		line := ev.Phase + ": " + ev.Status
+		if start, ok := p.started[ev.Phase]; ok {
+			line += " after " + formatMs(ev.OccurredAt.Sub(start).Milliseconds())
+		}
+		if ev.Message != nil && *ev.Message != "" {
+			line += ": " + strings.ReplaceAll(*ev.Message, "\n", "\n  ")
+		}
+		return line + "\n"
+
+	case "log":
+		if e.Log == nil {
+			return ""
+		}
+		key := e.Log.Timestamp.String() + e.Log.Message
+		if p.logs[key] {
+			return ""
+		}
+		p.logs[key] = true
+		return "predeploy | " + e.Log.Message + "\n"
+
+	case "rollout":
+		if e.Rollout == nil {
+			return ""
+		}
+		ready := fmt.Sprintf("%d/%d pods ready", e.Rollout.Ready, e.Rollout.Desired)
+		if ready == p.ready {
+			return ""
+		}
+		p.ready = ready
+		return fmt.Sprintf("verifying: %s (%d updated)\n", ready, e.Rollout.Updated)
+
+	case "done":
+		p.status, p.finished = e.Status, true
+		line := "Deploy " + strings.ReplaceAll(e.Status, "_", " ")
+		if e.Revision > 0 {
+			line += fmt.Sprintf(" (revision %d)", e.Revision)
+		}
+		if e.Error != nil && *e.Error != "" {
+			line += ": " + *e.Error
+		}
+		return line + "\n"
+	}
+	return ""
+}
+
+// deployJobID extracts the job ID from a POST /api/apps/{id}/deploy response.
+func deployJobID(resp []byte) string {
+	var result struct {
+		JobID string `json:"job_id"`
+	}
+	json.Unmarshal(resp, &result)
+	return result.JobID
+}
+
+// Logs
+
+func logsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logs <app-id>",
+		Short: "Stream logs from all of an app's pods",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			query := url.Values{}
+			if follow, _ := cmd.Flags().GetBool("follow"); follow {
+				query.Set("follow", "true")
+			}
+			if previous, _ := cmd.Flags().GetBool("previous"); previous {
+				query.Set("previous", "true")
+			}
+			for _, name := range []string{"tail", "pod", "container", "since", "grep"} {
+				if v, _ := cmd.Flags().GetString(name); v != "" {
+					query.Set(name, v)
+				}
+			}
+			noColor, _ := cmd.Flags().GetBool("no-color")
+			color := !noColor && os.Getenv("NO_COLOR") == "" && term.IsTerminal(int(os.Stdout.Fd()))
+
+			logsURL := apiURL + "/api/apps/" + args[0] + "/logs"
+			if len(query) > 0 {
+				logsURL += "?" + query.Encode()
+			}
+
+			req, _ := http.NewRequest("GET", logsURL, nil)
+			req.Header.Set("Authorization", "Bearer "+apiToken)
+
+			client := &http.Client{Timeout: 0} // No timeout for streaming
+			resp, err := client.Do(req)
+			if err != nil {
+				fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				body, _ := io.ReadAll(resp.Body)
+				fatal(fmt.Errorf("API error: %s", string(body)))
+			}
+
+			scanner := bufio.NewScanner(resp.Body)
+			scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+			for scanner.Scan() {
+				data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+				if !ok {
+					continue
+				}
+				fmt.Println(formatLogLine(data, color))
+			}
+		},
+	}
+	cmd.Flags().BoolP("follow", "f", false, "Follow log output, including pods started later")
+	cmd.Flags().String("tail", "", "Number of lines to show from the end of each container's log")
+	cmd.Flags().String("pod", "", "Only show logs from this pod")
+	cmd.Flags().StringP("container", "c", "", "Only show logs from this container")
+	cmd.Flags().String("since", "", "Only show logs newer than a duration (10m, 1h) or an RFC 3339 time")
+	cmd.Flags().BoolP("previous", "p", false, "Show logs of the previous instance of restarted containers")
+	cmd.Flags().String("grep", "", "Only show lines matching a regular expression")
+	cmd.Flags().Bool("no-color", false, "Don't color the pod prefixes")
+
+	cmd.AddCommand(logsSearchCmd())
+
+	return cmd
+}
+
+func logsSearchCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "search <app-id> [pattern]",
+		Short: "Search an app's archived logs, including pods and deploys that are gone",
+		Long:  "Search the log archive (the server needs LOG_ARCHIVE_URL). The pattern is a regular\nexpression matched against each line; without one every line in the range is shown.",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			query := url.Values{}
+			if len(args) == 2 {
+				query.Set("q", args[1])
+			}
+			for _, name := range []string{"from", "to", "revision", "pod", "limit"} {
+				if v, _ := cmd.Flags().GetString(name); v != "" {
+					query.Set(name, v)
+				}
+			}
+			resp, err := apiRequest("GET", "/api/apps/"+args[0]+"/logs/search?"+query.Encode(), nil)
+			if err != nil {
+				fatal(err)
+			}
+			if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+				printJSON(resp)
+				return
+			}
+
+			var result struct {
+				Lines     []json.RawMessage `json:"lines"`
+				Truncated bool              `json:"truncated"`
+			}
+			if err := json.Unmarshal(resp, &result); err != nil {
+				fatal(err)
+			}
+			noColor, _ := cmd.Flags().GetBool("no-color")
+			color := !noColor && os.Getenv("NO_COLOR") == "" && term.IsTerminal(int(os.Stdout.Fd()))
+			for _, line := range result.Lines {
+				var meta struct {
+					Timestamp time.Time `json:"timestamp"`
+				}
+				json.Unmarshal(line, &meta)
+				fmt.Println(meta.Timestamp.Local().Format(time.RFC3339), formatLogLine(string(line), color))
+			}
+			if result.Truncated {
+				fmt.Fprintln(os.Stderr, "More lines match; narrow --from/--to or raise --limit to see them.")
+			}
+		},
+	}
+	cmd.Flags().String("from", "", "Start of the range: an RFC 3339 time or a duration ago, e.g. 2h")
+	cmd.Flags().String("to", "", "End of the range: an RFC 3339 time or a duration ago")
+	cmd.Flags().String("revision", "", "Only lines from pods of this revision")
+	cmd.Flags().String("pod", "", "Only lines from this pod")
+	cmd.Flags().String("limit", "", "Maximum lines to return (default 500, at most 5000)")
+	cmd.Flags().Bool("json", false, "Print the raw JSON response")
+	cmd.Flags().Bool("no-color", false, "Don't color the pod prefixes")
+	return cmd
+}
+
+// logColors are ANSI colors for pod prefixes; a pod keeps its color for
+// the whole stream.
+var logColors = []string{"\033[36m", "\033[33m", "\033[32m", "\033[35m", "\033[34m", "\033[91m", "\033[96m", "\033[93m"}
+
+// formatLogLine renders one SSE log event as "<pod> <container> | <message>".
+// Events that aren't JSON (servers before per-pod streaming) print as is.
+func formatLogLine(data string, color bool) string {
+	var line struct {
+		Pod       string `json:"pod"`
+		Container string `json:"container"`
+		Message   string `json:"message"`
+	}
+	if err := json.Unmarshal([]byte(data), &line); err != nil || line.Pod == "" {
+		return data
+	}
+	prefix := line.Pod
+	if line.Container != "" {
+		prefix += " " + line.Container
+	}
+	if color {
+		h := sha256.Sum256([]byte(line.Pod))
+		prefix = logColors[int(h[0])%len(logColors)] + prefix + "\033[0m"
+	}
+	return prefix + " | " + line.Message
+}
+
+// formatDiagnosis renders an app's status and events (GET .../status and
+// .../events) for apps status --explain.
+func formatDiagnosis(statusData, eventsData []byte) string {
+	var status struct {
+		Status          string `json:"status"`
+		ReadyReplicas   int32  `json:"ready_replicas"`
+		DesiredReplicas int32  `json:"desired_replicas"`
+	}
+	var diag struct {
+		Summary    []string `json:"summary"`
+		Containers []struct {
+			Pod          string   `json:"pod"`
+			Container    string   `json:"container"`
+			PreviousLogs []string `json:"previous_logs"`
+		} `json:"containers"`
+		Events []struct {
+			Object   string    `json:"object"`
+			Reason   string    `json:"reason"`
+			Message  string    `json:"message"`
+			Count    int32     `json:"count"`
+			LastSeen time.Time `json:"last_seen"`
+		} `json:"events"`
+	}
+	json.Unmarshal(statusData, &status)
+	json.Unmarshal(eventsData, &diag)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Status: %s (%d/%d pods ready)\n", status.Status, status.ReadyReplicas, status.DesiredReplicas)
+	if len(diag.Summary) == 0 {
+		b.WriteString("\nNo problems found in pod states or recent events.\n")
+		return b.String()
+	}
+
+	b.WriteString("\nLikely cause:\n")
+	for _, line := range diag.Summary {
+		b.WriteString("  - " + line + "\n")
+	}
+	if len(diag.Events) > 0 {
+		b.WriteString("\nWarning events:\n")
+		for _, e := range diag.Events {
+			fmt.Fprintf(&b, "  %-8s %s %s: %s", formatAge(time.Since(e.LastSeen)), e.Object, e.Reason, e.Message)
+			if e.Count > 1 {
+				fmt.Fprintf(&b, " (x%d)", e.Count)
+			}
+			b.WriteString("\n")
+		}
+	}
+	for _, c := range diag.Containers {
+		if len(c.PreviousLogs) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\nLast logs of the crashed %s container (%s):\n", c.Container, c.Pod)
+		for _, line := range c.PreviousLogs {
+			b.WriteString("  " + line + "\n")
+		}
+	}
+	return b.String()
+}
+
+// formatTimeline renders a revision's deploy timeline (GET
+// .../revisions/{revision}/timeline) for apps timeline.
+func formatTimeline(data []byte) string {
+	var tl struct {
+		Revision      int     `json:"revision"`
+		DeployStatus  string  `json:"deploy_status"`
+		DeployMessage *string `json:"deploy_message"`
+		Phases        []struct {
+			Phase      string     `json:"phase"`
+			Status     string     `json:"status"`
+			StartedAt  time.Time  `json:"started_at"`
+			EndedAt    *time.Time `json:"ended_at"`
+			DurationMs int64      `json:"duration_ms"`
+			Message    *string    `json:"message"`
+			Pods       []struct {
+				Pod     string `json:"pod"`
+				AfterMs int64  `json:"after_ms"`
+			} `json:"pods"`
+		} `json:"phases"`
+	}
+	json.Unmarshal(data, &tl)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Revision %d: %s\n", tl.Revision, tl.DeployStatus)
+	if len(tl.Phases) == 0 {
+		b.WriteString("\nNo timeline recorded for this revision.\n")
+		return b.String()
+	}
+
+	start := tl.Phases[0].StartedAt
+	end := start
+	fmt.Fprintf(&b, "\n%-12s %-10s %9s %9s\n", "PHASE", "STATUS", "AT", "DURATION")
+	for _, p := range tl.Phases {
+		fmt.Fprintf(&b, "%-12s %-10s %9s %9s\n", p.Phase, p.Status, "+"+formatMs(p.StartedAt.Sub(start).Milliseconds()), formatMs(p.DurationMs))
+		for _, pod := range p.Pods {
+			fmt.Fprintf(&b, "  pod %s ready after %s\n", pod.Pod, formatMs(pod.AfterMs))
+		}
+		if p.Message != nil && *p.Message != "" {
+			b.WriteString("  " + strings.ReplaceAll(*p.Message, "\n", "\n  ") + "\n")
+		}
+		if p.EndedAt != nil && p.EndedAt.After(end) {
+			end = *p.EndedAt
+		}
+	}
+	fmt.Fprintf(&b, "\nTotal: %s\n", formatMs(end.Sub(start).Milliseconds()))
+	return b.String()
+}
+
+// formatMs renders a duration in milliseconds as "850ms", "12.3s" or
+// "4m05s".
+func formatMs(ms int64) string {
+	switch {
+	case ms < 1000:
+		return fmt.Sprintf("%dms", ms)
+	case ms < 60000:
+		return fmt.Sprintf("%.1fs", float64(ms)/1000)
+	}
+	return fmt.Sprintf("%dm%02ds", ms/60000, ms%60000/1000)
+}
+
+// formatAge renders d as "45s ago", "12m ago", "3h ago" or "2d ago".
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds ago", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+}
+
+// Secrets
+
+func secretsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "secrets",
+		Aliases: []string{"secret", "s"},
+		Short:   "Manage application secrets",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list <app-id>",
+		Short: "List secrets for an app (keys and sources; values are never shown)",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			resp, err := apiRequest("GET", "/api/apps/"+args[0]+"/secrets", nil)
@@ -906,56 +1752,734 @@ func secretsCmd() *cobra.Command {
 
 	setCmd := &cobra.Command{
 		Use:   "set <app-id>",
-		Short: "Set a secret for an app",
+		Short: "Set a secret for an app, or point it at an external secret with --ref",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			key, _ := cmd.Flags().GetString("key")
 			value, _ := cmd.Flags().GetString("value")
+			ref, _ := cmd.Flags().GetString("ref")
+			fromStdin, _ := cmd.Flags().GetBool("from-stdin")
+			apply, _ := cmd.Flags().GetBool("apply")
 
-			if key == "" || value == "" {
-				fatal(fmt.Errorf("--key and --value are required"))
+			if fromStdin {
+				if value != "" || ref != "" {
+					fatal(fmt.Errorf("--from-stdin can't be combined with --value or --ref"))
+				}
+				data, err := io.ReadAll(os.Stdin)
+				if err != nil {
+					fatal(err)
+				}
+				value = trimTrailingNewline(string(data))
+			}
+			if key == "" || (value == "") == (ref == "") {
+				fatal(fmt.Errorf("--key and one of --value, --from-stdin or --ref are required"))
 			}
 
-			body := map[string]string{
-				"key":   key,
-				"value": value,
+			body := map[string]string{"key": key}
+			if ref != "" {
+				body["ref"] = ref
+			} else {
+				body["value"] = value
 			}
-			resp, err := apiRequest("POST", "/api/apps/"+args[0]+"/secrets", body)
+			resp, err := apiRequest("POST", "/api/apps/"+args[0]+"/secrets"+applyQuery(apply), body)
 			if err != nil {
 				fatal(err)
 			}
 			printJSON(resp)
-			fmt.Println("\nSecret set. Redeploy the app to apply: shipit apps deploy " + args[0])
+			printSecretApply("Secret set", args[0], resp, apply)
 		},
 	}
 	setCmd.Flags().String("key", "", "Secret key (required)")
-	setCmd.Flags().String("value", "", "Secret value (required)")
+	setCmd.Flags().String("value", "", "Secret value")
+	setCmd.Flags().String("ref", "", "Reference to an external secret, resolved at deploy time: vault://<mount>/<path>#<field> or awssm://<name-or-arn>[#<field>]")
+	setCmd.Flags().Bool("from-stdin", false, "Read the value from stdin, keeping it out of shell history")
+	setCmd.Flags().Bool("apply", false, "Redeploy the app with the change (batched with other changes made within a few seconds)")
 	cmd.AddCommand(setCmd)
 
+	importCmd := &cobra.Command{
+		Use:   "import <app-id>",
+		Short: "Set many secrets from a .env file, or restore a file from secrets export",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			file, _ := cmd.Flags().GetString("file")
+			replace, _ := cmd.Flags().GetBool("replace")
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			apply, _ := cmd.Flags().GetBool("apply")
+
+			if file == "" {
+				fatal(fmt.Errorf("--file is required"))
+			}
+			var data []byte
+			var err error
+			if file == "-" {
+				data, err = io.ReadAll(os.Stdin)
+			} else {
+				data, err = os.ReadFile(file)
+			}
+			if err != nil {
+				fatal(err)
+			}
+
+			contentType := "text/plain"
+			switch {
+			case secretexport.IsSealed(data):
+				// Exports are decrypted here; the passphrase never reaches
+				// the server on import.
+				passphrase, err := readPassphrase(false)
+				if err != nil {
+					fatal(err)
+				}
+				secrets, err := secretexport.Open(data, passphrase)
+				if err != nil {
+					fatal(err)
+				}
+				data, _ = json.Marshal(secrets)
+				contentType = "application/json"
+			case strings.HasSuffix(file, ".json"):
+				contentType = "application/json"
+			}
+
+			query := url.Values{}
+			if replace {
+				query.Set("mode", "replace")
+			}
+			if dryRun {
+				query.Set("dry_run", "true")
+			}
+			if apply {
+				query.Set("apply", "true")
+			}
+			path := "/api/apps/" + args[0] + "/secrets/bulk"
+			if len(query) > 0 {
+				path += "?" + query.Encode()
+			}
+			resp, err := apiRequestRaw("POST", path, contentType, data)
+			if err != nil {
+				fatal(err)
+			}
+			printJSON(resp)
+			if !dryRun {
+				printSecretApply("Secrets imported", args[0], resp, apply)
+			}
+		},
+	}
+	importCmd.Flags().StringP("file", "f", "", "A .env file, a JSON file, or an export; - reads stdin (required)")
+	importCmd.Flags().Bool("replace", false, "Delete secrets that aren't in the file")
+	importCmd.Flags().Bool("dry-run", false, "Show what would change without changing anything")
+	importCmd.Flags().Bool("apply", false, "Redeploy the app if anything changed")
+	cmd.AddCommand(importCmd)
+
+	exportCmd := &cobra.Command{
+		Use:   "export <app-id>",
+		Short: "Export an app's secrets, encrypted with a passphrase (admin only)",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			output, _ := cmd.Flags().GetString("output")
+
+			passphrase, err := readPassphrase(true)
+			if err != nil {
+				fatal(err)
+			}
+			resp, err := apiRequest("POST", "/api/apps/"+args[0]+"/secrets/export", map[string]string{"passphrase": passphrase})
+			if err != nil {
+				fatal(err)
+			}
+			if output == "" || output == "-" {
+				os.Stdout.Write(resp)
+				return
+			}
+			if err := os.WriteFile(output, resp, 0600); err != nil {
+				fatal(err)
+			}
+			fmt.Printf("Secrets exported to %s. Restore with: shipit secrets import <app-id> -f %s\n", output, output)
+		},
+	}
+	exportCmd.Flags().StringP("output", "o", "", "File to write (default stdout)")
+	cmd.AddCommand(exportCmd)
+
 	deleteCmd := &cobra.Command{
 		Use:   "delete <app-id>",
 		Short: "Delete a secret from an app",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			key, _ := cmd.Flags().GetString("key")
+			apply, _ := cmd.Flags().GetBool("apply")
 
 			if key == "" {
 				fatal(fmt.Errorf("--key is required"))
 			}
 
-			_, err := apiRequest("DELETE", "/api/apps/"+args[0]+"/secrets/"+key, nil)
+			resp, err := apiRequest("DELETE", "/api/apps/"+args[0]+"/secrets/"+key+applyQuery(apply), nil)
 			if err != nil {
 				fatal(err)
 			}
-			fmt.Println("Secret deleted. Redeploy the app to apply: shipit apps deploy " + args[0])
+			printSecretApply("Secret deleted", args[0], resp, apply)
 		},
 	}
 	deleteCmd.Flags().String("key", "", "Secret key to delete (required)")
+	deleteCmd.Flags().Bool("apply", false, "Redeploy the app with the change (batched with other changes made within a few seconds)")
 	cmd.AddCommand(deleteCmd)
 
 	return cmd
 }
 
+func applyQuery(apply bool) string {
+	if apply {
+		return "?apply=true"
+	}
+	return ""
+}
+
+// printSecretApply tells the user how a secret change reaches the app: the
+// redeploy queued by --apply, or the command to run.
+func printSecretApply(done, appID string, resp []byte, apply bool) {
+	var result struct {
+		Deploy *struct {
+			JobID string `json:"job_id"`
+		} `json:"deploy"`
+	}
+	json.Unmarshal(resp, &result)
+	switch {
+	case result.Deploy != nil:
+		fmt.Printf("\n%s. Redeploy queued; follow it with: shipit deploy status %s\n", done, result.Deploy.JobID)
+	case apply:
+		fmt.Printf("\n%s. Nothing changed, so no redeploy was queued.\n", done)
+	default:
+		fmt.Printf("\n%s. Redeploy the app to apply: shipit apps deploy %s (or pass --apply)\n", done, appID)
+	}
+}
+
+// readPassphrase returns the passphrase for secrets export files, from
+// SHIPIT_SECRETS_PASSPHRASE or a prompt. confirm asks for it twice.
+func readPassphrase(confirm bool) (string, error) {
+	if p := os.Getenv("SHIPIT_SECRETS_PASSPHRASE"); p != "" {
+		return p, nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("no terminal to prompt for a passphrase; set SHIPIT_SECRETS_PASSPHRASE")
+	}
+	fmt.Fprint(os.Stderr, "Passphrase: ")
+	p, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Repeat passphrase: ")
+		again, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		if string(again) != string(p) {
+			return "", fmt.Errorf("passphrases don't match")
+		}
+	}
+	return string(p), nil
+}
+
+// trimTrailingNewline drops the newline echo and most editors leave at the
+// end of piped input, which is never part of the secret.
+func trimTrailingNewline(s string) string {
+	s = strings.TrimSuffix(s, "\n")
+	return strings.TrimSuffix(s, "\r")
+}
+
+// Notifications
+
+func notificationsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "notifications",
+		Aliases: []string{"notify"},
+		Short:   "Manage Slack and webhook notifications for deploy events",
+		Long:    "Deploy events (deploy_started, predeploy_failed, deploy_succeeded, deploy_failed,\nauto_rolled_back) go to a project's default targets unless an app overrides them.",
+	}
+
+	notificationsPath := func(cmd *cobra.Command, id string) string {
+		if project, _ := cmd.Flags().GetBool("project"); project {
+			return "/api/projects/" + id + "/notifications"
+		}
+		return "/api/apps/" + id + "/notifications"
+	}
+
+	getCmd := &cobra.Command{
+		Use:   "get <app-id>",
+		Short: "Show an app's notification overrides and effective settings",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			resp, err := apiRequest("GET", notificationsPath(cmd, args[0]), nil)
+			if err != nil {
+				fatal(err)
+			}
+			printJSON(resp)
+		},
+	}
+	getCmd.Flags().Bool("project", false, "Treat the argument as a project ID and show the project defaults")
+	cmd.AddCommand(getCmd)
+
+	setCmd := &cobra.Command{
+		Use:   "set <app-id>",
+		Short: "Set where an app's deploy events are sent",
+		Long:  "Set notification targets for an app, or with --project the defaults for every app in a project.\nAn empty value turns a target off; --inherit resets app fields to the project default.",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			body := map[string]interface{}{}
+			for flag, field := range map[string]string{
+				"slack-webhook":  "slack_webhook",
+				"webhook-url":    "webhook_url",
+				"webhook-secret": "webhook_secret",
+			} {
+				if cmd.Flags().Changed(flag) {
+					value, _ := cmd.Flags().GetString(flag)
+					body[field] = value
+				}
+			}
+			if cmd.Flags().Changed("events") {
+				events, _ := cmd.Flags().GetStringSlice("events")
+				if events == nil {
+					events = []string{}
+				}
+				body["events"] = events
+			}
+			inherit, _ := cmd.Flags().GetStringSlice("inherit")
+			for _, field := range inherit {
+				field = strings.ReplaceAll(field, "-", "_")
+				if _, ok := body[field]; ok {
+					fatal(fmt.Errorf("--inherit %s conflicts with the value given for it", field))
+				}
+				body[field] = nil
+			}
+			if len(body) == 0 {
+				fatal(fmt.Errorf("nothing to set: pass --slack-webhook, --webhook-url, --webhook-secret, --events or --inherit"))
+			}
+
+			resp, err := apiRequest("PUT", notificationsPath(cmd, args[0]), body)
+			if err != nil {
+				fatal(err)
+			}
+			printJSON(resp)
+		},
+	}
+	setCmd.Flags().Bool("project", false, "Treat the argument as a project ID and set the project defaults")
+	setCmd.Flags().String("slack-webhook", "", "Slack incoming webhook URL (\"\" to disable)")
+	setCmd.Flags().String("webhook-url", "", "Generic JSON webhook URL (\"\" to disable)")
+	setCmd.Flags().String("webhook-secret", "", "Secret for the X-Shipit-Signature-256 HMAC on webhook deliveries")
+	setCmd.Flags().StringSlice("events", nil, "Events to send, comma-separated (default: all)")
+	setCmd.Flags().StringSlice("inherit", nil, "Fields to reset to the project default: slack_webhook, webhook_url, webhook_secret, events")
+	cmd.AddCommand(setCmd)
+
+	return cmd
+}
+
+// Audit log
+
+func auditCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Search the audit log of changes made through the API",
+		Long:  "List audit log entries, newest first. Actions are named like app.update, secret.set,\napp.rollback; --action secret.* matches every secret action.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			resp, err := apiRequest("GET", "/api/audit"+auditQuery(cmd), nil)
+			if err != nil {
+				fatal(err)
+			}
+			printJSON(resp)
+		},
+	}
+	addAuditFlags(cmd)
+	cmd.Flags().String("app", "", "Only entries for this app ID")
+	return cmd
+}
+
+func addAuditFlags(cmd *cobra.Command) {
+	cmd.Flags().String("actor", "", "Only entries by this user email or API token name")
+	cmd.Flags().String("action", "", "Only this action (e.g. app.update, or secret.* for a prefix)")
+	cmd.Flags().String("since", "", "Only entries since an RFC 3339 time or a duration ago (e.g. 24h)")
+	cmd.Flags().String("before", "", "Only entries older than this RFC 3339 time (next_before from the previous page)")
+	cmd.Flags().Int("limit", 50, "Number of entries to show")
+}
+
+// auditQuery builds the query string for the audit flags that were set.
+func auditQuery(cmd *cobra.Command) string {
+	q := url.Values{}
+	for flag, param := range map[string]string{
+		"actor":  "actor",
+		"action": "action",
+		"since":  "since",
+		"before": "before",
+		"app":    "app_id",
+	} {
+		if f := cmd.Flags().Lookup(flag); f != nil && f.Changed {
+			q.Set(param, f.Value.String())
+		}
+	}
+	if limit, _ := cmd.Flags().GetInt("limit"); limit > 0 {
+		q.Set("limit", fmt.Sprintf("%d", limit))
+	}
+	return "?" + q.Encode()
+}
+
+// API tokens
+
+func tokensCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "tokens",
+		Aliases: []string{"token"},
+		Short:   "Manage your API tokens",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List your API tokens with their scopes and last use",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			resp, err := apiRequest("GET", "/api/tokens", nil)
+			if err != nil {
+				fatal(err)
+			}
+			printJSON(resp)
+		},
+	})
+
+	createCmd := &cobra.Command{
+		Use:   "create <name>",
+		Short: "Create a scoped, expiring API token (the token is shown once)",
+		Long: `Create an API token limited to the given scopes, written <verb>:<target>:
+
+  read:*              read anything you can read
+  deploy:app/<id>     deploy, roll back and read one app
+  deploy:project/<id> the same for every app in a project
+  read:logs           read logs of any app you can read
+  admin:*             everything your roles allow
+
+Verbs are read, deploy and admin; each includes the ones before it.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			scopes, _ := cmd.Flags().GetStringSlice("scope")
+			expires, _ := cmd.Flags().GetString("expires")
+			allowIPs, _ := cmd.Flags().GetStringSlice("allow-ip")
+
+			if len(scopes) == 0 {
+				fatal(fmt.Errorf("at least one --scope is required (use admin:* for full access)"))
+			}
+			lifetime, err := parseLifetime(expires)
+			if err != nil {
+				fatal(err)
+			}
+
+			body := map[string]interface{}{
+				"name":       args[0],
+				"scopes":     scopes,
+				"expires_at": time.Now().Add(lifetime).UTC().Format(time.RFC3339),
+			}
+			if len(allowIPs) > 0 {
+				body["allowed_ips"] = allowIPs
+			}
+			resp, err := apiRequest("POST", "/api/tokens", body)
+			if err != nil {
+				fatal(err)
+			}
+			printJSON(resp)
+			fmt.Println("\nCopy the token now; it won't be shown again.")
+		},
+	}
+	createCmd.Flags().StringSlice("scope", nil, "Scope to grant, repeatable or comma-separated (required)")
+	createCmd.Flags().String("expires", "30d", "Lifetime of the token, in days (30d), weeks (2w) or a duration (12h); at most 365d")
+	createCmd.Flags().StringSlice("allow-ip", nil, "Only accept the token from these addresses or CIDRs")
+	cmd.AddCommand(createCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "delete <token-id>",
+		Short: "Revoke an API token",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			_, err := apiRequest("DELETE", "/api/tokens/"+args[0], nil)
+			if err != nil {
+				fatal(err)
+			}
+			fmt.Println("Token revoked")
+		},
+	})
+
+	return cmd
+}
+
+// parseLifetime parses a token lifetime such as 30d, 2w or 12h.
+func parseLifetime(s string) (time.Duration, error) {
+	unit := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, d := range unit {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil || count <= 0 {
+				return 0, fmt.Errorf("invalid --expires %q", s)
+			}
+			return time.Duration(count) * d, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid --expires %q: use e.g. 30d, 2w or 12h", s)
+	}
+	return d, nil
+}
+
+// Login
+
+func loginCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "login",
+		Short: "Sign in through the browser and save an API token",
+		Long: `Sign in through the dashboard's login page and save an expiring API token
+for the CLI. By default the browser is opened on this machine and sends the
+result back to a temporary local port; use --device on a remote machine to
+get a code to enter in any browser instead.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			device, _ := cmd.Flags().GetBool("device")
+			expires, _ := cmd.Flags().GetString("expires")
+
+			if apiURL == "" {
+				fatal(fmt.Errorf("API URL not set. Run: shipit config set-url <url> or pass --api-url"))
+			}
+			lifetime, err := parseLifetime(expires)
+			if err != nil {
+				fatal(err)
+			}
+			hostname, _ := os.Hostname()
+			body := map[string]interface{}{
+				"name":       strings.TrimSpace("shipit login " + hostname),
+				"expires_at": time.Now().Add(lifetime).UTC().Format(time.RFC3339),
+			}
+
+			var token cliToken
+			if device {
+				token, err = deviceLogin(body)
+			} else {
+				token, err = browserLogin(body)
+			}
+			if err != nil {
+				fatal(err)
+			}
+
+			saveConfigValue("api_url", apiURL)
+			saveConfigValue("api_token", token.Token)
+			saveConfigValue("token_id", token.ID)
+			fmt.Printf("Logged in as %s (token expires %s)\n", token.Email, token.ExpiresAt.Local().Format("2006-01-02 15:04"))
+		},
+	}
+	cmd.Flags().Bool("device", false, "Sign in from another device with a one-time code instead of opening a browser here")
+	cmd.Flags().String("expires", "30d", "Lifetime of the token, in days (30d), weeks (2w) or a duration (12h); at most 365d")
+	return cmd
+}
+
+func logoutCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "logout",
+		Short: "Revoke the token saved by shipit login and remove it from the config",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg := readConfig()
+			if cfg["api_token"] == "" {
+				fmt.Println("Not logged in")
+				return
+			}
+			if id := cfg["token_id"]; id != "" {
+				apiToken = cfg["api_token"]
+				if _, err := apiRequest("DELETE", "/api/tokens/"+id, nil); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: could not revoke token %s: %v\n", id, err)
+				}
+			}
+			deleteConfigValues("api_token", "token_id")
+			fmt.Println("Logged out")
+		},
+	}
+}
+
+// cliToken is the token issued at the end of shipit login.
+type cliToken struct {
+	ID        string    `json:"id"`
+	Token     string    `json:"token"`
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// browserLogin opens the approval page in the browser and waits for it to
+// redirect back to a local port with an authorization code, which is then
+// exchanged (with the PKCE verifier) for a token.
+func browserLogin(body map[string]interface{}) (cliToken, error) {
+	state, err := randomString()
+	if err != nil {
+		return cliToken{}, err
+	}
+	verifier, err := randomString()
+	if err != nil {
+		return cliToken{}, err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return cliToken{}, fmt.Errorf("listening for the login callback: %w", err)
+	}
+	defer listener.Close()
+	redirectURI := fmt.Sprintf("http://%s/callback", listener.Addr())
+
+	type result struct {
+		code string
+		err  error
+	}
+	results := make(chan result, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/callback" {
+			http.NotFound(w, r)
+			return
+		}
+		q := r.URL.Query()
+		if q.Get("state") != state || q.Get("code") == "" {
+			http.Error(w, "Login failed: unexpected response. Run shipit login again.", http.StatusBadRequest)
+			select {
+			case results <- result{err: fmt.Errorf("login callback had an invalid state or no code")}:
+			default:
+			}
+			return
+		}
+		fmt.Fprintln(w, "Logged in to the ShipIt CLI. You can close this window.")
+		select {
+		case results <- result{code: q.Get("code")}:
+		default:
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	authURL := apiURL + "/auth/cli/authorize?" + url.Values{
+		"redirect_uri":          {redirectURI},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}.Encode()
+	fmt.Printf("Opening %s\n", authURL)
+	if err := openBrowser(authURL); err != nil {
+		fmt.Println("Could not open a browser; open the URL above to continue, or use shipit login --device.")
+	}
+	fmt.Println("Waiting for you to approve the login in the browser...")
+
+	var res result
+	select {
+	case res = <-results:
+	case <-time.After(5 * time.Minute):
+		return cliToken{}, fmt.Errorf("timed out waiting for the browser login")
+	}
+	if res.err != nil {
+		return cliToken{}, res.err
+	}
+
+	body["grant_type"] = "authorization_code"
+	body["code"] = res.code
+	body["code_verifier"] = verifier
+	token, errCode, err := exchangeCLILogin(body)
+	if err == nil && errCode != "" {
+		err = fmt.Errorf("login failed: %s", errCode)
+	}
+	return token, err
+}
+
+// deviceLogin shows a code to enter at the server's device page and polls
+// until it is approved.
+func deviceLogin(body map[string]interface{}) (cliToken, error) {
+	resp, err := http.Post(apiURL+"/auth/device/code", "application/json", nil)
+	if err != nil {
+		return cliToken{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(resp.Body)
+		return cliToken{}, fmt.Errorf("API error (%d): %s", resp.StatusCode, string(data))
+	}
+	var start struct {
+		DeviceCode      string `json:"device_code"`
+		UserCode        string `json:"user_code"`
+		VerificationURI string `json:"verification_uri"`
+		ExpiresIn       int    `json:"expires_in"`
+		Interval        int    `json:"interval"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&start); err != nil {
+		return cliToken{}, err
+	}
+
+	fmt.Printf("Open %s in a browser and enter the code: %s\n", start.VerificationURI, start.UserCode)
+	fmt.Println("Waiting for approval...")
+
+	body["grant_type"] = "device_code"
+	body["device_code"] = start.DeviceCode
+	interval := time.Duration(max(start.Interval, 1)) * time.Second
+	deadline := time.Now().Add(time.Duration(start.ExpiresIn) * time.Second)
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		token, errCode, err := exchangeCLILogin(body)
+		switch {
+		case err != nil:
+			return cliToken{}, err
+		case errCode == "":
+			return token, nil
+		case errCode == "authorization_pending":
+			continue
+		case errCode == "expired_token":
+			return cliToken{}, fmt.Errorf("the code expired before it was approved; run shipit login again")
+		default:
+			return cliToken{}, fmt.Errorf("login failed: %s", errCode)
+		}
+	}
+	return cliToken{}, fmt.Errorf("the code expired before it was approved; run shipit login again")
+}
+
+// exchangeCLILogin posts to the token endpoint. A refusal (e.g.
+// authorization_pending) is returned as errCode rather than an error.
+func exchangeCLILogin(body map[string]interface{}) (token cliToken, errCode string, err error) {
+	data, _ := json.Marshal(body)
+	resp, err := http.Post(apiURL+"/auth/cli/token", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return token, "", err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode == http.StatusBadRequest {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(respBody, &e) == nil && e.Error != "" {
+			return token, e.Error, nil
+		}
+	}
+	if resp.StatusCode >= 400 {
+		return token, "", fmt.Errorf("API error (%d): %s", resp.StatusCode, string(respBody))
+	}
+	err = json.Unmarshal(respBody, &token)
+	return token, "", err
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func openBrowser(target string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", target).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", target).Start()
+	default:
+		return exec.Command("xdg-open", target).Start()
+	}
+}
+
 // Helpers
 
 func loadConfig() {
@@ -976,34 +2500,56 @@ func loadConfig() {
 	}
 }
 
-func saveConfigValue(key, value string) {
+func readConfig() map[string]string {
+	cfg := make(map[string]string)
+	data, _ := os.ReadFile(filepath.Join(os.Getenv("HOME"), ".shipit", "config.json"))
+	json.Unmarshal(data, &cfg)
+	return cfg
+}
+
+func writeConfig(cfg map[string]string) {
 	configDir := filepath.Join(os.Getenv("HOME"), ".shipit")
 	os.MkdirAll(configDir, 0700)
 
-	configPath := filepath.Join(configDir, "config.json")
-
-	cfg := make(map[string]string)
-	data, _ := os.ReadFile(configPath)
-	json.Unmarshal(data, &cfg)
+	data, _ := json.MarshalIndent(cfg, "", "  ")
+	os.WriteFile(filepath.Join(configDir, "config.json"), data, 0600)
+}
 
+func saveConfigValue(key, value string) {
+	cfg := readConfig()
 	cfg[key] = value
+	writeConfig(cfg)
+}
 
-	data, _ = json.MarshalIndent(cfg, "", "  ")
-	os.WriteFile(configPath, data, 0600)
+func deleteConfigValues(keys ...string) {
+	cfg := readConfig()
+	for _, key := range keys {
+		delete(cfg, key)
+	}
+	writeConfig(cfg)
 }
 
 func apiRequest(method, path string, body interface{}) ([]byte, error) {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	return apiRequestRaw(method, path, "application/json", data)
+}
+
+// apiRequestRaw is apiRequest for a body that is already encoded, such as a
+// .env file.
+func apiRequestRaw(method, path, contentType string, body []byte) ([]byte, error) {
 	if apiURL == "" {
 		return nil, fmt.Errorf("API URL not set. Run: shipit config set-url <url>")
 	}
 	if apiToken == "" {
-		return nil, fmt.Errorf("API token not set. Run: shipit config set-token <token>")
+		return nil, fmt.Errorf("API token not set. Run: shipit login (or shipit config set-token <token>)")
 	}
 
 	var bodyReader io.Reader
 	if body != nil {
-		data, _ := json.Marshal(body)
-		bodyReader = bytes.NewReader(data)
+		bodyReader = bytes.NewReader(body)
 	}
 
 	req, err := http.NewRequest(method, apiURL+path, bodyReader)
@@ -1012,7 +2558,7 @@ func apiRequest(method, path string, body interface{}) ([]byte, error) {
 	}
 
 	req.Header.Set("Authorization", "Bearer "+apiToken)
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", contentType)
 
 	client := &http.Client{Timeout: 30 * time.Second}
 	resp, err := client.Do(req)
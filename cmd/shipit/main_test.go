@@ -1,7 +1,11 @@
 package main
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestRunCmd_Flags(t *testing.T) {
@@ -132,3 +136,488 @@ func TestRunCmd_DefaultFlagValues(t *testing.T) {
 		t.Errorf("expected ram default to be 0, got %d", ram)
 	}
 }
+
+func TestDeployCmd_HasStatusSubcommand(t *testing.T) {
+	cmd := deployCmd()
+
+	var found bool
+	for _, sub := range cmd.Commands() {
+		if sub.Name() == "status" {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Error("expected status to be a subcommand of deploy")
+	}
+}
+
+func TestDeployJobID(t *testing.T) {
+	if got := deployJobID([]byte(`{"status":"queued","job_id":"abc-123"}`)); got != "abc-123" {
+		t.Errorf("want abc-123, got %q", got)
+	}
+	if got := deployJobID([]byte(`not json`)); got != "" {
+		t.Errorf("want empty job id for malformed response, got %q", got)
+	}
+}
+
+func TestAppsCmd_HasCanarySubcommands(t *testing.T) {
+	cmd := appsCmd()
+
+	for _, name := range []string{"strategy", "promote", "abort"} {
+		var found bool
+		for _, sub := range cmd.Commands() {
+			if sub.Name() == name {
+				found = true
+				if err := sub.Args(sub, []string{}); err == nil {
+					t.Errorf("expected %s to require an app id", name)
+				}
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected %s to be a subcommand of apps", name)
+		}
+	}
+}
+
+func TestAppsStrategyCmd_HasRetentionFlag(t *testing.T) {
+	cmd := appsCmd()
+	for _, sub := range cmd.Commands() {
+		if sub.Name() == "strategy" {
+			if sub.Flags().Lookup("retention") == nil {
+				t.Error("expected apps strategy to have a --retention flag")
+			}
+			return
+		}
+	}
+	t.Fatal("apps strategy subcommand not found")
+}
+
+func TestAppsVerificationCmd_Flags(t *testing.T) {
+	cmd := appsCmd()
+	for _, sub := range cmd.Commands() {
+		if sub.Name() == "verification" {
+			for _, flag := range []string{"successes", "path", "timeout"} {
+				if sub.Flags().Lookup(flag) == nil {
+					t.Errorf("expected apps verification to have a --%s flag", flag)
+				}
+			}
+			if err := sub.Args(sub, []string{}); err == nil {
+				t.Error("expected verification to require an app id")
+			}
+			return
+		}
+	}
+	t.Fatal("apps verification subcommand not found")
+}
+
+func TestAppsTrackCmd_Flags(t *testing.T) {
+	cmd := appsCmd()
+	for _, sub := range cmd.Commands() {
+		if sub.Name() == "track" {
+			for _, flag := range []string{"repo", "branch", "tag-template"} {
+				if sub.Flags().Lookup(flag) == nil {
+					t.Errorf("expected apps track to have a --%s flag", flag)
+				}
+			}
+			if err := sub.Args(sub, []string{}); err == nil {
+				t.Error("expected track to require an app id")
+			}
+			return
+		}
+	}
+	t.Fatal("apps track subcommand not found")
+}
+
+func TestAppsDeployCmd_CIFlags(t *testing.T) {
+	cmd := appsCmd()
+	for _, sub := range cmd.Commands() {
+		if sub.Name() == "deploy" {
+			for _, flag := range []string{"image", "sha", "ref", "ci-url"} {
+				if sub.Flags().Lookup(flag) == nil {
+					t.Errorf("expected apps deploy to have a --%s flag", flag)
+				}
+			}
+			return
+		}
+	}
+	t.Fatal("apps deploy subcommand not found")
+}
+
+func TestNotificationsCmd_Subcommands(t *testing.T) {
+	cmd := notificationsCmd()
+	flags := map[string][]string{
+		"get": {"project"},
+		"set": {"project", "slack-webhook", "webhook-url", "webhook-secret", "events", "inherit"},
+	}
+	for _, sub := range cmd.Commands() {
+		want, ok := flags[sub.Name()]
+		if !ok {
+			continue
+		}
+		delete(flags, sub.Name())
+		for _, flag := range want {
+			if sub.Flags().Lookup(flag) == nil {
+				t.Errorf("expected notifications %s to have a --%s flag", sub.Name(), flag)
+			}
+		}
+		if err := sub.Args(sub, []string{}); err == nil {
+			t.Errorf("expected notifications %s to require an id", sub.Name())
+		}
+	}
+	for name := range flags {
+		t.Errorf("notifications %s subcommand not found", name)
+	}
+}
+
+func TestAuditCmds_Flags(t *testing.T) {
+	audit := auditCmd()
+	for _, flag := range []string{"actor", "action", "since", "before", "limit", "app"} {
+		if audit.Flags().Lookup(flag) == nil {
+			t.Errorf("expected audit to have a --%s flag", flag)
+		}
+	}
+	if err := audit.Args(audit, []string{"extra"}); err == nil {
+		t.Error("expected audit to take no arguments")
+	}
+
+	for _, sub := range appsCmd().Commands() {
+		if sub.Name() == "history" {
+			for _, flag := range []string{"actor", "action", "since", "before", "limit"} {
+				if sub.Flags().Lookup(flag) == nil {
+					t.Errorf("expected apps history to have a --%s flag", flag)
+				}
+			}
+			if err := sub.Args(sub, []string{}); err == nil {
+				t.Error("expected history to require an app id")
+			}
+			return
+		}
+	}
+	t.Fatal("apps history subcommand not found")
+}
+
+func TestProjectMembersCmd(t *testing.T) {
+	for _, name := range []string{"members", "groups"} {
+		parent, _, err := projectsCmd().Find([]string{name})
+		if err != nil || parent.Name() != name {
+			t.Fatalf("projects %s subcommand not found", name)
+		}
+
+		want := map[string]int{"list": 1, "add": 2, "remove": 2}
+		for _, sub := range parent.Commands() {
+			args, ok := want[sub.Name()]
+			if !ok {
+				continue
+			}
+			delete(want, sub.Name())
+			if err := sub.Args(sub, make([]string, args-1)); err == nil {
+				t.Errorf("expected %s %s to require %d arguments", name, sub.Name(), args)
+			}
+			if sub.Name() == "add" {
+				if f := sub.Flags().Lookup("role"); f == nil || f.DefValue != "viewer" {
+					t.Errorf("expected %s add to have --role defaulting to viewer", name)
+				}
+			}
+		}
+		for sub := range want {
+			t.Errorf("%s %s subcommand not found", name, sub)
+		}
+	}
+}
+
+func TestTokensCreateCmd_Flags(t *testing.T) {
+	create, _, err := tokensCmd().Find([]string{"create"})
+	if err != nil || create.Name() != "create" {
+		t.Fatal("tokens create subcommand not found")
+	}
+	for _, flag := range []string{"scope", "expires", "allow-ip"} {
+		if create.Flags().Lookup(flag) == nil {
+			t.Errorf("expected tokens create to have a --%s flag", flag)
+		}
+	}
+	if err := create.Args(create, []string{}); err == nil {
+		t.Error("expected create to require a token name")
+	}
+}
+
+func TestLoginCmds(t *testing.T) {
+	login := loginCmd()
+	for _, flag := range []string{"device", "expires"} {
+		if login.Flags().Lookup(flag) == nil {
+			t.Errorf("expected login to have a --%s flag", flag)
+		}
+	}
+	if err := login.Args(login, []string{"extra"}); err == nil {
+		t.Error("expected login to take no arguments")
+	}
+	if logout := logoutCmd(); logout.Args(logout, []string{"extra"}) == nil {
+		t.Error("expected logout to take no arguments")
+	}
+}
+
+func TestDeleteConfigValues(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	saveConfigValue("api_url", "https://shipit.test")
+	saveConfigValue("api_token", "tok")
+	saveConfigValue("token_id", "id-1")
+
+	deleteConfigValues("api_token", "token_id")
+	cfg := readConfig()
+	if len(cfg) != 1 || cfg["api_url"] != "https://shipit.test" {
+		t.Errorf("config after delete = %v", cfg)
+	}
+}
+
+func TestExchangeCLILogin(t *testing.T) {
+	var pending bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/auth/cli/token" {
+			http.NotFound(w, r)
+			return
+		}
+		if pending {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"authorization_pending"}`))
+			return
+		}
+		w.Write([]byte(`{"id":"id-1","token":"tok","email":"ana@example.com","expires_at":"2026-02-01T00:00:00Z"}`))
+	}))
+	defer server.Close()
+	apiURL = server.URL
+	defer func() { apiURL = "" }()
+
+	pending = true
+	if _, code, err := exchangeCLILogin(map[string]interface{}{}); err != nil || code != "authorization_pending" {
+		t.Errorf("pending: code %q, err %v", code, err)
+	}
+	pending = false
+	token, code, err := exchangeCLILogin(map[string]interface{}{})
+	if err != nil || code != "" || token.ID != "id-1" || token.Token != "tok" || token.Email != "ana@example.com" {
+		t.Errorf("approved: token %+v, code %q, err %v", token, code, err)
+	}
+}
+
+func TestParseLifetime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"30d", 30 * 24 * time.Hour},
+		{"2w", 14 * 24 * time.Hour},
+		{"12h", 12 * time.Hour},
+	}
+	for _, tt := range tests {
+		got, err := parseLifetime(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseLifetime(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "0d", "-1d", "xd", "1.5d", "forever"} {
+		if _, err := parseLifetime(bad); err == nil {
+			t.Errorf("parseLifetime(%q): expected error", bad)
+		}
+	}
+}
+
+func TestSecretsCmd_Flags(t *testing.T) {
+	cmd := secretsCmd()
+	flags := map[string][]string{
+		"set":    {"key", "value", "ref", "from-stdin", "apply"},
+		"delete": {"key", "apply"},
+		"import": {"file", "replace", "dry-run", "apply"},
+		"export": {"output"},
+	}
+	for name, want := range flags {
+		sub, _, err := cmd.Find([]string{name})
+		if err != nil || sub.Name() != name {
+			t.Errorf("secrets %s subcommand not found", name)
+			continue
+		}
+		for _, flag := range want {
+			if sub.Flags().Lookup(flag) == nil {
+				t.Errorf("expected secrets %s to have a --%s flag", name, flag)
+			}
+		}
+	}
+}
+
+func TestTrimTrailingNewline(t *testing.T) {
+	for in, want := range map[string]string{"s3cret\n": "s3cret", "s3cret\r\n": "s3cret", "a\n\n": "a\n", "none": "none"} {
+		if got := trimTrailingNewline(in); got != want {
+			t.Errorf("trimTrailingNewline(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestApplyQuery(t *testing.T) {
+	if got := applyQuery(true); got != "?apply=true" {
+		t.Errorf("applyQuery(true) = %q", got)
+	}
+	if got := applyQuery(false); got != "" {
+		t.Errorf("applyQuery(false) = %q", got)
+	}
+}
+
+func TestLogsCmd_Flags(t *testing.T) {
+	cmd := logsCmd()
+	for _, flag := range []string{"follow", "tail", "pod", "container", "since", "previous", "grep", "no-color"} {
+		if cmd.Flags().Lookup(flag) == nil {
+			t.Errorf("expected logs to have a --%s flag", flag)
+		}
+	}
+}
+
+func TestFormatLogLine(t *testing.T) {
+	data := `{"pod":"web-a","container":"web","timestamp":"2026-03-01T10:00:00Z","message":"GET / 200"}`
+	if got := formatLogLine(data, false); got != "web-a web | GET / 200" {
+		t.Errorf("formatLogLine = %q", got)
+	}
+	colored := formatLogLine(data, true)
+	if !strings.HasPrefix(colored, "\033[") || !strings.HasSuffix(colored, "web-a web\033[0m | GET / 200") {
+		t.Errorf("colored formatLogLine = %q", colored)
+	}
+	if formatLogLine(data, true) != colored {
+		t.Error("a pod should keep its color")
+	}
+	if got := formatLogLine("plain text", true); got != "plain text" {
+		t.Errorf("non-JSON data should print as is, got %q", got)
+	}
+}
+
+func TestLogsSearchCmd_Flags(t *testing.T) {
+	sub, _, err := logsCmd().Find([]string{"search"})
+	if err != nil || sub.Name() != "search" {
+		t.Fatal("logs search subcommand not found")
+	}
+	for _, flag := range []string{"from", "to", "revision", "pod", "limit", "json", "no-color"} {
+		if sub.Flags().Lookup(flag) == nil {
+			t.Errorf("expected logs search to have a --%s flag", flag)
+		}
+	}
+
+	sub, _, err = projectsCmd().Find([]string{"log-retention"})
+	if err != nil || sub.Name() != "log-retention" || sub.Flags().Lookup("days") == nil {
+		t.Error("expected projects log-retention with a --days flag")
+	}
+}
+
+func TestAppsStatusCmd_ExplainFlag(t *testing.T) {
+	sub, _, err := appsCmd().Find([]string{"status"})
+	if err != nil || sub.Name() != "status" || sub.Flags().Lookup("explain") == nil {
+		t.Error("expected apps status with an --explain flag")
+	}
+}
+
+func TestFormatDiagnosis(t *testing.T) {
+	status := []byte(`{"status":"partial","ready_replicas":1,"desired_replicas":2}`)
+	events := []byte(`{
+		"summary": ["CrashLoopBackOff: container web, 1 pod (last exit code 1)"],
+		"containers": [{"pod": "web-b", "container": "web", "previous_logs": ["panic: missing DATABASE_URL"]}],
+		"events": [{"object": "Pod/web-b", "reason": "BackOff", "message": "Back-off restarting failed container", "count": 4, "last_seen": "2020-01-01T00:00:00Z"}]
+	}`)
+	got := formatDiagnosis(status, events)
+	for _, want := range []string{
+		"Status: partial (1/2 pods ready)",
+		"  - CrashLoopBackOff: container web, 1 pod (last exit code 1)",
+		"Pod/web-b BackOff: Back-off restarting failed container (x4)",
+		"Last logs of the crashed web container (web-b):\n  panic: missing DATABASE_URL",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("formatDiagnosis output missing %q:\n%s", want, got)
+		}
+	}
+
+	if got := formatDiagnosis(status, []byte(`{"summary":[]}`)); !strings.Contains(got, "No problems found") {
+		t.Errorf("healthy diagnosis = %q", got)
+	}
+}
+
+func TestAppsTimelineCmd(t *testing.T) {
+	sub, _, err := appsCmd().Find([]string{"timeline"})
+	if err != nil || sub.Name() != "timeline" || sub.Flags().Lookup("json") == nil {
+		t.Fatal("expected apps timeline with a --json flag")
+	}
+	if err := sub.Args(sub, []string{"app"}); err != nil {
+		t.Errorf("timeline without a revision rejected: %v", err)
+	}
+	if err := sub.Args(sub, []string{"app", "3", "extra"}); err == nil {
+		t.Error("timeline accepted three args")
+	}
+}
+
+func TestFormatTimeline(t *testing.T) {
+	data := []byte(`{
+		"revision": 7,
+		"deploy_status": "rolled_back",
+		"phases": [
+			{"phase": "revision", "status": "succeeded", "started_at": "2026-01-01T00:00:00Z", "ended_at": "2026-01-01T00:00:00.2Z", "duration_ms": 200},
+			{"phase": "verifying", "status": "failed", "started_at": "2026-01-01T00:00:01Z", "ended_at": "2026-01-01T00:01:31Z", "duration_ms": 90000,
+			 "message": "rollout did not become ready", "pods": [{"pod": "web-a", "after_ms": 4200}]}
+		]
+	}`)
+	got := formatTimeline(data)
+	for _, want := range []string{
+		"Revision 7: rolled_back",
+		"revision     succeeded       +0ms     200ms",
+		"verifying    failed         +1.0s     1m30s",
+		"  pod web-a ready after 4.2s",
+		"  rollout did not become ready",
+		"Total: 1m31s",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("formatTimeline output missing %q:\n%s", want, got)
+		}
+	}
+
+	if got := formatTimeline([]byte(`{"revision": 1, "deploy_status": "success", "phases": []}`)); !strings.Contains(got, "No timeline recorded") {
+		t.Errorf("empty timeline = %q", got)
+	}
+}
+
+func TestAppsDeployCmd_WaitFlag(t *testing.T) {
+	sub, _, err := appsCmd().Find([]string{"deploy"})
+	if err != nil || sub.Name() != "deploy" || sub.Flags().Lookup("wait") == nil {
+		t.Error("expected apps deploy with a --wait flag")
+	}
+}
+
+func TestDeployProgress(t *testing.T) {
+	stream := []string{
+		`{"type":"job","status":"running","revision":9}`,
+		`{"type":"phase","event":{"id":"e1","phase":"predeploy","status":"started","occurred_at":"2026-01-01T00:00:00Z"}}`,
+		`{"type":"log","log":{"timestamp":"2026-01-01T00:00:01Z","message":"migrating"}}`,
+		`{"type":"phase","event":{"id":"e2","phase":"predeploy","status":"succeeded","occurred_at":"2026-01-01T00:00:02.5Z"}}`,
+		`{"type":"rollout","rollout":{"desired":3,"updated":3,"ready":1}}`,
+		`{"type":"phase","event":{"id":"e3","phase":"verifying","status":"ready","pod":"web-a","occurred_at":"2026-01-01T00:00:05Z"}}`,
+		`{"type":"phase","event":{"id":"e4","phase":"verifying","status":"failed","message":"rollout did not become ready","occurred_at":"2026-01-01T00:01:00Z"}}`,
+	}
+	p := newDeployProgress()
+	var out strings.Builder
+	for _, data := range stream {
+		out.WriteString(p.render([]byte(data)))
+	}
+	want := "Job running (revision 9)\n" +
+		"predeploy: started\n" +
+		"predeploy | migrating\n" +
+		"predeploy: succeeded after 2.5s\n" +
+		"verifying: 1/3 pods ready (3 updated)\n" +
+		"verifying: pod web-a ready\n" +
+		"verifying: failed: rollout did not become ready\n"
+	if out.String() != want {
+		t.Errorf("rendered:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	// A reconnect replays the stream; nothing is printed twice.
+	for _, data := range stream {
+		if got := p.render([]byte(data)); got != "" {
+			t.Errorf("replayed event printed again: %q", got)
+		}
+	}
+
+	done := p.render([]byte(`{"type":"done","status":"rolled_back","revision":9,"error":"rolled back to revision 8"}`))
+	if done != "Deploy rolled back (revision 9): rolled back to revision 8\n" || !p.finished || p.status != "rolled_back" {
+		t.Errorf("done = %q, finished = %v, status = %q", done, p.finished, p.status)
+	}
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vigneshsubbiah/shipit/internal/config"
+	"github.com/vigneshsubbiah/shipit/internal/db"
+)
+
+func TestAuditDiff(t *testing.T) {
+	before := &db.App{
+		Name:     "web",
+		Image:    "ghcr.io/org/web:1",
+		Replicas: 2,
+		Status:   "running",
+		EnvVars:  json.RawMessage(`{"LOG_LEVEL":"info","API_KEY":"old","DATABASE_URL":"postgres://u:p@db/app","GONE":"x"}`),
+	}
+	after := &db.App{
+		Name:     "web",
+		Image:    "ghcr.io/org/web:2",
+		Replicas: 2,
+		Status:   "deploying",
+		EnvVars:  json.RawMessage(`{"LOG_LEVEL":"debug","API_KEY":"new","DATABASE_URL":"postgres://u:q@db/app","ADDED":"y"}`),
+	}
+
+	got := auditDiff(before, after)
+	want := map[string]auditChange{
+		"image":                 {Before: "ghcr.io/org/web:1", After: "ghcr.io/org/web:2"},
+		"env_vars.LOG_LEVEL":    {Before: "info", After: "debug"},
+		"env_vars.API_KEY":      {Before: auditRedacted, After: auditRedacted},
+		"env_vars.DATABASE_URL": {Before: auditRedacted, After: auditRedacted},
+		"env_vars.GONE":         {Before: "x"},
+		"env_vars.ADDED":        {After: "y"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("auditDiff =\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestAuditDiffCreateAndDelete(t *testing.T) {
+	app := &db.App{Name: "web", EnvVars: json.RawMessage(`{"SECRET_TOKEN":"s"}`)}
+
+	created := auditDiff(nil, app)
+	if c := created["name"]; c.Before != nil || c.After != "web" {
+		t.Errorf("create: name change = %+v", c)
+	}
+	if c := created["env_vars.SECRET_TOKEN"]; c.After != auditRedacted {
+		t.Errorf("create: secret-looking env var not redacted: %+v", c)
+	}
+
+	var none *db.App
+	deleted := auditDiff(app, none)
+	if c := deleted["name"]; c.Before != "web" || c.After != nil {
+		t.Errorf("delete: name change = %+v", c)
+	}
+}
+
+func TestRedactCommand(t *testing.T) {
+	got := redactCommand([]string{"psql", "postgres://admin:hunter2@db/app", "--password=hunter2", "--verbose=1", "-c", "select 1"})
+	want := []string{"psql", auditRedacted, "--password=" + auditRedacted, "--verbose=1", "-c", "select 1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("redactCommand = %v, want %v", got, want)
+	}
+}
+
+// auditAction must see the full pattern of nested chi routes once the
+// handler has run.
+func TestAuditAction(t *testing.T) {
+	var got string
+	var audited bool
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			next.ServeHTTP(w, req)
+			got, audited = auditAction(req)
+		})
+	})
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+	r.Route("/api/apps/{appID}", func(r chi.Router) {
+		r.Get("/", noop)
+		r.Put("/", noop)
+		r.Route("/secrets", func(r chi.Router) {
+			r.Delete("/{key}", noop)
+		})
+		r.Post("/bounce", noop)
+	})
+
+	tests := []struct {
+		method, path string
+		want         string
+		audited      bool
+	}{
+		{"PUT", "/api/apps/a1", "app.update", true},
+		{"DELETE", "/api/apps/a1/secrets/API_KEY", "secret.delete", true},
+		{"GET", "/api/apps/a1", "", false},
+		{"POST", "/api/apps/a1/bounce", "POST /api/apps/{appID}/bounce", true},
+	}
+	for _, tt := range tests {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+		if got != tt.want || audited != tt.audited {
+			t.Errorf("%s %s: action = %q (audited %v), want %q (%v)", tt.method, tt.path, got, audited, tt.want, tt.audited)
+		}
+	}
+}
+
+// Every mutating route should have a readable action name.
+func TestAuditActionsCoverRouter(t *testing.T) {
+	router := NewRouter(NewHandler(nil, nil, "", "", nil, nil, nil, nil, nil), nil, &config.Config{}).(chi.Routes)
+	err := chi.Walk(router, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if method == http.MethodGet || method == http.MethodHead || !strings.HasPrefix(route, "/api") {
+			return nil
+		}
+		key := method + " " + strings.TrimSuffix(route, "/")
+		if _, ok := auditActions[key]; !ok {
+			t.Errorf("no audit action for %s", key)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseAuditFilter(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/audit?actor=ana@example.com&action=secret.*&limit=1000&since=2026-01-02T03:04:05Z", nil)
+	f, err := parseAuditFilter(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.Actor != "ana@example.com" || f.Action != "secret.*" || f.Limit != maxAuditLimit {
+		t.Errorf("unexpected filter %+v", f)
+	}
+	if f.Since == nil || !f.Since.Equal(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("since = %v", f.Since)
+	}
+
+	req = httptest.NewRequest("GET", "/api/audit?since=24h", nil)
+	f, err = parseAuditFilter(req)
+	if err != nil || f.Since == nil || time.Since(*f.Since) < 23*time.Hour || f.Limit != defaultAuditLimit {
+		t.Errorf("since=24h: filter %+v err %v", f, err)
+	}
+
+	for _, q := range []string{"limit=0", "limit=x", "until=yesterday", "before=24h"} {
+		if _, err := parseAuditFilter(httptest.NewRequest("GET", "/api/audit?"+q, nil)); err == nil {
+			t.Errorf("%s: expected error", q)
+		}
+	}
+}
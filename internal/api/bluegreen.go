@@ -0,0 +1,284 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/vigneshsubbiah/shipit/internal/db"
+	"github.com/vigneshsubbiah/shipit/internal/k8s"
+)
+
+// Bounds for blue_green_retention, the window the previous color stays
+// scaled up after a switchover.
+const (
+	blueGreenMinRetention = 60
+	blueGreenMaxRetention = 24 * 60 * 60
+)
+
+// blueGreenUnsupported returns why app can't be deployed blue/green, or ""
+// if it can. The switchover is a Service selector flip, so the app needs a
+// port; and each color runs a fixed replica count, which an HPA (scoped to
+// a single Deployment) would fight.
+func blueGreenUnsupported(app *db.App) string {
+	switch {
+	case app.Port == nil:
+		return "app has no port, so there is no Service to switch"
+	case app.HPAEnabled:
+		return "autoscaling is enabled"
+	}
+	return ""
+}
+
+// usesBlueGreen reports whether deploys of app go through the blue/green
+// path rather than falling back to a rolling update.
+func usesBlueGreen(app *db.App) bool {
+	return app.DeployStrategy == "blue_green" && blueGreenUnsupported(app) == ""
+}
+
+// activeColor returns the color app's Service selects, or "" if the app
+// isn't on blue/green colors (yet).
+func activeColor(app *db.App) string {
+	if app.ActiveColor == nil {
+		return ""
+	}
+	return *app.ActiveColor
+}
+
+// deploymentName is the Deployment currently serving app's traffic.
+func deploymentName(app *db.App) string {
+	return k8s.DeploymentName(app.Name, activeColor(app))
+}
+
+// blueGreenRetention is how long the previous color stays up after a
+// switchover.
+func blueGreenRetention(app *db.App) time.Duration {
+	secs := app.BlueGreenRetention
+	if secs < blueGreenMinRetention {
+		secs = blueGreenMinRetention
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// appClient builds a k8s client for the app's cluster.
+func (h *Handler) appClient(ctx context.Context, app *db.App) (*k8s.Client, error) {
+	cluster, err := h.db.GetCluster(ctx, app.ClusterID)
+	if err != nil {
+		return nil, fmt.Errorf("cluster not found: %w", err)
+	}
+	kubeconfig, err := h.keys.Decrypt(cluster.KubeconfigEncrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt kubeconfig: %w", err)
+	}
+	return k8s.NewClient(kubeconfig)
+}
+
+// prepareBlueGreen returns the color this deploy should stand up: the one
+// not currently serving. An app coming from rolling updates is first moved
+// onto blue (AdoptIntoColor) so the new color is never mixed into its
+// Service. The idle color's rollback window ends here: it is about to be
+// overwritten with the new revision.
+func (h *Handler) prepareBlueGreen(ctx context.Context, appID string, app *db.App, client *k8s.Client, req k8s.DeployRequest) (string, error) {
+	active := activeColor(app)
+	if active == "" {
+		adopted, err := client.AdoptIntoColor(ctx, req, k8s.ColorBlue)
+		if err != nil {
+			return "", err
+		}
+		if adopted {
+			log.Printf("deploy: moved rolling deployment onto color app=%s color=%s", appID, k8s.ColorBlue)
+			active = k8s.ColorBlue
+			app.ActiveColor = &active
+		}
+	}
+
+	if active != "" {
+		if err := h.db.SetActiveColor(ctx, appID, &active, nil, nil); err != nil {
+			return "", fmt.Errorf("failed to record active color: %w", err)
+		}
+	}
+	return k8s.OtherColor(active), nil
+}
+
+// switchBlueGreen flips the Service to the color this deploy stood up and
+// records the previous color as idle: it keeps running the prior revision
+// for the app's retention window, so rolling back to it is another flip.
+func (h *Handler) switchBlueGreen(ctx context.Context, appID string, app *db.App, client *k8s.Client, req k8s.DeployRequest) error {
+	if err := client.SwitchServiceColor(req, req.Color); err != nil {
+		return err
+	}
+
+	color := req.Color
+	var idleRevision *int
+	var idleUntil *time.Time
+	if activeColor(app) != "" && app.CurrentRevision > 0 {
+		rev := app.CurrentRevision
+		until := time.Now().Add(blueGreenRetention(app))
+		idleRevision, idleUntil = &rev, &until
+	}
+	if err := h.db.SetActiveColor(ctx, appID, &color, idleRevision, idleUntil); err != nil {
+		log.Printf("deploy: failed to record active color app=%s color=%s err=%v", appID, color, err)
+	}
+	log.Printf("deploy: switched service app=%s from=%s to=%s", appID, activeColor(app), color)
+	return nil
+}
+
+// revertBlueGreen points the Service back at the color that was active
+// before switchBlueGreen, for a new color that failed post-rollout
+// verification. A first blue/green deploy has nothing to go back to.
+func (h *Handler) revertBlueGreen(ctx context.Context, appID string, app *db.App, client *k8s.Client, req k8s.DeployRequest) {
+	prev := activeColor(app)
+	if prev == "" {
+		return
+	}
+	if err := client.SwitchServiceColor(req, prev); err != nil {
+		log.Printf("deploy: failed to switch service back app=%s color=%s err=%v", appID, prev, err)
+		return
+	}
+	if err := h.db.SetActiveColor(ctx, appID, &prev, nil, nil); err != nil {
+		log.Printf("deploy: failed to record active color app=%s color=%s err=%v", appID, prev, err)
+	}
+	log.Printf("deploy: switched service back app=%s to=%s", appID, prev)
+}
+
+// retireColor scales a color that never took traffic (its rollout failed,
+// or the switchover did) down to zero.
+func (h *Handler) retireColor(ctx context.Context, appID string, app *db.App, client *k8s.Client, color string) {
+	if err := client.ScaleDeployment(ctx, k8s.DeploymentName(app.Name, color), app.Namespace, 0); err != nil {
+		log.Printf("deploy: failed to scale down color app=%s color=%s err=%v", appID, color, err)
+	}
+}
+
+// leaveBlueGreen removes the color Deployments once a rolling update has
+// replaced them (the app was switched back to the rolling strategy).
+func (h *Handler) leaveBlueGreen(ctx context.Context, appID string, app *db.App, client *k8s.Client) {
+	if err := client.DeleteColors(ctx, app.Name, app.Namespace); err != nil {
+		log.Printf("deploy: failed to delete color deployments app=%s err=%v", appID, err)
+		return
+	}
+	h.db.SetActiveColor(ctx, appID, nil, nil, nil)
+}
+
+// switchToIdleColor rolls a blue/green app back by pointing its Service at
+// the idle color, when that color still runs target and is ready, and then
+// points the app row at target. Returns the new active color, or "" when a
+// flip isn't possible and the caller should queue a regular rollback
+// instead.
+//
+// The flip holds the deploy queue lock, so a deploy queued or claimed on
+// another replica can't overwrite the idle color while it happens.
+func (h *Handler) switchToIdleColor(ctx context.Context, app *db.App, target *db.AppRevision) string {
+	active := activeColor(app)
+	if !usesBlueGreen(app) || active == "" || app.IdleRevision == nil || *app.IdleRevision != target.RevisionNumber {
+		return ""
+	}
+	if app.IdleScaleDownAt == nil || !time.Now().Before(*app.IdleScaleDownAt) {
+		return ""
+	}
+	// A deploy in flight is about to overwrite the idle color.
+	for _, s := range reconcilableStatuses {
+		if app.Status == s {
+			return ""
+		}
+	}
+	unlock, ok := h.tryLockAppDeploy(app.ID)
+	if !ok {
+		return ""
+	}
+	defer unlock()
+
+	idle := k8s.OtherColor(active)
+	err := h.db.WithDeployLock(ctx, app.ID, func() error {
+		client, err := h.appClient(ctx, app)
+		if err != nil {
+			return err
+		}
+		state, _, err := client.CheckRollout(ctx, k8s.DeploymentName(app.Name, idle), app.Namespace)
+		if err != nil {
+			return err
+		}
+		if state != k8s.RolloutReady {
+			return fmt.Errorf("idle color %s is %s", idle, state)
+		}
+
+		req := k8s.DeployRequest{Name: app.Name, Namespace: app.Namespace, Port: target.Port}
+		if err := client.SwitchServiceColor(req, idle); err != nil {
+			return fmt.Errorf("switch service: %w", err)
+		}
+
+		// Only now does the app row follow target. If that fails, put the
+		// Service back so the row still describes what is served.
+		if err := h.applyRevision(ctx, app.ID, target); err != nil {
+			if backErr := client.SwitchServiceColor(req, active); backErr != nil {
+				log.Printf("rollback: failed to switch service back app=%s color=%s err=%v", app.ID, active, backErr)
+			}
+			return fmt.Errorf("update app: %w", err)
+		}
+		if err := h.db.RecordDeployedCommit(ctx, app.ID, target.RevisionNumber); err != nil {
+			log.Printf("rollback: failed to record deployed commit app=%s revision=%d err=%v", app.ID, target.RevisionNumber, err)
+		}
+
+		// The color we just left becomes the idle one with a fresh window, so
+		// the rollback can itself be undone with another flip.
+		prev := app.CurrentRevision
+		until := time.Now().Add(blueGreenRetention(app))
+		if err := h.db.SetActiveColor(ctx, app.ID, &idle, &prev, &until); err != nil {
+			log.Printf("rollback: failed to record active color app=%s color=%s err=%v", app.ID, idle, err)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("rollback: color switch unavailable, queueing rollback app=%s color=%s err=%v", app.ID, idle, err)
+		return ""
+	}
+	log.Printf("rollback: switched service app=%s from=%s to=%s revision=%d", app.ID, active, idle, target.RevisionNumber)
+	return idle
+}
+
+// retireIdleColors scales down idle colors whose rollback window has
+// passed. Run by the deploy reconciler on each pass.
+func (h *Handler) retireIdleColors(ctx context.Context) {
+	apps, err := h.db.ListAppsWithExpiredIdleColor(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("reconcile: failed to list expired idle colors err=%v", err)
+		}
+		return
+	}
+	for i := range apps {
+		if ctx.Err() != nil {
+			return
+		}
+		h.retireIdleColor(ctx, &apps[i])
+	}
+}
+
+func (h *Handler) retireIdleColor(ctx context.Context, app *db.App) {
+	unlock, ok := h.tryLockAppDeploy(app.ID)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	active := activeColor(app)
+	if active == "" {
+		h.db.ClearExpiredIdleColor(ctx, app.ID)
+		return
+	}
+	client, err := h.appClient(ctx, app)
+	if err != nil {
+		log.Printf("reconcile: failed to retire idle color app=%s err=%v", app.ID, err)
+		return
+	}
+	won, err := h.db.ClearExpiredIdleColor(ctx, app.ID)
+	if err != nil || !won {
+		return
+	}
+	idle := k8s.OtherColor(active)
+	if err := client.ScaleDeployment(ctx, k8s.DeploymentName(app.Name, idle), app.Namespace, 0); err != nil {
+		log.Printf("reconcile: failed to scale down idle color app=%s color=%s err=%v", app.ID, idle, err)
+		return
+	}
+	log.Printf("reconcile: rollback window over, scaled down idle color app=%s color=%s", app.ID, idle)
+}
@@ -0,0 +1,369 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vigneshsubbiah/shipit/internal/db"
+	"github.com/vigneshsubbiah/shipit/internal/k8s"
+	"github.com/vigneshsubbiah/shipit/internal/metrics"
+	"github.com/vigneshsubbiah/shipit/internal/notify"
+)
+
+const (
+	// canaryPollInterval is how often a canary step checks for an operator
+	// promote/abort while waiting out its interval.
+	canaryPollInterval = 5 * time.Second
+
+	// Bounds for the per-app canary schedule. A step of 100 would skip the
+	// canary entirely; an interval under canaryMinStepInterval leaves the
+	// health check no time to see restarts.
+	canaryMinStepInterval = 10
+	canaryMaxStepInterval = 3600
+)
+
+// canaryUnsupported returns why a canary deploy can't run for app, or ""
+// if it can. Traffic shifting needs the default-URL Ingress (a port and a
+// base domain), and there must be a primary Deployment for the canary to
+// run alongside — a first deploy has nothing to compare against.
+func canaryUnsupported(app *db.App, baseDomain string, primary k8s.RolloutState) string {
+	switch {
+	case app.Port == nil:
+		return "app has no port, so there is no ingress to shift traffic on"
+	case baseDomain == "":
+		return "no app base domain configured, so there is no ingress to shift traffic on"
+	case primary == k8s.RolloutMissing:
+		return "no existing deployment to run the canary alongside"
+	}
+	return ""
+}
+
+// nextCanaryWeight steps the canary's traffic share up by step percent,
+// capped at 100.
+func nextCanaryWeight(weight, step int) int {
+	weight += step
+	if weight > 100 {
+		weight = 100
+	}
+	return weight
+}
+
+// canaryRestarts sums container restarts across the canary's pods.
+func canaryRestarts(status *k8s.DeploymentStatus) int32 {
+	var n int32
+	for _, p := range status.Pods {
+		n += p.Restarts
+	}
+	return n
+}
+
+// canaryHealthy is the check run between canary steps: every desired pod
+// ready, and no container restarts since the canary first became ready
+// (baselineRestarts).
+func canaryHealthy(status *k8s.DeploymentStatus, baselineRestarts int32) error {
+	if status.ReadyReplicas < status.DesiredReplicas {
+		return fmt.Errorf("only %d/%d canary pods ready", status.ReadyReplicas, status.DesiredReplicas)
+	}
+	for _, p := range status.Pods {
+		if !p.Ready {
+			return fmt.Errorf("canary pod %s not ready (phase %s)", p.Name, p.Phase)
+		}
+	}
+	if restarts := canaryRestarts(status) - baselineRestarts; restarts > 0 {
+		return fmt.Errorf("canary pods restarted %d times", restarts)
+	}
+	return nil
+}
+
+// runCanary runs newRevision as the <name>-canary Deployment next to the
+// primary and steps its traffic weight up every CanaryStepInterval seconds,
+// checking the canary pods between steps. Returns true when the canary
+// should be promoted (every step passed, or an operator promoted it early)
+// and the caller should roll the primary Deployment forward. Returns false
+// when the canary was aborted — by a failed check or by an operator — in
+// which case it has been torn down and the revision marked rolled back.
+//
+// Called from deployApp under the per-app deploy lock, so the app status
+// stays "canary" for the whole schedule and the deploy job keeps
+// heartbeating. A server restart mid-canary is settled by the reconciler,
+// which tears the canary down (the primary never changed).
+func (h *Handler) runCanary(ctx context.Context, appID string, app *db.App, client *k8s.Client, req k8s.DeployRequest, newRevision int) bool {
+	step := app.CanaryStepPercent
+	if step <= 0 || step >= 100 {
+		step = 20
+	}
+	interval := time.Duration(app.CanaryStepInterval) * time.Second
+	if interval < canaryMinStepInterval*time.Second {
+		interval = canaryMinStepInterval * time.Second
+	}
+	canaryName := k8s.CanaryName(app.Name)
+
+	abort := func(reason error) bool {
+		h.abortCanary(ctx, appID, app, client, newRevision, reason)
+		return false
+	}
+
+	log.Printf("deploy: canary starting app=%s revision=%d step=%d%% interval=%s", appID, newRevision, step, interval)
+	msg := "canary for revision " + strconv.Itoa(newRevision) + " starting"
+	h.db.UpdateAppStatus(ctx, appID, "canary", &msg)
+
+	var baseline int32 = -1
+	for weight := step; weight < 100; weight = nextCanaryWeight(weight, step) {
+		// Size the canary for the share it is about to take and wait for
+		// the pods before sending them any traffic.
+		if err := client.DeployCanary(req, weight); err != nil {
+			return abort(err)
+		}
+		deadline := client.DeploymentProgressDeadline(ctx, canaryName, app.Namespace) + 10*time.Second
+		watchCtx, cancel := context.WithTimeout(ctx, deadline)
+		err := client.WatchRollout(watchCtx, canaryName, app.Namespace)
+		cancel()
+		if err != nil {
+			return abort(fmt.Errorf("canary did not become ready: %w", err))
+		}
+		if baseline < 0 {
+			status, err := client.GetEnhancedDeploymentStatus(canaryName, app.Namespace)
+			if err != nil {
+				return abort(fmt.Errorf("canary health check failed: %w", err))
+			}
+			baseline = canaryRestarts(status)
+		}
+
+		if err := client.SetCanaryWeight(req, weight); err != nil {
+			return abort(err)
+		}
+		w := weight
+		h.db.SetCanaryWeight(ctx, appID, &w)
+		msg := "canary for revision " + strconv.Itoa(newRevision) + " at " + strconv.Itoa(weight) + "% of traffic"
+		h.db.UpdateAppStatus(ctx, appID, "canary", &msg)
+		log.Printf("deploy: canary weight set app=%s revision=%d weight=%d", appID, newRevision, weight)
+
+		switch h.waitCanaryStep(ctx, appID, interval) {
+		case "promote":
+			log.Printf("deploy: canary promoted by operator app=%s revision=%d weight=%d", appID, newRevision, weight)
+			return true
+		case "abort":
+			return abort(errors.New("canary aborted by operator"))
+		}
+
+		// A failed read counts as a failed check: aborting only withdraws
+		// the canary's traffic, while promoting blind could ship a bad
+		// revision to the whole fleet.
+		status, err := client.GetEnhancedDeploymentStatus(canaryName, app.Namespace)
+		if err != nil {
+			return abort(fmt.Errorf("canary health check failed: %w", err))
+		}
+		if err := canaryHealthy(status, baseline); err != nil {
+			return abort(fmt.Errorf("canary check failed at %d%%: %w", weight, err))
+		}
+	}
+
+	log.Printf("deploy: canary passed every step app=%s revision=%d", appID, newRevision)
+	return true
+}
+
+// waitCanaryStep waits out one canary step, returning early with "promote"
+// or "abort" if an operator requested one (PromoteCanary / AbortCanary may
+// have landed on another replica, so the request is read from the app row).
+// Returns "" when the interval elapsed.
+func (h *Handler) waitCanaryStep(ctx context.Context, appID string, interval time.Duration) string {
+	ticker := time.NewTicker(canaryPollInterval)
+	defer ticker.Stop()
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return "abort"
+		case <-timer.C:
+			return ""
+		case <-ticker.C:
+			app, err := h.db.GetApp(ctx, appID)
+			if err != nil {
+				continue
+			}
+			if app.CanaryAction != nil && *app.CanaryAction != "" {
+				return *app.CanaryAction
+			}
+		}
+	}
+}
+
+// abortCanary withdraws the canary's traffic, deletes it, and records the
+// revision as rolled back. The primary Deployment never left the previous
+// revision (nor did current_revision), so there is nothing to redeploy:
+// going through autoRollback would only add its secret preflight, which
+// can fail an abort that has already succeeded.
+func (h *Handler) abortCanary(ctx context.Context, appID string, app *db.App, client *k8s.Client, newRevision int, reason error) {
+	log.Printf("deploy: canary aborted app=%s revision=%d err=%v", appID, newRevision, reason)
+	h.teardownCanary(ctx, appID, app, client)
+
+	msg := reason.Error()
+	h.timeline(appID, newRevision).fail(phaseCanary, msg)
+	metrics.AutoRollbacksTotal.Inc()
+	h.db.UpdateAppStatus(ctx, appID, "running", nil)
+	h.db.UpdateRevisionStatus(ctx, appID, newRevision, "rolled_back", &msg)
+	h.publish(app, notify.Event{
+		Type:       notify.EventAutoRolledBack,
+		Revision:   newRevision,
+		RollbackTo: app.CurrentRevision,
+		Message:    msg,
+	})
+}
+
+// teardownCanary deletes the canary resources and clears the canary state
+// on the app row. Failures are logged, not returned: a leftover canary
+// Ingress is cleaned up by the next canary deploy or by DeleteApp.
+func (h *Handler) teardownCanary(ctx context.Context, appID string, app *db.App, client *k8s.Client) {
+	if err := client.DeleteCanary(app.Name, app.Namespace); err != nil {
+		log.Printf("deploy: canary teardown failed app=%s err=%v", appID, err)
+	}
+	h.db.SetCanaryWeight(ctx, appID, nil)
+}
+
+// Deploy strategy
+
+func deployStrategyResponse(app *db.App) map[string]interface{} {
+	return map[string]interface{}{
+		"strategy":             app.DeployStrategy,
+		"canary_step_percent":  app.CanaryStepPercent,
+		"canary_step_interval": app.CanaryStepInterval,
+		"canary_weight":        app.CanaryWeight,
+		"blue_green_retention": app.BlueGreenRetention,
+		"active_color":         app.ActiveColor,
+		"idle_revision":        app.IdleRevision,
+		"idle_scale_down_at":   app.IdleScaleDownAt,
+	}
+}
+
+func (h *Handler) GetDeployStrategy(w http.ResponseWriter, r *http.Request) {
+	appID := chi.URLParam(r, "appID")
+
+	app, err := h.db.GetApp(r.Context(), appID)
+	if err != nil {
+		httpError(w, "app not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(deployStrategyResponse(app))
+}
+
+func (h *Handler) SetDeployStrategy(w http.ResponseWriter, r *http.Request) {
+	appID := chi.URLParam(r, "appID")
+
+	existing, err := h.db.GetApp(r.Context(), appID)
+	if err != nil {
+		httpError(w, "app not found", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		Strategy           *string `json:"strategy"`
+		CanaryStepPercent  *int    `json:"canary_step_percent"`
+		CanaryStepInterval *int    `json:"canary_step_interval"`
+		BlueGreenRetention *int    `json:"blue_green_retention"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	params := db.UpdateAppDeployStrategyParams{
+		ID:                 appID,
+		DeployStrategy:     existing.DeployStrategy,
+		CanaryStepPercent:  existing.CanaryStepPercent,
+		CanaryStepInterval: existing.CanaryStepInterval,
+		BlueGreenRetention: existing.BlueGreenRetention,
+	}
+	if req.Strategy != nil {
+		params.DeployStrategy = *req.Strategy
+	}
+	if req.CanaryStepPercent != nil {
+		params.CanaryStepPercent = *req.CanaryStepPercent
+	}
+	if req.CanaryStepInterval != nil {
+		params.CanaryStepInterval = *req.CanaryStepInterval
+	}
+	if req.BlueGreenRetention != nil {
+		params.BlueGreenRetention = *req.BlueGreenRetention
+	}
+
+	switch params.DeployStrategy {
+	case "rolling", "canary":
+	case "blue_green":
+		if reason := blueGreenUnsupported(existing); reason != "" {
+			httpError(w, "blue_green is not available for this app: "+reason, http.StatusBadRequest)
+			return
+		}
+	default:
+		httpError(w, "strategy must be rolling, canary or blue_green", http.StatusBadRequest)
+		return
+	}
+	if params.CanaryStepPercent < 1 || params.CanaryStepPercent > 99 {
+		httpError(w, "canary_step_percent must be between 1 and 99", http.StatusBadRequest)
+		return
+	}
+	if params.CanaryStepInterval < canaryMinStepInterval || params.CanaryStepInterval > canaryMaxStepInterval {
+		httpError(w, fmt.Sprintf("canary_step_interval must be between %d and %d seconds", canaryMinStepInterval, canaryMaxStepInterval), http.StatusBadRequest)
+		return
+	}
+	if params.BlueGreenRetention < blueGreenMinRetention || params.BlueGreenRetention > blueGreenMaxRetention {
+		httpError(w, fmt.Sprintf("blue_green_retention must be between %d and %d seconds", blueGreenMinRetention, blueGreenMaxRetention), http.StatusBadRequest)
+		return
+	}
+
+	app, err := h.db.UpdateAppDeployStrategy(r.Context(), params)
+	if err != nil {
+		httpError(w, "failed to update deploy strategy", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(deployStrategyResponse(app))
+}
+
+// PromoteCanary ends an in-progress canary early and rolls the new revision
+// out to the primary Deployment.
+func (h *Handler) PromoteCanary(w http.ResponseWriter, r *http.Request) {
+	h.requestCanaryAction(w, r, "promote")
+}
+
+// AbortCanary tears an in-progress canary down and rolls back to the
+// current revision.
+func (h *Handler) AbortCanary(w http.ResponseWriter, r *http.Request) {
+	h.requestCanaryAction(w, r, "abort")
+}
+
+// requestCanaryAction records the action for the deploy worker stepping the
+// canary, which picks it up within canaryPollInterval. 202 because the
+// promote/abort itself runs on the worker.
+func (h *Handler) requestCanaryAction(w http.ResponseWriter, r *http.Request, action string) {
+	appID := chi.URLParam(r, "appID")
+
+	if _, err := h.db.GetApp(r.Context(), appID); err != nil {
+		httpError(w, "app not found", http.StatusNotFound)
+		return
+	}
+
+	ok, err := h.db.RequestCanaryAction(r.Context(), appID, action)
+	if err != nil {
+		httpError(w, "failed to "+action+" canary", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		httpError(w, "no canary in progress", http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": action + "_requested",
+	})
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/vigneshsubbiah/shipit/internal/db"
+	"github.com/vigneshsubbiah/shipit/internal/k8s"
+)
+
+func TestNextCanaryWeight(t *testing.T) {
+	weights := []int{}
+	for w := 30; w < 100; w = nextCanaryWeight(w, 30) {
+		weights = append(weights, w)
+	}
+	if len(weights) != 3 || weights[0] != 30 || weights[1] != 60 || weights[2] != 90 {
+		t.Errorf("canary schedule for step 30 = %v, want [30 60 90]", weights)
+	}
+	if got := nextCanaryWeight(90, 30); got != 100 {
+		t.Errorf("nextCanaryWeight(90, 30) = %d, want capped at 100", got)
+	}
+}
+
+func TestCanaryHealthy(t *testing.T) {
+	healthy := &k8s.DeploymentStatus{
+		ReadyReplicas:   2,
+		DesiredReplicas: 2,
+		Pods: []k8s.PodStatus{
+			{Name: "svc-canary-a", Ready: true, Restarts: 1},
+			{Name: "svc-canary-b", Ready: true},
+		},
+	}
+	if err := canaryHealthy(healthy, 1); err != nil {
+		t.Errorf("restarts at baseline should pass: %v", err)
+	}
+
+	cases := []struct {
+		name     string
+		status   *k8s.DeploymentStatus
+		baseline int32
+		want     string
+	}{
+		{
+			name:     "not enough ready",
+			status:   &k8s.DeploymentStatus{ReadyReplicas: 1, DesiredReplicas: 2},
+			baseline: 0,
+			want:     "1/2",
+		},
+		{
+			name: "pod not ready",
+			status: &k8s.DeploymentStatus{
+				ReadyReplicas:   1,
+				DesiredReplicas: 1,
+				Pods:            []k8s.PodStatus{{Name: "svc-canary-a", Phase: "Running"}},
+			},
+			want: "svc-canary-a",
+		},
+		{
+			name:     "restarted since baseline",
+			status:   healthy,
+			baseline: 0,
+			want:     "restarted 1 times",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := canaryHealthy(tc.status, tc.baseline)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("canaryHealthy() = %v, want error containing %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestCanaryUnsupported(t *testing.T) {
+	port := 8080
+	app := &db.App{Port: &port}
+
+	if got := canaryUnsupported(app, "apps.example.com", k8s.RolloutReady); got != "" {
+		t.Errorf("expected canary to be supported, got %q", got)
+	}
+	if got := canaryUnsupported(app, "apps.example.com", k8s.RolloutMissing); got == "" {
+		t.Error("first deploy (no primary) must fall back to a rolling update")
+	}
+	if got := canaryUnsupported(app, "", k8s.RolloutReady); got == "" {
+		t.Error("no base domain must fall back to a rolling update")
+	}
+	if got := canaryUnsupported(&db.App{}, "apps.example.com", k8s.RolloutReady); got == "" {
+		t.Error("no port must fall back to a rolling update")
+	}
+}
+
+func TestBlueGreenUnsupported(t *testing.T) {
+	port := 8080
+	app := &db.App{Port: &port, DeployStrategy: "blue_green"}
+	if got := blueGreenUnsupported(app); got != "" {
+		t.Errorf("expected blue/green to be supported, got %q", got)
+	}
+	if !usesBlueGreen(app) {
+		t.Error("usesBlueGreen = false for a supported blue_green app")
+	}
+	if got := blueGreenUnsupported(&db.App{}); got == "" {
+		t.Error("no port must fall back to a rolling update")
+	}
+	if got := blueGreenUnsupported(&db.App{Port: &port, HPAEnabled: true}); got == "" {
+		t.Error("autoscaled apps must fall back to a rolling update")
+	}
+}
+
+func TestDeploymentNameFollowsActiveColor(t *testing.T) {
+	app := &db.App{Name: "svc"}
+	if got := deploymentName(app); got != "svc" {
+		t.Errorf("deploymentName without color = %q, want svc", got)
+	}
+	green := k8s.ColorGreen
+	app.ActiveColor = &green
+	if got := deploymentName(app); got != "svc-green" {
+		t.Errorf("deploymentName = %q, want svc-green", got)
+	}
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/vigneshsubbiah/shipit/internal/db"
+)
+
+// commitSHAPattern accepts abbreviated and full git commit hashes.
+var commitSHAPattern = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// ciDeployRequest is the optional body of POST /api/apps/{id}/deploy. CI
+// systems send the image they just pushed plus the commit it was built
+// from; an empty body redeploys the app as it is.
+type ciDeployRequest struct {
+	Image string `json:"image"`
+	SHA   string `json:"sha"`
+	Ref   string `json:"ref"`
+	CIURL string `json:"ci_url"`
+}
+
+// parseCIDeployRequest decodes and validates a deploy body. A missing or
+// empty body is a plain redeploy and returns nil.
+func parseCIDeployRequest(body io.Reader) (*ciDeployRequest, error) {
+	var req ciDeployRequest
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, errors.New("invalid request body")
+	}
+	req.Image = strings.TrimSpace(req.Image)
+	req.SHA = strings.ToLower(strings.TrimSpace(req.SHA))
+	req.Ref = strings.TrimPrefix(strings.TrimSpace(req.Ref), "refs/heads/")
+	req.CIURL = strings.TrimSpace(req.CIURL)
+	if req == (ciDeployRequest{}) {
+		return nil, nil
+	}
+
+	if req.Image == "" {
+		return nil, errors.New("image is required when deploying a commit")
+	}
+	if len(req.Image) > 512 || strings.ContainsAny(req.Image, " \t\r\n") {
+		return nil, fmt.Errorf("invalid image %q", req.Image)
+	}
+	if req.SHA != "" && !commitSHAPattern.MatchString(req.SHA) {
+		return nil, errors.New("sha must be a 7-40 character hex commit hash")
+	}
+	if len(req.Ref) > 255 {
+		return nil, errors.New("ref must be at most 255 characters")
+	}
+	if req.CIURL != "" {
+		u, err := url.Parse(req.CIURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(req.CIURL) > 1024 {
+			return nil, errors.New("ci_url must be an http(s) URL")
+		}
+	}
+	return &req, nil
+}
+
+// enqueueParams maps the request onto the deploy job: the image is written
+// to the app row and the job is queued in one transaction, reserving the
+// revision number it will ship as.
+func (req *ciDeployRequest) enqueueParams(appID string, requestedBy *string) db.EnqueueDeployJobParams {
+	p := db.EnqueueDeployJobParams{
+		AppID:       appID,
+		Kind:        "deploy",
+		RequestedBy: requestedBy,
+		Image:       &req.Image,
+	}
+	if req.SHA != "" {
+		p.CommitSHA = &req.SHA
+	}
+	if req.Ref != "" {
+		p.CommitRef = &req.Ref
+	}
+	if req.CIURL != "" {
+		p.CIURL = &req.CIURL
+	}
+	return p
+}
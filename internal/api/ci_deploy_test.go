@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vigneshsubbiah/shipit/internal/db"
+)
+
+func TestParseCIDeployRequest_PlainRedeploy(t *testing.T) {
+	for _, body := range []string{"", "{}", `{"image": "  "}`} {
+		req, err := parseCIDeployRequest(strings.NewReader(body))
+		if err != nil || req != nil {
+			t.Errorf("body %q = %+v, %v; want nil, nil", body, req, err)
+		}
+	}
+}
+
+func TestParseCIDeployRequest_Normalizes(t *testing.T) {
+	req, err := parseCIDeployRequest(strings.NewReader(`{
+		"image": "ghcr.io/org/web:9c2d1b8",
+		"sha": "9C2D1B8E4F7A3C5D6E0B1A2F3C4D5E6F7A8B9C0D",
+		"ref": "refs/heads/main",
+		"ci_url": "https://github.com/org/web/actions/runs/42"
+	}`))
+	if err != nil {
+		t.Fatalf("parseCIDeployRequest: %v", err)
+	}
+	if req.SHA != "9c2d1b8e4f7a3c5d6e0b1a2f3c4d5e6f7a8b9c0d" || req.Ref != "main" {
+		t.Errorf("sha=%q ref=%q, want lowercased sha and bare branch", req.SHA, req.Ref)
+	}
+
+	p := req.enqueueParams("app-1", nil)
+	if p.Kind != "deploy" || *p.Image != req.Image || *p.CommitSHA != req.SHA || *p.CIURL != req.CIURL {
+		t.Errorf("enqueue params = %+v", p)
+	}
+}
+
+func TestParseCIDeployRequest_Rejects(t *testing.T) {
+	cases := map[string]string{
+		"not json":       `{`,
+		"sha only":       `{"sha": "9c2d1b8"}`,
+		"image spaces":   `{"image": "r/app :v1"}`,
+		"short sha":      `{"image": "r/app:v1", "sha": "9c2d1"}`,
+		"non-hex sha":    `{"image": "r/app:v1", "sha": "main-branch"}`,
+		"ci_url scheme":  `{"image": "r/app:v1", "ci_url": "javascript:alert(1)"}`,
+		"ci_url no host": `{"image": "r/app:v1", "ci_url": "https://"}`,
+	}
+	for name, body := range cases {
+		if _, err := parseCIDeployRequest(strings.NewReader(body)); err == nil {
+			t.Errorf("%s: expected an error for %s", name, body)
+		}
+	}
+}
+
+func TestDeployApp_InvalidBodyRejectedBeforeDB(t *testing.T) {
+	// h.db is nil: a bad body must be answered without touching it.
+	h := &Handler{}
+	req := httptest.NewRequest("POST", "/api/apps/app-1/deploy", strings.NewReader(`{"sha": "9c2d1b8"}`))
+	rec := httptest.NewRecorder()
+	h.DeployApp(rec, req)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "image is required") {
+		t.Errorf("got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestJobSource_Deploy(t *testing.T) {
+	h := &Handler{}
+	rev, image, sha, ref := 7, "r/app:abc1234", "abc1234", "main"
+	src := h.jobSource(context.Background(), &db.DeployJob{
+		Kind:           "deploy",
+		RevisionNumber: &rev,
+		Image:          &image,
+		CommitSHA:      &sha,
+		CommitRef:      &ref,
+	})
+	if src.Revision != 7 || src.Image != image || *src.CommitSHA != sha || *src.CommitRef != ref || src.CIURL != nil {
+		t.Errorf("source = %+v", src)
+	}
+
+	if src := h.jobSource(context.Background(), &db.DeployJob{Kind: "deploy"}); src.Revision != 0 || src.CommitSHA != nil {
+		t.Errorf("plain deploy source = %+v, want empty", src)
+	}
+}
@@ -0,0 +1,439 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vigneshsubbiah/shipit/internal/auth"
+	"github.com/vigneshsubbiah/shipit/internal/db"
+)
+
+// Browser-based CLI login (shipit login). The CLI either listens on a
+// loopback port and opens /auth/cli/authorize, which sends a one-time code
+// back to it once the user approves, or (with --device) shows a user code
+// to enter at /auth/device while it polls. Both end with POST
+// /auth/cli/token, which exchanges the code for an expiring user token.
+
+const (
+	cliLoginCodeTTL    = 2 * time.Minute  // loopback authorization codes
+	deviceCodeTTL      = 10 * time.Minute // device codes, while the user signs in
+	devicePollInterval = 5                // seconds between CLI polls
+
+	// defaultCLITokenDays is the lifetime of login tokens when the CLI
+	// doesn't ask for one.
+	defaultCLITokenDays = 30
+
+	// userCodeAlphabet avoids vowels (no accidental words) and look-alike
+	// characters.
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+)
+
+var cliLoginTemplate = template.Must(template.New("cli-login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Shipit CLI login</title>
+<style>
+body { font-family: system-ui, sans-serif; background: #0f1115; color: #e6e6e6; display: flex; justify-content: center; padding-top: 15vh; }
+main { max-width: 24rem; width: 100%; background: #181b21; border: 1px solid #2a2e36; border-radius: 12px; padding: 2rem; }
+h1 { font-size: 1.25rem; margin-top: 0; }
+input { font: inherit; font-family: monospace; letter-spacing: 0.1em; text-transform: uppercase; width: 100%; box-sizing: border-box; padding: 0.5rem; margin: 0.5rem 0 1rem; background: #0f1115; color: inherit; border: 1px solid #2a2e36; border-radius: 6px; }
+button { font: inherit; width: 100%; padding: 0.6rem; background: #6366f1; color: white; border: 0; border-radius: 6px; cursor: pointer; }
+.muted { color: #9ca3af; font-size: 0.875rem; }
+.error { color: #f87171; }
+</style>
+</head>
+<body>
+<main>
+<h1>Sign in to the Shipit CLI</h1>
+{{if .Message}}
+<p>{{.Message}}</p>
+{{else}}
+<p class="muted">Signed in as {{.Email}}. Approving creates an API token with your access for the CLI that started this login.</p>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="post">
+{{if .AskCode}}<label>Code shown in your terminal<input name="user_code" value="{{.UserCode}}" autocomplete="off" autofocus></label>{{end}}
+<button type="submit">Authorize CLI</button>
+</form>
+{{end}}
+</main>
+</body>
+</html>
+`))
+
+type cliLoginPage struct {
+	Email    string
+	AskCode  bool
+	UserCode string
+	Message  string
+	Error    string
+}
+
+func renderCLILoginPage(w http.ResponseWriter, status int, page cliLoginPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := cliLoginTemplate.Execute(w, page); err != nil {
+		log.Printf("auth: rendering CLI login page: %v", err)
+	}
+}
+
+// cliLoginUser returns the signed-in user for a CLI login page. Without a
+// session it sends browsers to the login page, which returns here after
+// sign-in, and returns nil.
+func (h *Handler) cliLoginUser(w http.ResponseWriter, r *http.Request) *db.User {
+	if user := auth.SessionUser(r, h.db); user != nil {
+		return user
+	}
+	if r.Method == http.MethodGet {
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+	} else {
+		renderCLILoginPage(w, http.StatusUnauthorized, cliLoginPage{Message: "Your session has expired. Run shipit login again."})
+	}
+	return nil
+}
+
+// CLIAuthorize asks the signed-in user to approve a loopback CLI login, and
+// on approval redirects to the CLI's loopback address with a one-time code
+// bound to the CLI's PKCE challenge.
+func (h *Handler) CLIAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI, state, challenge := q.Get("redirect_uri"), q.Get("state"), q.Get("code_challenge")
+	if err := validateLoopbackRedirect(redirectURI); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if state == "" || challenge == "" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "state and an S256 code_challenge are required", http.StatusBadRequest)
+		return
+	}
+
+	user := h.cliLoginUser(w, r)
+	if user == nil {
+		return
+	}
+	if r.Method == http.MethodGet {
+		renderCLILoginPage(w, http.StatusOK, cliLoginPage{Email: user.Email})
+		return
+	}
+	if !sameOrigin(r) {
+		http.Error(w, "cross-origin request refused", http.StatusForbidden)
+		return
+	}
+
+	code, err := generateSecureToken()
+	if err != nil {
+		http.Error(w, "failed to generate code", http.StatusInternalServerError)
+		return
+	}
+	_, err = h.db.CreateCLILogin(r.Context(), db.CreateCLILoginParams{
+		CodeHash:      hashToken(code),
+		CodeChallenge: &challenge,
+		UserID:        &user.ID,
+		ExpiresAt:     time.Now().Add(cliLoginCodeTTL),
+	})
+	if err != nil {
+		log.Printf("auth: creating CLI login for %s: %v", user.Email, err)
+		http.Error(w, "failed to create login", http.StatusInternalServerError)
+		return
+	}
+
+	target, _ := url.Parse(redirectURI)
+	params := target.Query()
+	params.Set("code", code)
+	params.Set("state", state)
+	target.RawQuery = params.Encode()
+	http.Redirect(w, r, target.String(), http.StatusSeeOther)
+}
+
+// DeviceCode starts a device login for a CLI that can't open a browser on
+// the same machine.
+func (h *Handler) DeviceCode(w http.ResponseWriter, r *http.Request) {
+	deviceCode, err := generateSecureToken()
+	if err != nil {
+		httpError(w, "failed to generate device code", http.StatusInternalServerError)
+		return
+	}
+	userCode, err := newUserCode()
+	if err != nil {
+		httpError(w, "failed to generate user code", http.StatusInternalServerError)
+		return
+	}
+	_, err = h.db.CreateCLILogin(r.Context(), db.CreateCLILoginParams{
+		CodeHash:  hashToken(deviceCode),
+		UserCode:  &userCode,
+		ExpiresAt: time.Now().Add(deviceCodeTTL),
+	})
+	if err != nil {
+		httpError(w, "failed to create device login", http.StatusInternalServerError)
+		return
+	}
+
+	verificationURI := requestOrigin(r) + "/auth/device"
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"device_code":               deviceCode,
+		"user_code":                 userCode,
+		"verification_uri":          verificationURI,
+		"verification_uri_complete": verificationURI + "?code=" + userCode,
+		"expires_in":                int(deviceCodeTTL.Seconds()),
+		"interval":                  devicePollInterval,
+	})
+}
+
+// DeviceVerify lets the signed-in user approve a device login by entering
+// the code the CLI shows.
+func (h *Handler) DeviceVerify(w http.ResponseWriter, r *http.Request) {
+	user := h.cliLoginUser(w, r)
+	if user == nil {
+		return
+	}
+	page := cliLoginPage{Email: user.Email, AskCode: true}
+
+	if r.Method == http.MethodGet {
+		page.UserCode = r.URL.Query().Get("code")
+		renderCLILoginPage(w, http.StatusOK, page)
+		return
+	}
+	if !sameOrigin(r) {
+		http.Error(w, "cross-origin request refused", http.StatusForbidden)
+		return
+	}
+
+	page.UserCode = r.FormValue("user_code")
+	userCode := normalizeUserCode(page.UserCode)
+	if userCode == "" {
+		page.Error = "Enter the 8-letter code shown in your terminal."
+		renderCLILoginPage(w, http.StatusBadRequest, page)
+		return
+	}
+	approved, err := h.db.ApproveCLILogin(r.Context(), userCode, user.ID)
+	if err != nil {
+		log.Printf("auth: approving device login for %s: %v", user.Email, err)
+		page.Error = "Failed to approve the login, please try again."
+		renderCLILoginPage(w, http.StatusInternalServerError, page)
+		return
+	}
+	if !approved {
+		page.Error = "That code is unknown or has expired. Check your terminal, or run shipit login again."
+		renderCLILoginPage(w, http.StatusBadRequest, page)
+		return
+	}
+	renderCLILoginPage(w, http.StatusOK, cliLoginPage{Message: "The CLI is now signed in as " + user.Email + ". You can close this window."})
+}
+
+// CLIToken exchanges an approved login's code for a user token. It answers
+// "authorization_pending" while a device login awaits approval.
+func (h *Handler) CLIToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		GrantType    string     `json:"grant_type"` // authorization_code or device_code
+		Code         string     `json:"code"`
+		CodeVerifier string     `json:"code_verifier"`
+		DeviceCode   string     `json:"device_code"`
+		Name         string     `json:"name"`
+		ExpiresAt    *time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var code string
+	switch req.GrantType {
+	case "authorization_code":
+		code = req.Code
+	case "device_code":
+		code = req.DeviceCode
+	default:
+		httpError(w, "unsupported_grant_type", http.StatusBadRequest)
+		return
+	}
+	if code == "" {
+		httpError(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	var expiresIn *int
+	if req.ExpiresAt == nil {
+		days := defaultCLITokenDays
+		expiresIn = &days
+	}
+	expTime, err := tokenExpiry(expiresIn, req.ExpiresAt, time.Now())
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	login, err := h.db.ConsumeCLILogin(r.Context(), hashToken(code))
+	if errors.Is(err, sql.ErrNoRows) {
+		httpError(w, h.pendingLoginError(r.Context(), code), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		httpError(w, "failed to complete login", http.StatusInternalServerError)
+		return
+	}
+	if !cliGrantMatches(req.GrantType, req.CodeVerifier, login) {
+		httpError(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.db.GetUserByID(r.Context(), *login.UserID)
+	if err != nil {
+		httpError(w, "failed to load user", http.StatusInternalServerError)
+		return
+	}
+
+	name := req.Name
+	if name == "" {
+		name = "shipit login"
+	}
+	scopes := []string{"admin:*"} // everything the user's roles allow
+	token, rawToken, err := h.issueUserToken(r.Context(), user.ID, name, expTime, scopes, nil)
+	if err != nil {
+		httpError(w, "failed to create token", http.StatusInternalServerError)
+		return
+	}
+
+	// /auth routes aren't behind the Audit middleware; record the new token
+	// against the user who approved it.
+	h.recordAudit(r.WithContext(context.WithValue(r.Context(), auth.UserContextKey, user)), "token.create", http.StatusCreated, &auditEntry{
+		details: map[string]interface{}{
+			"token_id":   token.ID,
+			"name":       token.Name,
+			"expires_at": token.ExpiresAt,
+			"scopes":     scopes,
+			"login":      req.GrantType,
+		},
+	})
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":         token.ID,
+		"name":       token.Name,
+		"token":      rawToken,
+		"expires_at": token.ExpiresAt,
+		"email":      user.Email,
+	})
+}
+
+// pendingLoginError explains why a code can't be exchanged (yet).
+func (h *Handler) pendingLoginError(ctx context.Context, code string) string {
+	login, err := h.db.GetCLILogin(ctx, hashToken(code))
+	switch {
+	case err != nil:
+		return "invalid_grant"
+	case time.Now().After(login.ExpiresAt):
+		return "expired_token"
+	case login.UserID == nil:
+		return "authorization_pending"
+	}
+	return "invalid_grant"
+}
+
+// validateLoopbackRedirect only lets the authorization code go to the
+// machine the browser runs on: http://127.0.0.1, [::1] or localhost, with
+// a port.
+func validateLoopbackRedirect(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "http" || u.Port() == "" {
+		return errors.New("redirect_uri must be http://127.0.0.1:<port>/...")
+	}
+	if host := u.Hostname(); host != "localhost" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			return errors.New("redirect_uri must be a loopback address")
+		}
+	}
+	return nil
+}
+
+// cliGrantMatches reports whether a consumed login may be exchanged with
+// this grant: the grant type must be the one the login was started with,
+// and a login started with a PKCE challenge needs its verifier whichever
+// grant type is claimed, so an intercepted loopback code can't be posted
+// back as a device code.
+func cliGrantMatches(grantType, verifier string, login *db.CLILogin) bool {
+	switch grantType {
+	case "authorization_code":
+		if login.CodeChallenge == nil {
+			return false
+		}
+	case "device_code":
+		if login.UserCode == nil {
+			return false
+		}
+	default:
+		return false
+	}
+	return login.CodeChallenge == nil || verifyPKCE(verifier, *login.CodeChallenge)
+}
+
+func verifyPKCE(verifier, challenge string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier != "" && base64.RawURLEncoding.EncodeToString(sum[:]) == challenge
+}
+
+// newUserCode returns a device login code such as "BDFG-HJKL".
+func newUserCode() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := make([]byte, 0, 9)
+	for i, c := range b {
+		if i == 4 {
+			code = append(code, '-')
+		}
+		code = append(code, userCodeAlphabet[int(c)%len(userCodeAlphabet)])
+	}
+	return string(code), nil
+}
+
+// normalizeUserCode accepts a user code typed in any case, with or without
+// the dash, and returns it as stored, or "" if it can't be one.
+func normalizeUserCode(s string) string {
+	var letters []byte
+	for _, c := range []byte(strings.ToUpper(s)) {
+		switch {
+		case strings.IndexByte(userCodeAlphabet, c) >= 0:
+			letters = append(letters, c)
+		case c == '-' || c == ' ':
+		default:
+			return ""
+		}
+	}
+	if len(letters) != 8 {
+		return ""
+	}
+	return string(letters[:4]) + "-" + string(letters[4:])
+}
+
+// sameOrigin rejects form posts from other sites. Browsers send Origin on
+// every POST.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// requestOrigin is the scheme and host the client used to reach the server.
+func requestOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
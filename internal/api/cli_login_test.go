@@ -0,0 +1,153 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vigneshsubbiah/shipit/internal/db"
+)
+
+func TestValidateLoopbackRedirect(t *testing.T) {
+	tests := []struct {
+		uri string
+		ok  bool
+	}{
+		{"http://127.0.0.1:53682/callback", true},
+		{"http://[::1]:53682/callback", true},
+		{"http://localhost:8085/", true},
+		{"http://127.0.0.1/callback", false},        // no port
+		{"https://127.0.0.1:53682/callback", false}, // loopback is plain http
+		{"http://evil.com:53682/callback", false},
+		{"http://10.0.0.5:53682/callback", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if err := validateLoopbackRedirect(tt.uri); (err == nil) != tt.ok {
+			t.Errorf("validateLoopbackRedirect(%q) = %v, want ok=%v", tt.uri, err, tt.ok)
+		}
+	}
+}
+
+func TestUserCode(t *testing.T) {
+	code, err := newUserCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 9 || code[4] != '-' || normalizeUserCode(code) != code {
+		t.Errorf("newUserCode() = %q", code)
+	}
+
+	tests := []struct {
+		in, want string
+	}{
+		{"BCDF-GHJK", "BCDF-GHJK"},
+		{"bcdfghjk", "BCDF-GHJK"},
+		{" bcdf ghjk ", "BCDF-GHJK"},
+		{"BCDF-GHJ", ""},   // too short
+		{"BCDF-GHJA", ""},  // vowels are never issued
+		{"BCDF-GHJK1", ""}, // digits neither
+	}
+	for _, tt := range tests {
+		if got := normalizeUserCode(tt.in); got != tt.want {
+			t.Errorf("normalizeUserCode(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyPKCE(t *testing.T) {
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	if !verifyPKCE(verifier, challenge) {
+		t.Error("matching verifier rejected")
+	}
+	if verifyPKCE("other", challenge) || verifyPKCE("", challenge) {
+		t.Error("wrong verifier accepted")
+	}
+}
+
+func TestCLIGrantMatches(t *testing.T) {
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	userCode := "BDFG-HJKL"
+	loopback := &db.CLILogin{CodeChallenge: &challenge}
+	device := &db.CLILogin{UserCode: &userCode}
+
+	tests := []struct {
+		name      string
+		grantType string
+		verifier  string
+		login     *db.CLILogin
+		want      bool
+	}{
+		{"loopback code with verifier", "authorization_code", verifier, loopback, true},
+		{"loopback code without verifier", "authorization_code", "", loopback, false},
+		{"loopback code posted as device code", "device_code", "", loopback, false},
+		{"loopback code posted as device code with verifier", "device_code", verifier, loopback, false},
+		{"device code", "device_code", "", device, true},
+		{"device code posted as authorization code", "authorization_code", verifier, device, false},
+	}
+	for _, tt := range tests {
+		if got := cliGrantMatches(tt.grantType, tt.verifier, tt.login); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCLIAuthorizeRejectsBadRequests(t *testing.T) {
+	h := NewHandler(nil, nil, "", "", nil, nil, nil, nil, nil)
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"remote redirect", "redirect_uri=http://evil.com:80/cb&state=s&code_challenge=c&code_challenge_method=S256"},
+		{"no state", "redirect_uri=http://127.0.0.1:5000/cb&code_challenge=c&code_challenge_method=S256"},
+		{"plain PKCE", "redirect_uri=http://127.0.0.1:5000/cb&state=s&code_challenge=c&code_challenge_method=plain"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.CLIAuthorize(w, httptest.NewRequest("GET", "/auth/cli/authorize?"+tt.query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d", tt.name, w.Code)
+		}
+	}
+}
+
+func TestCLITokenRejectsBadGrants(t *testing.T) {
+	h := NewHandler(nil, nil, "", "", nil, nil, nil, nil, nil)
+	tests := []struct {
+		body, want string
+	}{
+		{`{"grant_type":"password"}`, "unsupported_grant_type"},
+		{`{"grant_type":"device_code"}`, "invalid_request"},
+		{`{"grant_type":"authorization_code","code":"x","expires_at":"2000-01-01T00:00:00Z"}`, "expires_at must be in the future"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.CLIToken(w, httptest.NewRequest("POST", "/auth/cli/token", strings.NewReader(tt.body)))
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("%s: status %d, body %s", tt.body, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestSameOrigin(t *testing.T) {
+	r := httptest.NewRequest("POST", "http://shipit.test/auth/device", nil)
+	if !sameOrigin(r) {
+		t.Error("request without Origin rejected")
+	}
+	r.Header.Set("Origin", "http://shipit.test")
+	if !sameOrigin(r) {
+		t.Error("same-origin request rejected")
+	}
+	r.Header.Set("Origin", "https://evil.com")
+	if sameOrigin(r) {
+		t.Error("cross-origin request accepted")
+	}
+}
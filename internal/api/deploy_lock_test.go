@@ -88,3 +88,26 @@ func TestLockAppDeploy_ReusesMutexPerAppID(t *testing.T) {
 		t.Errorf("mutex for appID %q was replaced between calls: %p → %p", appID, m1, m2)
 	}
 }
+
+// tryLockAppDeploy must report an in-flight deploy instead of blocking, and
+// succeed again once that deploy releases the lock.
+func TestTryLockAppDeploy_SkipsInFlightDeploy(t *testing.T) {
+	h := &Handler{}
+	unlock := h.lockAppDeploy("app-1")
+
+	if _, ok := h.tryLockAppDeploy("app-1"); ok {
+		t.Fatal("expected tryLockAppDeploy to fail while a deploy holds the lock")
+	}
+	if u, ok := h.tryLockAppDeploy("app-2"); !ok {
+		t.Fatal("expected a different app to be lockable")
+	} else {
+		u()
+	}
+
+	unlock()
+	u, ok := h.tryLockAppDeploy("app-1")
+	if !ok {
+		t.Fatal("expected tryLockAppDeploy to succeed after unlock")
+	}
+	u()
+}
@@ -0,0 +1,359 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vigneshsubbiah/shipit/internal/auth"
+	"github.com/vigneshsubbiah/shipit/internal/db"
+	"github.com/vigneshsubbiah/shipit/internal/metrics"
+)
+
+const (
+	// deployJobPollInterval is how often an idle worker checks the queue for
+	// jobs enqueued on other replicas. Local enqueues wake a worker directly.
+	deployJobPollInterval = 2 * time.Second
+
+	// deployJobHeartbeatInterval / deployJobStaleAfter: a running job whose
+	// worker hasn't heartbeated for deployJobStaleAfter is presumed dead (the
+	// process crashed or was restarted mid-deploy) and gets requeued. The
+	// stale window is several heartbeats wide so a slow DB write doesn't
+	// cause a live deploy to be run twice.
+	deployJobHeartbeatInterval = 15 * time.Second
+	deployJobStaleAfter        = 2 * time.Minute
+	deployJobSweepInterval     = 30 * time.Second
+
+	// deployJobMaxAttempts bounds how many times a job is retried after its
+	// worker disappears. A deploy that reliably takes down the server (OOM
+	// on a huge pre-deploy log, say) must not loop forever.
+	deployJobMaxAttempts = 3
+)
+
+// enqueueDeploy persists a deploy job and wakes a local worker.
+func (h *Handler) enqueueDeploy(ctx context.Context, p db.EnqueueDeployJobParams) (*db.DeployJob, error) {
+	job, err := h.db.EnqueueDeployJob(ctx, p)
+	if err != nil {
+		log.Printf("deploy: enqueue failed app=%s kind=%s err=%v", p.AppID, p.Kind, err)
+		return nil, err
+	}
+	log.Printf("deploy: queued app=%s kind=%s job=%s", p.AppID, p.Kind, job.ID)
+	select {
+	case h.deployWake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// RunDeployWorkers starts n deploy workers plus the stale-job sweeper and
+// blocks until ctx is cancelled. Workers stop claiming new jobs once ctx is
+// done; a deploy already in flight keeps running on its own background
+// context; if the process exits before it finishes, its heartbeat stops
+// and the sweeper on a surviving (or restarted) replica requeues it.
+func (h *Handler) RunDeployWorkers(ctx context.Context, n int) {
+	if n <= 0 {
+		n = 1
+	}
+	base := deployWorkerBaseID()
+	log.Printf("deploy: starting %d deploy workers id=%s", n, base)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(workerID string) {
+			defer wg.Done()
+			h.deployWorker(ctx, workerID)
+		}(fmt.Sprintf("%s-%d", base, i))
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h.sweepStaleDeployJobs(ctx)
+	}()
+
+	wg.Wait()
+}
+
+func (h *Handler) deployWorker(ctx context.Context, workerID string) {
+	ticker := time.NewTicker(deployJobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Drain the queue before going back to sleep.
+		for ctx.Err() == nil {
+			job, err := h.db.ClaimDeployJob(ctx, workerID)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("deploy: claim failed worker=%s err=%v", workerID, err)
+				}
+				break
+			}
+			if job == nil {
+				break
+			}
+			h.runDeployJob(job, workerID)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.deployWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Handler) sweepStaleDeployJobs(ctx context.Context) {
+	ticker := time.NewTicker(deployJobSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := h.db.RequeueStaleDeployJobs(ctx, deployJobStaleAfter, deployJobMaxAttempts)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("deploy: stale job sweep failed err=%v", err)
+		} else if n > 0 {
+			log.Printf("deploy: recovered %d stale deploy jobs", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runDeployJob executes one claimed job to completion and records its
+// terminal status. It deliberately uses a background context: a deploy
+// that has started applying manifests should finish (or auto-rollback)
+// rather than be abandoned half-way because the server is shutting down.
+func (h *Handler) runDeployJob(job *db.DeployJob, workerID string) {
+	ctx := context.Background()
+	log.Printf("deploy: job started job=%s app=%s kind=%s attempt=%d worker=%s", job.ID, job.AppID, job.Kind, job.Attempts, workerID)
+
+	defer h.heartbeatDeployJob(ctx, job.ID, workerID)()
+
+	fail := func(msg string) {
+		log.Printf("deploy: job failed job=%s app=%s err=%s", job.ID, job.AppID, msg)
+		h.db.UpdateAppStatus(ctx, job.AppID, "failed", &msg)
+		h.db.FinishDeployJob(ctx, job.ID, "failed", nil, &msg)
+	}
+
+	app, err := h.db.GetApp(ctx, job.AppID)
+	if err != nil {
+		// App was deleted after the job was queued. ON DELETE CASCADE
+		// normally removes the job too; this covers the race.
+		msg := "app not found"
+		h.db.FinishDeployJob(ctx, job.ID, "failed", nil, &msg)
+		return
+	}
+	cluster, err := h.db.GetCluster(ctx, app.ClusterID)
+	if err != nil {
+		fail("cluster not found: " + err.Error())
+		return
+	}
+	kubeconfig, err := h.keys.Decrypt(cluster.KubeconfigEncrypted)
+	if err != nil {
+		fail("failed to decrypt kubeconfig: " + err.Error())
+		return
+	}
+
+	startStatus := "deploying"
+	if job.Kind == "rollback" {
+		startStatus = "rolling_back"
+		// CurrentRevision must point at the target BEFORE deployApp runs.
+		// If we leave it at the broken revision, a subsequent watch-timeout
+		// would invoke autoRollback, which reads app.CurrentRevision as the
+		// rollback target — and redeploys the very revision the user was
+		// escaping from. Done here rather than in RollbackApp so nothing
+		// else is deploying the app while its row changes.
+		if job.TargetRevision != nil {
+			target, err := h.db.GetRevision(ctx, app.ID, *job.TargetRevision)
+			if err != nil {
+				fail("rollback target revision not found: " + err.Error())
+				return
+			}
+			if err := h.applyRevision(ctx, app.ID, target); err != nil {
+				fail("failed to update app configuration: " + err.Error())
+				return
+			}
+		}
+	}
+	h.db.UpdateAppStatus(ctx, app.ID, startStatus, nil)
+
+	revisionNumber := h.deployApp(app.ID, app, kubeconfig, h.jobSource(ctx, job))
+
+	status := h.finishDeployJob(ctx, job, app.ID, revisionNumber)
+	log.Printf("deploy: job finished job=%s app=%s revision=%d status=%s", job.ID, job.AppID, revisionNumber, status)
+}
+
+// heartbeatDeployJob keeps a running job's heartbeat fresh, so that
+// neither the stale-job sweeper nor another replica's reconciler takes it
+// over, until the returned stop function is called.
+func (h *Handler) heartbeatDeployJob(ctx context.Context, jobID, workerID string) (stop func()) {
+	hbCtx, stop := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(deployJobHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-hbCtx.Done():
+				return
+			case <-ticker.C:
+				if err := h.db.HeartbeatDeployJob(hbCtx, jobID, workerID); err != nil && hbCtx.Err() == nil {
+					log.Printf("deploy: heartbeat failed job=%s err=%v", jobID, err)
+				}
+			}
+		}
+	}()
+	return stop
+}
+
+// deploySource is what a deploy records about where its image came from,
+// carried from the job onto the revision deployApp creates. Revision is the
+// number reserved when the job was queued, or 0 to allocate one then.
+type deploySource struct {
+	Revision  int
+	Image     string // image the source describes; ignored if the app moved on
+	CommitSHA *string
+	CommitRef *string
+	CIURL     *string
+	// Digest Image was deployed at before; set for rollbacks so the
+	// target revision's exact image is redeployed rather than whatever
+	// its tag points to now.
+	Digest string
+	// RollbackTo is the target revision of a manual rollback and
+	// RequestedBy who asked for the deploy; both only label notifications.
+	RollbackTo  int
+	RequestedBy *string
+	// JobID is the deploy job running the deploy, which is told the
+	// revision as soon as it exists.
+	JobID string
+}
+
+// jobSource returns the deploy source for a job. A rollback ships the
+// target revision's image again, so it inherits that revision's commit.
+func (h *Handler) jobSource(ctx context.Context, job *db.DeployJob) deploySource {
+	src := deploySource{RequestedBy: job.RequestedBy, JobID: job.ID}
+	if job.RevisionNumber != nil {
+		src.Revision = *job.RevisionNumber
+	}
+	if job.Kind == "rollback" && job.TargetRevision != nil {
+		src.RollbackTo = *job.TargetRevision
+		if target, err := h.db.GetRevision(ctx, job.AppID, *job.TargetRevision); err == nil {
+			src.Image = target.Image
+			src.CommitSHA, src.CommitRef, src.CIURL = target.CommitSHA, target.CommitRef, target.CIURL
+			if target.ImageDigest != nil {
+				src.Digest = *target.ImageDigest
+			}
+		}
+		return src
+	}
+	if job.Image != nil {
+		src.Image = *job.Image
+	}
+	src.CommitSHA, src.CommitRef, src.CIURL = job.CommitSHA, job.CommitRef, job.CIURL
+	return src
+}
+
+// finishDeployJob records a job's terminal status. deployApp (and the
+// reconciler, for jobs it takes over) record their outcome on the app row
+// and the revision; read it back rather than threading a result through
+// every return path. Returns the status written.
+func (h *Handler) finishDeployJob(ctx context.Context, job *db.DeployJob, appID string, revisionNumber int) string {
+	finalApp, _ := h.db.GetApp(ctx, appID)
+	var rev *db.AppRevision
+	var revPtr *int
+	if revisionNumber > 0 {
+		revPtr = &revisionNumber
+		if r, err := h.db.GetRevision(ctx, appID, revisionNumber); err == nil {
+			rev = r
+		}
+	}
+	status, msg := deployJobOutcome(finalApp, rev)
+	if err := h.db.FinishDeployJob(ctx, job.ID, status, revPtr, msg); err != nil {
+		log.Printf("deploy: failed to record job result job=%s err=%v", job.ID, err)
+	}
+	metrics.DeploysTotal.WithLabelValues(job.Kind, deployOutcomeLabel(status)).Inc()
+	return status
+}
+
+// deployOutcomeLabel is the shipit_deploys_total outcome for a terminal
+// job status.
+func deployOutcomeLabel(status string) string {
+	if status == "succeeded" {
+		return "success"
+	}
+	return status
+}
+
+// deployJobOutcome maps the state deployApp left behind to a terminal job
+// status. rev is nil when deployApp failed before allocating a revision.
+func deployJobOutcome(app *db.App, rev *db.AppRevision) (string, *string) {
+	if rev == nil {
+		msg := "deploy failed before a revision was created"
+		if app != nil && app.StatusMessage != nil && *app.StatusMessage != "" {
+			msg = *app.StatusMessage
+		}
+		return "failed", &msg
+	}
+	switch rev.DeployStatus {
+	case "success":
+		return "succeeded", nil
+	case "rolled_back":
+		return "rolled_back", rev.DeployMessage
+	default:
+		if rev.DeployMessage != nil {
+			return "failed", rev.DeployMessage
+		}
+		if app != nil && app.StatusMessage != nil {
+			return "failed", app.StatusMessage
+		}
+		msg := "deploy failed"
+		return "failed", &msg
+	}
+}
+
+// deployWorkerBaseID identifies this process in deploy_jobs.worker_id so
+// operators can tell which replica ran (or abandoned) a job.
+func deployWorkerBaseID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "shipit"
+	}
+	b := make([]byte, 3)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b))
+}
+
+// requestedBy returns the authenticated user's email for attribution on
+// queued jobs, or nil for legacy API tokens.
+func requestedBy(r *http.Request) *string {
+	if user := auth.GetUser(r.Context()); user != nil {
+		email := user.Email
+		return &email
+	}
+	return nil
+}
+
+// GetDeployJob returns a queued/running/finished deploy job so callers of
+// POST /api/apps/{id}/deploy can poll for the result.
+func (h *Handler) GetDeployJob(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "deployID")
+
+	job, err := h.db.GetDeployJob(r.Context(), jobID)
+	if err != nil {
+		httpError(w, "deploy not found", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(job)
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/vigneshsubbiah/shipit/internal/db"
+)
+
+func strPtr(s string) *string { return &s }
+
+// deployJobOutcome must map every terminal state deployApp can leave behind
+// to the right job status, and carry the most specific error message.
+func TestDeployJobOutcome(t *testing.T) {
+	cases := []struct {
+		name       string
+		app        *db.App
+		rev        *db.AppRevision
+		wantStatus string
+		wantMsg    string
+	}{
+		{
+			name:       "success",
+			app:        &db.App{Status: "running"},
+			rev:        &db.AppRevision{DeployStatus: "success"},
+			wantStatus: "succeeded",
+		},
+		{
+			name:       "auto rolled back",
+			app:        &db.App{Status: "running"},
+			rev:        &db.AppRevision{DeployStatus: "rolled_back", DeployMessage: strPtr("rollout did not become ready: timeout")},
+			wantStatus: "rolled_back",
+			wantMsg:    "rollout did not become ready: timeout",
+		},
+		{
+			name:       "failed with revision message",
+			app:        &db.App{Status: "failed", StatusMessage: strPtr("app message")},
+			rev:        &db.AppRevision{DeployStatus: "failed", DeployMessage: strPtr("pre-deploy hook failed")},
+			wantStatus: "failed",
+			wantMsg:    "pre-deploy hook failed",
+		},
+		{
+			name:       "failed falls back to app message",
+			app:        &db.App{Status: "failed", StatusMessage: strPtr("app message")},
+			rev:        &db.AppRevision{DeployStatus: "failed"},
+			wantStatus: "failed",
+			wantMsg:    "app message",
+		},
+		{
+			name:       "failed before revision allocated",
+			app:        &db.App{Status: "failed", StatusMessage: strPtr("failed to allocate revision number: boom")},
+			rev:        nil,
+			wantStatus: "failed",
+			wantMsg:    "failed to allocate revision number: boom",
+		},
+		{
+			name:       "no revision and app vanished",
+			app:        nil,
+			rev:        nil,
+			wantStatus: "failed",
+			wantMsg:    "deploy failed before a revision was created",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, msg := deployJobOutcome(tc.app, tc.rev)
+			if status != tc.wantStatus {
+				t.Errorf("status: want %q, got %q", tc.wantStatus, status)
+			}
+			got := ""
+			if msg != nil {
+				got = *msg
+			}
+			if got != tc.wantMsg {
+				t.Errorf("message: want %q, got %q", tc.wantMsg, got)
+			}
+		})
+	}
+}
+
+// A local enqueue must never block on the wake channel, whether or not a
+// worker is idle to receive it.
+func TestDeployWake_NonBlocking(t *testing.T) {
+	h := NewHandler(nil, nil, "", "", nil, nil, nil, nil, nil)
+	for i := 0; i < 3; i++ {
+		select {
+		case h.deployWake <- struct{}{}:
+		default:
+		}
+	}
+	if len(h.deployWake) != 1 {
+		t.Errorf("expected a single pending wake-up, got %d", len(h.deployWake))
+	}
+}
@@ -44,13 +44,14 @@ func buildDeployRequestFromRevision(app *db.App, rev *db.AppRevision, baseDomain
 	req := k8s.DeployRequest{
 		Name:       app.Name,
 		Namespace:  app.Namespace,
-		Image:      rev.Image,
+		Image:      revisionImage(rev),
 		Replicas:   int32(rev.Replicas),
 		Port:       rev.Port,
 		EnvVars:    envVars,
 		SecretName: secretName,
 		BaseDomain: baseDomain,
 		HPAEnabled: rev.HPAEnabled,
+		Revision:   rev.RevisionNumber,
 	}
 	if rev.CPURequest != nil {
 		req.CPURequest = *rev.CPURequest
@@ -0,0 +1,259 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vigneshsubbiah/shipit/internal/db"
+	"github.com/vigneshsubbiah/shipit/internal/k8s"
+)
+
+const (
+	// deployStreamInterval is how often StreamDeploy polls the job, its
+	// revision's timeline and the rollout.
+	deployStreamInterval = time.Second
+
+	// deployStreamKeepalive is how often an otherwise idle stream sends a
+	// comment, so proxies don't close it during a long pre-deploy hook.
+	deployStreamKeepalive = 15 * time.Second
+
+	// deployLogDrainTimeout bounds how long a finished deploy's stream
+	// waits for the rest of the pre-deploy hook's output.
+	deployLogDrainTimeout = 5 * time.Second
+)
+
+// deployStreamEvent is one message of StreamDeploy's event stream. Type
+// says which of the other fields are set:
+//   - job: Status (and Revision, once allocated) when the job changes
+//   - phase: Event, a timeline row (a phase starting or ending, or a new
+//     pod becoming ready)
+//   - log: Log, a line of the pre-deploy hook's output
+//   - rollout: Rollout, the new ReplicaSet's ready/desired counts while
+//     the rollout is verified
+//   - done: the job's final Status, Revision and Error; the stream ends
+type deployStreamEvent struct {
+	Type     string               `json:"type"`
+	Status   string               `json:"status,omitempty"`
+	Revision int                  `json:"revision,omitempty"`
+	Error    *string              `json:"error,omitempty"`
+	Event    *db.DeployEvent      `json:"event,omitempty"`
+	Log      *k8s.LogLine         `json:"log,omitempty"`
+	Rollout  *k8s.RolloutProgress `json:"rollout,omitempty"`
+}
+
+// deployJobFinished reports whether a deploy job status is terminal.
+func deployJobFinished(status string) bool {
+	switch status {
+	case "succeeded", "failed", "rolled_back", "superseded":
+		return true
+	}
+	return false
+}
+
+// StreamDeploy follows a deploy job as server-sent events until it
+// finishes: status changes, its revision's timeline as phases start and
+// end, the pre-deploy hook's output and the new ReplicaSet's ready count.
+// Everything is read back from the database and the cluster, so a stream
+// served by one replica follows a deploy running on another.
+func (h *Handler) StreamDeploy(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	job, err := h.db.GetDeployJob(ctx, chi.URLParam(r, "deployID"))
+	if err != nil {
+		httpError(w, "deploy not found", http.StatusNotFound)
+		return
+	}
+	app, err := h.db.GetApp(ctx, job.AppID)
+	if err != nil {
+		httpError(w, "app not found", http.StatusNotFound)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	lastWrite := time.Now()
+	send := func(e deployStreamEvent) {
+		data, _ := json.Marshal(e)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+		lastWrite = time.Now()
+	}
+
+	// The cluster only adds hook output and rollout counts; without it
+	// the stream still follows the job and its timeline.
+	client, err := h.appClient(ctx, app)
+	if err != nil {
+		log.Printf("deploy stream: no cluster client app=%s err=%v", app.ID, err)
+	}
+
+	f := &deployFollower{client: client, sent: make(map[string]bool)}
+	defer f.stop()
+
+	ticker := time.NewTicker(deployStreamInterval)
+	defer ticker.Stop()
+	status := ""
+	for {
+		current, err := h.db.GetDeployJob(ctx, job.ID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("deploy stream: failed to read job job=%s err=%v", job.ID, err)
+		} else {
+			job = current
+			revision := 0
+			if job.RevisionNumber != nil {
+				revision = *job.RevisionNumber
+			}
+			if job.Status != status || revision != f.revision {
+				status = job.Status
+				f.revision = revision
+				send(deployStreamEvent{Type: "job", Status: status, Revision: revision})
+			}
+
+			// Read the timeline after the job, so a finished job's
+			// timeline is complete.
+			if revision > 0 {
+				events, err := h.db.ListDeployEvents(ctx, app.ID, revision)
+				if err != nil && ctx.Err() == nil {
+					log.Printf("deploy stream: failed to read timeline app=%s revision=%d err=%v", app.ID, revision, err)
+				}
+				for i := range events {
+					if f.sent[events[i].ID] {
+						continue
+					}
+					f.sent[events[i].ID] = true
+					send(deployStreamEvent{Type: "phase", Event: &events[i]})
+					f.phaseEvent(ctx, h, app, &events[i])
+				}
+			}
+
+			if f.verifying != "" {
+				if p, ok := f.rolloutProgress(ctx, app.Namespace); ok {
+					send(deployStreamEvent{Type: "rollout", Rollout: &p})
+				}
+			}
+
+			if deployJobFinished(job.Status) {
+				f.drainLogs(func(line k8s.LogLine) {
+					send(deployStreamEvent{Type: "log", Log: &line})
+				})
+				send(deployStreamEvent{Type: "done", Status: job.Status, Revision: revision, Error: job.Error})
+				return
+			}
+		}
+
+		if time.Since(lastWrite) >= deployStreamKeepalive {
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+			lastWrite = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case line, ok := <-f.logs:
+			if !ok {
+				f.logs = nil
+				continue
+			}
+			send(deployStreamEvent{Type: "log", Log: &line})
+		case <-ticker.C:
+		}
+	}
+}
+
+// deployFollower is StreamDeploy's state between polls.
+type deployFollower struct {
+	client   *k8s.Client
+	revision int
+	sent     map[string]bool // timeline events already sent, by id
+
+	// logs carries the pre-deploy hook's output while it runs.
+	logs     <-chan k8s.LogLine
+	stopLogs context.CancelFunc
+
+	// verifying is the Deployment whose rollout is being verified, and
+	// last the counts most recently sent for it.
+	verifying string
+	last      k8s.RolloutProgress
+}
+
+// phaseEvent starts following the hook's output when the predeploy phase
+// starts, and the rollout while the verifying phase runs.
+func (f *deployFollower) phaseEvent(ctx context.Context, h *Handler, app *db.App, e *db.DeployEvent) {
+	if f.client == nil {
+		return
+	}
+	switch {
+	case e.Phase == phasePredeploy && e.Status == eventStarted && f.logs == nil:
+		logCtx, cancel := context.WithCancel(ctx)
+		f.logs, f.stopLogs = f.client.FollowPreDeployLogs(logCtx, app.Name, app.Namespace, e.OccurredAt), cancel
+	case e.Phase == phaseVerifying && e.Status == eventStarted:
+		// A blue/green deploy verifies the idle color; read the app
+		// again, as the deploy may have just moved it onto colors.
+		fresh, err := h.db.GetApp(ctx, app.ID)
+		if err != nil {
+			fresh = app
+		}
+		f.verifying = deploymentName(fresh)
+		if usesBlueGreen(fresh) {
+			f.verifying = k8s.DeploymentName(fresh.Name, k8s.OtherColor(activeColor(fresh)))
+		}
+	case e.Phase == phaseVerifying && (e.Status == eventSucceeded || e.Status == eventFailed):
+		f.verifying = ""
+	}
+}
+
+// rolloutProgress reads the verified rollout's counts, reporting whether
+// they changed since the last call.
+func (f *deployFollower) rolloutProgress(ctx context.Context, namespace string) (k8s.RolloutProgress, bool) {
+	p, err := f.client.GetRolloutProgress(ctx, f.verifying, namespace)
+	if err != nil {
+		return p, false
+	}
+	if p.Desired == f.last.Desired && p.Updated == f.last.Updated && p.Ready == f.last.Ready {
+		return p, false
+	}
+	f.last = p
+	return p, true
+}
+
+// drainLogs passes on what is left of the hook's output once the job has
+// finished. The hook has exited by then, so its log ends shortly; a
+// stream that doesn't (the pod was deleted mid-read) is cut off.
+func (f *deployFollower) drainLogs(fn func(k8s.LogLine)) {
+	if f.logs == nil {
+		return
+	}
+	timeout := time.After(deployLogDrainTimeout)
+	for {
+		select {
+		case line, ok := <-f.logs:
+			if !ok {
+				return
+			}
+			fn(line)
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func (f *deployFollower) stop() {
+	if f.stopLogs != nil {
+		f.stopLogs()
+	}
+}
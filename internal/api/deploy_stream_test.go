@@ -0,0 +1,39 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/vigneshsubbiah/shipit/internal/k8s"
+)
+
+func TestDeployJobFinished(t *testing.T) {
+	for status, want := range map[string]bool{
+		"queued":      false,
+		"running":     false,
+		"succeeded":   true,
+		"failed":      true,
+		"rolled_back": true,
+		"superseded":  true,
+	} {
+		if got := deployJobFinished(status); got != want {
+			t.Errorf("deployJobFinished(%q) = %v, want %v", status, got, want)
+		}
+	}
+}
+
+func TestDeployFollower_DrainLogs(t *testing.T) {
+	// No hook ran: nothing to drain, and no blocking on a nil channel.
+	f := &deployFollower{}
+	f.drainLogs(func(k8s.LogLine) { t.Error("drained a line without a hook") })
+
+	logs := make(chan k8s.LogLine, 2)
+	logs <- k8s.LogLine{Message: "migrating"}
+	logs <- k8s.LogLine{Message: "done"}
+	close(logs)
+	f.logs = logs
+	var got []string
+	f.drainLogs(func(line k8s.LogLine) { got = append(got, line.Message) })
+	if len(got) != 2 || got[1] != "done" {
+		t.Errorf("drained %q, want the rest of the hook's output", got)
+	}
+}
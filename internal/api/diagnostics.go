@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vigneshsubbiah/shipit/internal/db"
+	"github.com/vigneshsubbiah/shipit/internal/k8s"
+)
+
+const (
+	// diagnoseLogLines is how much of a crashed container's previous log
+	// a diagnosis includes.
+	diagnoseLogLines = 20
+
+	// diagnoseTimeout bounds the diagnosis autoRollback adds to a failed
+	// deploy's message; it must not hold up the rollback.
+	diagnoseTimeout = 15 * time.Second
+)
+
+// GetAppEvents explains an app's unhealthy pods: recent Warning events on
+// its Deployments, ReplicaSets and pods, containers stuck in
+// ImagePullBackOff, CrashLoopBackOff and the like, and the last lines their
+// crashed instances logged.
+func (h *Handler) GetAppEvents(w http.ResponseWriter, r *http.Request) {
+	appID := chi.URLParam(r, "appID")
+
+	app, err := h.db.GetApp(r.Context(), appID)
+	if err != nil {
+		httpError(w, "app not found", http.StatusNotFound)
+		return
+	}
+
+	client, err := h.appClient(r.Context(), app)
+	if err != nil {
+		httpError(w, "failed to connect to cluster: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	diag, err := client.Diagnose(r.Context(), app.Name, app.Namespace, k8s.DiagnoseOptions{LogLines: diagnoseLogLines})
+	if err != nil {
+		httpError(w, "failed to get events: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(diag)
+}
+
+// rolloutCause is the root-cause summary appended to the message of a
+// deploy whose pods never became ready, or "" if there's nothing to add.
+// Failed HTTP checks aren't diagnosed: their pods are ready, and the
+// response already says what went wrong.
+func rolloutCause(ctx context.Context, client *k8s.Client, app *db.App, deployErr error) string {
+	var hc *healthCheckError
+	if client == nil || errors.As(deployErr, &hc) {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(ctx, diagnoseTimeout)
+	defer cancel()
+	diag, err := client.Diagnose(ctx, app.Name, app.Namespace, k8s.DiagnoseOptions{LogLines: diagnoseLogLines})
+	if err != nil {
+		log.Printf("rollback: diagnosis failed app=%s err=%v", app.ID, err)
+		return ""
+	}
+	return diag.String()
+}
@@ -0,0 +1,24 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/vigneshsubbiah/shipit/internal/db"
+	"github.com/vigneshsubbiah/shipit/internal/k8s"
+)
+
+func TestRolloutCause_SkipsHealthCheckFailures(t *testing.T) {
+	app := &db.App{ID: "a1", Name: "web", Namespace: "default"}
+	hcErr := &healthCheckError{Path: "/healthz", StatusCode: 500}
+
+	// The client is never touched for a failed HTTP check, so an unusable
+	// one proves no diagnosis was attempted.
+	if got := rolloutCause(context.Background(), &k8s.Client{}, app, hcErr); got != "" {
+		t.Errorf("rolloutCause(health check failure) = %q, want empty", got)
+	}
+	if got := rolloutCause(context.Background(), nil, app, errors.New("rollout failed: ProgressDeadlineExceeded")); got != "" {
+		t.Errorf("rolloutCause(nil client) = %q, want empty", got)
+	}
+}
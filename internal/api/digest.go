@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/vigneshsubbiah/shipit/internal/db"
+	"github.com/vigneshsubbiah/shipit/internal/registry"
+)
+
+// digestResolveTimeout bounds the registry round trips at the start of a
+// deploy; a slow registry only costs the pin, not the deploy.
+const digestResolveTimeout = 30 * time.Second
+
+// pullSecretReader is the part of *k8s.Client digest resolution uses.
+type pullSecretReader interface {
+	ImagePullSecretConfigs(ctx context.Context, namespace string) ([][]byte, error)
+}
+
+// resolveImageDigest returns the manifest digest app.Image's tag points to,
+// so the deploy can pin it: with a bare tag (":latest" in particular, pulled
+// with imagePullPolicy Always) two pods of one revision can run different
+// images, and rolling back to the revision redeploys whatever the tag means
+// by then. A rollback reuses the digest its target revision recorded.
+//
+// Credentials are the namespace's imagePullSecrets (what the kubelet pulls
+// with), falling back to REGISTRY_AUTH_FILE. Returns "" when the tag can't
+// be resolved, e.g. ECR pulled via the node's IAM role: the deploy then goes
+// ahead by tag, as before.
+func (h *Handler) resolveImageDigest(ctx context.Context, app *db.App, secrets pullSecretReader, src deploySource) string {
+	ref, err := registry.ParseReference(app.Image)
+	if err != nil {
+		log.Printf("deploy: cannot resolve image digest app=%s image=%s err=%v", app.ID, app.Image, err)
+		return ""
+	}
+	if ref.Digest != "" {
+		return ref.Digest
+	}
+	if src.Digest != "" && src.Image == app.Image {
+		return src.Digest
+	}
+	if h.registry == nil {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, digestResolveTimeout)
+	defer cancel()
+
+	keychain := registry.Keychain{}
+	configs, err := secrets.ImagePullSecretConfigs(ctx, app.Namespace)
+	if err != nil {
+		log.Printf("deploy: failed to read image pull secrets app=%s err=%v", app.ID, err)
+	}
+	for _, cfg := range configs {
+		pullAuth, err := registry.ParseDockerConfig(cfg)
+		if err != nil {
+			log.Printf("deploy: skipping unreadable image pull secret app=%s err=%v", app.ID, err)
+			continue
+		}
+		keychain = keychain.Merge(pullAuth)
+	}
+	keychain = keychain.Merge(h.registryAuth)
+
+	digest, err := h.registry.Resolve(ctx, app.Image, keychain)
+	if err != nil {
+		log.Printf("deploy: image digest resolution failed, deploying by tag app=%s image=%s err=%v", app.ID, app.Image, err)
+		return ""
+	}
+	return digest
+}
+
+// revisionImage is the image a revision runs: pinned to the digest it was
+// deployed at, when one was resolved.
+func revisionImage(rev *db.AppRevision) string {
+	if rev.ImageDigest == nil {
+		return rev.Image
+	}
+	pinned, err := registry.Pinned(rev.Image, *rev.ImageDigest)
+	if err != nil {
+		return rev.Image
+	}
+	return pinned
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vigneshsubbiah/shipit/internal/db"
+	"github.com/vigneshsubbiah/shipit/internal/registry"
+)
+
+var testDigest = "sha256:" + strings.Repeat("ab", 32)
+
+type stubPullSecrets struct {
+	configs [][]byte
+	err     error
+}
+
+func (s *stubPullSecrets) ImagePullSecretConfigs(ctx context.Context, namespace string) ([][]byte, error) {
+	return s.configs, s.err
+}
+
+// newBasicAuthRegistry serves testDigest for team/app:v1 to clients
+// presenting user:password.
+func newBasicAuthRegistry(t *testing.T, user, password string) (*registry.Resolver, string) {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !ok || u != user || p != password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/v2/team/app/manifests/v1" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", testDigest)
+	}))
+	t.Cleanup(srv.Close)
+	return &registry.Resolver{Client: srv.Client()}, strings.TrimPrefix(srv.URL, "https://")
+}
+
+func dockerConfigFor(host, user, password string) []byte {
+	auth := base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+	return []byte(`{"auths":{"` + host + `":{"auth":"` + auth + `"}}}`)
+}
+
+func TestResolveImageDigest_UsesPullSecrets(t *testing.T) {
+	resolver, host := newBasicAuthRegistry(t, "puller", "s3cret")
+	h := &Handler{registry: resolver}
+	app := &db.App{ID: "a1", Namespace: "apps", Image: host + "/team/app:v1"}
+
+	secrets := &stubPullSecrets{configs: [][]byte{[]byte("not json"), dockerConfigFor(host, "puller", "s3cret")}}
+	if got := h.resolveImageDigest(context.Background(), app, secrets, deploySource{}); got != testDigest {
+		t.Errorf("digest = %q, want %q", got, testDigest)
+	}
+
+	// Configured credentials fill in when the cluster has none.
+	h.registryAuth = registry.Keychain{host: {Username: "puller", Password: "s3cret"}}
+	if got := h.resolveImageDigest(context.Background(), app, &stubPullSecrets{err: errors.New("forbidden")}, deploySource{}); got != testDigest {
+		t.Errorf("digest with configured credentials = %q, want %q", got, testDigest)
+	}
+}
+
+func TestResolveImageDigest_FallsBackToTag(t *testing.T) {
+	resolver, host := newBasicAuthRegistry(t, "puller", "s3cret")
+	h := &Handler{registry: resolver}
+	app := &db.App{ID: "a1", Namespace: "apps", Image: host + "/team/app:v1"}
+
+	if got := h.resolveImageDigest(context.Background(), app, &stubPullSecrets{}, deploySource{}); got != "" {
+		t.Errorf("digest without credentials = %q, want empty (deploy by tag)", got)
+	}
+}
+
+func TestResolveImageDigest_NoLookup(t *testing.T) {
+	// Pinned images and rollbacks to a recorded digest never hit the
+	// registry; nil secrets would panic if they did.
+	h := &Handler{registry: &registry.Resolver{}}
+
+	pinned := &db.App{Image: "ghcr.io/org/web@" + testDigest}
+	if got := h.resolveImageDigest(context.Background(), pinned, nil, deploySource{}); got != testDigest {
+		t.Errorf("pinned image digest = %q", got)
+	}
+
+	app := &db.App{Image: "ghcr.io/org/web:latest"}
+	src := deploySource{Image: app.Image, Digest: testDigest}
+	if got := h.resolveImageDigest(context.Background(), app, nil, src); got != testDigest {
+		t.Errorf("rollback digest = %q, want the target revision's", got)
+	}
+}
+
+func TestRevisionImage(t *testing.T) {
+	rev := &db.AppRevision{Image: "ghcr.io/org/web:latest"}
+	if got := revisionImage(rev); got != "ghcr.io/org/web:latest" {
+		t.Errorf("unpinned revision image = %q", got)
+	}
+	rev.ImageDigest = &testDigest
+	if got := revisionImage(rev); got != "ghcr.io/org/web@"+testDigest {
+		t.Errorf("pinned revision image = %q", got)
+	}
+	if got := buildDeployRequestFromRevision(&db.App{}, rev, "", "", nil).Image; got != "ghcr.io/org/web@"+testDigest {
+		t.Errorf("rollback deploy image = %q", got)
+	}
+}
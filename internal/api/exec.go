@@ -14,8 +14,8 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/gorilla/websocket"
-	"github.com/vigneshsubbiah/shipit/internal/auth"
 	"github.com/vigneshsubbiah/shipit/internal/k8s"
+	"github.com/vigneshsubbiah/shipit/internal/metrics"
 )
 
 type execRequest struct {
@@ -78,6 +78,8 @@ func (h *Handler) ExecCommand(w http.ResponseWriter, r *http.Request) {
 		httpError(w, "timeout cannot exceed 3600 seconds", http.StatusBadRequest)
 		return
 	}
+	auditDetail(r, "command", redactCommand(req.Command))
+	auditDetail(r, "existing_pod", req.ExistingPod)
 	timeout := time.Duration(req.Timeout) * time.Second
 	ctx, cancel := context.WithTimeout(r.Context(), timeout)
 	defer cancel()
@@ -94,7 +96,7 @@ func (h *Handler) ExecCommand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	kubeconfig, err := auth.Decrypt(cluster.KubeconfigEncrypted, h.encryptKey)
+	kubeconfig, err := h.keys.Decrypt(cluster.KubeconfigEncrypted)
 	if err != nil {
 		httpError(w, "failed to decrypt kubeconfig", http.StatusInternalServerError)
 		return
@@ -106,6 +108,8 @@ func (h *Handler) ExecCommand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	defer metrics.ExecSession("command")()
+
 	var stdoutBuf, stderrBuf bytes.Buffer
 	var podName, containerName string
 
@@ -188,6 +192,10 @@ func (h *Handler) ExecInteractive(w http.ResponseWriter, r *http.Request) {
 	container := r.URL.Query().Get("container")
 	cpu := r.URL.Query().Get("cpu")
 	ram := r.URL.Query().Get("ram")
+	auditDetail(r, "existing_pod", existingPod)
+	if container != "" {
+		auditDetail(r, "container", container)
+	}
 
 	app, err := h.db.GetApp(r.Context(), appID)
 	if err != nil {
@@ -201,7 +209,7 @@ func (h *Handler) ExecInteractive(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	kubeconfig, err := auth.Decrypt(cluster.KubeconfigEncrypted, h.encryptKey)
+	kubeconfig, err := h.keys.Decrypt(cluster.KubeconfigEncrypted)
 	if err != nil {
 		httpError(w, "failed to decrypt kubeconfig", http.StatusInternalServerError)
 		return
@@ -222,6 +230,7 @@ func (h *Handler) ExecInteractive(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer conn.Close()
+	defer metrics.ExecSession("interactive")()
 
 	// Read first message to get the command
 	var wsReq struct {
@@ -331,7 +340,7 @@ func (h *Handler) CleanupExec(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	kubeconfig, err := auth.Decrypt(cluster.KubeconfigEncrypted, h.encryptKey)
+	kubeconfig, err := h.keys.Decrypt(cluster.KubeconfigEncrypted)
 	if err != nil {
 		httpError(w, "failed to decrypt kubeconfig", http.StatusInternalServerError)
 		return
@@ -363,13 +372,13 @@ func (h *Handler) syncSecretsToK8s(ctx context.Context, appID, appName, namespac
 		return "", nil
 	}
 
-	secretData := make(map[string]string)
-	for _, s := range secrets {
-		decrypted, err := auth.Decrypt(s.ValueEncrypted, h.encryptKey)
-		if err != nil {
-			return "", fmt.Errorf("failed to decrypt secret: %w", err)
-		}
-		secretData[s.Key] = string(decrypted)
+	project, err := h.secretRefProject(ctx, appID)
+	if err != nil {
+		return "", err
+	}
+	secretData, err := h.secretValues(ctx, project, secrets)
+	if err != nil {
+		return "", err
 	}
 
 	secretName := appName + "-secrets"
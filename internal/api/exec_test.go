@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/gorilla/websocket"
+	"github.com/vigneshsubbiah/shipit/internal/auth"
 )
 
 // newExecTestRouter creates a minimal chi router with the exec handlers
@@ -23,16 +25,25 @@ func newExecTestRouter(h *Handler) *chi.Mux {
 	return r
 }
 
-// newTestHandler returns a Handler with a nil DB and a dummy encrypt key.
+// newTestHandler returns a Handler with a nil DB and a dummy encryption key.
 // Requests that try to load an app will fail with "app not found",
 // which is exactly what we need for validation tests.
 func newTestHandler() *Handler {
 	return &Handler{
-		db:         nil,
-		encryptKey: "0000000000000000000000000000000000000000000000000000000000000000",
+		db:   nil,
+		keys: testKeyring(),
 	}
 }
 
+func testKeyring() *auth.Keyring {
+	key := strings.Repeat("0", 64)
+	kek, err := auth.NewLocalKEKs("test", map[string]string{"test": key})
+	if err != nil {
+		panic(err)
+	}
+	return auth.NewKeyring(kek, key, auth.FingerprintKey(key))
+}
+
 // --- ExecCommand validation tests ---
 
 func TestExecCommand_EmptyCommand(t *testing.T) {
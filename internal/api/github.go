@@ -0,0 +1,379 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vigneshsubbiah/shipit/internal/db"
+)
+
+// githubMaxPayload bounds the webhook body read. GitHub caps deliveries
+// at 25MB; push payloads for normal pushes are a few KB.
+const githubMaxPayload = 25 << 20
+
+// githubPushEvent is the part of a GitHub push event payload shipit uses.
+type githubPushEvent struct {
+	Ref     string `json:"ref"`
+	After   string `json:"after"`
+	Deleted bool   `json:"deleted"`
+
+	Repository struct {
+		HTMLURL string `json:"html_url"`
+	} `json:"repository"`
+
+	Pusher struct {
+		Name string `json:"name"`
+	} `json:"pusher"`
+}
+
+// parsePushEvent decodes a push event body. Webhooks configured with the
+// form content type wrap the JSON in a payload field (the signature still
+// covers the raw body).
+func parsePushEvent(contentType string, body []byte) (*githubPushEvent, error) {
+	payload := body
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("invalid form payload")
+		}
+		payload = []byte(form.Get("payload"))
+	}
+	var event githubPushEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("invalid push payload")
+	}
+	return &event, nil
+}
+
+// target returns the normalized repository and branch the push deploys.
+// ok is false for pushes with nothing to deploy: tags and branch
+// deletions.
+func (e *githubPushEvent) target() (repoURL, branch string, ok bool, err error) {
+	branch, isBranch := strings.CutPrefix(e.Ref, "refs/heads/")
+	if !isBranch || e.Deleted || strings.Trim(e.After, "0") == "" {
+		return "", "", false, nil
+	}
+	repoURL, err = normalizeRepoURL(e.Repository.HTMLURL)
+	if err != nil {
+		return "", "", false, err
+	}
+	return repoURL, branch, true, nil
+}
+
+// verifyGitHubSignature checks an X-Hub-Signature-256 header ("sha256=" and
+// the hex HMAC-SHA256 of the raw body under the webhook secret).
+func verifyGitHubSignature(secret string, body []byte, header string) bool {
+	sig, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// normalizeRepoURL reduces the ways a GitHub repository is written
+// (https/ssh clone URLs, html URL, with or without .git) to
+// github.com/<owner>/<repo>, lowercased, so the app's repo_url and a
+// webhook's repository compare equal.
+func normalizeRepoURL(raw string) (string, error) {
+	s := strings.TrimSpace(raw)
+	if rest, ok := strings.CutPrefix(s, "git@"); ok {
+		s = "ssh://" + strings.Replace(rest, ":", "/", 1)
+	}
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid repository URL %q", raw)
+	}
+	path := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("repository URL %q must name <owner>/<repo>", raw)
+	}
+	return strings.ToLower(u.Hostname() + "/" + path), nil
+}
+
+// imageTagPattern is what a Docker tag may contain.
+var imageTagPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+// renderImageTag expands an image_tag_template for a pushed commit:
+// {sha} is the full commit SHA, {short_sha} its first 7 characters and
+// {branch} the branch name with "/" replaced by "-".
+func renderImageTag(template, sha, branch string) (string, error) {
+	short := sha
+	if len(short) > 7 {
+		short = short[:7]
+	}
+	tag := strings.NewReplacer(
+		"{sha}", sha,
+		"{short_sha}", short,
+		"{branch}", strings.ReplaceAll(branch, "/", "-"),
+	).Replace(template)
+	if !imageTagPattern.MatchString(tag) {
+		return "", fmt.Errorf("image tag template %q renders to invalid tag %q", template, tag)
+	}
+	return tag, nil
+}
+
+// imageWithTag replaces the tag (or digest) on image with tag, leaving the
+// registry (including a :port) and repository as they are.
+func imageWithTag(image, tag string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image + ":" + tag
+}
+
+// pushDeployResult reports what a push did for one tracking app.
+type pushDeployResult struct {
+	AppID    string `json:"app_id"`
+	Status   string `json:"status"` // queued, skipped, failed
+	Image    string `json:"image,omitempty"`
+	JobID    string `json:"job_id,omitempty"`
+	Revision int    `json:"revision,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// GitHubWebhook receives GitHub push events. Every app tracking the pushed
+// branch of the pushed repository gets its image retagged for the new
+// commit and a deploy queued, unless it was already deployed from that
+// commit. Authenticated by the X-Hub-Signature-256 HMAC, not a user token.
+func (h *Handler) GitHubWebhook(w http.ResponseWriter, r *http.Request) {
+	if h.githubWebhookSecret == "" {
+		httpError(w, "GitHub webhook is not configured (set GITHUB_WEBHOOK_SECRET)", http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, githubMaxPayload))
+	if err != nil {
+		httpError(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+	if !verifyGitHubSignature(h.githubWebhookSecret, body, r.Header.Get("X-Hub-Signature-256")) {
+		httpError(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Header.Get("X-GitHub-Event") {
+	case "ping":
+		json.NewEncoder(w).Encode(map[string]string{"status": "pong"})
+		return
+	case "push":
+	default:
+		json.NewEncoder(w).Encode(map[string]string{"status": "ignored"})
+		return
+	}
+
+	event, err := parsePushEvent(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	repoURL, branch, ok, err := event.target()
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !ok {
+		// Tag pushes and branch deletions have nothing to deploy.
+		json.NewEncoder(w).Encode(map[string]string{"status": "ignored"})
+		return
+	}
+
+	apps, err := h.db.ListAppsTrackingBranch(r.Context(), repoURL, branch)
+	if err != nil {
+		httpError(w, "failed to look up tracking apps", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("webhook: github push repo=%s branch=%s sha=%s apps=%d", repoURL, branch, event.After, len(apps))
+
+	var requester *string
+	if event.Pusher.Name != "" {
+		s := "github:" + event.Pusher.Name
+		requester = &s
+	}
+	results := make([]pushDeployResult, 0, len(apps))
+	for i := range apps {
+		results = append(results, h.deployPush(r, &apps[i], event, branch, requester))
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     "ok",
+		"repository": repoURL,
+		"branch":     branch,
+		"sha":        event.After,
+		"deploys":    results,
+	})
+}
+
+// deployPush retags one tracking app's image for the pushed commit and
+// queues its deploy through the same queue as POST /api/apps/{id}/deploy.
+func (h *Handler) deployPush(r *http.Request, app *db.App, event *githubPushEvent, branch string, requester *string) pushDeployResult {
+	result := pushDeployResult{AppID: app.ID}
+	fail := func(reason string) pushDeployResult {
+		log.Printf("webhook: push deploy not queued app=%s sha=%s reason=%s", app.ID, event.After, reason)
+		result.Status, result.Reason = "failed", reason
+		return result
+	}
+
+	if app.ManagedBy != "" && app.ManagedBy != "shipit" {
+		result.Status, result.Reason = "skipped", "app is managed by "+app.ManagedBy
+		return result
+	}
+	if app.LastDeployedSHA != nil && *app.LastDeployedSHA == event.After {
+		result.Status, result.Reason = "skipped", "already deployed from this commit"
+		return result
+	}
+
+	tag, err := renderImageTag(app.ImageTagTemplate, event.After, branch)
+	if err != nil {
+		return fail(err.Error())
+	}
+	result.Image = imageWithTag(app.Image, tag)
+
+	cluster, err := h.db.GetCluster(r.Context(), app.ClusterID)
+	if err != nil {
+		return fail("cluster not found")
+	}
+	if _, err := h.keys.Decrypt(cluster.KubeconfigEncrypted); err != nil {
+		return fail("failed to decrypt kubeconfig")
+	}
+
+	// The image change and the job are written together, and only if the
+	// commit isn't already queued, running or deployed: a redelivery of
+	// the same push (possibly concurrent) queues nothing, while one after
+	// a failed or rolled-back deploy tries again.
+	sha := event.After
+	job, err := h.enqueueDeploy(r.Context(), db.EnqueueDeployJobParams{
+		AppID:               app.ID,
+		Kind:                "deploy",
+		RequestedBy:         requester,
+		Image:               &result.Image,
+		CommitSHA:           &sha,
+		CommitRef:           &branch,
+		SkipDuplicateCommit: true,
+	})
+	if errors.Is(err, db.ErrDuplicateCommit) {
+		result.Status, result.Reason = "skipped", "already deployed or queued from this commit"
+		return result
+	}
+	if err != nil {
+		return fail("failed to queue deploy")
+	}
+	result.Status, result.JobID = "queued", job.ID
+	if job.RevisionNumber != nil {
+		result.Revision = *job.RevisionNumber
+	}
+	return result
+}
+
+func trackingResponse(app *db.App) map[string]interface{} {
+	return map[string]interface{}{
+		"repo_url":           app.RepoURL,
+		"tracked_branch":     app.TrackedBranch,
+		"image_tag_template": app.ImageTagTemplate,
+		"last_deployed_sha":  app.LastDeployedSHA,
+		"last_deployed_at":   app.LastDeployedAt,
+	}
+}
+
+func (h *Handler) GetTracking(w http.ResponseWriter, r *http.Request) {
+	appID := chi.URLParam(r, "appID")
+
+	app, err := h.db.GetApp(r.Context(), appID)
+	if err != nil {
+		httpError(w, "app not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(trackingResponse(app))
+}
+
+// SetTracking configures which repository branch deploys the app on push.
+// An empty repo_url stops tracking.
+func (h *Handler) SetTracking(w http.ResponseWriter, r *http.Request) {
+	appID := chi.URLParam(r, "appID")
+
+	existing, err := h.db.GetApp(r.Context(), appID)
+	if err != nil {
+		httpError(w, "app not found", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		RepoURL          *string `json:"repo_url"`
+		TrackedBranch    *string `json:"tracked_branch"`
+		ImageTagTemplate *string `json:"image_tag_template"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	params := db.UpdateAppTrackingParams{
+		ID:               appID,
+		RepoURL:          existing.RepoURL,
+		TrackedBranch:    existing.TrackedBranch,
+		ImageTagTemplate: existing.ImageTagTemplate,
+	}
+	if req.RepoURL != nil {
+		params.RepoURL = nil
+		if *req.RepoURL != "" {
+			normalized, err := normalizeRepoURL(*req.RepoURL)
+			if err != nil {
+				httpError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			params.RepoURL = &normalized
+		}
+	}
+	if req.TrackedBranch != nil {
+		branch := strings.TrimPrefix(*req.TrackedBranch, "refs/heads/")
+		params.TrackedBranch = &branch
+	}
+	if req.ImageTagTemplate != nil {
+		params.ImageTagTemplate = *req.ImageTagTemplate
+	}
+
+	if params.RepoURL != nil && (params.TrackedBranch == nil || *params.TrackedBranch == "") {
+		httpError(w, "tracked_branch is required when repo_url is set", http.StatusBadRequest)
+		return
+	}
+	if !strings.Contains(params.ImageTagTemplate, "{sha}") && !strings.Contains(params.ImageTagTemplate, "{short_sha}") {
+		httpError(w, "image_tag_template must contain {sha} or {short_sha} so each commit gets its own tag", http.StatusBadRequest)
+		return
+	}
+	if _, err := renderImageTag(params.ImageTagTemplate, strings.Repeat("0", 40), "main"); err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	app, err := h.db.UpdateAppTracking(r.Context(), params)
+	if err != nil {
+		httpError(w, "failed to update branch tracking", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(trackingResponse(app))
+}
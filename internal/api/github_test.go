@@ -0,0 +1,183 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testWebhookSecret = "It's a Secret to Everybody"
+
+func githubSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func readPayload(t *testing.T, name string) []byte {
+	t.Helper()
+	body, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	return body
+}
+
+func TestVerifyGitHubSignature(t *testing.T) {
+	// Example from GitHub's "Validating webhook deliveries" docs.
+	body := []byte("Hello, World!")
+	header := "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+	if !verifyGitHubSignature(testWebhookSecret, body, header) {
+		t.Error("documented signature did not verify")
+	}
+	if verifyGitHubSignature("wrong secret", body, header) {
+		t.Error("signature verified under the wrong secret")
+	}
+	if verifyGitHubSignature(testWebhookSecret, []byte("Hello, World?"), header) {
+		t.Error("signature verified for a modified body")
+	}
+	if verifyGitHubSignature(testWebhookSecret, body, strings.TrimPrefix(header, "sha256=")) {
+		t.Error("signature without the sha256= prefix must be rejected")
+	}
+}
+
+func TestParsePushEvent_RecordedPayload(t *testing.T) {
+	body := readPayload(t, "github_push.json")
+
+	// Same event delivered with the JSON and the form content types.
+	form := []byte("payload=" + url.QueryEscape(string(body)))
+	for contentType, raw := range map[string][]byte{
+		"application/json":                  body,
+		"application/x-www-form-urlencoded": form,
+	} {
+		event, err := parsePushEvent(contentType, raw)
+		if err != nil {
+			t.Fatalf("%s: parsePushEvent: %v", contentType, err)
+		}
+		repo, branch, ok, err := event.target()
+		if err != nil || !ok {
+			t.Fatalf("%s: target() = ok %v, err %v", contentType, ok, err)
+		}
+		if repo != "github.com/octo-org/web-api" || branch != "main" {
+			t.Errorf("%s: target = %s@%s", contentType, repo, branch)
+		}
+		if event.After != "9c2d1b8e4f7a3c5d6e0b1a2f3c4d5e6f7a8b9c0d" || event.Pusher.Name != "monalisa" {
+			t.Errorf("%s: after=%s pusher=%s", contentType, event.After, event.Pusher.Name)
+		}
+	}
+}
+
+func TestPushEventTarget_NothingToDeploy(t *testing.T) {
+	tag, err := parsePushEvent("application/json", readPayload(t, "github_push_tag.json"))
+	if err != nil {
+		t.Fatalf("parsePushEvent: %v", err)
+	}
+	if _, _, ok, _ := tag.target(); ok {
+		t.Error("tag push must not deploy")
+	}
+
+	deleted := &githubPushEvent{Ref: "refs/heads/main", After: strings.Repeat("0", 40)}
+	if _, _, ok, _ := deleted.target(); ok {
+		t.Error("branch deletion must not deploy")
+	}
+}
+
+func TestNormalizeRepoURL(t *testing.T) {
+	want := "github.com/octo-org/web-api"
+	for _, in := range []string{
+		"https://github.com/Octo-Org/Web-API",
+		"https://github.com/Octo-Org/Web-API.git",
+		"git@github.com:Octo-Org/Web-API.git",
+		"github.com/octo-org/web-api/",
+	} {
+		got, err := normalizeRepoURL(in)
+		if err != nil || got != want {
+			t.Errorf("normalizeRepoURL(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "https://github.com/octo-org", "https://github.com/a/b/c"} {
+		if _, err := normalizeRepoURL(in); err == nil {
+			t.Errorf("normalizeRepoURL(%q) should fail", in)
+		}
+	}
+}
+
+func TestPushImage(t *testing.T) {
+	sha := "9c2d1b8e4f7a3c5d6e0b1a2f3c4d5e6f7a8b9c0d"
+	cases := []struct {
+		image, template, branch, want string
+	}{
+		{"ghcr.io/octo-org/web-api:latest", "{sha}", "main", "ghcr.io/octo-org/web-api:" + sha},
+		{"registry.local:5000/web-api", "{branch}-{short_sha}", "release/2.1", "registry.local:5000/web-api:release-2.1-9c2d1b8"},
+		{"web-api@sha256:abcd", "sha-{short_sha}", "main", "web-api:sha-9c2d1b8"},
+	}
+	for _, tc := range cases {
+		tag, err := renderImageTag(tc.template, sha, tc.branch)
+		if err != nil {
+			t.Fatalf("renderImageTag(%q): %v", tc.template, err)
+		}
+		if got := imageWithTag(tc.image, tag); got != tc.want {
+			t.Errorf("image for %q with %q = %q, want %q", tc.image, tc.template, got, tc.want)
+		}
+	}
+	if _, err := renderImageTag("{sha}:{branch}", sha, "main"); err == nil {
+		t.Error("template rendering an invalid tag should fail")
+	}
+}
+
+func TestGitHubWebhook_RejectsBeforeTouchingDB(t *testing.T) {
+	// h.db is nil: every case below must answer without a DB read.
+	h := &Handler{githubWebhookSecret: testWebhookSecret}
+	push := readPayload(t, "github_push.json")
+
+	cases := []struct {
+		name       string
+		event      string
+		body       []byte
+		signature  string
+		wantStatus int
+		wantBody   string
+	}{
+		{"bad signature", "push", push, githubSignature("wrong", push), http.StatusUnauthorized, "invalid signature"},
+		{"missing signature", "push", push, "", http.StatusUnauthorized, "invalid signature"},
+		{"ping", "ping", readPayload(t, "github_ping.json"), "", http.StatusOK, "pong"},
+		{"tag push", "push", readPayload(t, "github_push_tag.json"), "", http.StatusOK, "ignored"},
+		{"other event", "issues", []byte(`{}`), "", http.StatusOK, "ignored"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			sig := tc.signature
+			if sig == "" && tc.wantStatus == http.StatusOK {
+				sig = githubSignature(testWebhookSecret, tc.body)
+			}
+			req := httptest.NewRequest("POST", "/api/webhooks/github", bytes.NewReader(tc.body))
+			req.Header.Set("X-GitHub-Event", tc.event)
+			req.Header.Set("Content-Type", "application/json")
+			if sig != "" {
+				req.Header.Set("X-Hub-Signature-256", sig)
+			}
+			rec := httptest.NewRecorder()
+			h.GitHubWebhook(rec, req)
+			if rec.Code != tc.wantStatus || !strings.Contains(rec.Body.String(), tc.wantBody) {
+				t.Errorf("got %d %s, want %d containing %q", rec.Code, rec.Body.String(), tc.wantStatus, tc.wantBody)
+			}
+		})
+	}
+}
+
+func TestGitHubWebhook_DisabledWithoutSecret(t *testing.T) {
+	h := &Handler{}
+	req := httptest.NewRequest("POST", "/api/webhooks/github", bytes.NewReader(readPayload(t, "github_push.json")))
+	rec := httptest.NewRecorder()
+	h.GitHubWebhook(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503 when GITHUB_WEBHOOK_SECRET is unset", rec.Code)
+	}
+}
@@ -18,15 +18,42 @@ import (
 	"github.com/vigneshsubbiah/shipit/internal/auth"
 	"github.com/vigneshsubbiah/shipit/internal/db"
 	"github.com/vigneshsubbiah/shipit/internal/k8s"
+	"github.com/vigneshsubbiah/shipit/internal/logarchive"
+	"github.com/vigneshsubbiah/shipit/internal/metrics"
+	"github.com/vigneshsubbiah/shipit/internal/notify"
 	"github.com/vigneshsubbiah/shipit/internal/porter"
+	"github.com/vigneshsubbiah/shipit/internal/registry"
+	"github.com/vigneshsubbiah/shipit/internal/secretref"
 )
 
 type Handler struct {
 	db              *db.DB
-	encryptKey      string
-	appBaseDomain   string // e.g., "apps.shipit.unboundsec.dev"
+	keys            *auth.Keyring // encrypts kubeconfigs, secrets and notification credentials
+	appBaseDomain   string        // e.g., "apps.shipit.unboundsec.dev"
 	porterDiscovery *porter.DiscoveryService
 
+	// githubWebhookSecret verifies X-Hub-Signature-256 on push webhooks;
+	// the webhook endpoint is disabled when it is empty.
+	githubWebhookSecret string
+
+	// registry resolves image tags to digests at deploy time, with
+	// registryAuth as credentials for registries the cluster's
+	// imagePullSecrets don't cover.
+	registry     *registry.Resolver
+	registryAuth registry.Keychain
+
+	// secretRefs resolves secrets stored as references (vault://, awssm://)
+	// when the app's Kubernetes Secret is written.
+	secretRefs *secretref.Resolver
+
+	// notifier delivers deploy lifecycle events to the Slack and webhook
+	// targets configured per project and app.
+	notifier *notify.Notifier
+
+	// logArchive stores archived log chunks (LOG_ARCHIVE_URL); nil when
+	// the log archive is off.
+	logArchive logarchive.Store
+
 	// deployLocks serializes concurrent deploys of the same app. Two overlapping
 	// deployApp goroutines would race on the Deployment spec (replicas, image)
 	// and on the HPA (reconciled on every deploy now). sync.Map lets us allocate
@@ -35,14 +62,26 @@ type Handler struct {
 	// intent is preserved and the second deploy will converge on the final
 	// state once the first finishes.
 	deployLocks sync.Map // map[string]*sync.Mutex
+
+	// deployWake nudges idle deploy workers when this replica enqueues a job
+	// so they don't wait out the poll interval. Jobs enqueued on other
+	// replicas are still picked up by polling.
+	deployWake chan struct{}
 }
 
-func NewHandler(database *db.DB, encryptKey, appBaseDomain string, porterDiscovery *porter.DiscoveryService) *Handler {
+func NewHandler(database *db.DB, keys *auth.Keyring, appBaseDomain, githubWebhookSecret string, registryAuth registry.Keychain, porterDiscovery *porter.DiscoveryService, secretRefs *secretref.Resolver, logArchive logarchive.Store, notifier *notify.Notifier) *Handler {
 	return &Handler{
-		db:              database,
-		encryptKey:      encryptKey,
-		appBaseDomain:   appBaseDomain,
-		porterDiscovery: porterDiscovery,
+		db:                  database,
+		keys:                keys,
+		appBaseDomain:       appBaseDomain,
+		githubWebhookSecret: githubWebhookSecret,
+		registry:            registry.NewResolver(),
+		registryAuth:        registryAuth,
+		notifier:            notifier,
+		porterDiscovery:     porterDiscovery,
+		secretRefs:          secretRefs,
+		logArchive:          logArchive,
+		deployWake:          make(chan struct{}, 1),
 	}
 }
 
@@ -65,6 +104,18 @@ func (h *Handler) lockAppDeploy(appID string) func() {
 	return mu.Unlock
 }
 
+// tryLockAppDeploy is the non-blocking variant used by the deploy
+// reconciler: if a deploy for the app is in flight in this process, the app
+// isn't orphaned and must be left alone.
+func (h *Handler) tryLockAppDeploy(appID string) (func(), bool) {
+	m, _ := h.deployLocks.LoadOrStore(appID, &sync.Mutex{})
+	mu := m.(*sync.Mutex)
+	if !tryLock(mu) {
+		return nil, false
+	}
+	return mu.Unlock, true
+}
+
 // tryLock is a best-effort non-blocking Lock. Used only for the logging
 // fast-path above; correctness does not depend on it.
 func tryLock(mu *sync.Mutex) bool {
@@ -78,13 +129,36 @@ func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
 
 // Projects
 
+// ListProjects returns the projects the caller is a member of (every
+// project for platform admins), with the caller's role in each
 func (h *Handler) ListProjects(w http.ResponseWriter, r *http.Request) {
-	projects, err := h.db.ListProjects(r.Context())
-	if err != nil {
-		httpError(w, "failed to list projects", http.StatusInternalServerError)
-		return
+	projects := []db.ProjectWithRole{}
+	if auth.IsPlatformAdmin(r.Context()) {
+		all, err := h.db.ListProjects(r.Context())
+		if err != nil {
+			httpError(w, "failed to list projects", http.StatusInternalServerError)
+			return
+		}
+		for _, p := range all {
+			projects = append(projects, db.ProjectWithRole{Project: p, Role: string(auth.RoleAdmin)})
+		}
+	} else if user := auth.GetUser(r.Context()); user != nil {
+		mine, err := h.db.ListProjectsForUser(r.Context(), user.ID)
+		if err != nil {
+			httpError(w, "failed to list projects", http.StatusInternalServerError)
+			return
+		}
+		projects = append(projects, mine...)
 	}
-	json.NewEncoder(w).Encode(projects)
+
+	// A scoped token only sees the projects its scopes cover
+	visible := projects[:0]
+	for _, p := range projects {
+		if auth.ScopeAllows(r.Context(), auth.Target{ProjectID: p.ID}, auth.RoleViewer) {
+			visible = append(visible, p)
+		}
+	}
+	json.NewEncoder(w).Encode(visible)
 }
 
 func (h *Handler) CreateProject(w http.ResponseWriter, r *http.Request) {
@@ -105,6 +179,9 @@ func (h *Handler) CreateProject(w http.ResponseWriter, r *http.Request) {
 		httpError(w, "failed to create project", http.StatusInternalServerError)
 		return
 	}
+	auditDetail(r, "project_id", project.ID)
+	auditDetail(r, "name", project.Name)
+	h.addProjectCreator(r, project.ID)
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(project)
@@ -195,7 +272,7 @@ func (h *Handler) ConnectCluster(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Encrypt kubeconfig
-	encrypted, err := auth.Encrypt(kubeconfig, h.encryptKey)
+	encrypted, err := h.keys.Encrypt(kubeconfig)
 	if err != nil {
 		httpError(w, "failed to encrypt kubeconfig", http.StatusInternalServerError)
 		return
@@ -206,6 +283,8 @@ func (h *Handler) ConnectCluster(w http.ResponseWriter, r *http.Request) {
 		httpError(w, "failed to create cluster", http.StatusInternalServerError)
 		return
 	}
+	auditDetail(r, "cluster_id", cluster.ID)
+	auditDetail(r, "name", cluster.Name)
 
 	// Test connection in background
 	go h.testClusterConnection(cluster.ID, kubeconfig)
@@ -349,6 +428,8 @@ func (h *Handler) CreateApp(w http.ResponseWriter, r *http.Request) {
 		httpError(w, "failed to create app", http.StatusInternalServerError)
 		return
 	}
+	auditTarget(r, app.ID)
+	auditChanges(r, nil, app)
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(app)
@@ -469,6 +550,14 @@ func (h *Handler) UpdateApp(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) DeployApp(w http.ResponseWriter, r *http.Request) {
 	appID := chi.URLParam(r, "appID")
 
+	// Optional {image, sha, ref, ci_url}: CI deploys a freshly pushed image
+	// without PATCHing the app first.
+	req, err := parseCIDeployRequest(r.Body)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	app, err := h.db.GetApp(r.Context(), appID)
 	if err != nil {
 		httpError(w, "app not found", http.StatusNotFound)
@@ -481,23 +570,48 @@ func (h *Handler) DeployApp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Decrypt kubeconfig
-	kubeconfig, err := auth.Decrypt(cluster.KubeconfigEncrypted, h.encryptKey)
-	if err != nil {
+	// Fail fast on a broken cluster row rather than queueing a job that can
+	// never run. The worker decrypts again when it claims the job.
+	if _, err := h.keys.Decrypt(cluster.KubeconfigEncrypted); err != nil {
 		httpError(w, "failed to decrypt kubeconfig", http.StatusInternalServerError)
 		return
 	}
 
-	// Update status to deploying
-	h.db.UpdateAppStatus(r.Context(), appID, "deploying", nil)
-
-	// Deploy in background
-	go h.deployApp(appID, app, kubeconfig)
+	params := db.EnqueueDeployJobParams{
+		AppID:       appID,
+		Kind:        "deploy",
+		RequestedBy: requestedBy(r),
+	}
+	if req != nil {
+		params = req.enqueueParams(appID, requestedBy(r))
+	}
+	job, err := h.enqueueDeploy(r.Context(), params)
+	if err != nil {
+		httpError(w, "failed to queue deploy", http.StatusInternalServerError)
+		return
+	}
+	auditDetail(r, "job_id", job.ID)
+	if req != nil {
+		auditDetail(r, "source", req)
+	}
 
-	json.NewEncoder(w).Encode(map[string]string{"status": "deploying"})
+	resp := map[string]interface{}{
+		"status": "queued",
+		"job_id": job.ID,
+	}
+	// Deploys of an image reserve their revision number when queued.
+	if job.RevisionNumber != nil {
+		resp["revision"] = *job.RevisionNumber
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(resp)
 }
 
-func (h *Handler) deployApp(appID string, app *db.App, kubeconfig []byte) {
+// deployApp runs the full deploy pipeline for one app and returns the
+// revision number it created, or 0 if it failed before creating one.
+// The terminal outcome is recorded on the app row and the revision's
+// deploy_status; the deploy worker reads it back from there.
+func (h *Handler) deployApp(appID string, app *db.App, kubeconfig []byte, src deploySource) int {
 	// Serialize concurrent deploys on the same app. Without this, two goroutines
 	// would race on Deployment spec (replicas, image) and on the HPA (reconciled
 	// on every deploy). Lock is acquired BEFORE the DB re-fetch so the second
@@ -506,11 +620,12 @@ func (h *Handler) deployApp(appID string, app *db.App, kubeconfig []byte) {
 	defer unlock()
 
 	ctx := context.Background()
+	deployStart := time.Now()
 	client, err := k8s.NewClient(kubeconfig)
 	if err != nil {
 		msg := err.Error()
 		h.db.UpdateAppStatus(ctx, appID, "failed", &msg)
-		return
+		return 0
 	}
 
 	// Re-fetch the app inside the goroutine so we pick up any HPA / image /
@@ -521,17 +636,54 @@ func (h *Handler) deployApp(appID string, app *db.App, kubeconfig []byte) {
 		app = fresh
 	}
 
-	// Allocate the next revision number via MAX+1, NOT CurrentRevision+1.
-	// CurrentRevision tracks the last successful deploy; after an auto-
-	// rollback it regresses to the prior success, so CurrentRevision+1
-	// collides with the rolled_back revision that still exists under the
-	// UNIQUE(app_id, revision_number) constraint. MAX+1 is collision-free.
-	newRevision, nextErr := h.db.GetNextRevisionNumber(ctx, appID)
-	if nextErr != nil {
-		msg := "failed to allocate revision number: " + nextErr.Error()
+	// Use the revision number reserved when the job was queued (CI
+	// deploys report it to the caller), unless an earlier attempt of this
+	// job already wrote it. Otherwise allocate one from the app's counter,
+	// NOT CurrentRevision+1: CurrentRevision tracks the last successful
+	// deploy; after an auto-rollback it regresses to the prior success, so
+	// CurrentRevision+1 collides with the rolled_back revision that still
+	// exists under the UNIQUE(app_id, revision_number) constraint.
+	newRevision := src.Revision
+	if newRevision > 0 {
+		if _, err := h.db.GetRevision(ctx, appID, newRevision); err == nil {
+			newRevision = 0
+		}
+	}
+	if newRevision == 0 {
+		n, nextErr := h.db.AllocateRevisionNumber(ctx, appID)
+		if nextErr != nil {
+			msg := "failed to allocate revision number: " + nextErr.Error()
+			h.db.UpdateAppStatus(ctx, appID, "failed", &msg)
+			return 0
+		}
+		newRevision = n
+	}
+	// The commit only describes this revision if the app still runs the
+	// image it was queued with; a PATCH in between replaced it.
+	if src.Image != "" && src.Image != app.Image {
+		log.Printf("deploy: image changed since the job was queued, not recording commit app=%s revision=%d", appID, newRevision)
+		src.CommitSHA, src.CommitRef, src.CIURL = nil, nil, nil
+	}
+	// Pin the tag to the digest it points at now, so every pod of this
+	// revision (and any rollback to it) runs the same image.
+	deployImage := app.Image
+	var imageDigest *string
+	if digest := h.resolveImageDigest(ctx, app, client, src); digest != "" {
+		if pinned, err := registry.Pinned(app.Image, digest); err == nil {
+			deployImage, imageDigest = pinned, &digest
+		}
+	}
+	// Snapshot which secret keys (and value fingerprints) this revision
+	// runs with, so a later rollback to it can detect deleted or rotated
+	// secrets before applying anything.
+	secretSnap, err := h.currentSecretSnapshot(ctx, appID)
+	if err != nil {
+		msg := err.Error()
 		h.db.UpdateAppStatus(ctx, appID, "failed", &msg)
-		return
+		return 0
 	}
+	secretKeysJSON, _ := json.Marshal(secretSnap)
+
 	cpuReq := app.CPURequest
 	cpuLim := app.CPULimit
 	memReq := app.MemoryRequest
@@ -561,79 +713,230 @@ func (h *Handler) deployApp(appID string, app *db.App, kubeconfig []byte) {
 		Domain: app.Domain,
 		// Pre-deploy hook snapshot
 		PreDeployCommand: app.PreDeployCommand,
+		// Secret key snapshot
+		SecretKeys: secretKeysJSON,
+		// Deploy source
+		CommitSHA:   src.CommitSHA,
+		CommitRef:   src.CommitRef,
+		CIURL:       src.CIURL,
+		ImageDigest: imageDigest,
 	})
 	if err != nil {
 		msg := "failed to create revision: " + err.Error()
 		h.db.UpdateAppStatus(ctx, appID, "failed", &msg)
-		return
+		return 0
+	}
+
+	if src.JobID != "" {
+		if err := h.db.SetDeployJobRevision(ctx, src.JobID, newRevision); err != nil {
+			log.Printf("deploy: failed to record revision on job job=%s revision=%d err=%v", src.JobID, newRevision, err)
+		}
 	}
 
+	// Record each phase on the revision's timeline from here on; the
+	// revision phase covers everything up to creating the row.
+	tl := h.timeline(appID, newRevision)
+	tl.record(phaseRevision, eventStarted, "", "", deployStart)
+	tl.succeed(phaseRevision)
+
+	// Notify on the way in and, once the revision's outcome is recorded,
+	// on the way out. A failed pre-deploy hook sends predeploy_failed
+	// instead of deploy_failed.
+	h.publish(app, deployEvent(notify.EventDeployStarted, newRevision, deployImage, src))
+	notified := false
+	defer func() {
+		if !notified {
+			h.publishDeployOutcome(ctx, app, newRevision, deployImage, src)
+		}
+	}()
+
 	var envVars map[string]string
 	json.Unmarshal(app.EnvVars, &envVars)
 
 	// Sync secrets to K8s
-	secretName, secretErr := h.syncSecretsToCluster(ctx, app, client)
+	phaseStart := time.Now()
+	tl.start(phaseSecretSync)
+	secretName, secretChecksum, secretErr := h.syncSecretsToCluster(ctx, app, client)
+	metrics.ObserveSince(metrics.DeployPhaseSeconds.WithLabelValues("secret_sync"), phaseStart)
 	if secretErr != nil {
 		msg := secretErr.Error()
 		h.db.UpdateAppStatus(ctx, appID, "failed", &msg)
-		return
+		h.db.UpdateRevisionStatus(ctx, appID, newRevision, "failed", &msg)
+		tl.fail(phaseSecretSync, msg)
+		return newRevision
 	}
+	tl.succeed(phaseSecretSync)
 
 	// Run pre-deploy hook if configured
 	if app.PreDeployCommand != nil && *app.PreDeployCommand != "" {
 		h.db.UpdateAppStatus(ctx, appID, "running_predeploy", nil)
 
+		phaseStart = time.Now()
+		tl.start(phasePredeploy)
 		result, err := client.RunPreDeployJob(ctx, k8s.PreDeployJobRequest{
 			AppName:    app.Name,
 			Namespace:  app.Namespace,
-			Image:      app.Image,
+			Image:      deployImage,
 			Command:    *app.PreDeployCommand,
 			EnvVars:    envVars,
 			SecretName: secretName,
 		})
+		metrics.ObserveSince(metrics.DeployPhaseSeconds.WithLabelValues("predeploy"), phaseStart)
 		if err != nil {
 			msg := "failed to run pre-deploy hook: " + err.Error()
 			h.db.UpdateAppStatus(ctx, appID, "failed", &msg)
 			h.db.UpdateRevisionStatus(ctx, appID, newRevision, "failed", &msg)
-			return
+			tl.fail(phasePredeploy, msg)
+			h.publishPreDeployFailure(app, newRevision, deployImage, src, msg)
+			notified = true
+			return newRevision
 		}
 		if !result.Success {
 			msg := "pre-deploy hook failed: " + result.Error + "\nLogs:\n" + result.Logs
 			h.db.UpdateAppStatus(ctx, appID, "failed", &msg)
 			h.db.UpdateRevisionStatus(ctx, appID, newRevision, "failed", &msg)
-			return
+			tl.fail(phasePredeploy, msg)
+			h.publishPreDeployFailure(app, newRevision, deployImage, src, msg)
+			notified = true
+			return newRevision
 		}
+		tl.succeed(phasePredeploy)
+	}
+
+	deployReq := buildDeployRequestFromApp(app, h.appBaseDomain, secretName, envVars)
+	deployReq.Image = deployImage
+	deployReq.SecretChecksum = secretChecksum
+	deployReq.Revision = newRevision
+
+	// Canary strategy: run the new revision next to the current one and
+	// step its traffic share up before touching the primary Deployment.
+	// The canary keeps serving its share while the primary rolls forward
+	// below and is torn down once that settles either way.
+	canary := false
+	switch app.DeployStrategy {
+	case "canary":
+		primary, _, err := client.CheckRollout(ctx, app.Name, app.Namespace)
+		reason := canaryUnsupported(app, h.appBaseDomain, primary)
+		if err != nil {
+			reason = "failed to read primary deployment: " + err.Error()
+		}
+		if reason != "" {
+			log.Printf("deploy: canary skipped, using rolling update app=%s revision=%d reason=%s", appID, newRevision, reason)
+		} else {
+			canary = true
+			// A canary that fails its checks is rolled back inside
+			// runCanary, which records the phase's failure.
+			tl.start(phaseCanary)
+			if !h.runCanary(ctx, appID, app, client, deployReq, newRevision) {
+				return newRevision
+			}
+			tl.succeed(phaseCanary)
+		}
+	case "blue_green":
+		// Blue/green: stand the new revision up as a complete second
+		// Deployment in the idle color. The Service keeps selecting the
+		// active color until the new one is fully ready, then flips.
+		if reason := blueGreenUnsupported(app); reason != "" {
+			log.Printf("deploy: blue/green skipped, using rolling update app=%s revision=%d reason=%s", appID, newRevision, reason)
+			break
+		}
+		color, err := h.prepareBlueGreen(ctx, appID, app, client, deployReq)
+		if err != nil {
+			msg := "blue/green: " + err.Error()
+			h.db.UpdateAppStatus(ctx, appID, "failed", &msg)
+			h.db.UpdateRevisionStatus(ctx, appID, newRevision, "failed", &msg)
+			tl.fail(phaseApply, msg)
+			return newRevision
+		}
+		deployReq.Color = color
 	}
 
-	err = client.DeployApp(buildDeployRequestFromApp(app, h.appBaseDomain, secretName, envVars))
+	phaseStart = time.Now()
+	tl.start(phaseApply)
+	err = client.DeployApp(deployReq)
+	metrics.ObserveSince(metrics.DeployPhaseSeconds.WithLabelValues("apply"), phaseStart)
 	if err != nil {
+		if canary {
+			h.teardownCanary(ctx, appID, app, client)
+		}
+		if deployReq.Color != "" {
+			h.retireColor(ctx, appID, app, client, deployReq.Color)
+		}
 		msg := err.Error()
 		h.db.UpdateAppStatus(ctx, appID, "failed", &msg)
 		// Mark revision as failed
 		h.db.UpdateRevisionStatus(ctx, appID, newRevision, "failed", &msg)
-		return
+		tl.fail(phaseApply, msg)
+		return newRevision
 	}
+	tl.succeed(phaseApply)
 
 	// Rollout observation. Kube accepted the spec; now watch the
 	// Deployment's pods actually come up. A bounded ctx lets us detect
 	// stuck rollouts (ImagePullBackOff, CrashLoopBackOff) rather than
-	// reporting "running" purely because the apply succeeded.
+	// reporting "running" purely because the apply succeeded. The new
+	// pods' readiness times go on the timeline as the watch sees them.
 	h.db.UpdateAppStatus(ctx, appID, "verifying", nil)
-	deadline := client.DeploymentProgressDeadline(ctx, app.Name, app.Namespace) + 10*time.Second
+	tl.start(phaseVerifying)
+	watchName := k8s.DeploymentName(app.Name, deployReq.Color)
+	deadline := client.DeploymentProgressDeadline(ctx, watchName, app.Namespace) + 10*time.Second
 	watchCtx, cancel := context.WithTimeout(ctx, deadline)
-	watchErr := client.WatchRollout(watchCtx, app.Name, app.Namespace)
+	phaseStart = time.Now()
+	watchErr := client.WatchRolloutProgress(watchCtx, watchName, app.Namespace, tl.rolloutProgress)
+	metrics.ObserveSince(metrics.DeployPhaseSeconds.WithLabelValues("rollout_watch"), phaseStart)
 	cancel()
+	if canary {
+		h.teardownCanary(ctx, appID, app, client)
+	}
 	if watchErr != nil {
 		log.Printf("deploy: rollout verification failed app=%s revision=%d err=%v", appID, newRevision, watchErr)
-		h.autoRollback(ctx, appID, app, client, newRevision, watchErr)
-		return
+		h.autoRollback(ctx, appID, app, client, newRevision, phaseVerifying, watchErr)
+		if deployReq.Color != "" {
+			// The Service never left the active color; just stop the
+			// failed one.
+			h.retireColor(ctx, appID, app, client, deployReq.Color)
+		}
+		return newRevision
+	}
+
+	if deployReq.Color != "" {
+		if err := h.switchBlueGreen(ctx, appID, app, client, deployReq); err != nil {
+			msg := err.Error()
+			h.db.UpdateAppStatus(ctx, appID, "failed", &msg)
+			h.db.UpdateRevisionStatus(ctx, appID, newRevision, "failed", &msg)
+			tl.fail(phaseVerifying, msg)
+			h.retireColor(ctx, appID, app, client, deployReq.Color)
+			return newRevision
+		}
+	} else if app.ActiveColor != nil {
+		// A rolling update just replaced an app that was on blue/green
+		// colors: the Service now selects every app=<name> pod again, so
+		// the color Deployments must go.
+		h.leaveBlueGreen(ctx, appID, app, client)
+	}
+
+	// Ready pods aren't proof the app works: readiness probes can pass
+	// while real routes return 500s. Gate success on HTTP checks through
+	// the Service (a no-op unless the app has verification enabled).
+	if err := verifyRollout(ctx, client, app, verifyInterval); err != nil {
+		log.Printf("deploy: post-rollout health check failed app=%s revision=%d err=%v", appID, newRevision, err)
+		if deployReq.Color != "" {
+			h.revertBlueGreen(ctx, appID, app, client, deployReq)
+		}
+		h.autoRollback(ctx, appID, app, client, newRevision, phaseVerifying, err)
+		if deployReq.Color != "" {
+			h.retireColor(ctx, appID, app, client, deployReq.Color)
+		}
+		return newRevision
 	}
+	tl.succeed(phaseVerifying)
 
 	// Update app's current revision and status
 	h.db.UpdateAppRevision(ctx, appID, newRevision)
 	h.db.UpdateAppStatus(ctx, appID, "running", nil)
 	// Mark revision as successful
 	h.db.UpdateRevisionStatus(ctx, appID, newRevision, "success", nil)
+	h.db.RecordDeployedCommit(ctx, appID, newRevision)
 
 	h.syncCustomDomainIngress(ctx, appID, app, client, app.Port)
 
@@ -641,32 +944,34 @@ func (h *Handler) deployApp(appID string, app *db.App, kubeconfig []byte) {
 	// autoRollback relies on — prior revision N-1 must exist when deploy N
 	// fails. Don't lower `keep` below 2 without updating autoRollback's guard.
 	h.db.DeleteOldRevisions(ctx, appID, 10)
+	return newRevision
 }
 
-// syncSecretsToCluster decrypts the app's secrets from DB and writes them to
-// the cluster Secret object. Returns the secret name (empty if no secrets).
+// syncSecretsToCluster decrypts the app's secrets from DB (resolving any
+// references) and writes them to the cluster Secret object. Returns the secret name (empty if no secrets)
+// and a checksum of its data for the pod template, so pods restart when only secrets changed.
 // Called from both the forward deploy path and autoRollback so the cluster
 // Secret always reflects current DB state — critical during rollback because
 // secrets aren't versioned in revisions; a rotation during the watch window
 // needs to land in the cluster before the rollback pods start.
-func (h *Handler) syncSecretsToCluster(ctx context.Context, app *db.App, client *k8s.Client) (string, error) {
+func (h *Handler) syncSecretsToCluster(ctx context.Context, app *db.App, client *k8s.Client) (string, string, error) {
 	secrets, err := h.db.GetSecretsByAppID(ctx, app.ID)
 	if err != nil || len(secrets) == 0 {
-		return "", nil
+		return "", "", nil
 	}
-	secretData := make(map[string]string)
-	for _, s := range secrets {
-		decrypted, err := auth.Decrypt(s.ValueEncrypted, h.encryptKey)
-		if err != nil {
-			return "", fmt.Errorf("failed to decrypt secret: %w", err)
-		}
-		secretData[s.Key] = string(decrypted)
+	project, err := h.secretRefProject(ctx, app.ID)
+	if err != nil {
+		return "", "", err
+	}
+	secretData, err := h.secretValues(ctx, project, secrets)
+	if err != nil {
+		return "", "", err
 	}
 	secretName := app.Name + "-secrets"
 	if err := client.CreateOrUpdateSecret(secretName, app.Namespace, secretData); err != nil {
-		return "", fmt.Errorf("failed to create k8s secret: %w", err)
+		return "", "", fmt.Errorf("failed to create k8s secret: %w", err)
 	}
-	return secretName, nil
+	return secretName, k8s.SecretChecksum(secretData, h.keys.Fingerprint), nil
 }
 
 // syncCustomDomainIngress reconciles the Ingress for an app's custom domain
@@ -714,15 +1019,27 @@ func (h *Handler) syncCustomDomainIngress(ctx context.Context, appID string, app
 //     newRevision-1 can be a previously-rolled-back revision from an earlier
 //     incident, which would be the wrong thing to redeploy.
 //
-// Known v1 gaps (tracked in ROADMAP Phase 2.11):
-//   - Server restart between UpdateAppStatus("verifying") and the terminal
-//     status leaves the row orphaned in "verifying" — no boot-time reconciler
-//     sweeps it.
-//   - Secrets are re-resolved from the live rows rather than snapshotted in
-//     the revision, so a rollback cannot recover a deploy broken by a deleted
-//     secret key that the prior revision's env references.
-func (h *Handler) autoRollback(ctx context.Context, appID string, app *db.App, client *k8s.Client, newRevision int, deployErr error) {
-	origMsg := "rollout did not become ready: " + deployErr.Error()
+// A server restart part-way through leaves the app in "rolling_back"; the
+// deploy reconciler (reconcile.go) settles it against the live Deployment.
+//
+// Secrets are re-resolved from the live rows; the revision only snapshots
+// key names and value fingerprints. A secret deleted or rotated since the
+// prior revision aborts the rollback (secretPreflight) rather than
+// deploying a config that was never known-good.
+//
+// When the pods never became ready, the recorded message ends with a
+// root-cause summary from the cluster (rolloutCause): warning events,
+// container waiting reasons and the crashed container's last log line.
+//
+// failedPhase is the timeline phase the deploy failed in; it is recorded
+// as failed with that message, followed by the rollback phase.
+func (h *Handler) autoRollback(ctx context.Context, appID string, app *db.App, client *k8s.Client, newRevision int, failedPhase string, deployErr error) {
+	origMsg := rolloutFailureMessage(deployErr)
+	if cause := rolloutCause(ctx, client, app, deployErr); cause != "" {
+		origMsg += " | cause: " + cause
+	}
+	tl := h.timeline(appID, newRevision)
+	tl.fail(failedPhase, origMsg)
 
 	if app.CurrentRevision <= 0 {
 		log.Printf("rollback: first-deploy-cannot-rollback app=%s revision=%d", appID, newRevision)
@@ -731,51 +1048,86 @@ func (h *Handler) autoRollback(ctx context.Context, appID string, app *db.App, c
 		return
 	}
 
+	tl.start(phaseRollback)
+	rollbackFailed := func(reason string) {
+		msg := origMsg + " | " + reason
+		h.db.UpdateAppStatus(ctx, appID, "failed", &msg)
+		h.db.UpdateRevisionStatus(ctx, appID, newRevision, "failed", &msg)
+		tl.fail(phaseRollback, reason)
+	}
+
 	prior, err := h.db.GetRevision(ctx, appID, app.CurrentRevision)
 	if err != nil {
 		log.Printf("rollback: prior-revision-missing app=%s target_revision=%d err=%v", appID, app.CurrentRevision, err)
-		msg := origMsg + " | rollback aborted: prior revision " + strconv.Itoa(app.CurrentRevision) + " not found: " + err.Error()
-		h.db.UpdateAppStatus(ctx, appID, "failed", &msg)
-		h.db.UpdateRevisionStatus(ctx, appID, newRevision, "failed", &msg)
+		rollbackFailed("rollback aborted: prior revision " + strconv.Itoa(app.CurrentRevision) + " not found: " + err.Error())
+		return
+	}
+
+	// Preflight: secret values aren't versioned, so the rollback will run
+	// with whatever is in app_secrets now. If a key revision N-1 was
+	// deployed with has since been deleted or rotated, redeploying it would
+	// not reproduce the known-good state — fail fast with the diff instead
+	// of shipping pods that may crash on a missing env var.
+	diff, err := h.secretPreflight(ctx, prior)
+	if err != nil {
+		log.Printf("rollback: secret preflight failed app=%s target_revision=%d err=%v", appID, prior.RevisionNumber, err)
+		rollbackFailed("rollback aborted: secret preflight failed: " + err.Error())
+		return
+	}
+	if !diff.empty() {
+		log.Printf("rollback: secrets drifted since target revision app=%s target_revision=%d %s", appID, prior.RevisionNumber, diff)
+		rollbackFailed("rollback aborted: secrets changed since revision " + strconv.Itoa(prior.RevisionNumber) + " (" + diff.String() + ")")
 		return
 	}
 
 	log.Printf("rollback: starting app=%s from=%d to=%d reason=%v", appID, newRevision, prior.RevisionNumber, deployErr)
+	metrics.AutoRollbacksTotal.Inc()
 	h.db.UpdateAppStatus(ctx, appID, "rolling_back", &origMsg)
 
 	// Env vars come from the revision snapshot. Secret values aren't
-	// versioned — re-sync the cluster Secret from current DB state (matches
-	// the forward path and handles the case where secrets rotated during the
-	// watch window). A rollback can recover from an env-var regression but
-	// not from a deleted secret key that revision N-1's env references.
+	// versioned — re-sync the cluster Secret from current DB state. The
+	// preflight above guarantees the keys and values still match what
+	// revision N-1 was deployed with.
 	var envVars map[string]string
 	if len(prior.EnvVars) > 0 {
 		_ = json.Unmarshal(prior.EnvVars, &envVars)
 	}
-	secretName, err := h.syncSecretsToCluster(ctx, app, client)
+	secretName, secretChecksum, err := h.syncSecretsToCluster(ctx, app, client)
 	if err != nil {
 		log.Printf("rollback: secret sync failed app=%s err=%v original_err=%v", appID, err, deployErr)
-		msg := origMsg + " | rollback secret sync failed: " + err.Error()
-		h.db.UpdateAppStatus(ctx, appID, "failed", &msg)
-		h.db.UpdateRevisionStatus(ctx, appID, newRevision, "failed", &msg)
+		rollbackFailed("rollback secret sync failed: " + err.Error())
 		return
 	}
 
-	if err := client.DeployApp(buildDeployRequestFromRevision(app, prior, h.appBaseDomain, secretName, envVars)); err != nil {
+	// Blue/green: the Service never left the active color, which is where
+	// the prior revision runs, so the redeploy below lands there (and is
+	// normally a no-op).
+	rollbackReq := buildDeployRequestFromRevision(app, prior, h.appBaseDomain, secretName, envVars)
+	rollbackReq.SecretChecksum = secretChecksum
+	if usesBlueGreen(app) {
+		rollbackReq.Color = activeColor(app)
+	}
+	if err := client.DeployApp(rollbackReq); err != nil {
 		log.Printf("rollback: failed app=%s target_revision=%d err=%v original_err=%v", appID, prior.RevisionNumber, err, deployErr)
-		msg := origMsg + " | rollback to revision " + strconv.Itoa(prior.RevisionNumber) + " also failed: " + err.Error()
-		h.db.UpdateAppStatus(ctx, appID, "failed", &msg)
-		h.db.UpdateRevisionStatus(ctx, appID, newRevision, "failed", &msg)
+		rollbackFailed("rollback to revision " + strconv.Itoa(prior.RevisionNumber) + " also failed: " + err.Error())
 		return
 	}
 
 	log.Printf("rollback: succeeded app=%s target_revision=%d", appID, prior.RevisionNumber)
 	// app.CurrentRevision already equals prior.RevisionNumber (that's how we
 	// selected the rollback target above), so no UpdateAppRevision call is
-	// needed. Subsequent deploys will allocate their revision number via
-	// GetNextRevisionNumber (MAX+1), which is collision-free regardless.
+	// needed. Subsequent deploys allocate their revision number from the
+	// app's counter (AllocateRevisionNumber), which is collision-free
+	// regardless.
 	h.db.UpdateAppStatus(ctx, appID, "running", nil)
 	h.db.UpdateRevisionStatus(ctx, appID, newRevision, "rolled_back", &origMsg)
+	tl.succeed(phaseRollback)
+	h.publish(app, notify.Event{
+		Type:       notify.EventAutoRolledBack,
+		Revision:   newRevision,
+		RollbackTo: prior.RevisionNumber,
+		Message:    origMsg,
+	})
 
 	// Mirror the happy path: re-reconcile the custom-domain Ingress so it
 	// matches revision N-1's Port if that changed between N-1 and N.
@@ -798,7 +1150,7 @@ func (h *Handler) DeleteApp(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Decrypt kubeconfig and delete from K8s
-	kubeconfig, err := auth.Decrypt(cluster.KubeconfigEncrypted, h.encryptKey)
+	kubeconfig, err := h.keys.Decrypt(cluster.KubeconfigEncrypted)
 	if err == nil {
 		if client, err := k8s.NewClient(kubeconfig); err == nil {
 			client.DeleteApp(app.Name, app.Namespace)
@@ -850,31 +1202,71 @@ func (h *Handler) SetSecret(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Key   string `json:"key"`
 		Value string `json:"value"`
+		Ref   string `json:"ref"` // e.g. vault://kv/payments/stripe#api_key, instead of a value
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		httpError(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
-	if req.Key == "" || req.Value == "" {
-		httpError(w, "key and value are required", http.StatusBadRequest)
+	if req.Key == "" || (req.Value == "") == (req.Ref == "") {
+		httpError(w, "key and one of value or ref are required", http.StatusBadRequest)
 		return
 	}
 
-	// Encrypt the value
-	encrypted, err := auth.Encrypt([]byte(req.Value), h.encryptKey)
-	if err != nil {
-		httpError(w, "failed to encrypt secret", http.StatusInternalServerError)
-		return
+	_, existsErr := h.db.GetSecret(r.Context(), appID, req.Key)
+	var secret *db.AppSecret
+	if req.Ref != "" {
+		// Resolve once now so a typo or missing permission shows up here
+		// rather than failing the next deploy.
+		project, err := h.secretRefProject(r.Context(), appID)
+		if err != nil {
+			httpError(w, "failed to set secret", http.StatusInternalServerError)
+			return
+		}
+		ref, _, err := h.resolveSecretRef(r.Context(), project, req.Ref)
+		if err != nil {
+			httpError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		secret, err = h.db.SetSecretRef(r.Context(), appID, req.Key, ref.Scheme, ref.String())
+		if err != nil {
+			httpError(w, "failed to set secret", http.StatusInternalServerError)
+			return
+		}
+		auditDetail(r, "ref", ref.String())
+	} else {
+		// Encrypt the value
+		encrypted, err := h.keys.Encrypt([]byte(req.Value))
+		if err != nil {
+			httpError(w, "failed to encrypt secret", http.StatusInternalServerError)
+			return
+		}
+		secret, err = h.db.SetSecret(r.Context(), appID, req.Key, encrypted)
+		if err != nil {
+			httpError(w, "failed to set secret", http.StatusInternalServerError)
+			return
+		}
 	}
+	// The value is never audited, only which key changed.
+	auditDetail(r, "key", req.Key)
+	auditDetail(r, "rotated", existsErr == nil)
 
-	secret, err := h.db.SetSecret(r.Context(), appID, req.Key, encrypted)
-	if err != nil {
-		httpError(w, "failed to set secret", http.StatusInternalServerError)
-		return
+	// apply=true redeploys with the new value (debounced, see
+	// secretApplyDebounce) instead of leaving it for the next deploy.
+	var apply *secretApply
+	if wantsApply(r) {
+		var err error
+		if apply, err = h.applySecretChange(r, appID); err != nil {
+			httpError(w, "secret set, but failed to queue deploy", http.StatusInternalServerError)
+			return
+		}
 	}
 
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(secret)
+	json.NewEncoder(w).Encode(struct {
+		*db.AppSecret
+		Deploy *secretApply `json:"deploy,omitempty"`
+	}{secret, apply})
 }
 
 func (h *Handler) DeleteSecret(w http.ResponseWriter, r *http.Request) {
@@ -891,6 +1283,18 @@ func (h *Handler) DeleteSecret(w http.ResponseWriter, r *http.Request) {
 		httpError(w, "failed to delete secret", http.StatusInternalServerError)
 		return
 	}
+	auditDetail(r, "key", key)
+
+	if wantsApply(r) {
+		apply, err := h.applySecretChange(r, appID)
+		if err != nil {
+			httpError(w, "secret deleted, but failed to queue deploy", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]*secretApply{"deploy": apply})
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -936,7 +1340,17 @@ func (h *Handler) GetRevision(w http.ResponseWriter, r *http.Request) {
 		httpError(w, "revision not found", http.StatusNotFound)
 		return
 	}
-	json.NewEncoder(w).Encode(revision)
+
+	// Expose the snapshotted secret key names, never the fingerprints.
+	// Null for revisions created before snapshots existed.
+	var secretKeys []string
+	if snap, ok := revisionSecretSnapshot(revision); ok {
+		secretKeys = snap.keys()
+	}
+	json.NewEncoder(w).Encode(struct {
+		*db.AppRevision
+		SecretKeys []string `json:"secret_keys"`
+	}{revision, secretKeys})
 }
 
 func (h *Handler) RollbackApp(w http.ResponseWriter, r *http.Request) {
@@ -951,10 +1365,15 @@ func (h *Handler) RollbackApp(w http.ResponseWriter, r *http.Request) {
 	// Parse optional revision number from request body
 	var req struct {
 		Revision *int `json:"revision"`
+		Force    bool `json:"force"` // skip the secret drift preflight
 	}
 	json.NewDecoder(r.Body).Decode(&req)
 
 	var targetRevision *db.AppRevision
+	auditDetail(r, "from_revision", app.CurrentRevision)
+	if req.Force {
+		auditDetail(r, "force", true)
+	}
 
 	if req.Revision != nil {
 		// Rollback to specific revision
@@ -979,7 +1398,77 @@ func (h *Handler) RollbackApp(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Apply revision configuration to app
+	// Preflight before touching the app row: refuse to roll back to a
+	// revision whose secrets have since been deleted or rotated, unless the
+	// caller explicitly accepts running it with today's secrets.
+	if !req.Force {
+		diff, err := h.secretPreflight(r.Context(), targetRevision)
+		if err != nil {
+			httpError(w, "secret preflight failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !diff.empty() {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":        "secrets changed since revision " + strconv.Itoa(targetRevision.RevisionNumber) + " (" + diff.String() + "); pass force to roll back anyway",
+				"missing_keys": diff.Missing,
+				"changed_keys": diff.Changed,
+			})
+			return
+		}
+	}
+
+	// Blue/green: if the idle color still runs the target revision, roll
+	// back by flipping the Service selector to it — no redeploy, no queue.
+	auditDetail(r, "target_revision", targetRevision.RevisionNumber)
+	if color := h.switchToIdleColor(r.Context(), app, targetRevision); color != "" {
+		h.db.UpdateAppStatus(r.Context(), appID, "running", nil)
+		auditDetail(r, "switched_to_color", color)
+		// Notify as a queued rollback would: started, then succeeded.
+		src := deploySource{
+			CommitSHA:   targetRevision.CommitSHA,
+			CIURL:       targetRevision.CIURL,
+			RollbackTo:  targetRevision.RevisionNumber,
+			RequestedBy: requestedBy(r),
+		}
+		h.publish(app, deployEvent(notify.EventDeployStarted, targetRevision.RevisionNumber, revisionImage(targetRevision), src))
+		h.publish(app, deployEvent(notify.EventDeploySucceeded, targetRevision.RevisionNumber, revisionImage(targetRevision), src))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":          "switched",
+			"active_color":    color,
+			"target_revision": targetRevision.RevisionNumber,
+			"target_image":    targetRevision.Image,
+		})
+		return
+	}
+
+	// The worker points the app row at the target when it claims the job
+	// (runDeployJob): a deploy may be running now, and rewriting the row
+	// under it would mix the two.
+	target := targetRevision.RevisionNumber
+	job, err := h.enqueueDeploy(r.Context(), db.EnqueueDeployJobParams{
+		AppID:          appID,
+		Kind:           "rollback",
+		TargetRevision: &target,
+		RequestedBy:    requestedBy(r),
+	})
+	if err != nil {
+		httpError(w, "failed to queue rollback", http.StatusInternalServerError)
+		return
+	}
+	auditDetail(r, "job_id", job.ID)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":            "rolling_back",
+		"job_id":            job.ID,
+		"target_revision":   targetRevision.RevisionNumber,
+		"target_image":      targetRevision.Image,
+	})
+}
+
+// applyRevision points the app row at a revision: its configuration and
+// current_revision.
+func (h *Handler) applyRevision(ctx context.Context, appID string, targetRevision *db.AppRevision) error {
 	cpuReq := ""
 	if targetRevision.CPURequest != nil {
 		cpuReq = *targetRevision.CPURequest
@@ -997,7 +1486,7 @@ func (h *Handler) RollbackApp(w http.ResponseWriter, r *http.Request) {
 		memLim = *targetRevision.MemoryLimit
 	}
 
-	_, err = h.db.UpdateApp(r.Context(), db.UpdateAppParams{
+	_, err := h.db.UpdateApp(ctx, db.UpdateAppParams{
 		ID:           appID,
 		Image:        targetRevision.Image,
 		Replicas:     targetRevision.Replicas,
@@ -1012,60 +1501,10 @@ func (h *Handler) RollbackApp(w http.ResponseWriter, r *http.Request) {
 		HealthPeriod: targetRevision.HealthPeriod,
 	})
 	if err != nil {
-		httpError(w, "failed to update app configuration", http.StatusInternalServerError)
-		return
-	}
-
-	// CurrentRevision must point at the target BEFORE deployApp runs. If we
-	// leave it at the broken revision, a subsequent watch-timeout would
-	// invoke autoRollback, which reads app.CurrentRevision as the rollback
-	// target — and redeploys the very revision the user was escaping from.
-	if err := h.db.UpdateAppRevision(r.Context(), appID, targetRevision.RevisionNumber); err != nil {
-		httpError(w, "failed to update current revision", http.StatusInternalServerError)
-		return
-	}
-
-	// Get cluster for deployment
-	cluster, err := h.db.GetCluster(r.Context(), app.ClusterID)
-	if err != nil {
-		httpError(w, "cluster not found", http.StatusNotFound)
-		return
-	}
-
-	// Decrypt kubeconfig
-	kubeconfig, err := auth.Decrypt(cluster.KubeconfigEncrypted, h.encryptKey)
-	if err != nil {
-		httpError(w, "failed to decrypt kubeconfig", http.StatusInternalServerError)
-		return
+		return err
 	}
 
-	// Update status to deploying
-	h.db.UpdateAppStatus(r.Context(), appID, "rolling_back", nil)
-
-	// Belt-and-suspenders for C1: even if both this re-fetch AND deployApp's
-	// in-goroutine re-fetch fail (narrow DB-outage window), the snapshot
-	// we hand to deployApp must carry the new CurrentRevision. Otherwise
-	// autoRollback would target the broken revision the user is escaping.
-	app.CurrentRevision = targetRevision.RevisionNumber
-
-	// Re-fetch app with the UpdateApp changes from above applied. If the
-	// re-fetch fails we fall back to the pre-update `app` (with the
-	// in-memory CurrentRevision fix above): stale by one field-copy but
-	// still a valid pointer, and deployApp's own in-goroutine GetApp will
-	// try again. Without this guard, a failing GetApp would pass a nil
-	// pointer to deployApp.
-	updatedApp, err := h.db.GetApp(r.Context(), appID)
-	if err != nil {
-		log.Printf("rollback: app re-fetch failed — falling back to pre-update snapshot app=%s err=%v", appID, err)
-		updatedApp = app
-	}
-	go h.deployApp(appID, updatedApp, kubeconfig)
-
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":            "rolling_back",
-		"target_revision":   targetRevision.RevisionNumber,
-		"target_image":      targetRevision.Image,
-	})
+	return h.db.UpdateAppRevision(ctx, appID, targetRevision.RevisionNumber)
 }
 
 // Deployment History
@@ -1112,7 +1551,7 @@ func (h *Handler) GetAutoscaling(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	kubeconfig, err := auth.Decrypt(cluster.KubeconfigEncrypted, h.encryptKey)
+	kubeconfig, err := h.keys.Decrypt(cluster.KubeconfigEncrypted)
 	if err != nil {
 		httpError(w, "failed to decrypt kubeconfig", http.StatusInternalServerError)
 		return
@@ -1153,6 +1592,10 @@ func (h *Handler) SetAutoscaling(w http.ResponseWriter, r *http.Request) {
 		httpError(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
+	if req.Enabled && app.DeployStrategy == "blue_green" {
+		httpError(w, "autoscaling is not supported with the blue_green deploy strategy", http.StatusBadRequest)
+		return
+	}
 
 	cluster, err := h.db.GetCluster(r.Context(), app.ClusterID)
 	if err != nil {
@@ -1160,7 +1603,7 @@ func (h *Handler) SetAutoscaling(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	kubeconfig, err := auth.Decrypt(cluster.KubeconfigEncrypted, h.encryptKey)
+	kubeconfig, err := h.keys.Decrypt(cluster.KubeconfigEncrypted)
 	if err != nil {
 		httpError(w, "failed to decrypt kubeconfig", http.StatusInternalServerError)
 		return
@@ -1260,7 +1703,7 @@ func (h *Handler) GetDomain(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	kubeconfig, err := auth.Decrypt(cluster.KubeconfigEncrypted, h.encryptKey)
+	kubeconfig, err := h.keys.Decrypt(cluster.KubeconfigEncrypted)
 	if err != nil {
 		httpError(w, "failed to decrypt kubeconfig", http.StatusInternalServerError)
 		return
@@ -1320,7 +1763,7 @@ func (h *Handler) SetDomain(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	kubeconfig, err := auth.Decrypt(cluster.KubeconfigEncrypted, h.encryptKey)
+	kubeconfig, err := h.keys.Decrypt(cluster.KubeconfigEncrypted)
 	if err != nil {
 		httpError(w, "failed to decrypt kubeconfig", http.StatusInternalServerError)
 		return
@@ -1445,7 +1888,10 @@ func (h *Handler) GetMe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	json.NewEncoder(w).Encode(user)
+	json.NewEncoder(w).Encode(struct {
+		*db.User
+		PlatformAdmin bool `json:"platform_admin"`
+	}{user, auth.IsPlatformAdmin(r.Context())})
 }
 
 // ListMyTokens returns the current user's API tokens
@@ -1465,7 +1911,12 @@ func (h *Handler) ListMyTokens(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(tokens)
 }
 
-// CreateMyToken creates a new API token for the current user
+// maxTokenLifetime caps how far in the future a user token can expire
+const maxTokenLifetime = 365 * 24 * time.Hour
+
+// CreateMyToken creates a new API token for the current user. Tokens must
+// expire and carry at least one scope; they can also be pinned to client
+// IPs. A token created with another token can't outlive it.
 func (h *Handler) CreateMyToken(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetUser(r.Context())
 	if user == nil {
@@ -1474,8 +1925,11 @@ func (h *Handler) CreateMyToken(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Name      string `json:"name"`
-		ExpiresIn *int   `json:"expires_in"` // days, optional
+		Name       string     `json:"name"`
+		ExpiresIn  *int       `json:"expires_in"` // days
+		ExpiresAt  *time.Time `json:"expires_at"`
+		Scopes     []string   `json:"scopes"`
+		AllowedIPs []string   `json:"allowed_ips"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		httpError(w, "invalid request body", http.StatusBadRequest)
@@ -1487,35 +1941,73 @@ func (h *Handler) CreateMyToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate raw token (show to user once)
-	rawToken, err := generateSecureToken()
+	expTime, err := tokenExpiry(req.ExpiresIn, req.ExpiresAt, time.Now())
 	if err != nil {
-		httpError(w, "failed to generate token", http.StatusInternalServerError)
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	parent := auth.GetUserToken(r.Context())
+	if parent != nil && parent.ExpiresAt != nil && expTime.After(*parent.ExpiresAt) {
+		httpError(w, "a token can't outlive the token used to create it (expires "+parent.ExpiresAt.Format(time.RFC3339)+")", http.StatusBadRequest)
 		return
 	}
 
-	// Hash for storage
-	tokenHash := hashToken(rawToken)
-
-	// Calculate expiration
-	var expiresAt *string
-	if req.ExpiresIn != nil && *req.ExpiresIn > 0 {
-		t := strconv.FormatInt(int64(*req.ExpiresIn), 10)
-		expiresAt = &t
+	scopes, err := auth.ParseScopes(req.Scopes)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := auth.ValidateAllowlist(req.AllowedIPs); err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	var expTime *time.Time
-	if expiresAt != nil {
-		days, _ := strconv.Atoi(*expiresAt)
-		t := time.Now().Add(time.Duration(days) * 24 * time.Hour)
-		expTime = &t
+	// A token can only create tokens it could stand in for: no wider
+	// scopes, and no addresses outside its own allowlist, which a new
+	// token without one inherits.
+	if parent != nil {
+		if parentScopes, scoped := auth.GetScopes(r.Context()); scoped {
+			appProject := func(appID string) string {
+				projectID, _ := h.db.GetAppProjectID(r.Context(), appID)
+				return projectID
+			}
+			if err := auth.ScopesWithin(scopes, parentScopes, appProject); err != nil {
+				httpError(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		var parentIPs []string
+		if len(parent.AllowedIPs) > 0 {
+			if err := json.Unmarshal(parent.AllowedIPs, &parentIPs); err != nil {
+				httpError(w, "failed to read the token's IP allowlist", http.StatusInternalServerError)
+				return
+			}
+		}
+		if len(req.AllowedIPs) == 0 {
+			req.AllowedIPs = parentIPs
+		}
+		if err := auth.AllowlistWithin(req.AllowedIPs, parentIPs); err != nil {
+			httpError(w, err.Error(), http.StatusForbidden)
+			return
+		}
 	}
 
-	token, err := h.db.CreateUserToken(r.Context(), user.ID, req.Name, tokenHash, expTime)
+	scopeList := make([]string, len(scopes))
+	for i, scope := range scopes {
+		scopeList[i] = scope.String()
+	}
+	token, rawToken, err := h.issueUserToken(r.Context(), user.ID, req.Name, expTime, scopeList, req.AllowedIPs)
 	if err != nil {
 		httpError(w, "failed to create token", http.StatusInternalServerError)
 		return
 	}
+	auditDetail(r, "token_id", token.ID)
+	auditDetail(r, "name", token.Name)
+	auditDetail(r, "expires_at", token.ExpiresAt)
+	auditDetail(r, "scopes", scopeList)
+	if len(req.AllowedIPs) > 0 {
+		auditDetail(r, "allowed_ips", req.AllowedIPs)
+	}
 
 	// Return token with raw value (only shown once)
 	w.WriteHeader(http.StatusCreated)
@@ -1525,9 +2017,68 @@ func (h *Handler) CreateMyToken(w http.ResponseWriter, r *http.Request) {
 		"token":       rawToken, // Only shown once
 		"created_at":  token.CreatedAt,
 		"expires_at":  token.ExpiresAt,
+		"scopes":      scopeList,
+		"allowed_ips": req.AllowedIPs,
 	})
 }
 
+// issueUserToken creates a user token and returns it with its raw value,
+// which is only ever shown once.
+func (h *Handler) issueUserToken(ctx context.Context, userID, name string, expiresAt time.Time, scopes, allowedIPs []string) (*db.UserToken, string, error) {
+	rawToken, err := generateSecureToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	scopesJSON, err := json.Marshal(scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	var allowedIPsJSON []byte
+	if len(allowedIPs) > 0 {
+		allowedIPsJSON, _ = json.Marshal(allowedIPs)
+	}
+
+	token, err := h.db.CreateUserToken(ctx, db.CreateUserTokenParams{
+		UserID:     userID,
+		Name:       name,
+		TokenHash:  hashToken(rawToken),
+		ExpiresAt:  &expiresAt,
+		Scopes:     scopesJSON,
+		AllowedIPs: allowedIPsJSON,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return token, rawToken, nil
+}
+
+// tokenExpiry resolves a token's expiry from either a lifetime in days or an
+// absolute time. One is required, and it must lie within maxTokenLifetime.
+func tokenExpiry(expiresIn *int, expiresAt *time.Time, now time.Time) (time.Time, error) {
+	var exp time.Time
+	switch {
+	case expiresIn != nil && expiresAt != nil:
+		return exp, fmt.Errorf("set only one of expires_in and expires_at")
+	case expiresIn != nil:
+		if *expiresIn <= 0 {
+			return exp, fmt.Errorf("expires_in must be a positive number of days")
+		}
+		exp = now.Add(time.Duration(*expiresIn) * 24 * time.Hour)
+	case expiresAt != nil:
+		exp = *expiresAt
+	default:
+		return exp, fmt.Errorf("tokens must expire: set expires_in (days) or expires_at")
+	}
+	if !exp.After(now) {
+		return exp, fmt.Errorf("expires_at must be in the future")
+	}
+	if exp.After(now.Add(maxTokenLifetime)) {
+		return exp, fmt.Errorf("tokens can't be valid for more than 365 days")
+	}
+	return exp, nil
+}
+
 // DeleteMyToken revokes a user's API token
 func (h *Handler) DeleteMyToken(w http.ResponseWriter, r *http.Request) {
 	user := auth.GetUser(r.Context())
@@ -1546,6 +2097,7 @@ func (h *Handler) DeleteMyToken(w http.ResponseWriter, r *http.Request) {
 		httpError(w, "failed to delete token", http.StatusInternalServerError)
 		return
 	}
+	auditDetail(r, "token_id", tokenID)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -1594,7 +2146,7 @@ func (h *Handler) GetClusterIngress(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	kubeconfig, err := auth.Decrypt(cluster.KubeconfigEncrypted, h.encryptKey)
+	kubeconfig, err := h.keys.Decrypt(cluster.KubeconfigEncrypted)
 	if err != nil {
 		httpError(w, "failed to decrypt kubeconfig", http.StatusInternalServerError)
 		return
@@ -0,0 +1,575 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vigneshsubbiah/shipit/internal/db"
+	"github.com/vigneshsubbiah/shipit/internal/k8s"
+	"github.com/vigneshsubbiah/shipit/internal/logarchive"
+)
+
+const (
+	// logCollectorInterval is how often each replica renews its collector
+	// leases, takes over clusters whose lease expired, and each collector
+	// picks up new apps and restarts streams that ended.
+	logCollectorInterval = 30 * time.Second
+	// logCollectorLeaseTTL outlives two missed renewals, so a slow
+	// database round-trip doesn't hand a cluster to another replica.
+	logCollectorLeaseTTL = 3 * logCollectorInterval
+
+	// Buffered lines are written as chunks every logChunkFlushInterval, or
+	// sooner once an app has logChunkMaxLines of them.
+	logChunkFlushInterval = 30 * time.Second
+	logChunkMaxLines      = 5000
+
+	defaultLogSearchLimit = 500
+	maxLogSearchLimit     = 5000
+	// maxLogSearchChunks bounds the chunks one search reads from the store.
+	maxLogSearchChunks = 200
+)
+
+// RunLogCollectors archives the logs of every app on every connected
+// cluster until ctx is cancelled. One replica collects each cluster, the
+// one holding its lease in log_collector_leases. Does nothing when the log
+// archive is off.
+func (h *Handler) RunLogCollectors(ctx context.Context) {
+	if h.logArchive == nil {
+		return
+	}
+	holder := "logs-" + deployWorkerBaseID()
+	collectors := make(map[string]context.CancelFunc)
+	ticker := time.NewTicker(logCollectorInterval)
+	defer ticker.Stop()
+
+	for {
+		h.superviseLogCollectors(ctx, holder, collectors)
+
+		select {
+		case <-ctx.Done():
+			for _, cancel := range collectors {
+				cancel()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// superviseLogCollectors renews or takes the lease of each connected
+// cluster, starting a collector for clusters newly held and stopping those
+// whose lease was lost or whose cluster is gone.
+func (h *Handler) superviseLogCollectors(ctx context.Context, holder string, collectors map[string]context.CancelFunc) {
+	clusters, err := h.db.ListAllClustersWithKubeconfig(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("logarchive: failed to list clusters err=%v", err)
+		}
+		return
+	}
+
+	seen := make(map[string]bool)
+	for i := range clusters {
+		cluster := &clusters[i]
+		seen[cluster.ID] = true
+		held, err := h.db.AcquireLogCollectorLease(ctx, cluster.ID, holder, logCollectorLeaseTTL)
+		if err != nil {
+			// Keep whatever this replica was doing; the lease outlives
+			// a failed renewal or two.
+			log.Printf("logarchive: lease renewal failed cluster=%s err=%v", cluster.ID, err)
+			continue
+		}
+		cancel, running := collectors[cluster.ID]
+		switch {
+		case held && !running:
+			kubeconfig, err := h.keys.Decrypt(cluster.KubeconfigEncrypted)
+			if err != nil {
+				log.Printf("logarchive: failed to decrypt kubeconfig cluster=%s err=%v", cluster.ID, err)
+				continue
+			}
+			client, err := k8s.NewClient(kubeconfig)
+			if err != nil {
+				log.Printf("logarchive: failed to connect cluster=%s err=%v", cluster.ID, err)
+				continue
+			}
+			cctx, cancel := context.WithCancel(ctx)
+			collectors[cluster.ID] = cancel
+			log.Printf("logarchive: collecting cluster=%s holder=%s", cluster.ID, holder)
+			go h.collectClusterLogs(cctx, cluster.ID, client)
+		case !held && running:
+			log.Printf("logarchive: lease lost, stopping collector cluster=%s", cluster.ID)
+			cancel()
+			delete(collectors, cluster.ID)
+		}
+	}
+	for clusterID, cancel := range collectors {
+		if !seen[clusterID] {
+			cancel()
+			delete(collectors, clusterID)
+		}
+	}
+}
+
+// appLogStream is one app's archiving goroutine in a cluster collector.
+type appLogStream struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// collectClusterLogs keeps one archiving stream per app on the cluster
+// whose project archives logs, until ctx is cancelled. A stream that ended
+// (the app had no pods, or the connection dropped) is restarted on the
+// next pass.
+func (h *Handler) collectClusterLogs(ctx context.Context, clusterID string, client *k8s.Client) {
+	streams := make(map[string]*appLogStream)
+	ticker := time.NewTicker(logCollectorInterval)
+	defer ticker.Stop()
+
+	for {
+		apps, err := h.db.ListAppsToArchiveLogs(ctx, clusterID)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("logarchive: failed to list apps cluster=%s err=%v", clusterID, err)
+		}
+		if err == nil {
+			wanted := make(map[string]bool)
+			for i := range apps {
+				app := &apps[i]
+				wanted[app.ID] = true
+				if s, ok := streams[app.ID]; ok {
+					select {
+					case <-s.done:
+					default:
+						continue // still streaming
+					}
+				}
+				actx, cancel := context.WithCancel(ctx)
+				s := &appLogStream{cancel: cancel, done: make(chan struct{})}
+				streams[app.ID] = s
+				go func() {
+					defer close(s.done)
+					if err := h.archiveAppLogs(actx, client, app); err != nil && actx.Err() == nil {
+						log.Printf("logarchive: stream ended app=%s err=%v", app.ID, err)
+					}
+				}()
+			}
+			for appID, s := range streams {
+				if !wanted[appID] {
+					s.cancel()
+					delete(streams, appID)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			for _, s := range streams {
+				s.cancel()
+				<-s.done
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// archiveAppLogs follows every pod of app and writes its lines to the
+// archive in chunks, resuming after the newest line already archived.
+// Returns when the stream ends or ctx is done, after flushing what it has.
+func (h *Handler) archiveAppLogs(ctx context.Context, client *k8s.Client, app *db.App) error {
+	var since time.Time
+	last, err := h.db.LastLogChunkAt(ctx, app.ID)
+	if err != nil {
+		return err
+	}
+	if last != nil {
+		since = *last
+	}
+
+	lines, err := client.StreamLogs(ctx, app.Name, app.Namespace, k8s.LogOptions{Follow: true, Since: since})
+	if err != nil {
+		return err
+	}
+
+	var buf []k8s.LogLine
+	flush := func() {
+		if len(buf) == 0 {
+			return
+		}
+		// The final flush runs after ctx is cancelled, so writes get
+		// their own deadline.
+		fctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		if err := h.writeLogChunks(fctx, app.ID, buf); err != nil {
+			log.Printf("logarchive: failed to write chunks app=%s lines=%d err=%v", app.ID, len(buf), err)
+		}
+		buf = nil
+	}
+	ticker := time.NewTicker(logChunkFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				flush()
+				return nil
+			}
+			// Since has second precision at the kubelet, so the
+			// newest archived second is read again.
+			if !since.IsZero() && !line.Timestamp.After(since) {
+				continue
+			}
+			buf = append(buf, line)
+			if len(buf) >= logChunkMaxLines {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// writeLogChunks stores lines as one chunk per pod and indexes them.
+func (h *Handler) writeLogChunks(ctx context.Context, appID string, lines []k8s.LogLine) error {
+	for _, group := range groupLogLinesByPod(lines) {
+		data, err := logarchive.EncodeChunk(group)
+		if err != nil {
+			return err
+		}
+		first, last := group[0].Timestamp, group[0].Timestamp
+		for _, l := range group {
+			if l.Timestamp.Before(first) {
+				first = l.Timestamp
+			}
+			if l.Timestamp.After(last) {
+				last = l.Timestamp
+			}
+		}
+		chunk := &db.LogChunk{
+			AppID:     appID,
+			Pod:       group[0].Pod,
+			FirstAt:   first,
+			LastAt:    last,
+			Lines:     len(group),
+			Bytes:     len(data),
+			ObjectKey: logarchive.ChunkKey(appID, group[0].Pod, first.UnixNano()),
+		}
+		if rev := group[0].Revision; rev > 0 {
+			chunk.Revision = &rev
+		}
+		if err := h.logArchive.Put(ctx, chunk.ObjectKey, data); err != nil {
+			return err
+		}
+		if err := h.db.CreateLogChunk(ctx, chunk); err != nil {
+			// Don't leave an object nothing points to.
+			h.logArchive.Delete(ctx, chunk.ObjectKey)
+			return err
+		}
+	}
+	return nil
+}
+
+// groupLogLinesByPod splits lines by pod, keeping their order, with pods in
+// order of their first line.
+func groupLogLinesByPod(lines []k8s.LogLine) [][]k8s.LogLine {
+	index := make(map[string]int)
+	var groups [][]k8s.LogLine
+	for _, line := range lines {
+		i, ok := index[line.Pod]
+		if !ok {
+			i = len(groups)
+			index[line.Pod] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], line)
+	}
+	return groups
+}
+
+// RunLogArchiveSweeper deletes archived chunks past their project's
+// retention, or whose app is gone, hourly until ctx is cancelled.
+func (h *Handler) RunLogArchiveSweeper(ctx context.Context) {
+	if h.logArchive == nil {
+		return
+	}
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		if n, err := h.sweepLogChunks(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("logarchive: retention sweep failed err=%v", err)
+		} else if n > 0 {
+			log.Printf("logarchive: deleted %d expired chunks", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Handler) sweepLogChunks(ctx context.Context) (int, error) {
+	deleted := 0
+	for {
+		chunks, err := h.db.ListExpiredLogChunks(ctx, 500)
+		if err != nil || len(chunks) == 0 {
+			return deleted, err
+		}
+		for _, c := range chunks {
+			// Object first: a row without its object would fail searches,
+			// an object without its row is just never read.
+			if err := h.logArchive.Delete(ctx, c.ObjectKey); err != nil {
+				return deleted, err
+			}
+			if err := h.db.DeleteLogChunk(ctx, c.ID); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+	}
+}
+
+// logSearchResult is the response of SearchLogs.
+type logSearchResult struct {
+	Lines          []k8s.LogLine `json:"lines"`
+	ChunksSearched int           `json:"chunks_searched"`
+	// Truncated is set when the limit was reached or more chunks matched
+	// than one search reads; narrow the time range to see the rest.
+	Truncated bool `json:"truncated"`
+}
+
+// logSearchQuery is a parsed SearchLogs request.
+type logSearchQuery struct {
+	filter db.LogChunkFilter
+	match  *regexp.Regexp // nil matches every line
+}
+
+// parseLogSearch reads SearchLogs' query parameters: q (a regular
+// expression), from and to (RFC 3339 times, or durations like 2h meaning
+// that long ago), revision, pod and limit.
+func parseLogSearch(appID string, q url.Values) (logSearchQuery, error) {
+	s := logSearchQuery{filter: db.LogChunkFilter{AppID: appID, Pod: q.Get("pod"), Limit: defaultLogSearchLimit}}
+	if pattern := q.Get("q"); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return s, fmt.Errorf("invalid q pattern: %v", err)
+		}
+		s.match = re
+	}
+	for _, p := range []struct {
+		name string
+		dst  **time.Time
+	}{{"from", &s.filter.From}, {"to", &s.filter.To}} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			d, derr := time.ParseDuration(v)
+			if derr != nil || d <= 0 {
+				return s, fmt.Errorf("%s must be an RFC 3339 time or a duration like 2h", p.name)
+			}
+			t = time.Now().Add(-d)
+		}
+		*p.dst = &t
+	}
+	if s.filter.From != nil && s.filter.To != nil && s.filter.To.Before(*s.filter.From) {
+		return s, errors.New("to is before from")
+	}
+	if v := q.Get("revision"); v != "" {
+		rev, err := strconv.Atoi(v)
+		if err != nil || rev < 1 {
+			return s, errors.New("revision must be a positive integer")
+		}
+		s.filter.Revision = &rev
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return s, errors.New("limit must be a positive integer")
+		}
+		s.filter.Limit = min(n, maxLogSearchLimit)
+	}
+	return s, nil
+}
+
+// matches reports whether an archived line is in the searched range and
+// matches the pattern.
+func (s *logSearchQuery) matches(line k8s.LogLine) bool {
+	if s.filter.From != nil && line.Timestamp.Before(*s.filter.From) {
+		return false
+	}
+	if s.filter.To != nil && line.Timestamp.After(*s.filter.To) {
+		return false
+	}
+	return s.match == nil || s.match.MatchString(line.Message)
+}
+
+// SearchLogs searches an app's archived logs, across pods and deploys that
+// no longer exist. Lines are returned oldest first.
+func (h *Handler) SearchLogs(w http.ResponseWriter, r *http.Request) {
+	appID := chi.URLParam(r, "appID")
+
+	if h.logArchive == nil {
+		httpError(w, "log archive is not configured (set LOG_ARCHIVE_URL)", http.StatusServiceUnavailable)
+		return
+	}
+	search, err := parseLogSearch(appID, r.URL.Query())
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := h.db.GetApp(r.Context(), appID); err != nil {
+		httpError(w, "app not found", http.StatusNotFound)
+		return
+	}
+
+	filter := search.filter
+	filter.Limit = maxLogSearchChunks + 1
+	chunks, err := h.db.ListLogChunks(r.Context(), filter)
+	if err != nil {
+		httpError(w, "failed to search logs", http.StatusInternalServerError)
+		return
+	}
+
+	result := logSearchResult{Lines: []k8s.LogLine{}}
+	if len(chunks) > maxLogSearchChunks {
+		chunks, result.Truncated = chunks[:maxLogSearchChunks], true
+	}
+	lines, err := h.searchLogChunks(r.Context(), chunks, &search)
+	if err != nil {
+		httpError(w, "failed to read log archive: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	if len(lines) > search.filter.Limit {
+		lines, result.Truncated = lines[:search.filter.Limit], true
+	}
+	result.Lines = append(result.Lines, lines...)
+	result.ChunksSearched = len(chunks)
+	json.NewEncoder(w).Encode(result)
+}
+
+// searchLogChunks reads chunks from the archive, a few at a time, and
+// returns their matching lines in timestamp order. It stops reading once it
+// has more than the limit and every later chunk starts after them.
+func (h *Handler) searchLogChunks(ctx context.Context, chunks []db.LogChunk, search *logSearchQuery) ([]k8s.LogLine, error) {
+	const parallel = 8
+	var matched []k8s.LogLine
+	for start := 0; start < len(chunks); start += parallel {
+		batch := chunks[start:min(start+parallel, len(chunks))]
+		results := make([][]k8s.LogLine, len(batch))
+		errs := make([]error, len(batch))
+		var wg sync.WaitGroup
+		for i := range batch {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				data, err := h.logArchive.Get(ctx, batch[i].ObjectKey)
+				if errors.Is(err, logarchive.ErrNotFound) {
+					return // swept while we searched
+				}
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				lines, err := logarchive.DecodeChunk(data)
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				for _, line := range lines {
+					if search.matches(line) {
+						results[i] = append(results[i], line)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		for i := range batch {
+			if errs[i] != nil {
+				return nil, errs[i]
+			}
+			matched = append(matched, results[i]...)
+		}
+
+		// Chunks are ordered by first_at, so once enough lines precede
+		// the next chunk's first line, later chunks can't displace them.
+		sortLogLines(matched)
+		if len(matched) > search.filter.Limit && start+parallel < len(chunks) &&
+			matched[search.filter.Limit].Timestamp.Before(chunks[start+parallel].FirstAt) {
+			break
+		}
+	}
+	sortLogLines(matched)
+	return matched, nil
+}
+
+func sortLogLines(lines []k8s.LogLine) {
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].Timestamp.Before(lines[j].Timestamp) })
+}
+
+// GetProjectLogRetention returns how long the project's archived logs are
+// kept and whether the server archives logs at all.
+func (h *Handler) GetProjectLogRetention(w http.ResponseWriter, r *http.Request) {
+	project, err := h.db.GetProject(r.Context(), chi.URLParam(r, "projectID"))
+	if err != nil {
+		httpError(w, "project not found", http.StatusNotFound)
+		return
+	}
+	h.writeLogRetention(w, project)
+}
+
+// SetProjectLogRetention sets how many days the project's archived logs
+// are kept. 0 stops archiving the project's logs and deletes those already
+// archived.
+func (h *Handler) SetProjectLogRetention(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "projectID")
+
+	var req struct {
+		Days *int `json:"days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Days == nil {
+		httpError(w, `invalid request body: want {"days": N}`, http.StatusBadRequest)
+		return
+	}
+	if *req.Days < 0 || *req.Days > 3650 {
+		httpError(w, "days must be between 0 and 3650", http.StatusBadRequest)
+		return
+	}
+
+	before, err := h.db.GetProject(r.Context(), projectID)
+	if err != nil {
+		httpError(w, "project not found", http.StatusNotFound)
+		return
+	}
+	project, err := h.db.SetProjectLogRetention(r.Context(), projectID, *req.Days)
+	if err != nil {
+		httpError(w, "failed to update log retention", http.StatusInternalServerError)
+		return
+	}
+	auditDetail(r, "before_days", before.LogRetentionDays)
+	auditDetail(r, "days", project.LogRetentionDays)
+	h.writeLogRetention(w, project)
+}
+
+func (h *Handler) writeLogRetention(w http.ResponseWriter, project *db.Project) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"project_id":     project.ID,
+		"retention_days": project.LogRetentionDays,
+		"archiving":      h.logArchive != nil && project.LogRetentionDays > 0,
+	})
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vigneshsubbiah/shipit/internal/db"
+	"github.com/vigneshsubbiah/shipit/internal/k8s"
+	"github.com/vigneshsubbiah/shipit/internal/logarchive"
+)
+
+func TestParseLogSearch(t *testing.T) {
+	s, err := parseLogSearch("app-1", url.Values{
+		"q":        {"panic|fatal"},
+		"from":     {"2026-03-01T10:00:00Z"},
+		"to":       {"2026-03-01T12:00:00Z"},
+		"revision": {"7"},
+		"pod":      {"web-a"},
+		"limit":    {"100000"},
+	})
+	if err != nil {
+		t.Fatalf("parseLogSearch: %v", err)
+	}
+	f := s.filter
+	if f.AppID != "app-1" || f.Pod != "web-a" || f.Revision == nil || *f.Revision != 7 || f.Limit != maxLogSearchLimit {
+		t.Errorf("filter = %+v", f)
+	}
+	if !f.From.Equal(time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)) || !f.To.Equal(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("range = %v..%v", f.From, f.To)
+	}
+
+	s, err = parseLogSearch("app-1", url.Values{"from": {"2h"}})
+	if err != nil {
+		t.Fatalf("parseLogSearch: %v", err)
+	}
+	if ago := time.Since(*s.filter.From); ago < 2*time.Hour || ago > 2*time.Hour+time.Minute {
+		t.Errorf("from=2h gave %v ago", ago)
+	}
+	if s.match != nil || s.filter.Limit != defaultLogSearchLimit {
+		t.Errorf("defaults: %+v", s)
+	}
+
+	for _, bad := range []url.Values{
+		{"q": {"("}},
+		{"from": {"yesterday"}},
+		{"from": {"2026-03-02T00:00:00Z"}, "to": {"2026-03-01T00:00:00Z"}},
+		{"revision": {"0"}},
+		{"limit": {"-1"}},
+	} {
+		if _, err := parseLogSearch("app-1", bad); err == nil {
+			t.Errorf("parseLogSearch(%v): expected error", bad)
+		}
+	}
+}
+
+func TestGroupLogLinesByPod(t *testing.T) {
+	groups := groupLogLinesByPod([]k8s.LogLine{
+		{Pod: "b", Message: "1"}, {Pod: "a", Message: "2"}, {Pod: "b", Message: "3"},
+	})
+	if len(groups) != 2 || groups[0][0].Pod != "b" || len(groups[0]) != 2 || groups[0][1].Message != "3" || groups[1][0].Pod != "a" {
+		t.Errorf("groups = %+v", groups)
+	}
+}
+
+func TestSearchLogChunks(t *testing.T) {
+	store, err := logarchive.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &Handler{logArchive: store}
+	ctx := context.Background()
+	t0 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	// Two pods whose chunks overlap in time, plus one chunk swept from
+	// the store after the index was read.
+	put := func(pod string, offsets ...int) db.LogChunk {
+		var lines []k8s.LogLine
+		for _, o := range offsets {
+			lines = append(lines, k8s.LogLine{Pod: pod, Timestamp: t0.Add(time.Duration(o) * time.Second), Message: pod + " line"})
+		}
+		data, err := logarchive.EncodeChunk(lines)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := logarchive.ChunkKey("app-1", pod, lines[0].Timestamp.UnixNano())
+		if err := store.Put(ctx, key, data); err != nil {
+			t.Fatal(err)
+		}
+		return db.LogChunk{Pod: pod, FirstAt: lines[0].Timestamp, LastAt: lines[len(lines)-1].Timestamp, ObjectKey: key}
+	}
+	chunks := []db.LogChunk{put("web-a", 0, 2, 4), put("web-b", 1, 3), {ObjectKey: "app-1/gone/1.ndjson.gz"}}
+
+	search := logSearchQuery{filter: db.LogChunkFilter{Limit: 10}}
+	lines, err := h.searchLogChunks(ctx, chunks, &search)
+	if err != nil {
+		t.Fatalf("searchLogChunks: %v", err)
+	}
+	var order []string
+	for _, l := range lines {
+		order = append(order, l.Pod)
+	}
+	if got := len(lines); got != 5 || order[0] != "web-a" || order[1] != "web-b" || order[4] != "web-a" {
+		t.Errorf("lines across chunks should be merged by time, got %v", order)
+	}
+
+	from := t0.Add(2 * time.Second)
+	search, _ = parseLogSearch("app-1", url.Values{"q": {"^web-b"}, "from": {from.Format(time.RFC3339)}})
+	lines, err = h.searchLogChunks(ctx, chunks, &search)
+	if err != nil {
+		t.Fatalf("searchLogChunks: %v", err)
+	}
+	if len(lines) != 1 || lines[0].Pod != "web-b" || !lines[0].Timestamp.Equal(t0.Add(3*time.Second)) {
+		t.Errorf("q and from should filter lines, got %+v", lines)
+	}
+}
+
+func TestSearchLogs_ArchiveOff(t *testing.T) {
+	router := chi.NewRouter()
+	router.Get("/api/apps/{appID}/logs/search", newTestHandler().SearchLogs)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/apps/app-1/logs/search?q=panic", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503 when LOG_ARCHIVE_URL is unset", rec.Code)
+	}
+}
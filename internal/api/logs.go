@@ -1,13 +1,15 @@
 package api
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/vigneshsubbiah/shipit/internal/auth"
 	"github.com/vigneshsubbiah/shipit/internal/k8s"
 )
 
@@ -26,7 +28,7 @@ func (h *Handler) StreamLogs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	kubeconfig, err := auth.Decrypt(cluster.KubeconfigEncrypted, h.encryptKey)
+	kubeconfig, err := h.keys.Decrypt(cluster.KubeconfigEncrypted)
 	if err != nil {
 		httpError(w, "failed to decrypt kubeconfig", http.StatusInternalServerError)
 		return
@@ -38,15 +40,17 @@ func (h *Handler) StreamLogs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	follow := r.URL.Query().Get("follow") == "true"
-	tail := r.URL.Query().Get("tail")
+	opts, err := parseLogOptions(r.URL.Query())
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	logStream, err := client.GetLogs(app.Name, app.Namespace, follow, tail)
+	lines, err := client.StreamLogs(r.Context(), app.Name, app.Namespace, opts)
 	if err != nil {
 		httpError(w, "failed to get logs: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer logStream.Close()
 
 	// Set headers for SSE streaming
 	w.Header().Set("Content-Type", "text/event-stream")
@@ -61,19 +65,48 @@ func (h *Handler) StreamLogs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	scanner := bufio.NewScanner(logStream)
-	for scanner.Scan() {
-		// SSE format: data: <content>\n\n
-		fmt.Fprintf(w, "data: %s\n\n", scanner.Text())
+	// SSE format: data: {"pod", "container", "timestamp", "message"}\n\n.
+	// The channel closes when the logs end or the client disconnects
+	// (r.Context() is cancelled).
+	for line := range lines {
+		data, _ := json.Marshal(line)
+		fmt.Fprintf(w, "data: %s\n\n", data)
 		flusher.Flush()
+	}
+}
 
-		// Check if client disconnected
-		select {
-		case <-r.Context().Done():
-			return
-		default:
+// parseLogOptions reads StreamLogs' query parameters: follow, tail, pod,
+// container, since (a duration like 10m, or an RFC 3339 time),
+// previous=true and grep (a regular expression).
+func parseLogOptions(q url.Values) (k8s.LogOptions, error) {
+	opts := k8s.LogOptions{
+		Pod:       q.Get("pod"),
+		Container: q.Get("container"),
+		Follow:    q.Get("follow") == "true",
+		Previous:  q.Get("previous") == "true",
+	}
+	if tail := q.Get("tail"); tail != "" {
+		if lines, err := strconv.ParseInt(tail, 10, 64); err == nil && lines >= 0 {
+			opts.TailLines = &lines
+		}
+	}
+	if since := q.Get("since"); since != "" {
+		if d, err := time.ParseDuration(since); err == nil && d > 0 {
+			opts.Since = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, since); err == nil {
+			opts.Since = t
+		} else {
+			return opts, fmt.Errorf("invalid since %q: want a duration like 10m or an RFC 3339 time", since)
+		}
+	}
+	if grep := q.Get("grep"); grep != "" {
+		re, err := regexp.Compile(grep)
+		if err != nil {
+			return opts, fmt.Errorf("invalid grep pattern: %v", err)
 		}
+		opts.Grep = re
 	}
+	return opts, nil
 }
 
 func (h *Handler) GetAppStatus(w http.ResponseWriter, r *http.Request) {
@@ -91,7 +124,7 @@ func (h *Handler) GetAppStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	kubeconfig, err := auth.Decrypt(cluster.KubeconfigEncrypted, h.encryptKey)
+	kubeconfig, err := h.keys.Decrypt(cluster.KubeconfigEncrypted)
 	if err != nil {
 		httpError(w, "failed to decrypt kubeconfig", http.StatusInternalServerError)
 		return
@@ -104,7 +137,7 @@ func (h *Handler) GetAppStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Use enhanced status that includes CPU/memory metrics from metrics-server
-	status, err := client.GetEnhancedDeploymentStatus(app.Name, app.Namespace)
+	status, err := client.GetEnhancedDeploymentStatus(deploymentName(app), app.Namespace)
 	if err != nil {
 		httpError(w, "failed to get status: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseLogOptions(t *testing.T) {
+	q := url.Values{
+		"follow":    {"true"},
+		"tail":      {"50"},
+		"pod":       {"web-a"},
+		"container": {"proxy"},
+		"since":     {"10m"},
+		"grep":      {"ERROR|WARN"},
+	}
+	opts, err := parseLogOptions(q)
+	if err != nil {
+		t.Fatalf("parseLogOptions: %v", err)
+	}
+	if !opts.Follow || opts.Pod != "web-a" || opts.Container != "proxy" || opts.Previous {
+		t.Errorf("unexpected options: %+v", opts)
+	}
+	if opts.TailLines == nil || *opts.TailLines != 50 {
+		t.Errorf("tail = %v, want 50", opts.TailLines)
+	}
+	if ago := time.Since(opts.Since); ago < 10*time.Minute || ago > 11*time.Minute {
+		t.Errorf("since=10m gave %v ago", ago)
+	}
+	if opts.Grep == nil || !opts.Grep.MatchString("WARN disk") || opts.Grep.MatchString("INFO ok") {
+		t.Errorf("grep = %v", opts.Grep)
+	}
+
+	opts, err = parseLogOptions(url.Values{"since": {"2026-03-01T10:00:00Z"}, "previous": {"true"}})
+	if err != nil {
+		t.Fatalf("parseLogOptions: %v", err)
+	}
+	if !opts.Previous || !opts.Since.Equal(time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected options: %+v", opts)
+	}
+
+	for _, bad := range []url.Values{{"since": {"yesterday"}}, {"grep": {"("}}} {
+		if _, err := parseLogOptions(bad); err == nil {
+			t.Errorf("parseLogOptions(%v): expected error", bad)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vigneshsubbiah/shipit/internal/auth"
+	"github.com/vigneshsubbiah/shipit/internal/db"
+)
+
+// Project resolvers for auth.ProjectAccess: each maps a route's ID
+// parameter to the project that owns it.
+
+func (h *Handler) projectOfProject(r *http.Request) (auth.Target, error) {
+	return auth.Target{ProjectID: chi.URLParam(r, "projectID")}, nil
+}
+
+func (h *Handler) projectOfCluster(r *http.Request) (auth.Target, error) {
+	cluster, err := h.db.GetCluster(r.Context(), chi.URLParam(r, "clusterID"))
+	if err != nil {
+		return auth.Target{}, err
+	}
+	return auth.Target{ProjectID: cluster.ProjectID}, nil
+}
+
+func (h *Handler) projectOfApp(r *http.Request) (auth.Target, error) {
+	return h.appTarget(r, chi.URLParam(r, "appID"))
+}
+
+func (h *Handler) projectOfDeploy(r *http.Request) (auth.Target, error) {
+	job, err := h.db.GetDeployJob(r.Context(), chi.URLParam(r, "deployID"))
+	if err != nil {
+		return auth.Target{}, err
+	}
+	return h.appTarget(r, job.AppID)
+}
+
+func (h *Handler) appTarget(r *http.Request, appID string) (auth.Target, error) {
+	projectID, err := h.db.GetAppProjectID(r.Context(), appID)
+	if err != nil {
+		return auth.Target{}, err
+	}
+	return auth.Target{ProjectID: projectID, AppID: appID}, nil
+}
+
+// ListProjectMembers returns a project's members and their roles
+func (h *Handler) ListProjectMembers(w http.ResponseWriter, r *http.Request) {
+	members, err := h.db.ListProjectMembers(r.Context(), chi.URLParam(r, "projectID"))
+	if err != nil {
+		httpError(w, "failed to list members", http.StatusInternalServerError)
+		return
+	}
+	if members == nil {
+		members = []db.ProjectMember{}
+	}
+	json.NewEncoder(w).Encode(members)
+}
+
+// AddProjectMember grants a user a role in the project, or changes the role
+// of an existing member. The user must have signed in to shipit once.
+func (h *Handler) AddProjectMember(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "projectID")
+
+	var req struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Email == "" {
+		httpError(w, "email is required", http.StatusBadRequest)
+		return
+	}
+	role := auth.Role(req.Role)
+	if !auth.ValidRole(role) {
+		httpError(w, "role must be one of viewer, deployer, admin", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.db.GetUserByEmail(r.Context(), req.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		httpError(w, "no user with that email; they need to sign in to shipit once first", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpError(w, "failed to look up user", http.StatusInternalServerError)
+		return
+	}
+
+	if role != auth.RoleAdmin && !h.checkLastAdmin(w, r, projectID, user.ID) {
+		return
+	}
+
+	_, _, addedBy := auditActor(r)
+	member, err := h.db.UpsertProjectMember(r.Context(), projectID, user.ID, string(role), &addedBy)
+	if err != nil {
+		httpError(w, "failed to add member", http.StatusInternalServerError)
+		return
+	}
+	auditDetail(r, "email", member.Email)
+	auditDetail(r, "role", member.Role)
+
+	json.NewEncoder(w).Encode(member)
+}
+
+// RemoveProjectMember revokes a user's membership. The member can be given
+// by user ID or email.
+func (h *Handler) RemoveProjectMember(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "projectID")
+	member := chi.URLParam(r, "member")
+
+	userID := member
+	if strings.Contains(member, "@") {
+		user, err := h.db.GetUserByEmail(r.Context(), member)
+		if err != nil {
+			httpError(w, "member not found", http.StatusNotFound)
+			return
+		}
+		userID = user.ID
+	}
+
+	if !h.checkLastAdmin(w, r, projectID, userID) {
+		return
+	}
+
+	removed, err := h.db.RemoveProjectMember(r.Context(), projectID, userID)
+	if err != nil {
+		httpError(w, "failed to remove member", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		httpError(w, "member not found", http.StatusNotFound)
+		return
+	}
+	auditDetail(r, "member", member)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListProjectGroups returns the roles granted to OIDC groups in a project
+func (h *Handler) ListProjectGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := h.db.ListProjectGroupRoles(r.Context(), chi.URLParam(r, "projectID"))
+	if err != nil {
+		httpError(w, "failed to list groups", http.StatusInternalServerError)
+		return
+	}
+	if groups == nil {
+		groups = []db.ProjectGroupRole{}
+	}
+	json.NewEncoder(w).Encode(groups)
+}
+
+// AddProjectGroup grants everyone in an OIDC group a role in the project,
+// or changes the group's role. Members get the higher of their own role and
+// their groups' roles; groups are refreshed each time a user signs in.
+// Groups are named with their provider (okta:eng), so only that provider's
+// logins match.
+func (h *Handler) AddProjectGroup(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Group string `json:"group"`
+		Role  string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := auth.ValidateProviderGroup(req.Group); err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	role := auth.Role(req.Role)
+	if !auth.ValidRole(role) {
+		httpError(w, "role must be one of viewer, deployer, admin", http.StatusBadRequest)
+		return
+	}
+
+	_, _, addedBy := auditActor(r)
+	group, err := h.db.UpsertProjectGroupRole(r.Context(), chi.URLParam(r, "projectID"), req.Group, string(role), &addedBy)
+	if err != nil {
+		httpError(w, "failed to add group", http.StatusInternalServerError)
+		return
+	}
+	auditDetail(r, "group", group.GroupName)
+	auditDetail(r, "role", group.Role)
+
+	json.NewEncoder(w).Encode(group)
+}
+
+// RemoveProjectGroup revokes a group's role. Group names containing "/"
+// (such as GitHub's org/team) must be path-escaped.
+func (h *Handler) RemoveProjectGroup(w http.ResponseWriter, r *http.Request) {
+	group, err := url.PathUnescape(chi.URLParam(r, "group"))
+	if err != nil {
+		httpError(w, "invalid group name", http.StatusBadRequest)
+		return
+	}
+
+	removed, err := h.db.RemoveProjectGroupRole(r.Context(), chi.URLParam(r, "projectID"), group)
+	if err != nil {
+		httpError(w, "failed to remove group", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		httpError(w, "group not found", http.StatusNotFound)
+		return
+	}
+	auditDetail(r, "group", group)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkLastAdmin refuses to take the admin role away from userID if they
+// are the project's last admin, so members can't orphan a project. It
+// writes the error response and returns false if the change must not go
+// ahead.
+func (h *Handler) checkLastAdmin(w http.ResponseWriter, r *http.Request, projectID, userID string) bool {
+	role, err := h.db.GetProjectMemberRole(r.Context(), projectID, userID)
+	if err != nil || auth.Role(role) != auth.RoleAdmin {
+		return true
+	}
+	admins, err := h.db.CountProjectAdmins(r.Context(), projectID)
+	if err != nil {
+		httpError(w, "failed to count project admins", http.StatusInternalServerError)
+		return false
+	}
+	if admins <= 1 {
+		httpError(w, "cannot remove the project's last admin; add another admin first", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// addProjectCreator makes the user who created a project its first admin.
+// Legacy API tokens have no user and rely on being platform admins.
+func (h *Handler) addProjectCreator(r *http.Request, projectID string) {
+	user := auth.GetUser(r.Context())
+	if user == nil {
+		return
+	}
+	addedBy := user.Email
+	if _, err := h.db.UpsertProjectMember(r.Context(), projectID, user.ID, string(auth.RoleAdmin), &addedBy); err != nil {
+		log.Printf("rbac: failed to add %s as admin of new project %s: %v", user.Email, projectID, err)
+	}
+}
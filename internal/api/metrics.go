@@ -0,0 +1,55 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/gorilla/websocket"
+	"github.com/vigneshsubbiah/shipit/internal/metrics"
+)
+
+// instrumentRoutes records each request's latency in
+// metrics.HTTPRequestSeconds under its chi route pattern, not its path, so
+// /api/apps/{appID} is one series however many apps there are.
+func instrumentRoutes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		code := ww.Status()
+		switch {
+		case code == 0 && websocket.IsWebSocketUpgrade(r):
+			// The upgrade wrote 101 on the hijacked connection.
+			code = http.StatusSwitchingProtocols
+		case code == 0:
+			code = http.StatusOK
+		}
+		metrics.ObserveSince(metrics.HTTPRequestSeconds.WithLabelValues(r.Method, route, strconv.Itoa(code)), start)
+	})
+}
+
+// metricsHandler serves /metrics, behind a bearer token when one is set.
+func metricsHandler(token string) http.Handler {
+	serve := metrics.Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		serve.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vigneshsubbiah/shipit/internal/metrics"
+)
+
+func scrapeMetrics(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("scrape: status %d", rec.Code)
+	}
+	return rec.Body.String()
+}
+
+func TestInstrumentRoutes_LabelsByRoutePattern(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(instrumentRoutes)
+	r.Route("/api/apps/{appID}", func(r chi.Router) {
+		r.Get("/status", func(w http.ResponseWriter, r *http.Request) {
+			httpError(w, "app not found", http.StatusNotFound)
+		})
+	})
+
+	for _, path := range []string{"/api/apps/a1/status", "/api/apps/a2/status"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/nope", nil))
+
+	got := scrapeMetrics(t)
+	want := `shipit_http_request_duration_seconds_count{code="404",method="GET",route="/api/apps/{appID}/status"} 2`
+	if !strings.Contains(got, want) {
+		t.Errorf("metrics missing %q:\n%s", want, got)
+	}
+	if strings.Contains(got, "/api/apps/a1") {
+		t.Error("metrics labelled with a raw path")
+	}
+	if !strings.Contains(got, `code="404",method="GET",route="unmatched"`) {
+		t.Error("unrouted request not recorded as unmatched")
+	}
+}
+
+func TestMetricsHandler_Token(t *testing.T) {
+	for _, tc := range []struct {
+		name, token, header string
+		want                int
+	}{
+		{"open", "", "", http.StatusOK},
+		{"missing token", "s3cret", "", http.StatusUnauthorized},
+		{"wrong token", "s3cret", "Bearer nope", http.StatusUnauthorized},
+		{"right token", "s3cret", "Bearer s3cret", http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			rec := httptest.NewRecorder()
+			metricsHandler(tc.token).ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tc.want)
+			}
+			if tc.want == http.StatusOK && !strings.Contains(rec.Body.String(), "# TYPE shipit_auto_rollbacks_total counter") {
+				t.Errorf("body missing the auto-rollback counter:\n%s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestDeployOutcomeLabel(t *testing.T) {
+	for status, want := range map[string]string{
+		"succeeded":   "success",
+		"failed":      "failed",
+		"rolled_back": "rolled_back",
+	} {
+		if got := deployOutcomeLabel(status); got != want {
+			t.Errorf("deployOutcomeLabel(%q) = %q, want %q", status, got, want)
+		}
+	}
+}
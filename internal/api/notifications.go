@@ -0,0 +1,495 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vigneshsubbiah/shipit/internal/db"
+	"github.com/vigneshsubbiah/shipit/internal/notify"
+)
+
+// notificationConfig is one scope's notification settings with secrets
+// decrypted. A nil field is unset: at app scope it inherits the project's
+// value, at project scope it means none (or, for Events, every event).
+type notificationConfig struct {
+	SlackWebhookURL *string
+	WebhookURL      *string
+	WebhookSecret   *string
+	Events          []notify.EventType
+}
+
+// decodeNotificationSettings decrypts a settings row. A nil row decodes to
+// the zero config.
+func (h *Handler) decodeNotificationSettings(row *db.NotificationSettings) (notificationConfig, error) {
+	var c notificationConfig
+	if row == nil {
+		return c, nil
+	}
+	if row.SlackWebhookEncrypted != nil {
+		plain, err := h.keys.Decrypt(row.SlackWebhookEncrypted)
+		if err != nil {
+			return c, fmt.Errorf("failed to decrypt slack webhook: %w", err)
+		}
+		s := string(plain)
+		c.SlackWebhookURL = &s
+	}
+	c.WebhookURL = row.WebhookURL
+	if row.WebhookSecretEncrypted != nil {
+		plain, err := h.keys.Decrypt(row.WebhookSecretEncrypted)
+		if err != nil {
+			return c, fmt.Errorf("failed to decrypt webhook secret: %w", err)
+		}
+		s := string(plain)
+		c.WebhookSecret = &s
+	}
+	if len(row.Events) > 0 && string(row.Events) != "null" {
+		if err := json.Unmarshal(row.Events, &c.Events); err != nil {
+			return c, fmt.Errorf("invalid events: %w", err)
+		}
+		if c.Events == nil {
+			c.Events = []notify.EventType{}
+		}
+	}
+	return c, nil
+}
+
+// effectiveNotifications merges an app's override onto its project's
+// defaults, field by field.
+func effectiveNotifications(project, app notificationConfig) notify.Target {
+	pick := func(a, p *string) string {
+		if a != nil {
+			return *a
+		}
+		if p != nil {
+			return *p
+		}
+		return ""
+	}
+	t := notify.Target{
+		SlackWebhookURL: pick(app.SlackWebhookURL, project.SlackWebhookURL),
+		WebhookURL:      pick(app.WebhookURL, project.WebhookURL),
+		WebhookSecret:   pick(app.WebhookSecret, project.WebhookSecret),
+	}
+	switch {
+	case app.Events != nil:
+		t.Events = app.Events
+	case project.Events != nil:
+		t.Events = project.Events
+	default:
+		t.Events = notify.EventTypes
+	}
+	return t
+}
+
+// publish sends a deploy lifecycle event for app to its configured
+// notification channels. Delivery is asynchronous; failures are logged
+// and never affect the deploy.
+func (h *Handler) publish(app *db.App, e notify.Event) {
+	if h.notifier == nil || h.db == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	projectRow, appRow, err := h.db.GetNotificationSettingsForApp(ctx, app.ID)
+	if err != nil {
+		log.Printf("notify: failed to load settings app=%s event=%s err=%v", app.ID, e.Type, err)
+		return
+	}
+	if projectRow == nil && appRow == nil {
+		return
+	}
+	project, err := h.decodeNotificationSettings(projectRow)
+	if err != nil {
+		log.Printf("notify: project settings unusable app=%s err=%v", app.ID, err)
+	}
+	override, err := h.decodeNotificationSettings(appRow)
+	if err != nil {
+		log.Printf("notify: app settings unusable app=%s err=%v", app.ID, err)
+	}
+
+	e.AppID, e.App, e.Namespace = app.ID, app.Name, app.Namespace
+	h.notifier.Send(effectiveNotifications(project, override), e)
+}
+
+// deployEvent builds the event for a deploy of revision from src.
+func deployEvent(t notify.EventType, revision int, image string, src deploySource) notify.Event {
+	e := notify.Event{Type: t, Revision: revision, Image: image, RollbackTo: src.RollbackTo}
+	if src.CommitSHA != nil {
+		e.CommitSHA = *src.CommitSHA
+	}
+	if src.CIURL != nil {
+		e.CIURL = *src.CIURL
+	}
+	if src.RequestedBy != nil {
+		e.RequestedBy = *src.RequestedBy
+	}
+	return e
+}
+
+// publishDeployOutcome reports how a deploy that got as far as creating
+// its revision ended, read back from the revision's deploy_status.
+// Rolled-back revisions are skipped: autoRollback already sent
+// auto_rolled_back for them.
+func (h *Handler) publishDeployOutcome(ctx context.Context, app *db.App, revision int, image string, src deploySource) {
+	rev, err := h.db.GetRevision(ctx, app.ID, revision)
+	if err != nil {
+		return
+	}
+	var e notify.Event
+	switch rev.DeployStatus {
+	case "success":
+		e = deployEvent(notify.EventDeploySucceeded, revision, image, src)
+	case "failed":
+		e = deployEvent(notify.EventDeployFailed, revision, image, src)
+		if rev.DeployMessage != nil {
+			e.Message = *rev.DeployMessage
+		}
+	default:
+		return
+	}
+	h.publish(app, e)
+}
+
+// notificationSettingsView is the API shape of one scope's settings. The
+// Slack URL embeds a credential and the webhook secret is write-only, so
+// both are masked. At app scope null means "inherit from the project".
+type notificationSettingsView struct {
+	SlackWebhook  *string  `json:"slack_webhook"`
+	WebhookURL    *string  `json:"webhook_url"`
+	WebhookSecret *string  `json:"webhook_secret"`
+	Events        []string `json:"events"`
+}
+
+func notificationView(c notificationConfig) notificationSettingsView {
+	v := notificationSettingsView{WebhookURL: c.WebhookURL}
+	if c.SlackWebhookURL != nil {
+		masked := maskWebhookURL(*c.SlackWebhookURL)
+		v.SlackWebhook = &masked
+	}
+	if c.WebhookSecret != nil {
+		masked := ""
+		if *c.WebhookSecret != "" {
+			masked = "********"
+		}
+		v.WebhookSecret = &masked
+	}
+	if c.Events != nil {
+		v.Events = make([]string, len(c.Events))
+		for i, e := range c.Events {
+			v.Events[i] = string(e)
+		}
+	}
+	return v
+}
+
+// maskWebhookURL hides the path of a Slack webhook URL, which is the
+// token.
+func maskWebhookURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "********"
+	}
+	return u.Scheme + "://" + u.Host + "/********"
+}
+
+func effectiveView(t notify.Target) notificationSettingsView {
+	return notificationView(notificationConfig{
+		SlackWebhookURL: &t.SlackWebhookURL,
+		WebhookURL:      &t.WebhookURL,
+		WebhookSecret:   &t.WebhookSecret,
+		Events:          t.Events,
+	})
+}
+
+// loadNotificationSettings returns the decoded settings for one scope,
+// or the zero config when the scope has none.
+func (h *Handler) loadNotificationSettings(ctx context.Context, projectID, appID string) (notificationConfig, error) {
+	var row *db.NotificationSettings
+	var err error
+	if appID != "" {
+		row, err = h.db.GetAppNotificationSettings(ctx, appID)
+	} else {
+		row, err = h.db.GetProjectNotificationSettings(ctx, projectID)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return notificationConfig{}, nil
+	}
+	if err != nil {
+		return notificationConfig{}, err
+	}
+	return h.decodeNotificationSettings(row)
+}
+
+// GetAppNotifications returns the app's notification overrides and the
+// settings in effect after merging them onto the project's defaults.
+func (h *Handler) GetAppNotifications(w http.ResponseWriter, r *http.Request) {
+	appID := chi.URLParam(r, "appID")
+
+	if _, err := h.db.GetApp(r.Context(), appID); err != nil {
+		httpError(w, "app not found", http.StatusNotFound)
+		return
+	}
+
+	h.writeAppNotifications(w, r, appID)
+}
+
+func (h *Handler) writeAppNotifications(w http.ResponseWriter, r *http.Request, appID string) {
+	projectRow, appRow, err := h.db.GetNotificationSettingsForApp(r.Context(), appID)
+	if err != nil {
+		httpError(w, "failed to load notification settings", http.StatusInternalServerError)
+		return
+	}
+	project, err := h.decodeNotificationSettings(projectRow)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	override, err := h.decodeNotificationSettings(appRow)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"app_id":    appID,
+		"overrides": notificationView(override),
+		"effective": effectiveView(effectiveNotifications(project, override)),
+	})
+}
+
+// SetAppNotifications updates the app's notification overrides. Fields
+// left out of the body keep their value; null resets a field to inherit
+// the project's setting, and "" (or [] for events) turns it off for this
+// app.
+func (h *Handler) SetAppNotifications(w http.ResponseWriter, r *http.Request) {
+	appID := chi.URLParam(r, "appID")
+
+	if _, err := h.db.GetApp(r.Context(), appID); err != nil {
+		httpError(w, "app not found", http.StatusNotFound)
+		return
+	}
+	if !h.updateNotificationSettings(w, r, "", appID) {
+		return
+	}
+
+	h.writeAppNotifications(w, r, appID)
+}
+
+// GetProjectNotifications returns the project's default notification
+// settings.
+func (h *Handler) GetProjectNotifications(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "projectID")
+
+	if _, err := h.db.GetProject(r.Context(), projectID); err != nil {
+		httpError(w, "project not found", http.StatusNotFound)
+		return
+	}
+
+	h.writeProjectNotifications(w, r, projectID)
+}
+
+// SetProjectNotifications updates the project's default notification
+// settings. Fields left out keep their value; null or "" clears one, and
+// null events subscribes to every event.
+func (h *Handler) SetProjectNotifications(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "projectID")
+
+	if _, err := h.db.GetProject(r.Context(), projectID); err != nil {
+		httpError(w, "project not found", http.StatusNotFound)
+		return
+	}
+	if !h.updateNotificationSettings(w, r, projectID, "") {
+		return
+	}
+
+	h.writeProjectNotifications(w, r, projectID)
+}
+
+func (h *Handler) writeProjectNotifications(w http.ResponseWriter, r *http.Request, projectID string) {
+	settings, err := h.loadNotificationSettings(r.Context(), projectID, "")
+	if err != nil {
+		httpError(w, "failed to load notification settings", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"project_id": projectID,
+		"settings":   notificationView(settings),
+		"effective":  effectiveView(effectiveNotifications(settings, notificationConfig{})),
+	})
+}
+
+// updateNotificationSettings applies a PUT body to one scope's stored
+// settings. Writes the error response and returns false on failure.
+func (h *Handler) updateNotificationSettings(w http.ResponseWriter, r *http.Request, projectID, appID string) bool {
+	var body map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return false
+	}
+
+	current, err := h.loadNotificationSettings(r.Context(), projectID, appID)
+	if err != nil {
+		httpError(w, "failed to load notification settings", http.StatusInternalServerError)
+		return false
+	}
+	updated, err := applyNotificationUpdate(current, body)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	// Refuse URLs aimed at internal addresses when they are set; the
+	// notifier checks again on every delivery.
+	for _, u := range []struct {
+		key   string
+		value *string
+	}{{"slack_webhook", updated.SlackWebhookURL}, {"webhook_url", updated.WebhookURL}} {
+		if _, set := body[u.key]; !set || u.value == nil || *u.value == "" || h.notifier == nil {
+			continue
+		}
+		if err := h.notifier.CheckURL(r.Context(), *u.value); err != nil {
+			httpError(w, u.key+": "+err.Error(), http.StatusBadRequest)
+			return false
+		}
+	}
+	// Secrets are masked in the diff, so also record which fields were
+	// written.
+	auditChanges(r, notificationView(current), notificationView(updated))
+	fields := make([]string, 0, len(body))
+	for key := range body {
+		fields = append(fields, key)
+	}
+	sort.Strings(fields)
+	auditDetail(r, "fields", fields)
+
+	params := db.UpsertNotificationSettingsParams{WebhookURL: updated.WebhookURL}
+	if appID != "" {
+		params.AppID = &appID
+	} else {
+		params.ProjectID = &projectID
+	}
+	if updated.SlackWebhookURL != nil {
+		if params.SlackWebhookEncrypted, err = h.keys.Encrypt([]byte(*updated.SlackWebhookURL)); err != nil {
+			httpError(w, "failed to encrypt slack webhook", http.StatusInternalServerError)
+			return false
+		}
+	}
+	if updated.WebhookSecret != nil {
+		if params.WebhookSecretEncrypted, err = h.keys.Encrypt([]byte(*updated.WebhookSecret)); err != nil {
+			httpError(w, "failed to encrypt webhook secret", http.StatusInternalServerError)
+			return false
+		}
+	}
+	if updated.Events != nil {
+		params.Events, _ = json.Marshal(updated.Events)
+	}
+
+	if _, err := h.db.UpsertNotificationSettings(r.Context(), params); err != nil {
+		httpError(w, "failed to update notification settings", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// applyNotificationUpdate applies the fields present in a PUT body to c.
+// A JSON null unsets the field.
+func applyNotificationUpdate(c notificationConfig, body map[string]json.RawMessage) (notificationConfig, error) {
+	for key, raw := range body {
+		null := string(raw) == "null"
+		switch key {
+		case "slack_webhook", "webhook_url", "webhook_secret":
+			var value *string
+			if !null {
+				var s string
+				if err := json.Unmarshal(raw, &s); err != nil {
+					return c, fmt.Errorf("%s must be a string or null", key)
+				}
+				value = &s
+			}
+			switch key {
+			case "slack_webhook":
+				if value != nil && *value != "" {
+					if err := validateWebhookURL(*value, true); err != nil {
+						return c, fmt.Errorf("slack_webhook: %w", err)
+					}
+				}
+				c.SlackWebhookURL = value
+			case "webhook_url":
+				if value != nil && *value != "" {
+					if err := validateWebhookURL(*value, false); err != nil {
+						return c, fmt.Errorf("webhook_url: %w", err)
+					}
+				}
+				c.WebhookURL = value
+			case "webhook_secret":
+				c.WebhookSecret = value
+			}
+		case "events":
+			if null {
+				c.Events = nil
+				continue
+			}
+			var names []string
+			if err := json.Unmarshal(raw, &names); err != nil {
+				return c, fmt.Errorf("events must be a list of event types or null")
+			}
+			c.Events = []notify.EventType{}
+			seen := make(map[string]bool)
+			for _, name := range names {
+				if !notify.ValidEventType(name) {
+					return c, fmt.Errorf("unknown event %q (valid: %s)", name, eventTypeList())
+				}
+				if !seen[name] {
+					seen[name] = true
+					c.Events = append(c.Events, notify.EventType(name))
+				}
+			}
+		default:
+			return c, fmt.Errorf("unknown field %q", key)
+		}
+	}
+	return c, nil
+}
+
+func validateWebhookURL(raw string, requireHTTPS bool) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return fmt.Errorf("must be an absolute http(s) URL")
+	}
+	if requireHTTPS && u.Scheme != "https" {
+		return fmt.Errorf("must be an https URL")
+	}
+	return nil
+}
+
+func eventTypeList() string {
+	s := ""
+	for i, t := range notify.EventTypes {
+		if i > 0 {
+			s += ", "
+		}
+		s += string(t)
+	}
+	return s
+}
+
+// publishPreDeployFailure reports a failed pre-deploy hook; the hook's
+// output is the message.
+func (h *Handler) publishPreDeployFailure(app *db.App, revision int, image string, src deploySource, msg string) {
+	e := deployEvent(notify.EventPredeployFailed, revision, image, src)
+	e.Message = msg
+	h.publish(app, e)
+}
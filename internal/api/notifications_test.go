@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vigneshsubbiah/shipit/internal/notify"
+)
+
+func TestEffectiveNotifications(t *testing.T) {
+	project := notificationConfig{
+		SlackWebhookURL: strPtr("https://hooks.slack.com/services/P"),
+		WebhookURL:      strPtr("https://ci.example.com/hook"),
+		WebhookSecret:   strPtr("project-secret"),
+	}
+
+	tests := []struct {
+		name    string
+		project notificationConfig
+		app     notificationConfig
+		want    notify.Target
+	}{
+		{
+			name: "nothing configured",
+			want: notify.Target{Events: notify.EventTypes},
+		},
+		{
+			name:    "app inherits project",
+			project: project,
+			want: notify.Target{
+				SlackWebhookURL: "https://hooks.slack.com/services/P",
+				WebhookURL:      "https://ci.example.com/hook",
+				WebhookSecret:   "project-secret",
+				Events:          notify.EventTypes,
+			},
+		},
+		{
+			name:    "app overrides and disables fields",
+			project: project,
+			app: notificationConfig{
+				SlackWebhookURL: strPtr("https://hooks.slack.com/services/A"),
+				WebhookURL:      strPtr(""),
+				Events:          []notify.EventType{notify.EventDeployFailed},
+			},
+			want: notify.Target{
+				SlackWebhookURL: "https://hooks.slack.com/services/A",
+				WebhookSecret:   "project-secret",
+				Events:          []notify.EventType{notify.EventDeployFailed},
+			},
+		},
+		{
+			name:    "project events apply unless the app sets its own",
+			project: notificationConfig{Events: []notify.EventType{notify.EventAutoRolledBack}},
+			want:    notify.Target{Events: []notify.EventType{notify.EventAutoRolledBack}},
+		},
+		{
+			name:    "empty app events mutes the app",
+			project: project,
+			app:     notificationConfig{Events: []notify.EventType{}},
+			want: notify.Target{
+				SlackWebhookURL: "https://hooks.slack.com/services/P",
+				WebhookURL:      "https://ci.example.com/hook",
+				WebhookSecret:   "project-secret",
+				Events:          []notify.EventType{},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := effectiveNotifications(tt.project, tt.app)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyNotificationUpdate(t *testing.T) {
+	current := notificationConfig{
+		SlackWebhookURL: strPtr("https://hooks.slack.com/services/OLD"),
+		WebhookSecret:   strPtr("keep-me"),
+	}
+	var body map[string]json.RawMessage
+	json.Unmarshal([]byte(`{
+		"slack_webhook": null,
+		"webhook_url": "https://ci.example.com/hook",
+		"events": ["deploy_failed", "auto_rolled_back", "deploy_failed"]
+	}`), &body)
+
+	got, err := applyNotificationUpdate(current, body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.SlackWebhookURL != nil {
+		t.Errorf("slack_webhook null should unset the field, got %q", *got.SlackWebhookURL)
+	}
+	if got.WebhookURL == nil || *got.WebhookURL != "https://ci.example.com/hook" {
+		t.Errorf("webhook_url = %v", got.WebhookURL)
+	}
+	if got.WebhookSecret == nil || *got.WebhookSecret != "keep-me" {
+		t.Errorf("absent webhook_secret should be kept, got %v", got.WebhookSecret)
+	}
+	want := []notify.EventType{notify.EventDeployFailed, notify.EventAutoRolledBack}
+	if !reflect.DeepEqual(got.Events, want) {
+		t.Errorf("events = %v, want %v", got.Events, want)
+	}
+}
+
+func TestApplyNotificationUpdateRejects(t *testing.T) {
+	tests := []struct {
+		body    string
+		wantErr string
+	}{
+		{`{"slack_webhook": "http://hooks.slack.com/services/X"}`, "https"},
+		{`{"webhook_url": "ci.example.com/hook"}`, "absolute"},
+		{`{"events": ["deploy_exploded"]}`, "unknown event"},
+		{`{"events": "deploy_failed"}`, "list of event types"},
+		{`{"webhook_secret": 42}`, "string or null"},
+		{`{"slack": "x"}`, "unknown field"},
+	}
+	for _, tt := range tests {
+		var body map[string]json.RawMessage
+		json.Unmarshal([]byte(tt.body), &body)
+		_, err := applyNotificationUpdate(notificationConfig{}, body)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: err = %v, want containing %q", tt.body, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNotificationViewMasksSecrets(t *testing.T) {
+	v := notificationView(notificationConfig{
+		SlackWebhookURL: strPtr("https://hooks.slack.com/services/T0/B0/token"),
+		WebhookSecret:   strPtr("s3cret"),
+	})
+	if *v.SlackWebhook != "https://hooks.slack.com/********" {
+		t.Errorf("slack_webhook = %q", *v.SlackWebhook)
+	}
+	if *v.WebhookSecret != "********" {
+		t.Errorf("webhook_secret = %q", *v.WebhookSecret)
+	}
+	if v.WebhookURL != nil || v.Events != nil {
+		t.Errorf("unset fields should stay null: %+v", v)
+	}
+}
@@ -0,0 +1,325 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/vigneshsubbiah/shipit/internal/db"
+	"github.com/vigneshsubbiah/shipit/internal/k8s"
+)
+
+// deployReconcileInterval is how often the reconciler re-scans for apps
+// stuck mid-deploy after the boot-time pass.
+const deployReconcileInterval = time.Minute
+
+// reconcilableStatuses are the non-terminal statuses deployApp moves an app
+// through. An app sitting in one of them with no live deploy job behind it
+// was orphaned — typically by a server restart between the status write
+// and the terminal one.
+var reconcilableStatuses = []string{"deploying", "running_predeploy", "canary", "verifying", "rolling_back"}
+
+// Reconcile actions, chosen by reconcileAction from the app's DB status and
+// a single CheckRollout read of the live Deployment.
+const (
+	reconcileWait       = "wait"        // rollout still progressing; look again next pass
+	reconcileAbandon    = "abandon"     // new revision never reached the cluster
+	reconcilePromote    = "promote"     // verifying and the rollout is healthy
+	reconcileRollback   = "rollback"    // verifying and the rollout failed → autoRollback
+	reconcileRolledBack = "rolled_back" // autoRollback's redeploy of the prior revision is healthy
+	reconcileFail       = "fail"        // autoRollback's redeploy did not come up either
+)
+
+// reconcileAction decides what to do with an orphaned app.
+//
+// deploying, running_predeploy and canary are set before client.DeployApp,
+// so the new spec was never applied and the Deployment still runs the
+// previous revision (a canary only ever touches the <name>-canary
+// objects). rolling_back is ambiguous: RollbackApp and the deploy worker
+// set it (with no message) before the rollback revision is applied, while
+// autoRollback sets it with the original rollout failure as the message
+// right before redeploying the prior revision. Only the latter has touched
+// the cluster.
+func reconcileAction(status string, statusMessage *string, state k8s.RolloutState) string {
+	if state == k8s.RolloutProgressing {
+		return reconcileWait
+	}
+	switch status {
+	case "verifying":
+		if state == k8s.RolloutReady {
+			return reconcilePromote
+		}
+		return reconcileRollback
+	case "rolling_back":
+		if statusMessage == nil || *statusMessage == "" {
+			return reconcileAbandon
+		}
+		if state == k8s.RolloutReady {
+			return reconcileRolledBack
+		}
+		return reconcileFail
+	default:
+		return reconcileAbandon
+	}
+}
+
+// RunDeployReconciler settles apps left mid-deploy by a server restart:
+// once at boot and then every deployReconcileInterval until ctx is
+// cancelled. Apps with a live deploy job (queued, or running with a fresh
+// heartbeat) are left alone — they aren't orphaned. Each pass also scales
+// down blue/green idle colors whose rollback window has ended.
+func (h *Handler) RunDeployReconciler(ctx context.Context) {
+	workerID := "reconciler-" + deployWorkerBaseID()
+	ticker := time.NewTicker(deployReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		h.reconcileStuckApps(ctx, workerID)
+		h.retireIdleColors(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Handler) reconcileStuckApps(ctx context.Context, workerID string) {
+	apps, err := h.db.ListAppsByStatus(ctx, reconcilableStatuses)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("reconcile: failed to list stuck apps err=%v", err)
+		}
+		return
+	}
+	for i := range apps {
+		if ctx.Err() != nil {
+			return
+		}
+		h.reconcileApp(ctx, &apps[i], workerID)
+	}
+}
+
+func (h *Handler) reconcileApp(ctx context.Context, app *db.App, workerID string) {
+	// A deploy for this app is running in this process; not orphaned.
+	unlock, ok := h.tryLockAppDeploy(app.ID)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	jobs, err := h.db.ListActiveDeployJobs(ctx, app.ID)
+	if err != nil {
+		log.Printf("reconcile: failed to list deploy jobs app=%s err=%v", app.ID, err)
+		return
+	}
+	var running *db.DeployJob
+	queued := false
+	for i := range jobs {
+		if jobs[i].Status == "running" {
+			running = &jobs[i]
+		} else {
+			queued = true
+		}
+	}
+	if running == nil && queued {
+		// A worker will claim it shortly and overwrite the status.
+		return
+	}
+
+	rev := h.inFlightRevision(ctx, app)
+
+	if running != nil {
+		if running.HeartbeatAt != nil && time.Since(*running.HeartbeatAt) < deployJobStaleAfter {
+			return // owned by a live worker on another replica
+		}
+		if rev == nil || rev.DeployStatus != "deploying" {
+			// Died before allocating a revision: nothing reached the
+			// cluster, so the stale-job sweeper requeues it instead.
+			return
+		}
+		took, err := h.db.TakeOverStaleDeployJob(ctx, running.ID, workerID, deployJobStaleAfter)
+		if err != nil || !took {
+			return
+		}
+		// Promoting re-runs post-rollout verification and rolling back
+		// redeploys the prior revision; either can outlast
+		// deployJobStaleAfter, and the job must not look dead again
+		// meanwhile.
+		defer h.heartbeatDeployJob(ctx, running.ID, workerID)()
+	}
+
+	cluster, err := h.db.GetCluster(ctx, app.ClusterID)
+	if err != nil {
+		log.Printf("reconcile: cluster not found app=%s err=%v", app.ID, err)
+		return
+	}
+	kubeconfig, err := h.keys.Decrypt(cluster.KubeconfigEncrypted)
+	if err != nil {
+		log.Printf("reconcile: failed to decrypt kubeconfig app=%s err=%v", app.ID, err)
+		return
+	}
+	client, err := k8s.NewClient(kubeconfig)
+	if err != nil {
+		log.Printf("reconcile: failed to create k8s client app=%s err=%v", app.ID, err)
+		return
+	}
+
+	// A blue/green deploy verifies the idle color, not the one serving.
+	// If the Service already selects it, the switchover happened and only
+	// the bookkeeping after it was lost.
+	checkName := deploymentName(app)
+	newColor := ""
+	if app.Status == "verifying" && usesBlueGreen(app) {
+		newColor = k8s.OtherColor(activeColor(app))
+		checkName = k8s.DeploymentName(app.Name, newColor)
+	}
+
+	state, reason, err := client.CheckRollout(ctx, checkName, app.Namespace)
+	if err != nil {
+		log.Printf("reconcile: rollout check failed (will retry) app=%s err=%v", app.ID, err)
+		return
+	}
+	if newColor != "" && state != k8s.RolloutReady {
+		if serving, err := client.ServiceColor(ctx, app.Name, app.Namespace); err == nil && serving == newColor {
+			state = k8s.RolloutReady
+		}
+	}
+
+	action := reconcileAction(app.Status, app.StatusMessage, state)
+	revNum := 0
+	if rev != nil {
+		revNum = rev.RevisionNumber
+	}
+	if action == reconcileWait {
+		log.Printf("reconcile: rollout still progressing app=%s status=%s revision=%d", app.ID, app.Status, revNum)
+		return
+	}
+	log.Printf("reconcile: settling orphaned deploy app=%s status=%s revision=%d rollout=%s action=%s", app.ID, app.Status, revNum, state, action)
+
+	switch action {
+	case reconcileAbandon:
+		msg := "deploy interrupted by a shipit restart before the new revision was applied; redeploy to retry"
+		if revNum > 0 {
+			msg = "deploy of revision " + strconv.Itoa(revNum) + " interrupted by a shipit restart before it was applied; redeploy to retry"
+		}
+		if app.Status == "canary" {
+			msg = "canary of revision " + strconv.Itoa(revNum) + " interrupted by a shipit restart and torn down; redeploy to retry"
+		}
+		final := "running"
+		if state != k8s.RolloutReady {
+			final = "failed"
+		}
+		won, _ := h.db.CompareAndSetAppStatus(ctx, app.ID, app.Status, final, &msg)
+		if !won {
+			break
+		}
+		if app.Status == "canary" {
+			h.teardownCanary(ctx, app.ID, app, client)
+		}
+		// A blue/green deploy may have got as far as creating the new
+		// color. Stop it, unless it is still the idle color inside its
+		// rollback window (prepareBlueGreen ends the window first).
+		if usesBlueGreen(app) && activeColor(app) != "" && app.IdleRevision == nil {
+			h.retireColor(ctx, app.ID, app, client, k8s.OtherColor(activeColor(app)))
+		}
+		if rev != nil {
+			h.db.UpdateRevisionStatus(ctx, app.ID, revNum, "failed", &msg)
+		}
+
+	case reconcilePromote:
+		req := k8s.DeployRequest{Name: app.Name, Namespace: app.Namespace, Port: app.Port, Color: newColor}
+		if newColor != "" {
+			if err := h.switchBlueGreen(ctx, app.ID, app, client, req); err != nil {
+				log.Printf("reconcile: blue/green switchover failed (will retry) app=%s err=%v", app.ID, err)
+				return
+			}
+		}
+		// The restart may have cut post-rollout verification short; run
+		// it again before declaring the revision good.
+		if err := verifyRollout(ctx, client, app, verifyInterval); err != nil {
+			log.Printf("reconcile: post-rollout health check failed app=%s revision=%d err=%v", app.ID, revNum, err)
+			if newColor != "" {
+				h.revertBlueGreen(ctx, app.ID, app, client, req)
+			}
+			h.reconcileRollback(ctx, app, client, rev, newColor, err)
+			break
+		}
+		won, _ := h.db.CompareAndSetAppStatus(ctx, app.ID, app.Status, "running", nil)
+		if !won || rev == nil {
+			break
+		}
+		// Same tail as deployApp's happy path.
+		h.db.UpdateAppRevision(ctx, app.ID, revNum)
+		h.db.UpdateRevisionStatus(ctx, app.ID, revNum, "success", nil)
+		h.db.RecordDeployedCommit(ctx, app.ID, revNum)
+		h.timeline(app.ID, revNum).succeed(phaseVerifying)
+		h.syncCustomDomainIngress(ctx, app.ID, app, client, rev.Port)
+		h.db.DeleteOldRevisions(ctx, app.ID, 10)
+
+	case reconcileRollback:
+		watchErr := errors.New("deployment not found")
+		if state == k8s.RolloutFailed {
+			watchErr = fmt.Errorf("rollout failed: %s", reason)
+		}
+		h.reconcileRollback(ctx, app, client, rev, newColor, watchErr)
+
+	case reconcileRolledBack:
+		if won, _ := h.db.CompareAndSetAppStatus(ctx, app.ID, app.Status, "running", nil); won && rev != nil {
+			h.db.UpdateRevisionStatus(ctx, app.ID, revNum, "rolled_back", app.StatusMessage)
+		}
+
+	case reconcileFail:
+		msg := *app.StatusMessage + " | rollback to revision " + strconv.Itoa(app.CurrentRevision) + " did not become ready"
+		if won, _ := h.db.CompareAndSetAppStatus(ctx, app.ID, app.Status, "failed", &msg); won && rev != nil {
+			h.db.UpdateRevisionStatus(ctx, app.ID, revNum, "failed", &msg)
+		}
+	}
+
+	if running != nil {
+		status := h.finishDeployJob(ctx, running, app.ID, revNum)
+		log.Printf("reconcile: took over job=%s from dead worker status=%s", running.ID, status)
+	}
+}
+
+// reconcileRollback hands a verifying deploy that turned out bad to
+// autoRollback, as deployApp would have. newColor is the blue/green color
+// the deploy stood up, if any; it never took traffic (or has just been
+// switched away from), so it is scaled down afterwards.
+func (h *Handler) reconcileRollback(ctx context.Context, app *db.App, client *k8s.Client, rev *db.AppRevision, newColor string, watchErr error) {
+	msg := rolloutFailureMessage(watchErr)
+	if rev == nil {
+		h.db.CompareAndSetAppStatus(ctx, app.ID, app.Status, "failed", &msg)
+		return
+	}
+	if won, _ := h.db.CompareAndSetAppStatus(ctx, app.ID, app.Status, "rolling_back", &msg); won {
+		h.autoRollback(ctx, app.ID, app, client, rev.RevisionNumber, phaseVerifying, watchErr)
+		if newColor != "" {
+			h.retireColor(ctx, app.ID, app, client, newColor)
+		}
+	}
+}
+
+// inFlightRevision returns the revision the interrupted deploy was rolling
+// out, or nil if there isn't one. That is the latest revision when it is
+// newer than CurrentRevision (which only advances on success) and hasn't
+// reached a terminal failure status. "success" is accepted alongside
+// "deploying" for rows written before revisions were created as
+// "deploying".
+func (h *Handler) inFlightRevision(ctx context.Context, app *db.App) *db.AppRevision {
+	rev, err := h.db.GetLatestRevision(ctx, app.ID)
+	if err != nil {
+		return nil
+	}
+	if rev.RevisionNumber <= app.CurrentRevision {
+		return nil
+	}
+	if rev.DeployStatus != "deploying" && rev.DeployStatus != "success" {
+		return nil
+	}
+	return rev
+}
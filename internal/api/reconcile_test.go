@@ -0,0 +1,53 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/vigneshsubbiah/shipit/internal/k8s"
+)
+
+func TestReconcileAction(t *testing.T) {
+	autoRollbackMsg := "rollout did not become ready: rollout failed: ImagePullBackOff"
+	empty := ""
+
+	cases := []struct {
+		name   string
+		status string
+		msg    *string
+		state  k8s.RolloutState
+		want   string
+	}{
+		// A rollout that is still moving is never acted on.
+		{"verifying progressing", "verifying", nil, k8s.RolloutProgressing, reconcileWait},
+		{"rolling_back progressing", "rolling_back", &autoRollbackMsg, k8s.RolloutProgressing, reconcileWait},
+		{"predeploy progressing", "running_predeploy", nil, k8s.RolloutProgressing, reconcileWait},
+
+		// verifying: the new spec is on the cluster.
+		{"verifying ready", "verifying", nil, k8s.RolloutReady, reconcilePromote},
+		{"verifying failed", "verifying", nil, k8s.RolloutFailed, reconcileRollback},
+		{"verifying missing", "verifying", nil, k8s.RolloutMissing, reconcileRollback},
+
+		// Statuses set before client.DeployApp: nothing was applied.
+		{"deploying ready", "deploying", nil, k8s.RolloutReady, reconcileAbandon},
+		{"predeploy ready", "running_predeploy", nil, k8s.RolloutReady, reconcileAbandon},
+		{"predeploy failed", "running_predeploy", nil, k8s.RolloutFailed, reconcileAbandon},
+		{"canary ready", "canary", nil, k8s.RolloutReady, reconcileAbandon},
+
+		// rolling_back without a message comes from RollbackApp / the worker.
+		{"manual rollback nil msg", "rolling_back", nil, k8s.RolloutReady, reconcileAbandon},
+		{"manual rollback empty msg", "rolling_back", &empty, k8s.RolloutFailed, reconcileAbandon},
+
+		// rolling_back with the original failure comes from autoRollback.
+		{"auto rollback ready", "rolling_back", &autoRollbackMsg, k8s.RolloutReady, reconcileRolledBack},
+		{"auto rollback failed", "rolling_back", &autoRollbackMsg, k8s.RolloutFailed, reconcileFail},
+		{"auto rollback missing", "rolling_back", &autoRollbackMsg, k8s.RolloutMissing, reconcileFail},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := reconcileAction(tc.status, tc.msg, tc.state); got != tc.want {
+				t.Errorf("reconcileAction(%q, %v, %s) = %q, want %q", tc.status, tc.msg, tc.state, got, tc.want)
+			}
+		})
+	}
+}
@@ -9,78 +9,141 @@ import (
 	"github.com/vigneshsubbiah/shipit/internal/auth"
 	"github.com/vigneshsubbiah/shipit/internal/config"
 	"github.com/vigneshsubbiah/shipit/internal/db"
-	"github.com/vigneshsubbiah/shipit/internal/porter"
 	"github.com/vigneshsubbiah/shipit/internal/web"
 )
 
-func NewRouter(database *db.DB, cfg *config.Config, porterDiscovery *porter.DiscoveryService) http.Handler {
+func NewRouter(h *Handler, database *db.DB, cfg *config.Config) http.Handler {
 	r := chi.NewRouter()
-	h := NewHandler(database, cfg.EncryptKey, cfg.AppBaseDomain, porterDiscovery)
 	oauth := auth.NewOAuthHandler(cfg, database)
+	oidcLogin := auth.NewOIDCHandler(cfg, database)
 
 	// Global middleware
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.RequestID)
+	r.Use(auth.TrustProxies(cfg.TrustedProxies))
+	r.Use(instrumentRoutes)
 
 	// Public routes
 	r.Get("/health", h.Health)
 
+	// Prometheus metrics (METRICS_TOKEN, if set, is required as a bearer
+	// token)
+	r.Handle("/metrics", metricsHandler(cfg.MetricsToken))
+
 	// OAuth routes (public)
 	r.Get("/auth/login", oauth.HandleLogin)
 	r.Get("/auth/callback", oauth.HandleCallback)
 	r.Post("/auth/logout", oauth.HandleLogout)
 
+	// OIDC providers (Okta, Keycloak, GitHub, ...) from OIDC_PROVIDERS
+	r.Get("/auth/providers", oidcLogin.HandleProviders)
+	r.Get("/auth/oidc/{provider}/login", oidcLogin.HandleLogin)
+	r.Get("/auth/oidc/{provider}/callback", oidcLogin.HandleCallback)
+
+	// CLI login (shipit login): loopback redirect or device code, then a
+	// code-for-token exchange
+	r.Get("/auth/cli/authorize", h.CLIAuthorize)
+	r.Post("/auth/cli/authorize", h.CLIAuthorize)
+	r.Get("/auth/device", h.DeviceVerify)
+	r.Post("/auth/device", h.DeviceVerify)
+	r.With(jsonContentType).Post("/auth/device/code", h.DeviceCode)
+	r.With(jsonContentType).Post("/auth/cli/token", h.CLIToken)
+
+	// GitHub push webhook (authenticated by its HMAC signature)
+	r.With(jsonContentType, h.Audit).Post("/api/webhooks/github", h.GitHubWebhook)
+
 	// API routes with JSON content type
 	r.Group(func(r chi.Router) {
 		r.Use(jsonContentType)
-		r.Use(auth.Middleware(database))
+		r.Use(auth.Middleware(database, cfg.AdminEmails, cfg.LegacyAPITokens))
+		r.Use(h.Audit)
+
+		// Per-route permission checks. auth.ProjectAccess resolves the
+		// caller's role in the project that owns the route's resource;
+		// routes without a RequireRole only need membership (viewer).
+		deployer := auth.RequireRole(auth.RoleDeployer)
+		admin := auth.RequireRole(auth.RoleAdmin)
 
 		// Projects
 		r.Route("/api/projects", func(r chi.Router) {
 			r.Get("/", h.ListProjects)
-			r.Post("/", h.CreateProject)
+			r.With(auth.RequireGlobalScope(auth.RoleAdmin)).Post("/", h.CreateProject)
 
 			r.Route("/{projectID}", func(r chi.Router) {
+				r.Use(auth.ProjectAccess(database, h.projectOfProject))
+
 				r.Get("/", h.GetProject)
-				r.Delete("/", h.DeleteProject)
+				r.With(admin).Delete("/", h.DeleteProject)
+
+				// Default deploy notifications for the project's apps
+				r.Get("/notifications", h.GetProjectNotifications)
+				r.With(admin).Put("/notifications", h.SetProjectNotifications)
+
+				// How long archived logs are kept
+				r.Get("/log-retention", h.GetProjectLogRetention)
+				r.With(admin).Put("/log-retention", h.SetProjectLogRetention)
+
+				// Members and their roles
+				r.Route("/members", func(r chi.Router) {
+					r.Get("/", h.ListProjectMembers)
+					r.With(admin).Post("/", h.AddProjectMember)
+					r.With(admin).Delete("/{member}", h.RemoveProjectMember)
+				})
+
+				// Roles granted to OIDC groups
+				r.Route("/groups", func(r chi.Router) {
+					r.Get("/", h.ListProjectGroups)
+					r.With(admin).Post("/", h.AddProjectGroup)
+					r.With(admin).Delete("/{group}", h.RemoveProjectGroup)
+				})
 
 				// Clusters under project
 				r.Route("/clusters", func(r chi.Router) {
 					r.Get("/", h.ListClusters)
-					r.Post("/", h.ConnectCluster)
+					r.With(admin).Post("/", h.ConnectCluster)
 				})
 			})
 		})
 
 		// Clusters (direct access)
 		r.Route("/api/clusters/{clusterID}", func(r chi.Router) {
+			r.Use(auth.ProjectAccess(database, h.projectOfCluster))
+
 			r.Get("/", h.GetCluster)
-			r.Delete("/", h.DeleteCluster)
+			r.With(admin).Delete("/", h.DeleteCluster)
 			r.Get("/ingress", h.GetClusterIngress)
 
 			// Apps under cluster
 			r.Route("/apps", func(r chi.Router) {
 				r.Get("/", h.ListApps)
-				r.Post("/", h.CreateApp)
+				r.With(admin).Post("/", h.CreateApp)
 			})
 		})
 
 		// Apps (direct access)
 		r.Route("/api/apps/{appID}", func(r chi.Router) {
+			r.Use(auth.ProjectAccess(database, h.projectOfApp))
+			r.Use(h.auditAppChanges)
+
 			r.Get("/", h.GetApp)
-			r.Put("/", h.UpdateApp)
-			r.Patch("/", h.UpdateApp)
-			r.Delete("/", h.DeleteApp)
-			r.Post("/deploy", h.DeployApp)
+			r.With(deployer).Put("/", h.UpdateApp)
+			r.With(deployer).Patch("/", h.UpdateApp)
+			r.With(admin).Delete("/", h.DeleteApp)
+			r.With(deployer).Post("/deploy", h.DeployApp)
 			r.Get("/logs", h.StreamLogs)
+			r.Get("/logs/search", h.SearchLogs)
 			r.Get("/status", h.GetAppStatus)
-			r.Post("/rollback", h.RollbackApp)
+			r.Get("/events", h.GetAppEvents)
+			r.With(deployer).Post("/rollback", h.RollbackApp)
 
-			// Secrets under app
+			// Secrets under app (even listing keys is admin-only)
 			r.Route("/secrets", func(r chi.Router) {
+				r.Use(admin)
 				r.Get("/", h.ListSecrets)
 				r.Post("/", h.SetSecret)
+				r.Post("/bulk", h.ImportSecrets)
+				r.Post("/export", h.ExportSecrets)
 				r.Delete("/{key}", h.DeleteSecret)
 			})
 
@@ -88,35 +151,68 @@ func NewRouter(database *db.DB, cfg *config.Config, porterDiscovery *porter.Disc
 			r.Route("/revisions", func(r chi.Router) {
 				r.Get("/", h.ListRevisions)
 				r.Get("/{revision}", h.GetRevision)
+				r.Get("/{revision}/timeline", h.GetRevisionTimeline)
 			})
 
 			// Deployment history
 			r.Get("/deployments", h.GetDeploymentHistory)
 
+			// Audit history (who changed what)
+			r.Get("/history", h.GetAppHistory)
+
 			// Autoscaling (HPA)
 			r.Get("/autoscaling", h.GetAutoscaling)
-			r.Put("/autoscaling", h.SetAutoscaling)
+			r.With(deployer).Put("/autoscaling", h.SetAutoscaling)
 
 			// Custom domains
 			r.Get("/domain", h.GetDomain)
-			r.Put("/domain", h.SetDomain)
+			r.With(deployer).Put("/domain", h.SetDomain)
+
+			// Deploy strategy and canary control
+			r.Get("/strategy", h.GetDeployStrategy)
+			r.With(deployer).Put("/strategy", h.SetDeployStrategy)
+			r.With(deployer).Post("/canary/promote", h.PromoteCanary)
+			r.With(deployer).Post("/canary/abort", h.AbortCanary)
+
+			// GitHub branch tracking (push-to-deploy)
+			r.Get("/tracking", h.GetTracking)
+			r.With(deployer).Put("/tracking", h.SetTracking)
 
-			// Pre-deploy hooks
+			// Post-rollout HTTP verification
+			r.Get("/verification", h.GetVerification)
+			r.With(deployer).Put("/verification", h.SetVerification)
+
+			// Deploy notifications (overrides the project defaults)
+			r.Get("/notifications", h.GetAppNotifications)
+			r.With(admin).Put("/notifications", h.SetAppNotifications)
+
+			// Pre-deploy hooks (run a command with the app's secrets, like exec)
 			r.Get("/predeploy", h.GetPreDeployHook)
-			r.Put("/predeploy", h.SetPreDeployHook)
+			r.With(admin).Put("/predeploy", h.SetPreDeployHook)
 
 			// Exec - run commands in containers
-			r.Post("/exec", h.ExecCommand)
-			r.Get("/exec/interactive", h.ExecInteractive)
-			r.Delete("/exec/cleanup", h.CleanupExec)
+			r.Route("/exec", func(r chi.Router) {
+				r.Use(admin)
+				r.Post("/", h.ExecCommand)
+				r.Get("/interactive", h.ExecInteractive)
+				r.Delete("/cleanup", h.CleanupExec)
+			})
 
 			// Porter migration - switchover
-			r.Put("/switchover", h.SwitchAppManagement)
+			r.With(admin).Put("/switchover", h.SwitchAppManagement)
 		})
 
+		// Deploy jobs (returned by POST /api/apps/{appID}/deploy)
+		r.With(auth.ProjectAccess(database, h.projectOfDeploy)).Get("/api/deploys/{deployID}", h.GetDeployJob)
+		r.With(auth.ProjectAccess(database, h.projectOfDeploy)).Get("/api/deploys/{deployID}/stream", h.StreamDeploy)
+
+		// Audit log across all apps
+		r.With(auth.RequirePlatformAdmin).Get("/api/audit", h.ListAuditLogs)
+
 		// User profile and token management
 		r.Get("/api/me", h.GetMe)
 		r.Route("/api/tokens", func(r chi.Router) {
+			r.Use(auth.RequireGlobalScope(auth.RoleAdmin))
 			r.Get("/", h.ListMyTokens)
 			r.Post("/", h.CreateMyToken)
 			r.Delete("/{tokenID}", h.DeleteMyToken)
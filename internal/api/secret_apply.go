@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/vigneshsubbiah/shipit/internal/db"
+)
+
+// secretApplyDebounce is how long a redeploy queued by apply=true waits for
+// further secret changes. Each change within the window supersedes the
+// queued job and restarts the wait, so a burst of changes (a script setting
+// ten keys) ships as one rollout.
+const secretApplyDebounce = 10 * time.Second
+
+// secretApply is returned by secret changes made with apply=true.
+type secretApply struct {
+	Status   string     `json:"status"`
+	JobID    string     `json:"job_id"`
+	RunAfter *time.Time `json:"run_after,omitempty"`
+}
+
+// wantsApply reports whether a secret change asked for apply=true.
+func wantsApply(r *http.Request) bool {
+	return r.URL.Query().Get("apply") == "true"
+}
+
+// applySecretChange queues the redeploy for a secret change made with
+// apply=true. It is a normal deploy job: it records a revision, and the
+// new secret checksum on the pod template rolls the pods even when nothing
+// else changed.
+func (h *Handler) applySecretChange(r *http.Request, appID string) (*secretApply, error) {
+	job, err := h.enqueueDeploy(r.Context(), db.EnqueueDeployJobParams{
+		AppID:       appID,
+		Kind:        "deploy",
+		RequestedBy: requestedBy(r),
+		Delay:       secretApplyDebounce,
+	})
+	if err != nil {
+		return nil, err
+	}
+	auditDetail(r, "job_id", job.ID)
+	return &secretApply{Status: job.Status, JobID: job.ID, RunAfter: job.RunAfter}, nil
+}
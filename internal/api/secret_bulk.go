@@ -0,0 +1,293 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vigneshsubbiah/shipit/internal/db"
+	"github.com/vigneshsubbiah/shipit/internal/dotenv"
+	"github.com/vigneshsubbiah/shipit/internal/secretexport"
+)
+
+// maxSecretsPayload bounds bulk imports; a .env file is a few KB.
+const maxSecretsPayload = 1 << 20
+
+// importDiff reports what a bulk import changes, by key. Values are never
+// included.
+type importDiff struct {
+	Mode      string   `json:"mode"`
+	DryRun    bool     `json:"dry_run"`
+	Added     []string `json:"added"`
+	Changed   []string `json:"changed"`
+	Removed   []string `json:"removed"`
+	Unchanged int      `json:"unchanged"`
+
+	// Deploy is the redeploy queued with apply=true, if anything changed.
+	Deploy *secretApply `json:"deploy,omitempty"`
+}
+
+// storedSecret is a secret's current content: a value or a reference.
+type storedSecret struct {
+	value string
+	ref   string
+}
+
+// diffImport compares an import with the app's current secrets. Switching
+// a key between a value and a reference counts as a change. In replace mode
+// every current key missing from the import is removed.
+func diffImport(current map[string]storedSecret, incoming secretexport.Secrets, replace bool) importDiff {
+	diff := importDiff{Mode: "upsert", Added: []string{}, Changed: []string{}, Removed: []string{}}
+	if replace {
+		diff.Mode = "replace"
+	}
+
+	compare := func(key string, want storedSecret) {
+		have, ok := current[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, key)
+		case have != want:
+			diff.Changed = append(diff.Changed, key)
+		default:
+			diff.Unchanged++
+		}
+	}
+	for key, value := range incoming.Secrets {
+		compare(key, storedSecret{value: value})
+	}
+	for key, ref := range incoming.Refs {
+		compare(key, storedSecret{ref: ref})
+	}
+
+	if replace {
+		for key := range current {
+			_, isValue := incoming.Secrets[key]
+			_, isRef := incoming.Refs[key]
+			if !isValue && !isRef {
+				diff.Removed = append(diff.Removed, key)
+			}
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Changed)
+	sort.Strings(diff.Removed)
+	return diff
+}
+
+// parseSecretsPayload reads a bulk import: a JSON body
+// {"secrets": {...}, "refs": {...}} when the Content-Type is JSON, a .env
+// file otherwise.
+func parseSecretsPayload(contentType string, body []byte) (secretexport.Secrets, error) {
+	var incoming secretexport.Secrets
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "application/json" {
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&incoming); err != nil {
+			return incoming, fmt.Errorf(`invalid JSON payload: want {"secrets": {"KEY": "value"}, "refs": {"KEY": "vault://..."}}: %v`, err)
+		}
+	} else {
+		values, err := dotenv.Parse(body)
+		if err != nil {
+			return incoming, err
+		}
+		incoming.Secrets = values
+	}
+
+	if len(incoming.Secrets)+len(incoming.Refs) == 0 {
+		return incoming, errors.New("no secrets in payload")
+	}
+	for key := range incoming.Secrets {
+		if !dotenv.ValidKey(key) {
+			return incoming, fmt.Errorf("invalid key %q", key)
+		}
+		if _, ok := incoming.Refs[key]; ok {
+			return incoming, fmt.Errorf("%s is both a value and a ref", key)
+		}
+	}
+	for key := range incoming.Refs {
+		if !dotenv.ValidKey(key) {
+			return incoming, fmt.Errorf("invalid key %q", key)
+		}
+	}
+	return incoming, nil
+}
+
+// currentSecrets returns the app's secrets with values decrypted (references
+// are left unresolved).
+func (h *Handler) currentSecrets(secrets []db.AppSecret) (map[string]storedSecret, error) {
+	current := make(map[string]storedSecret, len(secrets))
+	for _, s := range secrets {
+		if s.Ref != nil {
+			current[s.Key] = storedSecret{ref: *s.Ref}
+			continue
+		}
+		decrypted, err := h.keys.Decrypt(s.ValueEncrypted)
+		if err != nil {
+			return nil, err
+		}
+		current[s.Key] = storedSecret{value: string(decrypted)}
+	}
+	return current, nil
+}
+
+// ImportSecrets sets many secrets at once from a .env file or JSON payload.
+// mode=upsert (the default) leaves other secrets alone; mode=replace deletes
+// them. All changes are written in one transaction, and dry_run=true only
+// reports the diff. apply=true redeploys as SetSecret does.
+func (h *Handler) ImportSecrets(w http.ResponseWriter, r *http.Request) {
+	appID := chi.URLParam(r, "appID")
+
+	// Verify app exists
+	if _, err := h.db.GetApp(r.Context(), appID); err != nil {
+		httpError(w, "app not found", http.StatusNotFound)
+		return
+	}
+
+	var replace bool
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", "upsert":
+	case "replace":
+		replace = true
+	default:
+		httpError(w, "mode must be upsert or replace", http.StatusBadRequest)
+		return
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSecretsPayload))
+	if err != nil {
+		httpError(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	incoming, err := parseSecretsPayload(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	existing, err := h.db.GetSecretsByAppID(r.Context(), appID)
+	if err != nil {
+		httpError(w, "failed to load secrets", http.StatusInternalServerError)
+		return
+	}
+	current, err := h.currentSecrets(existing)
+	if err != nil {
+		httpError(w, "failed to decrypt secrets", http.StatusInternalServerError)
+		return
+	}
+	diff := diffImport(current, incoming, replace)
+	diff.DryRun = dryRun
+	project, err := h.secretRefProject(r.Context(), appID)
+	if err != nil {
+		httpError(w, "failed to load app project", http.StatusInternalServerError)
+		return
+	}
+
+	params := db.BulkSecretParams{
+		AppID:  appID,
+		Values: make(map[string][]byte),
+		Refs:   make(map[string]db.SecretRefValue),
+		Delete: diff.Removed,
+	}
+	for _, key := range append(append([]string{}, diff.Added...), diff.Changed...) {
+		if raw, ok := incoming.Refs[key]; ok {
+			// Resolve new references now, as SetSecret does, so a typo
+			// fails the import rather than the next deploy.
+			ref, _, err := h.resolveSecretRef(r.Context(), project, raw)
+			if err != nil {
+				httpError(w, fmt.Sprintf("secret %s: %v", key, err), http.StatusBadRequest)
+				return
+			}
+			params.Refs[key] = db.SecretRefValue{Source: ref.Scheme, Ref: ref.String()}
+			continue
+		}
+		encrypted, err := h.keys.Encrypt([]byte(incoming.Secrets[key]))
+		if err != nil {
+			httpError(w, "failed to encrypt secret", http.StatusInternalServerError)
+			return
+		}
+		params.Values[key] = encrypted
+	}
+
+	if !dryRun {
+		if err := h.db.ApplySecrets(r.Context(), params); err != nil {
+			httpError(w, "failed to import secrets", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	auditDetail(r, "mode", diff.Mode)
+	auditDetail(r, "dry_run", dryRun)
+	auditDetail(r, "added", diff.Added)
+	auditDetail(r, "changed", diff.Changed)
+	auditDetail(r, "removed", diff.Removed)
+
+	changed := len(diff.Added)+len(diff.Changed)+len(diff.Removed) > 0
+	if !dryRun && changed && wantsApply(r) {
+		if diff.Deploy, err = h.applySecretChange(r, appID); err != nil {
+			httpError(w, "secrets imported, but failed to queue deploy", http.StatusInternalServerError)
+			return
+		}
+	}
+	json.NewEncoder(w).Encode(diff)
+}
+
+// ExportSecrets returns all of an app's secrets sealed with a passphrase
+// (see package secretexport). References are exported as references.
+func (h *Handler) ExportSecrets(w http.ResponseWriter, r *http.Request) {
+	appID := chi.URLParam(r, "appID")
+
+	app, err := h.db.GetApp(r.Context(), appID)
+	if err != nil {
+		httpError(w, "app not found", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		Passphrase string `json:"passphrase"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Passphrase) < secretexport.MinPassphraseLength {
+		httpError(w, fmt.Sprintf("passphrase must be at least %d characters", secretexport.MinPassphraseLength), http.StatusBadRequest)
+		return
+	}
+
+	secrets, err := h.db.GetSecretsByAppID(r.Context(), appID)
+	if err != nil {
+		httpError(w, "failed to load secrets", http.StatusInternalServerError)
+		return
+	}
+	current, err := h.currentSecrets(secrets)
+	if err != nil {
+		httpError(w, "failed to decrypt secrets", http.StatusInternalServerError)
+		return
+	}
+	export := secretexport.Secrets{Secrets: make(map[string]string), Refs: make(map[string]string)}
+	for key, s := range current {
+		if s.ref != "" {
+			export.Refs[key] = s.ref
+		} else {
+			export.Secrets[key] = s.value
+		}
+	}
+
+	sealed, err := secretexport.Seal(export, req.Passphrase)
+	if err != nil {
+		httpError(w, "failed to encrypt export", http.StatusInternalServerError)
+		return
+	}
+	auditDetail(r, "keys", len(current))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", app.Name+"-secrets.json"))
+	w.Write(sealed)
+}
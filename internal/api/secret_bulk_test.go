@@ -0,0 +1,69 @@
+package api
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vigneshsubbiah/shipit/internal/secretexport"
+)
+
+func TestDiffImport(t *testing.T) {
+	current := map[string]storedSecret{
+		"SAME":      {value: "1"},
+		"CHANGED":   {value: "old"},
+		"TO_REF":    {value: "v"},
+		"KEEP_REF":  {ref: "vault://kv/a#b"},
+		"UNTOUCHED": {value: "x"},
+	}
+	incoming := secretexport.Secrets{
+		Secrets: map[string]string{"SAME": "1", "CHANGED": "new", "NEW": "n"},
+		Refs:    map[string]string{"TO_REF": "vault://kv/c#d", "KEEP_REF": "vault://kv/a#b"},
+	}
+
+	upsert := diffImport(current, incoming, false)
+	want := importDiff{
+		Mode:      "upsert",
+		Added:     []string{"NEW"},
+		Changed:   []string{"CHANGED", "TO_REF"},
+		Removed:   []string{},
+		Unchanged: 2,
+	}
+	if !reflect.DeepEqual(upsert, want) {
+		t.Errorf("upsert diff = %+v, want %+v", upsert, want)
+	}
+
+	replace := diffImport(current, incoming, true)
+	want.Mode = "replace"
+	want.Removed = []string{"UNTOUCHED"}
+	if !reflect.DeepEqual(replace, want) {
+		t.Errorf("replace diff = %+v, want %+v", replace, want)
+	}
+}
+
+func TestParseSecretsPayload(t *testing.T) {
+	got, err := parseSecretsPayload("text/plain", []byte("A=1\nB=\"two\"\n"))
+	if err != nil || got.Secrets["A"] != "1" || got.Secrets["B"] != "two" {
+		t.Errorf("dotenv payload = %+v, %v", got, err)
+	}
+
+	got, err = parseSecretsPayload("application/json; charset=utf-8", []byte(`{"secrets": {"A": "1"}, "refs": {"B": "vault://kv/x#y"}}`))
+	if err != nil || got.Secrets["A"] != "1" || got.Refs["B"] != "vault://kv/x#y" {
+		t.Errorf("JSON payload = %+v, %v", got, err)
+	}
+
+	bad := []struct {
+		contentType, body, want string
+	}{
+		{"text/plain", "# only a comment\n", "no secrets"},
+		{"text/plain", "A=1\nA=2\n", "already set"},
+		{"application/json", `{"A": "1"}`, "invalid JSON payload"},
+		{"application/json", `{"secrets": {"BAD KEY": "1"}}`, "invalid key"},
+		{"application/json", `{"secrets": {"A": "1"}, "refs": {"A": "vault://kv/x#y"}}`, "both a value and a ref"},
+	}
+	for _, tt := range bad {
+		if _, err := parseSecretsPayload(tt.contentType, []byte(tt.body)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseSecretsPayload(%q) error = %v, want %q", tt.body, err, tt.want)
+		}
+	}
+}
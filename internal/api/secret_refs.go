@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vigneshsubbiah/shipit/internal/db"
+	"github.com/vigneshsubbiah/shipit/internal/secretref"
+)
+
+// secretValues returns the values of an app's secrets for its Kubernetes
+// Secret: stored values are decrypted and references resolved (through a
+// short-lived cache). An unresolvable reference fails the whole set rather
+// than deploying without it.
+func (h *Handler) secretValues(ctx context.Context, project secretref.Project, secrets []db.AppSecret) (map[string]string, error) {
+	data := make(map[string]string, len(secrets))
+	for _, s := range secrets {
+		if s.Ref != nil {
+			_, value, err := h.resolveSecretRef(ctx, project, *s.Ref)
+			if err != nil {
+				return nil, fmt.Errorf("secret %s: %w", s.Key, err)
+			}
+			data[s.Key] = value
+			continue
+		}
+		decrypted, err := h.keys.Decrypt(s.ValueEncrypted)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt secret: %w", err)
+		}
+		data[s.Key] = string(decrypted)
+	}
+	return data, nil
+}
+
+// resolveSecretRef validates a reference and returns its current value.
+// The path is checked against the project's allowed prefixes every time,
+// not just when the reference is stored, so narrowing the prefixes takes
+// effect on the next deploy.
+func (h *Handler) resolveSecretRef(ctx context.Context, project secretref.Project, raw string) (secretref.Ref, string, error) {
+	if h.secretRefs == nil {
+		return secretref.Ref{}, "", fmt.Errorf("secret references are not enabled on this server")
+	}
+	ref, err := h.secretRefs.Check(raw, project)
+	if err != nil {
+		return ref, "", err
+	}
+	value, err := h.secretRefs.Resolve(ctx, ref)
+	return ref, value, err
+}
+
+// secretRefProject returns the project an app's references are checked
+// against.
+func (h *Handler) secretRefProject(ctx context.Context, appID string) (secretref.Project, error) {
+	project, err := h.db.GetAppProject(ctx, appID)
+	if err != nil {
+		return secretref.Project{}, fmt.Errorf("failed to load app project: %w", err)
+	}
+	return secretref.Project{ID: project.ID, Name: project.Name}, nil
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vigneshsubbiah/shipit/internal/db"
+)
+
+// secretSnapshot maps secret key -> keyed fingerprint of its value (of the
+// reference, for external secrets). Stored on app_revisions.secret_keys at
+// deploy time so rollbacks can tell whether the secrets a revision ran with
+// still exist, unchanged, in app_secrets.
+type secretSnapshot map[string]string
+
+// currentSecretSnapshot fingerprints the app's live secrets.
+func (h *Handler) currentSecretSnapshot(ctx context.Context, appID string) (secretSnapshot, error) {
+	secrets, err := h.db.GetSecretsByAppID(ctx, appID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load secrets: %w", err)
+	}
+	snap := make(secretSnapshot, len(secrets))
+	for _, s := range secrets {
+		if s.Ref != nil {
+			// What an external secret holds is out of shipit's hands;
+			// only a changed reference counts.
+			snap[s.Key] = h.keys.Fingerprint([]byte("ref:" + *s.Ref))
+			continue
+		}
+		value, err := h.keys.Decrypt(s.ValueEncrypted)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt secret %s: %w", s.Key, err)
+		}
+		snap[s.Key] = h.keys.Fingerprint(value)
+	}
+	return snap, nil
+}
+
+// revisionSecretSnapshot decodes the snapshot stored on a revision. ok is
+// false for revisions created before snapshots existed; callers skip the
+// preflight for those rather than guessing.
+func revisionSecretSnapshot(rev *db.AppRevision) (secretSnapshot, bool) {
+	if rev == nil || len(rev.SecretKeys) == 0 || string(rev.SecretKeys) == "null" {
+		return nil, false
+	}
+	var snap secretSnapshot
+	if err := json.Unmarshal(rev.SecretKeys, &snap); err != nil {
+		return nil, false
+	}
+	return snap, true
+}
+
+// keys returns the snapshot's secret names, sorted.
+func (s secretSnapshot) keys() []string {
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// secretDiff lists the keys a revision was deployed with that are now
+// gone (Missing) or hold a different value (Changed). Keys added since the
+// revision are not drift: its env can't reference them.
+type secretDiff struct {
+	Missing []string `json:"missing_keys"`
+	Changed []string `json:"changed_keys"`
+}
+
+func diffSecrets(snapshot, current secretSnapshot) secretDiff {
+	d := secretDiff{Missing: []string{}, Changed: []string{}}
+	for _, key := range snapshot.keys() {
+		cur, ok := current[key]
+		switch {
+		case !ok:
+			d.Missing = append(d.Missing, key)
+		case cur != snapshot[key]:
+			d.Changed = append(d.Changed, key)
+		}
+	}
+	return d
+}
+
+func (d secretDiff) empty() bool {
+	return len(d.Missing) == 0 && len(d.Changed) == 0
+}
+
+func (d secretDiff) String() string {
+	var parts []string
+	if len(d.Missing) > 0 {
+		parts = append(parts, "missing: "+strings.Join(d.Missing, ", "))
+	}
+	if len(d.Changed) > 0 {
+		parts = append(parts, "changed: "+strings.Join(d.Changed, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// secretPreflight compares a rollback target's secret snapshot with the
+// live secrets. Returns an empty diff when the target predates snapshots.
+func (h *Handler) secretPreflight(ctx context.Context, target *db.AppRevision) (secretDiff, error) {
+	snap, ok := revisionSecretSnapshot(target)
+	if !ok {
+		return secretDiff{}, nil
+	}
+	current, err := h.currentSecretSnapshot(ctx, target.AppID)
+	if err != nil {
+		return secretDiff{}, err
+	}
+	return diffSecrets(snap, current), nil
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vigneshsubbiah/shipit/internal/db"
+	"github.com/vigneshsubbiah/shipit/internal/secretref"
+)
+
+func TestDiffSecrets(t *testing.T) {
+	snapshot := secretSnapshot{
+		"DATABASE_URL": "aaa",
+		"API_KEY":      "bbb",
+		"STRIPE_KEY":   "ccc",
+		"UNCHANGED":    "ddd",
+	}
+	current := secretSnapshot{
+		"DATABASE_URL": "aaa",
+		"API_KEY":      "rotated",
+		"UNCHANGED":    "ddd",
+		"NEW_KEY":      "eee", // added after the revision: not drift
+	}
+
+	d := diffSecrets(snapshot, current)
+	if want := []string{"STRIPE_KEY"}; !reflect.DeepEqual(d.Missing, want) {
+		t.Errorf("missing: want %v, got %v", want, d.Missing)
+	}
+	if want := []string{"API_KEY"}; !reflect.DeepEqual(d.Changed, want) {
+		t.Errorf("changed: want %v, got %v", want, d.Changed)
+	}
+	if d.empty() {
+		t.Error("expected non-empty diff")
+	}
+	if got, want := d.String(), "missing: STRIPE_KEY; changed: API_KEY"; got != want {
+		t.Errorf("String(): want %q, got %q", want, got)
+	}
+}
+
+func TestDiffSecrets_NoDrift(t *testing.T) {
+	snap := secretSnapshot{"A": "1", "B": "2"}
+	d := diffSecrets(snap, secretSnapshot{"A": "1", "B": "2", "C": "3"})
+	if !d.empty() {
+		t.Errorf("expected empty diff, got %s", d)
+	}
+	// Empty slices (not nil) so the 409 body always carries both arrays.
+	body, _ := json.Marshal(d)
+	if string(body) != `{"missing_keys":[],"changed_keys":[]}` {
+		t.Errorf("unexpected JSON: %s", body)
+	}
+}
+
+// Revisions written before migration 012 have no snapshot; the preflight
+// must treat that as "unknown" rather than "every key is missing".
+func TestRevisionSecretSnapshot_Legacy(t *testing.T) {
+	for _, raw := range []string{"", "null"} {
+		rev := &db.AppRevision{SecretKeys: json.RawMessage(raw)}
+		if _, ok := revisionSecretSnapshot(rev); ok {
+			t.Errorf("expected no snapshot for secret_keys=%q", raw)
+		}
+	}
+
+	rev := &db.AppRevision{SecretKeys: json.RawMessage(`{"B":"x","A":"y"}`)}
+	snap, ok := revisionSecretSnapshot(rev)
+	if !ok {
+		t.Fatal("expected snapshot to decode")
+	}
+	if want := []string{"A", "B"}; !reflect.DeepEqual(snap.keys(), want) {
+		t.Errorf("keys: want %v, got %v", want, snap.keys())
+	}
+
+	// An app with no secrets snapshots as {} — a real, empty snapshot.
+	empty := &db.AppRevision{SecretKeys: json.RawMessage(`{}`)}
+	if snap, ok := revisionSecretSnapshot(empty); !ok || len(snap) != 0 {
+		t.Errorf("expected empty snapshot, got %v ok=%v", snap, ok)
+	}
+}
+
+func TestSecretValuesResolvesRefs(t *testing.T) {
+	h := newTestHandler()
+	h.secretRefs = secretref.NewResolver(0)
+	h.secretRefs.Register("vault", secretref.Static{"vault://kv/payments#api_key": "sk_live_1"})
+	h.secretRefs.AllowPrefixes("vault", []string{"kv/{project}"})
+	project := secretref.Project{ID: "p1", Name: "payments"}
+
+	encrypted, err := h.keys.Encrypt([]byte("postgres://db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := "vault://kv/payments#api_key"
+	secrets := []db.AppSecret{
+		{Key: "DATABASE_URL", Source: "value", ValueEncrypted: encrypted},
+		{Key: "STRIPE_KEY", Source: "vault", Ref: &ref},
+	}
+	got, err := h.secretValues(context.Background(), project, secrets)
+	want := map[string]string{"DATABASE_URL": "postgres://db", "STRIPE_KEY": "sk_live_1"}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("secretValues = %v, %v", got, err)
+	}
+
+	missing := "vault://kv/payments#other"
+	secrets[1].Ref = &missing
+	if _, err := h.secretValues(context.Background(), project, secrets); err == nil || !strings.Contains(err.Error(), "secret STRIPE_KEY: resolving vault://kv/payments#other") {
+		t.Errorf("unresolvable ref: err = %v", err)
+	}
+
+	// References are checked against the project's prefixes again when
+	// resolved, not only when stored.
+	other := secretref.Project{ID: "p2", Name: "billing"}
+	secrets[1].Ref = &ref
+	if _, err := h.secretValues(context.Background(), other, secrets); err == nil || !strings.Contains(err.Error(), "outside this project's allowed paths") {
+		t.Errorf("another project's ref: err = %v", err)
+	}
+}
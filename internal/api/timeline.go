@@ -0,0 +1,194 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vigneshsubbiah/shipit/internal/db"
+	"github.com/vigneshsubbiah/shipit/internal/k8s"
+)
+
+// Phases of a deploy as recorded in its timeline (deploy_events).
+const (
+	phaseRevision   = "revision"    // revision allocated and snapshotted
+	phaseSecretSync = "secret_sync" // app secrets written to the cluster
+	phasePredeploy  = "predeploy"   // pre-deploy hook Job
+	phaseCanary     = "canary"      // canary traffic schedule
+	phaseApply      = "apply"       // manifests applied
+	phaseVerifying  = "verifying"   // rollout watch and HTTP checks
+	phaseRollback   = "rollback"    // automatic rollback to the prior revision
+)
+
+// Statuses of deploy events. eventReady marks one of the new pods
+// becoming ready during phaseVerifying.
+const (
+	eventStarted   = "started"
+	eventSucceeded = "succeeded"
+	eventFailed    = "failed"
+	eventReady     = "ready"
+)
+
+// deployTimeline records one revision's deploy events. A write that fails
+// is logged and otherwise ignored: the timeline must never fail a deploy.
+type deployTimeline struct {
+	db       *db.DB
+	appID    string
+	revision int
+	ready    map[string]time.Time // pods already recorded as ready
+}
+
+func (h *Handler) timeline(appID string, revision int) *deployTimeline {
+	return &deployTimeline{db: h.db, appID: appID, revision: revision, ready: make(map[string]time.Time)}
+}
+
+func (t *deployTimeline) record(phase, status, msg, pod string, at time.Time) {
+	p := db.CreateDeployEventParams{
+		AppID:          t.appID,
+		RevisionNumber: t.revision,
+		Phase:          phase,
+		Status:         status,
+		OccurredAt:     at,
+	}
+	if msg != "" {
+		p.Message = &msg
+	}
+	if pod != "" {
+		p.Pod = &pod
+	}
+	if err := t.db.CreateDeployEvent(context.Background(), p); err != nil {
+		log.Printf("deploy: failed to record timeline event app=%s revision=%d phase=%s status=%s err=%v", t.appID, t.revision, phase, status, err)
+	}
+}
+
+// start records phase starting now.
+func (t *deployTimeline) start(phase string) {
+	t.record(phase, eventStarted, "", "", time.Time{})
+}
+
+// succeed records phase finishing successfully now.
+func (t *deployTimeline) succeed(phase string) {
+	t.record(phase, eventSucceeded, "", "", time.Time{})
+}
+
+// fail records phase failing now with msg.
+func (t *deployTimeline) fail(phase, msg string) {
+	t.record(phase, eventFailed, msg, "", time.Time{})
+}
+
+// rolloutProgress is the WatchRolloutProgress callback: it records each of
+// the new ReplicaSet's pods the first time it is seen ready, or again if it
+// became ready anew (a restart in the middle of the watch).
+func (t *deployTimeline) rolloutProgress(p k8s.RolloutProgress) {
+	for _, pod := range p.ReadyPods {
+		if at, ok := t.ready[pod.Pod]; ok && at.Equal(pod.ReadyAt) {
+			continue
+		}
+		t.ready[pod.Pod] = pod.ReadyAt
+		t.record(phaseVerifying, eventReady, "", pod.Pod, pod.ReadyAt)
+	}
+}
+
+// timelinePhase is one run of a deploy phase, from its start event to the
+// event that ended it. A phase without an end is still running, or was cut
+// short by a server restart.
+type timelinePhase struct {
+	Phase      string        `json:"phase"`
+	Status     string        `json:"status"` // running, succeeded, failed
+	StartedAt  time.Time     `json:"started_at"`
+	EndedAt    *time.Time    `json:"ended_at,omitempty"`
+	DurationMs int64         `json:"duration_ms"`
+	Message    *string       `json:"message,omitempty"`
+	Pods       []timelinePod `json:"pods,omitempty"`
+}
+
+// timelinePod is a pod of the new revision becoming ready, AfterMs into
+// the phase it was seen in.
+type timelinePod struct {
+	Pod     string    `json:"pod"`
+	ReadyAt time.Time `json:"ready_at"`
+	AfterMs int64     `json:"after_ms"`
+}
+
+// buildTimeline pairs a revision's events (oldest first) into phases in
+// the order they started. An end without a start becomes a phase of its
+// own that started and ended at once; ready events attach to the latest
+// run of their phase.
+func buildTimeline(events []db.DeployEvent) []timelinePhase {
+	phases := []timelinePhase{}
+	latest := make(map[string]int) // phase -> index of its latest run
+	for _, e := range events {
+		i, ok := latest[e.Phase]
+		switch e.Status {
+		case eventStarted:
+			latest[e.Phase] = len(phases)
+			phases = append(phases, timelinePhase{Phase: e.Phase, Status: "running", StartedAt: e.OccurredAt})
+		case eventReady:
+			if !ok || e.Pod == nil {
+				continue
+			}
+			phases[i].Pods = append(phases[i].Pods, timelinePod{
+				Pod:     *e.Pod,
+				ReadyAt: e.OccurredAt,
+				AfterMs: e.OccurredAt.Sub(phases[i].StartedAt).Milliseconds(),
+			})
+		default:
+			if !ok || phases[i].EndedAt != nil {
+				i = len(phases)
+				latest[e.Phase] = i
+				phases = append(phases, timelinePhase{Phase: e.Phase, StartedAt: e.OccurredAt})
+			}
+			ended := e.OccurredAt
+			phases[i].Status = e.Status
+			phases[i].EndedAt = &ended
+			phases[i].DurationMs = ended.Sub(phases[i].StartedAt).Milliseconds()
+			phases[i].Message = e.Message
+		}
+	}
+	for i := range phases {
+		if phases[i].EndedAt == nil {
+			phases[i].DurationMs = time.Since(phases[i].StartedAt).Milliseconds()
+		}
+	}
+	return phases
+}
+
+// GetRevisionTimeline returns a revision's deploy timeline: each phase
+// with its start, end, duration and error text, the new pods' readiness
+// times, and the raw events they were built from.
+func (h *Handler) GetRevisionTimeline(w http.ResponseWriter, r *http.Request) {
+	appID := chi.URLParam(r, "appID")
+
+	revisionNumber, err := strconv.Atoi(chi.URLParam(r, "revision"))
+	if err != nil {
+		httpError(w, "invalid revision number", http.StatusBadRequest)
+		return
+	}
+
+	revision, err := h.db.GetRevision(r.Context(), appID, revisionNumber)
+	if err != nil {
+		httpError(w, "revision not found", http.StatusNotFound)
+		return
+	}
+
+	events, err := h.db.ListDeployEvents(r.Context(), appID, revisionNumber)
+	if err != nil {
+		httpError(w, "failed to get timeline", http.StatusInternalServerError)
+		return
+	}
+	if events == nil {
+		events = []db.DeployEvent{}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"revision":       revision.RevisionNumber,
+		"deploy_status":  revision.DeployStatus,
+		"deploy_message": revision.DeployMessage,
+		"phases":         buildTimeline(events),
+		"events":         events,
+	})
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vigneshsubbiah/shipit/internal/db"
+)
+
+func TestBuildTimeline(t *testing.T) {
+	t0 := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return t0.Add(d) }
+	str := func(s string) *string { return &s }
+	events := []db.DeployEvent{
+		{Phase: phaseRevision, Status: eventStarted, OccurredAt: at(0)},
+		{Phase: phaseRevision, Status: eventSucceeded, OccurredAt: at(100 * time.Millisecond)},
+		{Phase: phaseApply, Status: eventStarted, OccurredAt: at(time.Second)},
+		{Phase: phaseApply, Status: eventSucceeded, OccurredAt: at(2 * time.Second)},
+		{Phase: phaseVerifying, Status: eventStarted, OccurredAt: at(2 * time.Second)},
+		{Phase: phaseVerifying, Status: eventReady, Pod: str("web-a"), OccurredAt: at(5 * time.Second)},
+		{Phase: phaseVerifying, Status: eventFailed, Message: str("rollout did not become ready"), OccurredAt: at(10 * time.Second)},
+		// A failure recorded without a start still shows up.
+		{Phase: phaseRollback, Status: eventFailed, Message: str("rollback aborted"), OccurredAt: at(11 * time.Second)},
+	}
+
+	phases := buildTimeline(events)
+	if len(phases) != 4 {
+		t.Fatalf("got %d phases, want 4: %+v", len(phases), phases)
+	}
+	want := []struct {
+		phase, status string
+		durationMs    int64
+	}{
+		{phaseRevision, eventSucceeded, 100},
+		{phaseApply, eventSucceeded, 1000},
+		{phaseVerifying, eventFailed, 8000},
+		{phaseRollback, eventFailed, 0},
+	}
+	for i, w := range want {
+		p := phases[i]
+		if p.Phase != w.phase || p.Status != w.status || p.DurationMs != w.durationMs {
+			t.Errorf("phase %d = %s/%s %dms, want %s/%s %dms", i, p.Phase, p.Status, p.DurationMs, w.phase, w.status, w.durationMs)
+		}
+	}
+	verifying := phases[2]
+	if verifying.Message == nil || *verifying.Message != "rollout did not become ready" {
+		t.Errorf("verifying message = %v", verifying.Message)
+	}
+	if len(verifying.Pods) != 1 || verifying.Pods[0].Pod != "web-a" || verifying.Pods[0].AfterMs != 3000 {
+		t.Errorf("verifying pods = %+v, want web-a after 3s", verifying.Pods)
+	}
+}
+
+func TestBuildTimeline_RunningPhase(t *testing.T) {
+	events := []db.DeployEvent{
+		{Phase: phasePredeploy, Status: eventStarted, OccurredAt: time.Now().Add(-time.Minute)},
+	}
+	phases := buildTimeline(events)
+	if len(phases) != 1 || phases[0].Status != "running" || phases[0].EndedAt != nil {
+		t.Fatalf("phases = %+v, want one running phase", phases)
+	}
+	if phases[0].DurationMs < 60000 {
+		t.Errorf("running phase duration = %dms, want time so far", phases[0].DurationMs)
+	}
+}
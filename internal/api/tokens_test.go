@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vigneshsubbiah/shipit/internal/auth"
+	"github.com/vigneshsubbiah/shipit/internal/db"
+)
+
+func TestTokenExpiry(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	days := func(n int) *int { return &n }
+	at := func(d time.Duration) *time.Time { t := now.Add(d); return &t }
+
+	got, err := tokenExpiry(days(30), nil, now)
+	if err != nil || !got.Equal(now.Add(30*24*time.Hour)) {
+		t.Errorf("expires_in 30: got %v, %v", got, err)
+	}
+	got, err = tokenExpiry(nil, at(time.Hour), now)
+	if err != nil || !got.Equal(now.Add(time.Hour)) {
+		t.Errorf("expires_at +1h: got %v, %v", got, err)
+	}
+
+	tests := []struct {
+		name      string
+		expiresIn *int
+		expiresAt *time.Time
+	}{
+		{"no expiry", nil, nil},
+		{"both set", days(1), at(time.Hour)},
+		{"zero days", days(0), nil},
+		{"in the past", nil, at(-time.Minute)},
+		{"over a year", days(366), nil},
+	}
+	for _, tt := range tests {
+		if _, err := tokenExpiry(tt.expiresIn, tt.expiresAt, now); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestCreateMyTokenStaysWithinParent(t *testing.T) {
+	h := newTestHandler()
+	expires := time.Now().Add(48 * time.Hour)
+	parent := &db.UserToken{ID: "t1", ExpiresAt: &expires, AllowedIPs: []byte(`["203.0.113.7"]`)}
+	parentScopes, _ := auth.ParseScopes([]string{"admin:*", "deploy:project/p1"})
+
+	tests := []struct {
+		name   string
+		scoped bool
+		body   string
+		want   string
+	}{
+		{"drops the allowlist", false, `{"name":"ci","expires_in":1,"scopes":["read:*"],"allowed_ips":["0.0.0.0/0"]}`, "outside the allowlist"},
+		{"wider scope", true, `{"name":"ci","expires_in":1,"scopes":["admin:*"]}`, ""},
+	}
+	for _, tt := range tests {
+		ctx := context.WithValue(context.Background(), auth.UserContextKey, &db.User{ID: "u1"})
+		ctx = context.WithValue(ctx, auth.TokenContextKey, parent)
+		if tt.scoped {
+			ctx = context.WithValue(ctx, auth.ScopesContextKey, parentScopes[1:])
+		}
+		w := httptest.NewRecorder()
+		h.CreateMyToken(w, httptest.NewRequest("POST", "/api/tokens", strings.NewReader(tt.body)).WithContext(ctx))
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("%s: status %d, body %s", tt.name, w.Code, w.Body.String())
+		}
+	}
+}
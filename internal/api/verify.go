@@ -0,0 +1,269 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vigneshsubbiah/shipit/internal/db"
+)
+
+const (
+	// verifyInterval is the pause between rounds of post-rollout checks.
+	verifyInterval = 2 * time.Second
+
+	// verifyMaxFailures consecutive failed rounds end verification early:
+	// an app answering 500 on every request isn't going to recover by
+	// itself, and waiting out the whole timeout only delays the rollback.
+	verifyMaxFailures = 5
+
+	// verifyBodyLimit caps how much of a failing response body is kept in
+	// the deploy message.
+	verifyBodyLimit = 512
+
+	// Bounds for the per-app verification settings.
+	verifyMaxSuccesses = 20
+	verifyMaxPaths     = 10
+	verifyMinTimeout   = 10
+	verifyMaxTimeout   = 1800
+)
+
+// serviceGetter is the part of *k8s.Client post-rollout verification uses.
+type serviceGetter interface {
+	ServiceGet(ctx context.Context, name, namespace string, port int, path string) (int, []byte, error)
+}
+
+// healthCheckError is a failed post-rollout verification. Its message
+// carries the failing request and response, which ends up in the
+// revision's deploy_message via autoRollback.
+type healthCheckError struct {
+	Path       string
+	StatusCode int
+	Body       string
+	Err        error
+}
+
+func (e *healthCheckError) Error() string {
+	switch {
+	case e.Path == "":
+		return e.Err.Error()
+	case e.Err != nil:
+		return fmt.Sprintf("GET %s: %v", e.Path, e.Err)
+	case e.Body == "":
+		return fmt.Sprintf("GET %s returned %d", e.Path, e.StatusCode)
+	default:
+		return fmt.Sprintf("GET %s returned %d: %s", e.Path, e.StatusCode, e.Body)
+	}
+}
+
+func (e *healthCheckError) Unwrap() error { return e.Err }
+
+// rolloutFailureMessage is the deploy_message recorded for a deploy that
+// did not verify, distinguishing an HTTP check failure from pods that
+// never became ready.
+func rolloutFailureMessage(err error) string {
+	var hc *healthCheckError
+	if errors.As(err, &hc) {
+		return "post-rollout health check failed: " + err.Error()
+	}
+	return "rollout did not become ready: " + err.Error()
+}
+
+// verifyPaths returns the paths post-rollout verification GETs: the app's
+// health_path followed by its extra verify_paths, without duplicates.
+func verifyPaths(app *db.App) []string {
+	var paths []string
+	seen := map[string]bool{}
+	add := func(p string) {
+		if p != "" && !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+	if app.HealthPath != nil {
+		add(*app.HealthPath)
+	}
+	var extra []string
+	if len(app.VerifyPaths) > 0 {
+		_ = json.Unmarshal(app.VerifyPaths, &extra)
+	}
+	for _, p := range extra {
+		add(p)
+	}
+	return paths
+}
+
+// verifyRollout gates a ready rollout on real HTTP responses: readiness
+// probes can pass while the app answers 500 on actual routes. Each round
+// GETs every verifyPaths path through the app's Service; verify_successes
+// consecutive rounds where all of them return 2xx pass. Returns the last
+// failing response as a *healthCheckError after verifyMaxFailures failed
+// rounds in a row, or when verify_timeout runs out. Apps with the gate off
+// (verify_successes 0), no port, or no paths pass immediately.
+func verifyRollout(ctx context.Context, getter serviceGetter, app *db.App, interval time.Duration) error {
+	paths := verifyPaths(app)
+	if app.VerifySuccesses <= 0 || app.Port == nil || len(paths) == 0 {
+		return nil
+	}
+
+	timeout := time.Duration(app.VerifyTimeout) * time.Second
+	if app.VerifyTimeout < verifyMinTimeout {
+		timeout = verifyMinTimeout * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	successes, failures := 0, 0
+	var last *healthCheckError
+	for {
+		if failed := checkPaths(ctx, getter, app, paths); failed != nil {
+			last = failed
+			successes = 0
+			failures++
+			if failures >= verifyMaxFailures {
+				return last
+			}
+		} else {
+			successes++
+			failures = 0
+			if successes >= app.VerifySuccesses {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if last != nil {
+				return last
+			}
+			return &healthCheckError{Err: fmt.Errorf("timed out after %d of %d consecutive successful checks", successes, app.VerifySuccesses)}
+		case <-time.After(interval):
+		}
+	}
+}
+
+// checkPaths runs one round of verification, returning the first failure.
+func checkPaths(ctx context.Context, getter serviceGetter, app *db.App, paths []string) *healthCheckError {
+	for _, path := range paths {
+		code, body, err := getter.ServiceGet(ctx, app.Name, app.Namespace, *app.Port, path)
+		if err != nil {
+			return &healthCheckError{Path: path, Err: err}
+		}
+		if code < 200 || code > 299 {
+			return &healthCheckError{Path: path, StatusCode: code, Body: truncateBody(body)}
+		}
+	}
+	return nil
+}
+
+func truncateBody(body []byte) string {
+	s := strings.TrimSpace(string(body))
+	if len(s) > verifyBodyLimit {
+		s = s[:verifyBodyLimit] + "..."
+	}
+	return s
+}
+
+func verificationResponse(app *db.App) map[string]interface{} {
+	var paths []string
+	if len(app.VerifyPaths) > 0 {
+		_ = json.Unmarshal(app.VerifyPaths, &paths)
+	}
+	if paths == nil {
+		paths = []string{}
+	}
+	return map[string]interface{}{
+		"verify_successes": app.VerifySuccesses,
+		"verify_paths":     paths,
+		"verify_timeout":   app.VerifyTimeout,
+		"health_path":      app.HealthPath,
+		// The gate only runs with somewhere to send requests.
+		"active": app.VerifySuccesses > 0 && app.Port != nil && len(verifyPaths(app)) > 0,
+	}
+}
+
+func (h *Handler) GetVerification(w http.ResponseWriter, r *http.Request) {
+	appID := chi.URLParam(r, "appID")
+
+	app, err := h.db.GetApp(r.Context(), appID)
+	if err != nil {
+		httpError(w, "app not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(verificationResponse(app))
+}
+
+func (h *Handler) SetVerification(w http.ResponseWriter, r *http.Request) {
+	appID := chi.URLParam(r, "appID")
+
+	existing, err := h.db.GetApp(r.Context(), appID)
+	if err != nil {
+		httpError(w, "app not found", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		VerifySuccesses *int      `json:"verify_successes"`
+		VerifyPaths     *[]string `json:"verify_paths"`
+		VerifyTimeout   *int      `json:"verify_timeout"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	params := db.UpdateAppVerificationParams{
+		ID:              appID,
+		VerifySuccesses: existing.VerifySuccesses,
+		VerifyPaths:     existing.VerifyPaths,
+		VerifyTimeout:   existing.VerifyTimeout,
+	}
+	if req.VerifySuccesses != nil {
+		params.VerifySuccesses = *req.VerifySuccesses
+	}
+	if req.VerifyTimeout != nil {
+		params.VerifyTimeout = *req.VerifyTimeout
+	}
+	if req.VerifyPaths != nil {
+		paths := *req.VerifyPaths
+		if len(paths) > verifyMaxPaths {
+			httpError(w, fmt.Sprintf("at most %d verify_paths are allowed", verifyMaxPaths), http.StatusBadRequest)
+			return
+		}
+		for _, p := range paths {
+			if !strings.HasPrefix(p, "/") {
+				httpError(w, "verify_paths must start with /", http.StatusBadRequest)
+				return
+			}
+		}
+		if paths == nil {
+			paths = []string{}
+		}
+		params.VerifyPaths, _ = json.Marshal(paths)
+	}
+	if len(params.VerifyPaths) == 0 {
+		params.VerifyPaths = json.RawMessage("[]")
+	}
+
+	if params.VerifySuccesses < 0 || params.VerifySuccesses > verifyMaxSuccesses {
+		httpError(w, fmt.Sprintf("verify_successes must be between 0 and %d", verifyMaxSuccesses), http.StatusBadRequest)
+		return
+	}
+	if params.VerifyTimeout < verifyMinTimeout || params.VerifyTimeout > verifyMaxTimeout {
+		httpError(w, fmt.Sprintf("verify_timeout must be between %d and %d seconds", verifyMinTimeout, verifyMaxTimeout), http.StatusBadRequest)
+		return
+	}
+
+	app, err := h.db.UpdateAppVerification(r.Context(), params)
+	if err != nil {
+		httpError(w, "failed to update verification settings", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(verificationResponse(app))
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/vigneshsubbiah/shipit/internal/db"
+)
+
+// stubGetter answers ServiceGet from a scripted list of status codes per
+// path, repeating the last one once the script runs out.
+type stubGetter struct {
+	codes map[string][]int
+	body  string
+	err   error
+	calls int
+}
+
+func (s *stubGetter) ServiceGet(ctx context.Context, name, namespace string, port int, path string) (int, []byte, error) {
+	s.calls++
+	if s.err != nil {
+		return 0, nil, s.err
+	}
+	codes := s.codes[path]
+	code := codes[0]
+	if len(codes) > 1 {
+		s.codes[path] = codes[1:]
+	}
+	return code, []byte(s.body), nil
+}
+
+func verifyApp(successes int, extra ...string) *db.App {
+	port := 8080
+	health := "/healthz"
+	paths, _ := json.Marshal(extra)
+	return &db.App{
+		Name:            "svc",
+		Namespace:       "default",
+		Port:            &port,
+		HealthPath:      &health,
+		VerifySuccesses: successes,
+		VerifyPaths:     paths,
+		VerifyTimeout:   60,
+	}
+}
+
+func TestVerifyPaths(t *testing.T) {
+	app := verifyApp(3, "/api/ping", "/healthz", "/")
+	got := verifyPaths(app)
+	want := []string{"/healthz", "/api/ping", "/"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("verifyPaths = %v, want %v (health path first, no duplicates)", got, want)
+	}
+}
+
+func TestVerifyRollout_RequiresConsecutiveSuccesses(t *testing.T) {
+	app := verifyApp(3, "/api/ping")
+	getter := &stubGetter{codes: map[string][]int{
+		"/healthz":  {200},
+		"/api/ping": {500, 200},
+	}}
+	if err := verifyRollout(context.Background(), getter, app, 0); err != nil {
+		t.Fatalf("verifyRollout: %v", err)
+	}
+	// Round 1 fails on /api/ping, then three clean rounds of two paths.
+	if getter.calls != 2+3*2 {
+		t.Errorf("ServiceGet calls = %d, want 8", getter.calls)
+	}
+}
+
+func TestVerifyRollout_FailureCarriesResponse(t *testing.T) {
+	app := verifyApp(2)
+	getter := &stubGetter{
+		codes: map[string][]int{"/healthz": {503}},
+		body:  "  database unavailable\n",
+	}
+	err := verifyRollout(context.Background(), getter, app, 0)
+	if err == nil {
+		t.Fatal("expected verification to fail")
+	}
+	msg := rolloutFailureMessage(err)
+	want := "post-rollout health check failed: GET /healthz returned 503: database unavailable"
+	if msg != want {
+		t.Errorf("message = %q, want %q", msg, want)
+	}
+	if getter.calls != verifyMaxFailures {
+		t.Errorf("ServiceGet calls = %d, want %d (give up after consecutive failures)", getter.calls, verifyMaxFailures)
+	}
+}
+
+func TestVerifyRollout_TransportError(t *testing.T) {
+	getter := &stubGetter{err: errors.New("connection refused")}
+	err := verifyRollout(context.Background(), getter, verifyApp(1), 0)
+	if err == nil || !strings.Contains(err.Error(), "GET /healthz: connection refused") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestVerifyRollout_Disabled(t *testing.T) {
+	getter := &stubGetter{err: errors.New("must not be called")}
+	cases := map[string]*db.App{
+		"gate off": verifyApp(0),
+		"no port":  func() *db.App { a := verifyApp(3); a.Port = nil; return a }(),
+		"no paths": func() *db.App { a := verifyApp(3); a.HealthPath = nil; return a }(),
+	}
+	for name, app := range cases {
+		if err := verifyRollout(context.Background(), getter, app, 0); err != nil {
+			t.Errorf("%s: verifyRollout = %v, want nil", name, err)
+		}
+	}
+	if getter.calls != 0 {
+		t.Errorf("ServiceGet called %d times for disabled verification", getter.calls)
+	}
+}
+
+func TestRolloutFailureMessage_WatchError(t *testing.T) {
+	if got := rolloutFailureMessage(errors.New("timeout")); got != "rollout did not become ready: timeout" {
+		t.Errorf("message = %q", got)
+	}
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIPContextKey holds the client address resolved by TrustProxies.
+const ClientIPContextKey contextKey = "client_ip"
+
+// TrustProxies resolves each request's client address once, for ClientIP.
+// X-Forwarded-For and X-Real-IP are only honored on requests whose peer is
+// one of the trusted proxies (addresses or CIDRs, see TRUSTED_PROXIES);
+// anyone else could set them to an allowlisted address. With no trusted
+// proxies the peer address is always used.
+func TrustProxies(trusted []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := forwardedClientIP(r, trusted)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ClientIPContextKey, ip)))
+		})
+	}
+}
+
+// ClientIP returns the address a request came from: the one TrustProxies
+// resolved, or the peer address for requests it didn't see.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(ClientIPContextKey).(string); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+// forwardedClientIP walks X-Forwarded-For from the nearest hop back,
+// skipping trusted proxies, and returns the first address that isn't one.
+// Hops further back were written by the client and aren't believed.
+func forwardedClientIP(r *http.Request, trusted []string) string {
+	ip := remoteIP(r)
+	if len(trusted) == 0 || !IPAllowed(trusted, ip) {
+		return ip
+	}
+
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			ip = hop
+			if !IPAllowed(trusted, hop) {
+				break
+			}
+		}
+		return ip
+	}
+
+	if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(xri) != nil {
+		return xri
+	}
+	return ip
+}
+
+// remoteIP is the peer address of the connection, without its port.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func resolveClientIP(trusted []string, remoteAddr string, header http.Header) string {
+	req := httptest.NewRequest("GET", "/api/apps", nil)
+	req.RemoteAddr = remoteAddr
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	var got string
+	TrustProxies(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = ClientIP(r)
+	})).ServeHTTP(httptest.NewRecorder(), req)
+	return got
+}
+
+func TestClientIP(t *testing.T) {
+	trusted := []string{"10.0.0.0/8"}
+	tests := []struct {
+		name       string
+		trusted    []string
+		remoteAddr string
+		header     http.Header
+		want       string
+	}{
+		{"peer address", nil, "198.51.100.4:51234", nil, "198.51.100.4"},
+		{"ipv6 peer", nil, "[2001:db8::1]:51234", nil, "2001:db8::1"},
+		{"forwarded by trusted proxy", trusted, "10.0.0.2:443", http.Header{"X-Forwarded-For": {"198.51.100.4"}}, "198.51.100.4"},
+		{"client-supplied hops ignored", trusted, "10.0.0.2:443", http.Header{"X-Forwarded-For": {"203.0.113.7, 198.51.100.4, 10.0.0.3"}}, "198.51.100.4"},
+		{"x-real-ip from trusted proxy", trusted, "10.0.0.2:443", http.Header{"X-Real-Ip": {"198.51.100.4"}}, "198.51.100.4"},
+		{"untrusted peer", trusted, "198.51.100.4:51234", http.Header{"X-Forwarded-For": {"203.0.113.7"}, "X-Real-Ip": {"203.0.113.7"}}, "198.51.100.4"},
+		{"no trusted proxies", nil, "10.0.0.2:443", http.Header{"X-Forwarded-For": {"203.0.113.7"}}, "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		if got := resolveClientIP(tt.trusted, tt.remoteAddr, tt.header); got != tt.want {
+			t.Errorf("%s: ClientIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClientIPSpoofedForwardedForRejected(t *testing.T) {
+	// A leaked token limited to 203.0.113.7 is used from elsewhere, with
+	// the allowed address in X-Forwarded-For.
+	allowlist := []string{"203.0.113.7"}
+	ip := resolveClientIP(nil, "198.51.100.4:51234", http.Header{"X-Forwarded-For": {"203.0.113.7"}})
+	if IPAllowed(allowlist, ip) {
+		t.Errorf("spoofed X-Forwarded-For passed the allowlist as %s", ip)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"io"
@@ -85,3 +86,12 @@ func GenerateToken() (string, error) {
 	}
 	return hex.EncodeToString(token), nil
 }
+
+// FingerprintKey derives the HMAC key for Keyring.Fingerprint from an
+// encryption key, so fingerprints never expose key material. Set
+// ENCRYPT_FINGERPRINT_KEY to its hex form to keep fingerprints stable once
+// ENCRYPT_KEY is retired.
+func FingerprintKey(keyHex string) []byte {
+	derived := sha256.Sum256([]byte("shipit-secret-fingerprint:" + keyHex))
+	return derived[:]
+}
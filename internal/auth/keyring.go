@@ -0,0 +1,349 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/vigneshsubbiah/shipit/internal/config"
+)
+
+// Kubeconfigs, secrets and notification credentials are stored with
+// envelope encryption: each value is sealed (AES-256-GCM) with its own
+// random data key, and the data key is wrapped by a key-encryption key
+// (KEK) from a KEKProvider. The stored value records which KEK wrapped it:
+//
+//	"v1:" <KEK ID> ":" <uint16 wrapped key length> <wrapped data key> <nonce> <sealed value>
+//
+// Rotating KEKs therefore only rewraps data keys (see Keyring.Rewrap and
+// shipit-server rotate-keys). Values written before versioning are plain
+// Encrypt output under ENCRYPT_KEY and still decrypt with it.
+
+const ciphertextPrefix = "v1:"
+
+// EnvKeyID is the KEK ID ENCRYPT_KEY is registered under.
+const EnvKeyID = "env"
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// KEKProvider wraps and unwraps data keys with key-encryption keys. Every
+// KEK it knows can unwrap; new data keys are wrapped with the primary one.
+type KEKProvider interface {
+	// PrimaryKeyID is the KEK new data keys are wrapped with.
+	PrimaryKeyID() string
+	// WrapKey encrypts a data key with the KEK keyID.
+	WrapKey(keyID string, dek []byte) ([]byte, error)
+	// UnwrapKey decrypts a data key wrapped by WrapKey.
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// LocalKEKs is a KEKProvider holding AES-256 KEKs in memory, loaded from
+// ENCRYPT_KEY or an ENCRYPT_KEYS_FILE.
+type LocalKEKs struct {
+	primary string
+	keys    map[string][]byte
+}
+
+// NewLocalKEKs returns a provider for the given hex-encoded 32-byte keys,
+// wrapping new data keys with primary.
+func NewLocalKEKs(primary string, keysHex map[string]string) (*LocalKEKs, error) {
+	k := &LocalKEKs{primary: primary, keys: make(map[string][]byte)}
+	for id, keyHex := range keysHex {
+		if err := k.AddKey(id, keyHex); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := k.keys[primary]; !ok {
+		return nil, fmt.Errorf("primary key %q is not among the keys", primary)
+	}
+	return k, nil
+}
+
+// LoadKEKFile reads KEKs from a JSON file such as
+//
+//	{"primary": "2026-10", "keys": {"2026-10": "<64 hex chars>", "2026-01": "<64 hex chars>"}}
+func LoadKEKFile(path string) (*LocalKEKs, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Primary string            `json:"primary"`
+		Keys    map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	k, err := NewLocalKEKs(file.Primary, file.Keys)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return k, nil
+}
+
+// AddKey registers another KEK for unwrapping. Adding an ID again with the
+// same key is a no-op.
+func (k *LocalKEKs) AddKey(id, keyHex string) error {
+	if !keyIDPattern.MatchString(id) {
+		return fmt.Errorf("invalid key ID %q: use letters, digits, '.', '_' and '-'", id)
+	}
+	key, err := decodeKey(keyHex)
+	if err != nil {
+		return fmt.Errorf("key %q: %w", id, err)
+	}
+	if existing, ok := k.keys[id]; ok && !bytes.Equal(existing, key) {
+		return fmt.Errorf("key ID %q is defined twice with different keys", id)
+	}
+	k.keys[id] = key
+	return nil
+}
+
+// PrimaryKeyID implements KEKProvider.
+func (k *LocalKEKs) PrimaryKeyID() string {
+	return k.primary
+}
+
+// WrapKey implements KEKProvider.
+func (k *LocalKEKs) WrapKey(keyID string, dek []byte) ([]byte, error) {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %q", keyID)
+	}
+	return seal(key, dek)
+}
+
+// UnwrapKey implements KEKProvider.
+func (k *LocalKEKs) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %q", keyID)
+	}
+	return open(key, wrapped)
+}
+
+// Keyring encrypts and decrypts stored values. Create one with
+// LoadKeyring or NewKeyring.
+type Keyring struct {
+	kek            KEKProvider
+	legacyKey      string // hex; decrypts values written before versioning
+	fingerprintKey []byte
+}
+
+// NewKeyring returns a keyring wrapping data keys with kek. legacyKeyHex,
+// if set, decrypts unversioned values. fingerprintKey is the HMAC key for
+// Fingerprint (see FingerprintKey).
+func NewKeyring(kek KEKProvider, legacyKeyHex string, fingerprintKey []byte) *Keyring {
+	return &Keyring{kek: kek, legacyKey: legacyKeyHex, fingerprintKey: fingerprintKey}
+}
+
+// LoadKeyring builds the keyring from ENCRYPT_KEYS_FILE and/or ENCRYPT_KEY.
+// It fails rather than making up a key: data encrypted with a throwaway
+// key is lost on the next restart.
+func LoadKeyring(cfg *config.Config) (*Keyring, error) {
+	var kek *LocalKEKs
+	var err error
+	switch {
+	case cfg.EncryptKeysFile != "":
+		if kek, err = LoadKEKFile(cfg.EncryptKeysFile); err != nil {
+			return nil, err
+		}
+		// Values encrypted under ENCRYPT_KEY stay readable until
+		// rotate-keys has moved them to the file's primary key.
+		if cfg.EncryptKey != "" {
+			if err := kek.AddKey(EnvKeyID, cfg.EncryptKey); err != nil {
+				return nil, fmt.Errorf("ENCRYPT_KEY: %w", err)
+			}
+		}
+	case cfg.EncryptKey != "":
+		if kek, err = NewLocalKEKs(EnvKeyID, map[string]string{EnvKeyID: cfg.EncryptKey}); err != nil {
+			return nil, fmt.Errorf("ENCRYPT_KEY: %w", err)
+		}
+	default:
+		return nil, errors.New("no encryption key configured: set ENCRYPT_KEY (generate one with: openssl rand -hex 32) or ENCRYPT_KEYS_FILE")
+	}
+
+	// Secret fingerprints must not change when KEKs rotate, or every
+	// revision would appear to have changed all of its secrets.
+	var fingerprintKey []byte
+	switch {
+	case cfg.EncryptFingerprintKey != "":
+		if fingerprintKey, err = decodeKey(cfg.EncryptFingerprintKey); err != nil {
+			return nil, fmt.Errorf("ENCRYPT_FINGERPRINT_KEY: %w", err)
+		}
+	case cfg.EncryptKey != "":
+		fingerprintKey = FingerprintKey(cfg.EncryptKey)
+	default:
+		return nil, errors.New("ENCRYPT_FINGERPRINT_KEY is required when ENCRYPT_KEY is not set")
+	}
+
+	return NewKeyring(kek, cfg.EncryptKey, fingerprintKey), nil
+}
+
+// PrimaryKeyID is the KEK new values are encrypted under.
+func (k *Keyring) PrimaryKeyID() string {
+	return k.kek.PrimaryKeyID()
+}
+
+// Encrypt seals plaintext with a fresh data key wrapped by the primary KEK.
+func (k *Keyring) Encrypt(plaintext []byte) ([]byte, error) {
+	dek := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, err
+	}
+	sealed, err := seal(dek, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return k.wrap(dek, sealed)
+}
+
+// Decrypt opens a value written by Encrypt, or by the package-level
+// Encrypt under the legacy key.
+func (k *Keyring) Decrypt(ciphertext []byte) ([]byte, error) {
+	keyID, wrapped, sealed, ok := parseCiphertext(ciphertext)
+	if !ok {
+		return k.decryptLegacy(ciphertext)
+	}
+	dek, err := k.kek.UnwrapKey(keyID, wrapped)
+	if err == nil {
+		var plaintext []byte
+		if plaintext, err = open(dek, sealed); err == nil {
+			return plaintext, nil
+		}
+	}
+	// An unversioned value can start with "v1:" by chance.
+	if plaintext, legacyErr := k.decryptLegacy(ciphertext); legacyErr == nil {
+		return plaintext, nil
+	}
+	return nil, err
+}
+
+// Rewrap returns ciphertext with its data key wrapped by the primary KEK,
+// and whether that changed anything. Unversioned values are re-encrypted.
+func (k *Keyring) Rewrap(ciphertext []byte) ([]byte, bool, error) {
+	keyID, wrapped, sealed, ok := parseCiphertext(ciphertext)
+	if ok && keyID == k.kek.PrimaryKeyID() {
+		return ciphertext, false, nil
+	}
+	if ok {
+		if dek, err := k.kek.UnwrapKey(keyID, wrapped); err == nil {
+			rewrapped, err := k.wrap(dek, sealed)
+			return rewrapped, err == nil, err
+		}
+	}
+	plaintext, err := k.Decrypt(ciphertext)
+	if err != nil {
+		return nil, false, err
+	}
+	encrypted, err := k.Encrypt(plaintext)
+	return encrypted, err == nil, err
+}
+
+// Fingerprint returns a keyed (HMAC-SHA256) fingerprint of a secret value.
+// Used to detect that a secret changed between two revisions without
+// storing anything that allows an offline guess of low-entropy values. The
+// fingerprint key is fixed across KEK rotations.
+func (k *Keyring) Fingerprint(value []byte) string {
+	mac := hmac.New(sha256.New, k.fingerprintKey)
+	mac.Write(value)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CiphertextKeyID returns the KEK ID a value was encrypted under, or ""
+// for values written before versioning.
+func CiphertextKeyID(ciphertext []byte) string {
+	keyID, _, _, _ := parseCiphertext(ciphertext)
+	return keyID
+}
+
+func (k *Keyring) wrap(dek, sealed []byte) ([]byte, error) {
+	keyID := k.kek.PrimaryKeyID()
+	wrapped, err := k.kek.WrapKey(keyID, dek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) > 0xffff {
+		return nil, errors.New("wrapped data key too long")
+	}
+	out := make([]byte, 0, len(ciphertextPrefix)+len(keyID)+3+len(wrapped)+len(sealed))
+	out = append(out, ciphertextPrefix...)
+	out = append(out, keyID...)
+	out = append(out, ':')
+	out = binary.BigEndian.AppendUint16(out, uint16(len(wrapped)))
+	out = append(out, wrapped...)
+	return append(out, sealed...), nil
+}
+
+func (k *Keyring) decryptLegacy(ciphertext []byte) ([]byte, error) {
+	if k.legacyKey == "" {
+		return nil, errors.New("unversioned ciphertext and no ENCRYPT_KEY to decrypt it")
+	}
+	return Decrypt(ciphertext, k.legacyKey)
+}
+
+func parseCiphertext(ciphertext []byte) (keyID string, wrapped, sealed []byte, ok bool) {
+	rest, found := bytes.CutPrefix(ciphertext, []byte(ciphertextPrefix))
+	if !found {
+		return "", nil, nil, false
+	}
+	id, rest, found := bytes.Cut(rest, []byte(":"))
+	if !found || !keyIDPattern.Match(id) || len(rest) < 2 {
+		return "", nil, nil, false
+	}
+	n := int(binary.BigEndian.Uint16(rest))
+	if len(rest) < 2+n {
+		return "", nil, nil, false
+	}
+	return string(id), rest[2 : 2+n], rest[2+n:], true
+}
+
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func decodeKey(keyHex string) ([]byte, error) {
+	key, err := hex.DecodeString(keyHex)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("encryption key must be 32 bytes (64 hex characters)")
+	}
+	return key, nil
+}
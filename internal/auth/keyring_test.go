@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vigneshsubbiah/shipit/internal/config"
+)
+
+var (
+	keyA = strings.Repeat("a", 64)
+	keyB = strings.Repeat("b", 64)
+)
+
+func testKEKs(t *testing.T, primary string, keys map[string]string) *LocalKEKs {
+	t.Helper()
+	k, err := NewLocalKEKs(primary, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestKeyringRoundTrip(t *testing.T) {
+	k := NewKeyring(testKEKs(t, "2026-01", map[string]string{"2026-01": keyA}), "", nil)
+	ciphertext, err := k.Encrypt([]byte("kubeconfig"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(ciphertext, []byte("v1:2026-01:")) || CiphertextKeyID(ciphertext) != "2026-01" {
+		t.Errorf("ciphertext not versioned with its key ID: %q", ciphertext[:12])
+	}
+	plaintext, err := k.Decrypt(ciphertext)
+	if err != nil || string(plaintext) != "kubeconfig" {
+		t.Errorf("Decrypt = %q, %v", plaintext, err)
+	}
+
+	ciphertext[len(ciphertext)-1] ^= 1
+	if _, err := k.Decrypt(ciphertext); err == nil {
+		t.Error("tampered ciphertext decrypted")
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	oldRing := NewKeyring(testKEKs(t, "old", map[string]string{"old": keyA}), keyA, FingerprintKey(keyA))
+	versioned, _ := oldRing.Encrypt([]byte("secret"))
+	legacy, _ := Encrypt([]byte("legacy secret"), keyA)
+
+	// Both keys loaded, new one primary: everything still decrypts and
+	// Rewrap moves values to the new key.
+	ring := NewKeyring(testKEKs(t, "new", map[string]string{"new": keyB, "old": keyA}), keyA, FingerprintKey(keyA))
+	for _, tt := range []struct {
+		ciphertext []byte
+		want       string
+	}{{versioned, "secret"}, {legacy, "legacy secret"}} {
+		if got, err := ring.Decrypt(tt.ciphertext); err != nil || string(got) != tt.want {
+			t.Errorf("Decrypt before rotation = %q, %v", got, err)
+		}
+		rewrapped, changed, err := ring.Rewrap(tt.ciphertext)
+		if err != nil || !changed || CiphertextKeyID(rewrapped) != "new" {
+			t.Fatalf("Rewrap = key %q, %v, %v", CiphertextKeyID(rewrapped), changed, err)
+		}
+		if _, changed, _ := ring.Rewrap(rewrapped); changed {
+			t.Error("Rewrap changed a value already under the primary key")
+		}
+
+		// With the old key gone, rewrapped values still decrypt.
+		newRing := NewKeyring(testKEKs(t, "new", map[string]string{"new": keyB}), "", FingerprintKey(keyA))
+		if got, err := newRing.Decrypt(rewrapped); err != nil || string(got) != tt.want {
+			t.Errorf("Decrypt after rotation = %q, %v", got, err)
+		}
+		if _, err := newRing.Decrypt(tt.ciphertext); err == nil {
+			t.Error("value under a removed key decrypted")
+		}
+	}
+
+	// Fingerprints only depend on the fingerprint key.
+	if oldRing.Fingerprint([]byte("s")) != ring.Fingerprint([]byte("s")) {
+		t.Error("fingerprint changed with the primary key")
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "keys.json")
+	os.WriteFile(file, []byte(`{"primary": "2026-10", "keys": {"2026-10": "`+keyB+`"}}`), 0600)
+
+	ring, err := LoadKeyring(&config.Config{EncryptKey: keyA, EncryptKeysFile: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ring.PrimaryKeyID() != "2026-10" {
+		t.Errorf("primary = %q", ring.PrimaryKeyID())
+	}
+	// ENCRYPT_KEY values stay readable alongside the file's keys.
+	envRing, _ := LoadKeyring(&config.Config{EncryptKey: keyA})
+	fromEnv, _ := envRing.Encrypt([]byte("x"))
+	if CiphertextKeyID(fromEnv) != EnvKeyID {
+		t.Errorf("ENCRYPT_KEY values under key %q", CiphertextKeyID(fromEnv))
+	}
+	if got, err := ring.Decrypt(fromEnv); err != nil || string(got) != "x" {
+		t.Errorf("Decrypt ENCRYPT_KEY value = %q, %v", got, err)
+	}
+	if ring.Fingerprint([]byte("x")) != envRing.Fingerprint([]byte("x")) {
+		t.Error("fingerprint key differs between ENCRYPT_KEY and file configs")
+	}
+
+	bad := []struct {
+		name string
+		cfg  config.Config
+	}{
+		{"no key", config.Config{}},
+		{"short key", config.Config{EncryptKey: "abcd"}},
+		{"file without fingerprint key", config.Config{EncryptKeysFile: file}},
+		{"missing file", config.Config{EncryptKeysFile: filepath.Join(dir, "nope.json"), EncryptKey: keyA}},
+	}
+	for _, tt := range bad {
+		if _, err := LoadKeyring(&tt.cfg); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestNewLocalKEKsValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		primary string
+		keys    map[string]string
+	}{
+		{"primary missing", "b", map[string]string{"a": keyA}},
+		{"bad key ID", "a:1", map[string]string{"a:1": keyA}},
+		{"bad key", "a", map[string]string{"a": "zz"}},
+	}
+	for _, tt := range tests {
+		if _, err := NewLocalKEKs(tt.primary, tt.keys); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+
+	k := testKEKs(t, "a", map[string]string{"a": keyA})
+	if err := k.AddKey("a", keyA); err != nil {
+		t.Errorf("re-adding the same key: %v", err)
+	}
+	if err := k.AddKey("a", keyB); err == nil {
+		t.Error("expected conflicting key to be rejected")
+	}
+}
@@ -2,6 +2,8 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
+	"log"
 	"net/http"
 	"strings"
 
@@ -14,13 +16,27 @@ const (
 	TokenContextKey   contextKey = "api_token"
 	UserContextKey    contextKey = "user"
 	SessionContextKey contextKey = "session"
+	AdminContextKey   contextKey = "platform_admin"
+	ScopesContextKey  contextKey = "token_scopes"
 )
 
 // Middleware creates authentication middleware that supports:
 // 1. Session cookies (for web dashboard)
 // 2. User tokens (Bearer token, user-generated for CLI)
-// 3. Legacy API tokens (for backwards compatibility)
-func Middleware(database *db.DB) func(http.Handler) http.Handler {
+// 3. Legacy API tokens, only when legacyTokens is set (LEGACY_API_TOKENS)
+//
+// Users whose email is in adminEmails are marked as platform admins: they
+// hold the admin role in every project. Everyone else is limited to the
+// projects they are a member of (see ProjectAccess). Legacy API tokens have
+// no owner, scopes or expiry to limit them by, so they are refused unless
+// the operator opts back in, and are then platform admins as before access
+// control.
+func Middleware(database *db.DB, adminEmails []string, legacyTokens bool) func(http.Handler) http.Handler {
+	admins := make(map[string]bool, len(adminEmails))
+	for _, email := range adminEmails {
+		admins[strings.ToLower(email)] = true
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ctx := r.Context()
@@ -29,6 +45,7 @@ func Middleware(database *db.DB) func(http.Handler) http.Handler {
 			if session, user := validateSessionCookie(r, database); session != nil && user != nil {
 				ctx = context.WithValue(ctx, SessionContextKey, session)
 				ctx = context.WithValue(ctx, UserContextKey, user)
+				ctx = context.WithValue(ctx, AdminContextKey, admins[strings.ToLower(user.Email)])
 				next.ServeHTTP(w, r.WithContext(ctx))
 				return
 			}
@@ -37,16 +54,25 @@ func Middleware(database *db.DB) func(http.Handler) http.Handler {
 			token := extractToken(r)
 			if token != "" {
 				// Try user token first
-				if userToken, user := validateUserToken(r.Context(), database, token); userToken != nil && user != nil {
+				if userToken, user, scopes := validateUserToken(r, database, token); userToken != nil && user != nil {
 					ctx = context.WithValue(ctx, TokenContextKey, userToken)
 					ctx = context.WithValue(ctx, UserContextKey, user)
+					if scopes != nil {
+						ctx = context.WithValue(ctx, ScopesContextKey, scopes)
+					}
+					ctx = context.WithValue(ctx, AdminContextKey, admins[strings.ToLower(user.Email)])
 					next.ServeHTTP(w, r.WithContext(ctx))
 					return
 				}
 
 				// Fall back to legacy API token
+				if !legacyTokens {
+					http.Error(w, `{"error": "unauthorized"}`, http.StatusUnauthorized)
+					return
+				}
 				if apiToken, err := database.ValidateToken(r.Context(), token); err == nil {
 					ctx = context.WithValue(ctx, TokenContextKey, apiToken)
+					ctx = context.WithValue(ctx, AdminContextKey, true)
 					next.ServeHTTP(w, r.WithContext(ctx))
 					return
 				}
@@ -58,6 +84,13 @@ func Middleware(database *db.DB) func(http.Handler) http.Handler {
 	}
 }
 
+// SessionUser returns the user signed in with the request's session cookie,
+// or nil, for browser pages outside the API such as the CLI login page.
+func SessionUser(r *http.Request, database *db.DB) *db.User {
+	_, user := validateSessionCookie(r, database)
+	return user
+}
+
 // validateSessionCookie checks for a valid session cookie
 func validateSessionCookie(r *http.Request, database *db.DB) (*db.Session, *db.User) {
 	cookie, err := r.Cookie(SessionCookieName)
@@ -79,20 +112,54 @@ func validateSessionCookie(r *http.Request, database *db.DB) (*db.Session, *db.U
 	return session, user
 }
 
-// validateUserToken validates a user-generated API token
-func validateUserToken(ctx context.Context, database *db.DB, token string) (*db.UserToken, *db.User) {
+// validateUserToken validates a user-generated API token: it must not have
+// expired, the client IP must be in its allowlist (if it has one), and its
+// scopes must parse. Scopes are nil for tokens created before scoping,
+// which are not limited beyond the user's roles.
+func validateUserToken(r *http.Request, database *db.DB, token string) (*db.UserToken, *db.User, []Scope) {
+	ctx := r.Context()
+	ip := ClientIP(r)
 	tokenHash := hashString(token)
 	userToken, err := database.ValidateUserToken(ctx, tokenHash)
 	if err != nil {
-		return nil, nil
+		return nil, nil, nil
+	}
+
+	var allowlist []string
+	if len(userToken.AllowedIPs) > 0 {
+		if err := json.Unmarshal(userToken.AllowedIPs, &allowlist); err != nil {
+			log.Printf("auth: token %s has an unreadable IP allowlist: %v", userToken.ID, err)
+			return nil, nil, nil
+		}
+	}
+	if !IPAllowed(allowlist, ip) {
+		log.Printf("auth: token %s used from %s, outside its IP allowlist", userToken.ID, ip)
+		return nil, nil, nil
+	}
+
+	var scopes []Scope
+	if len(userToken.Scopes) > 0 {
+		var list []string
+		if err := json.Unmarshal(userToken.Scopes, &list); err != nil {
+			log.Printf("auth: token %s has unreadable scopes: %v", userToken.ID, err)
+			return nil, nil, nil
+		}
+		if scopes, err = ParseScopes(list); err != nil {
+			log.Printf("auth: token %s: %v", userToken.ID, err)
+			return nil, nil, nil
+		}
 	}
 
 	user, err := database.GetUserByID(ctx, userToken.UserID)
 	if err != nil {
-		return nil, nil
+		return nil, nil, nil
 	}
 
-	return userToken, user
+	// Only uses that got through count: a rejected address mustn't show up
+	// as the token's last_used_ip.
+	database.TouchUserToken(userToken.ID, ip)
+
+	return userToken, user, scopes
 }
 
 func extractToken(r *http.Request) string {
@@ -142,6 +209,13 @@ func GetUserToken(ctx context.Context) *db.UserToken {
 	return nil
 }
 
+// IsPlatformAdmin reports whether the caller has the admin role in every
+// project (ADMIN_EMAILS users and legacy API tokens)
+func IsPlatformAdmin(ctx context.Context) bool {
+	admin, _ := ctx.Value(AdminContextKey).(bool)
+	return admin
+}
+
 // IsAuthenticated returns true if the request is authenticated
 func IsAuthenticated(ctx context.Context) bool {
 	return GetUser(ctx) != nil || GetToken(ctx) != nil
@@ -12,6 +12,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -22,8 +23,9 @@ import (
 )
 
 const (
-	SessionCookieName = "shipit_session"
-	StateCookieName   = "oauth_state"
+	SessionCookieName   = "shipit_session"
+	StateCookieName     = "oauth_state"
+	LoginNextCookieName = "login_next"
 )
 
 // GoogleUserInfo represents the user info returned by Google
@@ -82,6 +84,8 @@ func (h *OAuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		SameSite: http.SameSiteLaxMode,
 	})
 
+	setLoginNext(w, r, h.config)
+
 	// Redirect to Google
 	url := h.oauth.AuthCodeURL(state, oauth2.AccessTypeOffline)
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
@@ -152,43 +156,93 @@ func (h *OAuthHandler) HandleCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create session
-	sessionToken, err := generateRandomString(32)
-	if err != nil {
-		log.Printf("Failed to generate session token: %v", err)
+	if err := startSession(w, r, h.config, h.database, user); err != nil {
+		log.Printf("Failed to create session: %v", err)
 		http.Redirect(w, r, "/login?error=session_failed", http.StatusTemporaryRedirect)
 		return
 	}
 
+	// Redirect to dashboard, or wherever the login was started from
+	http.Redirect(w, r, loginDestination(w, r, h.config), http.StatusTemporaryRedirect)
+}
+
+// startSession creates a web session for user and sets the session cookie.
+func startSession(w http.ResponseWriter, r *http.Request, cfg *config.Config, database *db.DB, user *db.User) error {
+	sessionToken, err := generateRandomString(32)
+	if err != nil {
+		return err
+	}
+
 	sessionHash := hashString(sessionToken)
-	expiresAt := time.Now().Add(time.Duration(h.config.SessionMaxAge) * time.Second)
+	expiresAt := time.Now().Add(time.Duration(cfg.SessionMaxAge) * time.Second)
 	userAgent := r.Header.Get("User-Agent")
-	ipAddress := getClientIP(r)
+	ipAddress := ClientIP(r)
 
-	_, err = h.database.CreateSession(r.Context(), user.ID, sessionHash, expiresAt, &userAgent, &ipAddress)
+	_, err = database.CreateSession(r.Context(), user.ID, sessionHash, expiresAt, &userAgent, &ipAddress)
 	if err != nil {
-		log.Printf("Failed to create session: %v", err)
-		http.Redirect(w, r, "/login?error=session_failed", http.StatusTemporaryRedirect)
-		return
+		return err
 	}
 
 	// Update last login
-	_ = h.database.UpdateUserLastLogin(r.Context(), user.ID)
+	_ = database.UpdateUserLastLogin(r.Context(), user.ID)
 
 	// Set session cookie
 	http.SetCookie(w, &http.Cookie{
 		Name:     SessionCookieName,
 		Value:    sessionToken,
 		Path:     "/",
-		MaxAge:   h.config.SessionMaxAge,
+		MaxAge:   cfg.SessionMaxAge,
 		HttpOnly: true,
-		Secure:   h.config.CookieSecure,
+		Secure:   cfg.CookieSecure,
 		SameSite: http.SameSiteLaxMode,
-		Domain:   h.config.CookieDomain,
+		Domain:   cfg.CookieDomain,
 	})
+	return nil
+}
 
-	// Redirect to dashboard
-	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+// setLoginNext remembers where to send the browser after sign-in, when the
+// login was started with ?next=<local path> (e.g. by the CLI login page).
+func setLoginNext(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
+	next := r.URL.Query().Get("next")
+	if !safeNext(next) {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     LoginNextCookieName,
+		Value:    url.QueryEscape(next),
+		Path:     "/",
+		MaxAge:   600, // 10 minutes
+		HttpOnly: true,
+		Secure:   cfg.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// loginDestination returns, and forgets, the page to open after sign-in.
+func loginDestination(w http.ResponseWriter, r *http.Request, cfg *config.Config) string {
+	cookie, err := r.Cookie(LoginNextCookieName)
+	if err != nil {
+		return "/"
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     LoginNextCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   cfg.CookieSecure,
+	})
+	next, err := url.QueryUnescape(cookie.Value)
+	if err != nil || !safeNext(next) {
+		return "/"
+	}
+	return next
+}
+
+// safeNext reports whether next is a path on this server, so that a login
+// link can't redirect to another site.
+func safeNext(next string) bool {
+	return strings.HasPrefix(next, "/") && !strings.HasPrefix(next, "//") && !strings.HasPrefix(next, "/\\")
 }
 
 // HandleLogout clears the session
@@ -287,19 +341,3 @@ func hashString(s string) string {
 	h := sha256.Sum256([]byte(s))
 	return hex.EncodeToString(h[:])
 }
-
-func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header first (for load balancers)
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		parts := strings.Split(xff, ",")
-		return strings.TrimSpace(parts[0])
-	}
-
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
-	}
-
-	// Fall back to RemoteAddr
-	return strings.Split(r.RemoteAddr, ":")[0]
-}
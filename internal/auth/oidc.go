@@ -0,0 +1,527 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-chi/chi/v5"
+	"github.com/vigneshsubbiah/shipit/internal/config"
+	"github.com/vigneshsubbiah/shipit/internal/db"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+)
+
+// OIDCStateCookieName holds the state, nonce and PKCE verifier of an OIDC
+// login in progress.
+const OIDCStateCookieName = "oidc_state"
+
+// Identity is a user as asserted by an OIDC provider.
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Picture       string
+	Groups        []string
+}
+
+// loginError is a login failure shown on the login page as ?error=<code>.
+type loginError struct {
+	code string
+	err  error
+}
+
+func (e *loginError) Error() string { return e.code + ": " + e.err.Error() }
+func (e *loginError) Unwrap() error { return e.err }
+
+func loginErr(code string, format string, args ...any) error {
+	return &loginError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+// oidcProvider is one configured login provider. Its discovery document is
+// fetched on first use and cached.
+type oidcProvider struct {
+	cfg    config.OIDCProvider
+	client *http.Client
+
+	mu       sync.Mutex
+	oauth    *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	apiURL   string // GitHub REST API
+}
+
+func newOIDCProvider(cfg config.OIDCProvider) *oidcProvider {
+	return &oidcProvider{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// setup returns the provider's OAuth2 config, discovering its endpoints on
+// first use.
+func (p *oidcProvider) setup() (*oauth2.Config, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.oauth != nil {
+		return p.oauth, nil
+	}
+
+	cfg := &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Scopes:       p.cfg.Scopes,
+	}
+
+	if p.cfg.Type == "github" {
+		// GitHub has no discovery document; Issuer optionally points at a
+		// GitHub Enterprise server.
+		cfg.Endpoint = github.Endpoint
+		p.apiURL = "https://api.github.com"
+		if base := strings.TrimSuffix(p.cfg.Issuer, "/"); base != "" {
+			cfg.Endpoint = oauth2.Endpoint{
+				AuthURL:  base + "/login/oauth/authorize",
+				TokenURL: base + "/login/oauth/access_token",
+			}
+			p.apiURL = base + "/api/v3"
+		}
+		if len(cfg.Scopes) == 0 {
+			cfg.Scopes = []string{"read:user", "user:email", "read:org"}
+		}
+		p.oauth = cfg
+		return cfg, nil
+	}
+
+	// The discovery context also fetches signing keys later on, so it must
+	// outlive the request that triggered discovery.
+	ctx := oidc.ClientContext(context.Background(), p.client)
+	provider, err := oidc.NewProvider(ctx, p.cfg.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discovering %s: %w", p.cfg.Issuer, err)
+	}
+	cfg.Endpoint = provider.Endpoint()
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.cfg.ClientID})
+	p.oauth = cfg
+	return cfg, nil
+}
+
+// authCodeURL returns the provider's sign-in URL.
+func (p *oidcProvider) authCodeURL(state, nonce, verifier string) (string, error) {
+	cfg, err := p.setup()
+	if err != nil {
+		return "", err
+	}
+	opts := []oauth2.AuthCodeOption{oauth2.S256ChallengeOption(verifier)}
+	if p.cfg.Type != "github" {
+		opts = append(opts, oidc.Nonce(nonce))
+	}
+	return cfg.AuthCodeURL(state, opts...), nil
+}
+
+// identity exchanges an authorization code for the signed-in user's
+// identity.
+func (p *oidcProvider) identity(ctx context.Context, code, verifier, nonce string) (*Identity, error) {
+	cfg, err := p.setup()
+	if err != nil {
+		return nil, loginErr("provider_unavailable", "%v", err)
+	}
+	ctx = oidc.ClientContext(ctx, p.client)
+	token, err := cfg.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, loginErr("exchange_failed", "exchanging code: %v", err)
+	}
+	if p.cfg.Type == "github" {
+		return p.githubIdentity(ctx, cfg, token)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, loginErr("userinfo_failed", "token response has no id_token")
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, loginErr("userinfo_failed", "verifying id_token: %v", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, loginErr("userinfo_failed", "id_token nonce does not match")
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, loginErr("userinfo_failed", "decoding claims: %v", err)
+	}
+	id := &Identity{
+		Provider:      p.cfg.Name,
+		Subject:       idToken.Subject,
+		Email:         stringClaim(claims, "email"),
+		EmailVerified: boolClaim(claims, "email_verified"),
+		Name:          stringClaim(claims, "name"),
+		Picture:       stringClaim(claims, "picture"),
+		Groups:        claimStrings(claims, p.cfg.GroupsClaim),
+	}
+	if id.Name == "" {
+		id.Name = stringClaim(claims, "preferred_username")
+	}
+	return id, nil
+}
+
+// githubIdentity reads the user, their primary verified email, and their
+// organizations and teams (as "org" and "org/team" groups) from GitHub's
+// API.
+func (p *oidcProvider) githubIdentity(ctx context.Context, cfg *oauth2.Config, token *oauth2.Token) (*Identity, error) {
+	client := cfg.Client(ctx, token)
+
+	var user struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name"`
+		AvatarURL string `json:"avatar_url"`
+	}
+	if err := p.githubGet(client, "/user", &user); err != nil {
+		return nil, loginErr("userinfo_failed", "%v", err)
+	}
+	id := &Identity{
+		Provider: p.cfg.Name,
+		Subject:  strconv.FormatInt(user.ID, 10),
+		Name:     user.Name,
+		Picture:  user.AvatarURL,
+	}
+	if id.Name == "" {
+		id.Name = user.Login
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.githubGet(client, "/user/emails", &emails); err != nil {
+		return nil, loginErr("userinfo_failed", "%v", err)
+	}
+	for _, e := range emails {
+		if e.Primary {
+			id.Email, id.EmailVerified = e.Email, e.Verified
+		}
+	}
+
+	var orgs []struct {
+		Login string `json:"login"`
+	}
+	if err := p.githubGet(client, "/user/orgs", &orgs); err != nil {
+		return nil, loginErr("userinfo_failed", "%v", err)
+	}
+	for _, org := range orgs {
+		id.Groups = append(id.Groups, org.Login)
+	}
+	var teams []struct {
+		Slug         string `json:"slug"`
+		Organization struct {
+			Login string `json:"login"`
+		} `json:"organization"`
+	}
+	if err := p.githubGet(client, "/user/teams", &teams); err != nil {
+		return nil, loginErr("userinfo_failed", "%v", err)
+	}
+	for _, team := range teams {
+		id.Groups = append(id.Groups, team.Organization.Login+"/"+team.Slug)
+	}
+	return id, nil
+}
+
+func (p *oidcProvider) githubGet(client *http.Client, path string, v any) error {
+	resp, err := client.Get(p.apiURL + path + "?per_page=100")
+	if err != nil {
+		return fmt.Errorf("GET %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// authorize checks an identity against the provider's sign-in rules.
+func (p *oidcProvider) authorize(id *Identity) error {
+	if id.Email == "" || !id.EmailVerified {
+		return loginErr("email_unverified", "%s: no verified email for subject %s", p.cfg.Name, id.Subject)
+	}
+	if p.cfg.AllowedDomain != "" && !strings.HasSuffix(strings.ToLower(id.Email), "@"+strings.ToLower(p.cfg.AllowedDomain)) {
+		return loginErr("unauthorized_domain", "%s: unauthorized email domain: %s", p.cfg.Name, id.Email)
+	}
+	if len(p.cfg.AllowedGroups) > 0 && !slices.ContainsFunc(id.Groups, func(g string) bool {
+		return slices.Contains(p.cfg.AllowedGroups, g)
+	}) {
+		return loginErr("unauthorized_group", "%s: %s is not in any allowed group", p.cfg.Name, id.Email)
+	}
+	return nil
+}
+
+// linksByEmail reports whether a first login through p may be linked to an
+// existing user with the same email. A provider restricted to a domain
+// only vouches for addresses in it, so that is taken as consent.
+func (p *oidcProvider) linksByEmail() bool {
+	return p.cfg.LinkByEmail || p.cfg.AllowedDomain != ""
+}
+
+// claimStrings reads a string or list-of-strings claim. path may be dotted
+// to reach nested claims, e.g. "realm_access.roles" for Keycloak.
+func claimStrings(claims map[string]any, path string) []string {
+	if path == "" {
+		return nil
+	}
+	var v any = claims
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var out []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func stringClaim(claims map[string]any, key string) string {
+	s, _ := claims[key].(string)
+	return s
+}
+
+// boolClaim reads a boolean claim; some providers send "true" as a string.
+func boolClaim(claims map[string]any, key string) bool {
+	switch v := claims[key].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// OIDCHandler handles sign-in through the providers in OIDC_PROVIDERS.
+type OIDCHandler struct {
+	config    *config.Config
+	database  *db.DB
+	providers map[string]*oidcProvider
+}
+
+// NewOIDCHandler creates a handler for every configured OIDC provider
+func NewOIDCHandler(cfg *config.Config, database *db.DB) *OIDCHandler {
+	h := &OIDCHandler{config: cfg, database: database, providers: make(map[string]*oidcProvider)}
+	for _, p := range cfg.OIDCProviders {
+		h.providers[p.Name] = newOIDCProvider(p)
+	}
+	return h
+}
+
+// LoginProvider is a sign-in option shown on the login page.
+type LoginProvider struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	LoginURL    string `json:"login_url"`
+}
+
+// HandleProviders lists the sign-in options, Google first if configured.
+func (h *OIDCHandler) HandleProviders(w http.ResponseWriter, r *http.Request) {
+	providers := []LoginProvider{}
+	if h.config.GoogleClientID != "" {
+		providers = append(providers, LoginProvider{Name: "google", DisplayName: "Google", LoginURL: "/auth/login"})
+	}
+	for _, p := range h.config.OIDCProviders {
+		providers = append(providers, LoginProvider{
+			Name:        p.Name,
+			DisplayName: p.DisplayName,
+			LoginURL:    "/auth/oidc/" + p.Name + "/login",
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(providers)
+}
+
+// HandleLogin redirects to the provider's sign-in page
+func (h *OIDCHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.providers[chi.URLParam(r, "provider")]
+	if !ok {
+		http.Error(w, "Unknown login provider", http.StatusNotFound)
+		return
+	}
+
+	state, err := generateRandomString(32)
+	if err != nil {
+		http.Error(w, "Failed to generate state", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := generateRandomString(32)
+	if err != nil {
+		http.Error(w, "Failed to generate nonce", http.StatusInternalServerError)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	url, err := p.authCodeURL(state, nonce, verifier)
+	if err != nil {
+		log.Printf("auth: %s: %v", p.cfg.Name, err)
+		http.Redirect(w, r, "/login?error=provider_unavailable", http.StatusTemporaryRedirect)
+		return
+	}
+
+	// State, nonce and verifier are URL-safe base64, so "." separates them
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDCStateCookieName,
+		Value:    state + "." + nonce + "." + verifier,
+		Path:     "/auth/oidc/" + p.cfg.Name,
+		MaxAge:   600, // 10 minutes
+		HttpOnly: true,
+		Secure:   h.config.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	setLoginNext(w, r, h.config)
+	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+}
+
+// HandleCallback completes a provider sign-in and starts a session
+func (h *OIDCHandler) HandleCallback(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.providers[chi.URLParam(r, "provider")]
+	if !ok {
+		http.Error(w, "Unknown login provider", http.StatusNotFound)
+		return
+	}
+
+	cookie, err := r.Cookie(OIDCStateCookieName)
+	if err != nil {
+		http.Error(w, "Missing state cookie", http.StatusBadRequest)
+		return
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 || r.URL.Query().Get("state") != parts[0] {
+		http.Error(w, "Invalid state", http.StatusBadRequest)
+		return
+	}
+	nonce, verifier := parts[1], parts[2]
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDCStateCookieName,
+		Value:    "",
+		Path:     "/auth/oidc/" + p.cfg.Name,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   h.config.CookieSecure,
+	})
+
+	if errMsg := r.URL.Query().Get("error"); errMsg != "" {
+		log.Printf("auth: %s: provider returned error: %s", p.cfg.Name, errMsg)
+		http.Redirect(w, r, "/login?error="+errMsg, http.StatusTemporaryRedirect)
+		return
+	}
+
+	user, err := h.signIn(r.Context(), p, r.URL.Query().Get("code"), verifier, nonce)
+	if err != nil {
+		log.Printf("auth: %s: %v", p.cfg.Name, err)
+		code := "user_failed"
+		var le *loginError
+		if errors.As(err, &le) {
+			code = le.code
+		}
+		http.Redirect(w, r, "/login?error="+code, http.StatusTemporaryRedirect)
+		return
+	}
+
+	if err := startSession(w, r, h.config, h.database, user); err != nil {
+		log.Printf("auth: %s: failed to create session: %v", p.cfg.Name, err)
+		http.Redirect(w, r, "/login?error=session_failed", http.StatusTemporaryRedirect)
+		return
+	}
+	http.Redirect(w, r, loginDestination(w, r, h.config), http.StatusTemporaryRedirect)
+}
+
+// signIn exchanges the code, checks the provider's sign-in rules and
+// returns the matching shipit user.
+func (h *OIDCHandler) signIn(ctx context.Context, p *oidcProvider, code, verifier, nonce string) (*db.User, error) {
+	id, err := p.identity(ctx, code, verifier, nonce)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.authorize(id); err != nil {
+		return nil, err
+	}
+	return h.findOrCreateUser(ctx, p, id)
+}
+
+// ProviderGroups qualifies a provider's group names with the provider,
+// e.g. "okta:eng" or "github:acme/sre", as they are stored on users and
+// in project group roles. Otherwise a GitHub org could be named after an
+// Okta group and inherit its roles.
+func ProviderGroups(provider string, groups []string) []string {
+	qualified := make([]string, len(groups))
+	for i, g := range groups {
+		qualified[i] = provider + ":" + g
+	}
+	return qualified
+}
+
+// ValidateProviderGroup checks that a project group role names its
+// provider, as "<provider>:<group>".
+func ValidateProviderGroup(name string) error {
+	provider, group, ok := strings.Cut(name, ":")
+	if !ok || provider == "" || group == "" {
+		return fmt.Errorf("group must be <provider>:<group>, e.g. okta:platform-team or github:acme/sre, with the provider's name from OIDC_PROVIDERS")
+	}
+	return nil
+}
+
+// findOrCreateUser returns the user linked to id, or creates one. An
+// existing user with the same email (e.g. one who signed in with Google) is
+// linked only if the provider may link by email: any provider can assert
+// any address it likes, so otherwise it could take that user's account
+// over. The user's groups are replaced with those from this login.
+func (h *OIDCHandler) findOrCreateUser(ctx context.Context, p *oidcProvider, id *Identity) (*db.User, error) {
+	user, err := h.database.GetUserByIdentity(ctx, id.Provider, id.Subject)
+	if errors.Is(err, sql.ErrNoRows) {
+		user, err = h.database.GetUserByEmail(ctx, id.Email)
+		if errors.Is(err, sql.ErrNoRows) {
+			user, err = h.database.CreateExternalUser(ctx, id.Email, id.Name, id.Picture)
+		} else if err == nil && !p.linksByEmail() {
+			return nil, loginErr("account_exists", "%s: %s already belongs to a user of another provider; set OIDC_<NAME>_LINK_BY_EMAIL to link them", p.cfg.Name, id.Email)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.database.LinkUserIdentity(ctx, id.Provider, id.Subject, user.ID, id.Email); err != nil {
+		return nil, err
+	}
+	_ = h.database.UpdateUserProfile(ctx, user.ID, id.Name, id.Picture)
+
+	groups, err := json.Marshal(ProviderGroups(id.Provider, id.Groups))
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(time.Duration(h.config.GroupRolesMaxAge) * time.Second)
+	if err := h.database.UpdateUserGroups(ctx, user.ID, groups, expiresAt); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
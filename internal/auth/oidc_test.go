@@ -0,0 +1,339 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vigneshsubbiah/shipit/internal/config"
+	"github.com/vigneshsubbiah/shipit/internal/oidctest"
+	"golang.org/x/oauth2"
+)
+
+// authorizeCode follows the provider's sign-in URL as a browser would and
+// returns the code and state from the redirect back.
+func authorizeCode(t *testing.T, authURL string) (code, state string) {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: status %d", resp.StatusCode)
+	}
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc.Query().Get("code"), loc.Query().Get("state")
+}
+
+func mockProvider(mock *oidctest.Server) *oidcProvider {
+	return newOIDCProvider(config.OIDCProvider{
+		Name:         "keycloak",
+		Issuer:       mock.URL(),
+		ClientID:     "shipit",
+		ClientSecret: "secret",
+		RedirectURL:  "http://shipit.test/auth/oidc/keycloak/callback",
+		GroupsClaim:  "realm_access.roles",
+	})
+}
+
+func TestOIDCLogin(t *testing.T) {
+	mock := oidctest.New()
+	defer mock.Close()
+	mock.SetUser(oidctest.Claims{
+		"sub":            "u-123",
+		"email":          "ana@example.com",
+		"email_verified": true,
+		"name":           "Ana",
+		"realm_access":   map[string]any{"roles": []string{"platform", "oncall"}},
+	})
+	p := mockProvider(mock)
+
+	verifier := oauth2.GenerateVerifier()
+	authURL, err := p.authCodeURL("state-1", "nonce-1", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := authorizeCode(t, authURL)
+	if state != "state-1" {
+		t.Errorf("state = %q", state)
+	}
+
+	id, err := p.identity(context.Background(), code, verifier, "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Identity{
+		Provider:      "keycloak",
+		Subject:       "u-123",
+		Email:         "ana@example.com",
+		EmailVerified: true,
+		Name:          "Ana",
+		Groups:        []string{"platform", "oncall"},
+	}
+	if !reflect.DeepEqual(id, want) {
+		t.Errorf("identity = %+v, want %+v", id, want)
+	}
+}
+
+func TestOIDCLoginRejected(t *testing.T) {
+	mock := oidctest.New()
+	defer mock.Close()
+	p := mockProvider(mock)
+
+	tests := []struct {
+		name             string
+		nonce            string
+		exchangeVerifier string
+		wantCode         string
+	}{
+		{"nonce mismatch", "other-nonce", "", "userinfo_failed"},
+		{"wrong PKCE verifier", "nonce-1", oauth2.GenerateVerifier(), "exchange_failed"},
+	}
+	for _, tt := range tests {
+		verifier := oauth2.GenerateVerifier()
+		authURL, err := p.authCodeURL("s", "nonce-1", verifier)
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, _ := authorizeCode(t, authURL)
+		if tt.exchangeVerifier != "" {
+			verifier = tt.exchangeVerifier
+		}
+
+		_, err = p.identity(context.Background(), code, verifier, tt.nonce)
+		var le *loginError
+		if !errors.As(err, &le) || le.code != tt.wantCode {
+			t.Errorf("%s: err = %v, want code %s", tt.name, err, tt.wantCode)
+		}
+	}
+}
+
+func TestOIDCDiscoveryFailure(t *testing.T) {
+	p := newOIDCProvider(config.OIDCProvider{Name: "down", Issuer: "http://127.0.0.1:1"})
+	if _, err := p.authCodeURL("s", "n", "v"); err == nil {
+		t.Error("expected discovery error")
+	}
+}
+
+func TestOIDCAuthorize(t *testing.T) {
+	p := newOIDCProvider(config.OIDCProvider{
+		Name:          "okta",
+		AllowedDomain: "Example.com",
+		AllowedGroups: []string{"engineering"},
+	})
+	tests := []struct {
+		name string
+		id   Identity
+		want string
+	}{
+		{"allowed", Identity{Email: "ana@example.com", EmailVerified: true, Groups: []string{"sales", "engineering"}}, ""},
+		{"unverified email", Identity{Email: "ana@example.com", Groups: []string{"engineering"}}, "email_unverified"},
+		{"other domain", Identity{Email: "ana@evil.com", EmailVerified: true, Groups: []string{"engineering"}}, "unauthorized_domain"},
+		{"not in group", Identity{Email: "ana@example.com", EmailVerified: true, Groups: []string{"sales"}}, "unauthorized_group"},
+	}
+	for _, tt := range tests {
+		err := p.authorize(&tt.id)
+		var got string
+		var le *loginError
+		if errors.As(err, &le) {
+			got = le.code
+		}
+		if got != tt.want {
+			t.Errorf("%s: code = %q, want %q (err %v)", tt.name, got, tt.want, err)
+		}
+	}
+}
+
+func TestOIDCLinksByEmail(t *testing.T) {
+	tests := []struct {
+		cfg  config.OIDCProvider
+		want bool
+	}{
+		{config.OIDCProvider{Name: "github"}, false},
+		{config.OIDCProvider{Name: "github", LinkByEmail: true}, true},
+		{config.OIDCProvider{Name: "okta", AllowedDomain: "example.com"}, true},
+	}
+	for _, tt := range tests {
+		if got := newOIDCProvider(tt.cfg).linksByEmail(); got != tt.want {
+			t.Errorf("linksByEmail(%+v) = %v, want %v", tt.cfg, got, tt.want)
+		}
+	}
+}
+
+func TestClaimStrings(t *testing.T) {
+	var claims map[string]any
+	json.Unmarshal([]byte(`{
+		"groups": ["a", "b", 3],
+		"role": "admin",
+		"resource_access": {"shipit": {"roles": ["deployer"]}}
+	}`), &claims)
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"groups", []string{"a", "b"}},
+		{"role", []string{"admin"}},
+		{"resource_access.shipit.roles", []string{"deployer"}},
+		{"resource_access.other.roles", nil},
+		{"missing", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := claimStrings(claims, tt.path); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("claimStrings(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestGitHubIdentity(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"gho_test","token_type":"bearer"}`))
+	})
+	api := map[string]string{
+		"/api/v3/user":        `{"id": 42, "login": "ana", "avatar_url": "https://avatars.test/ana"}`,
+		"/api/v3/user/emails": `[{"email":"ana@old.com","primary":false,"verified":true},{"email":"ana@example.com","primary":true,"verified":true}]`,
+		"/api/v3/user/orgs":   `[{"login":"acme"}]`,
+		"/api/v3/user/teams":  `[{"slug":"platform","organization":{"login":"acme"}}]`,
+	}
+	for path, body := range api {
+		mux.HandleFunc("GET "+path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer gho_test" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(body))
+		})
+	}
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	p := newOIDCProvider(config.OIDCProvider{Name: "github", Type: "github", Issuer: server.URL, ClientID: "id"})
+	authURL, err := p.authCodeURL("s", "n", "v")
+	if err != nil || !strings.HasPrefix(authURL, server.URL+"/login/oauth/authorize?") {
+		t.Fatalf("authCodeURL = %q, %v", authURL, err)
+	}
+	id, err := p.identity(context.Background(), "code", "v", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Identity{
+		Provider:      "github",
+		Subject:       "42",
+		Email:         "ana@example.com",
+		EmailVerified: true,
+		Name:          "ana",
+		Picture:       "https://avatars.test/ana",
+		Groups:        []string{"acme", "acme/platform"},
+	}
+	if !reflect.DeepEqual(id, want) {
+		t.Errorf("identity = %+v, want %+v", id, want)
+	}
+}
+
+func TestOIDCHandleLogin(t *testing.T) {
+	mock := oidctest.New()
+	defer mock.Close()
+	cfg := &config.Config{OIDCProviders: []config.OIDCProvider{{
+		Name:         "okta",
+		Issuer:       mock.URL(),
+		ClientID:     "shipit",
+		ClientSecret: "secret",
+		RedirectURL:  "http://shipit.test/auth/oidc/okta/callback",
+	}}}
+	r := chi.NewRouter()
+	h := NewOIDCHandler(cfg, nil)
+	r.Get("/auth/oidc/{provider}/login", h.HandleLogin)
+	r.Get("/auth/oidc/{provider}/callback", h.HandleCallback)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/auth/oidc/okta/login", nil))
+	if w.Code != http.StatusTemporaryRedirect || !strings.HasPrefix(w.Header().Get("Location"), mock.URL()+"/authorize?") {
+		t.Fatalf("login: status %d, location %q", w.Code, w.Header().Get("Location"))
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != OIDCStateCookieName || len(strings.Split(cookies[0].Value, ".")) != 3 {
+		t.Fatalf("unexpected cookies %v", cookies)
+	}
+	loc, _ := url.Parse(w.Header().Get("Location"))
+	state := strings.Split(cookies[0].Value, ".")[0]
+	if loc.Query().Get("state") != state || loc.Query().Get("code_challenge_method") != "S256" || loc.Query().Get("nonce") == "" {
+		t.Errorf("authorize URL missing state, nonce or PKCE: %s", loc)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/auth/oidc/nope/login", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown provider: status %d", w.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/auth/oidc/okta/callback?state=forged&code=x", nil)
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("forged state: status %d", w.Code)
+	}
+}
+
+func TestSafeNext(t *testing.T) {
+	tests := []struct {
+		next string
+		want bool
+	}{
+		{"/auth/cli/authorize?state=s", true},
+		{"/apps/123", true},
+		{"", false},
+		{"https://evil.com", false},
+		{"//evil.com", false},
+		{"/\\evil.com", false},
+	}
+	for _, tt := range tests {
+		if got := safeNext(tt.next); got != tt.want {
+			t.Errorf("safeNext(%q) = %v, want %v", tt.next, got, tt.want)
+		}
+	}
+}
+
+func TestProviderGroups(t *testing.T) {
+	got := ProviderGroups("github", []string{"acme", "acme/sre"})
+	if want := []string{"github:acme", "github:acme/sre"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ProviderGroups = %v, want %v", got, want)
+	}
+	if got := ProviderGroups("okta", nil); got == nil || len(got) != 0 {
+		t.Errorf("no groups = %#v, want an empty list", got)
+	}
+
+	// A GitHub org named after an Okta group doesn't become that group.
+	if slices.Contains(ProviderGroups("github", []string{"eng"}), "okta:eng") {
+		t.Error("github group matched okta:eng")
+	}
+
+	for _, name := range []string{"okta:eng", "github:acme/sre"} {
+		if err := ValidateProviderGroup(name); err != nil {
+			t.Errorf("ValidateProviderGroup(%q): %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "eng", "acme/sre", ":eng", "okta:"} {
+		if err := ValidateProviderGroup(name); err == nil {
+			t.Errorf("ValidateProviderGroup(%q): expected error", name)
+		}
+	}
+}
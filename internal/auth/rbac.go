@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/vigneshsubbiah/shipit/internal/db"
+)
+
+// Role is a user's role in a project. Each role includes everything the
+// roles before it allow: viewer < deployer < admin.
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"   // read-only: apps, status, logs, revisions, history
+	RoleDeployer Role = "deployer" // deploy, rollback and change app configuration
+	RoleAdmin    Role = "admin"    // secrets, exec, deletes, cluster and member management
+)
+
+// Roles lists the valid roles from least to most privileged.
+var Roles = []Role{RoleViewer, RoleDeployer, RoleAdmin}
+
+// ValidRole reports whether r is one of Roles.
+func ValidRole(r Role) bool {
+	return r.rank() > 0
+}
+
+func (r Role) rank() int {
+	for i, role := range Roles {
+		if r == role {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Includes reports whether r grants everything required does.
+func (r Role) Includes(required Role) bool {
+	return r.rank() > 0 && r.rank() >= required.rank()
+}
+
+// ProjectResolver returns the project (and app, if any) a request targets,
+// read from its route parameters. It returns sql.ErrNoRows if the targeted
+// resource does not exist.
+type ProjectResolver func(r *http.Request) (Target, error)
+
+const projectRoleContextKey contextKey = "project_role"
+
+type projectAccess struct {
+	target Target
+	role   Role
+}
+
+// ProjectAccess resolves the project a request targets and the caller's role
+// in it, and stores both in the request context for RequireRole. Platform
+// admins are admins of every project; other callers must be members. A
+// scoped token further caps the role to what its scopes grant.
+func ProjectAccess(database *db.DB, resolve ProjectResolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			target, err := resolve(r)
+			if errors.Is(err, sql.ErrNoRows) {
+				writeError(w, "not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("auth: resolving project for %s %s: %v", r.Method, r.URL.Path, err)
+				writeError(w, "failed to check access", http.StatusInternalServerError)
+				return
+			}
+
+			target.Kind = requestKind(r.URL.Path)
+
+			role, err := projectRole(r.Context(), database, target.ProjectID)
+			if err != nil {
+				log.Printf("auth: loading role in project %s: %v", target.ProjectID, err)
+				writeError(w, "failed to check access", http.StatusInternalServerError)
+				return
+			}
+			if role == "" {
+				writeError(w, "you are not a member of this project", http.StatusForbidden)
+				return
+			}
+			if role = capRole(r.Context(), role, target); role == "" {
+				writeError(w, "this token's scopes do not cover this resource", http.StatusForbidden)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), projectRoleContextKey, projectAccess{target: target, role: role})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// projectRole returns the caller's role in projectID, the higher of their
+// membership and any role granted to their groups, or "" if they have none.
+func projectRole(ctx context.Context, database *db.DB, projectID string) (Role, error) {
+	if IsPlatformAdmin(ctx) {
+		return RoleAdmin, nil
+	}
+	user := GetUser(ctx)
+	if user == nil {
+		return "", nil
+	}
+	role, err := database.GetEffectiveProjectRole(ctx, projectID, user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return Role(role), err
+}
+
+// RequireRole rejects requests whose caller lacks the required role in the
+// project resolved by ProjectAccess, which must run first.
+func RequireRole(required Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !GetProjectRole(r.Context()).Includes(required) {
+				writeError(w, "this action requires the "+string(required)+" role in the project", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequirePlatformAdmin rejects requests from anyone but platform admins,
+// for routes that span every project. Scoped tokens also need admin:*.
+func RequirePlatformAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !IsPlatformAdmin(r.Context()) || !ScopeAllows(r.Context(), Target{}, RoleAdmin) {
+			writeError(w, "this action requires a platform admin", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GetProjectRole returns the caller's role in the request's project, as
+// resolved by ProjectAccess, or "" outside a project route.
+func GetProjectRole(ctx context.Context) Role {
+	access, _ := ctx.Value(projectRoleContextKey).(projectAccess)
+	return access.role
+}
+
+// GetProjectID returns the project resolved by ProjectAccess.
+func GetProjectID(ctx context.Context) string {
+	access, _ := ctx.Value(projectRoleContextKey).(projectAccess)
+	return access.target.ProjectID
+}
+
+func writeError(w http.ResponseWriter, message string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRoleIncludes(t *testing.T) {
+	tests := []struct {
+		role, required Role
+		want           bool
+	}{
+		{RoleAdmin, RoleViewer, true},
+		{RoleAdmin, RoleAdmin, true},
+		{RoleDeployer, RoleViewer, true},
+		{RoleDeployer, RoleAdmin, false},
+		{RoleViewer, RoleDeployer, false},
+		{"", RoleViewer, false},
+		{"owner", RoleViewer, false},
+	}
+	for _, tt := range tests {
+		if got := tt.role.Includes(tt.required); got != tt.want {
+			t.Errorf("%q.Includes(%q) = %v, want %v", tt.role, tt.required, got, tt.want)
+		}
+	}
+}
+
+func withAccess(r *http.Request, role Role) *http.Request {
+	ctx := context.WithValue(r.Context(), projectRoleContextKey, projectAccess{target: Target{ProjectID: "p1"}, role: role})
+	return r.WithContext(ctx)
+}
+
+func TestRequireRole(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		role Role
+		want int
+	}{
+		{RoleViewer, http.StatusForbidden},
+		{RoleDeployer, http.StatusOK},
+		{RoleAdmin, http.StatusOK},
+		{"", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		RequireRole(RoleDeployer)(ok).ServeHTTP(w, withAccess(httptest.NewRequest("POST", "/api/apps/a1/deploy", nil), tt.role))
+		if w.Code != tt.want {
+			t.Errorf("role %q: status = %d, want %d", tt.role, w.Code, tt.want)
+		}
+	}
+}
+
+func TestProjectAccessPlatformAdmin(t *testing.T) {
+	var gotRole Role
+	var gotProject string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRole, gotProject = GetProjectRole(r.Context()), GetProjectID(r.Context())
+	})
+	resolve := func(r *http.Request) (Target, error) { return Target{ProjectID: "p1"}, nil }
+
+	req := httptest.NewRequest("GET", "/api/projects/p1", nil)
+	req = req.WithContext(context.WithValue(req.Context(), AdminContextKey, true))
+	w := httptest.NewRecorder()
+	ProjectAccess(nil, resolve)(next).ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || gotRole != RoleAdmin || gotProject != "p1" {
+		t.Errorf("status %d, role %q, project %q", w.Code, gotRole, gotProject)
+	}
+}
+
+func TestProjectAccessDenied(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler should not run")
+	})
+	tests := []struct {
+		name    string
+		resolve ProjectResolver
+		want    int
+	}{
+		{"unknown resource", func(*http.Request) (Target, error) { return Target{}, sql.ErrNoRows }, http.StatusNotFound},
+		{"resolver failure", func(*http.Request) (Target, error) { return Target{}, errors.New("boom") }, http.StatusInternalServerError},
+		{"no user", func(*http.Request) (Target, error) { return Target{ProjectID: "p1"}, nil }, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		ProjectAccess(nil, tt.resolve)(next).ServeHTTP(w, httptest.NewRequest("GET", "/api/clusters/c1", nil))
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestRequirePlatformAdmin(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	RequirePlatformAdmin(ok).ServeHTTP(w, httptest.NewRequest("GET", "/api/audit", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("non-admin: status = %d, want 403", w.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/api/audit", nil)
+	req = req.WithContext(context.WithValue(req.Context(), AdminContextKey, true))
+	w = httptest.NewRecorder()
+	RequirePlatformAdmin(ok).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("admin: status = %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,298 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Scope limits what a user token can do, on top of the user's own project
+// roles. It is written "<verb>:<target>":
+//
+//	read:*              read anything the user can read
+//	deploy:project/<id> deploy (and read) any app in a project
+//	deploy:app/<id>     deploy (and read) one app
+//	read:logs           read logs of any app the user can read
+//	admin:*             everything the user's roles allow
+//
+// Verbs map to roles (read=viewer, deploy=deployer, admin=admin) and
+// include the verbs below them.
+type Scope struct {
+	Role    Role
+	Project string // project/<id>
+	App     string // app/<id>
+	Kind    string // an app sub-resource such as logs or secrets
+}
+
+var scopeVerbs = map[string]Role{
+	"read":   RoleViewer,
+	"deploy": RoleDeployer,
+	"admin":  RoleAdmin,
+}
+
+// ScopeKinds are the app sub-resources a scope can be narrowed to, named
+// after their path under /api/apps/{appID}.
+var ScopeKinds = []string{
+	"logs", "status", "revisions", "deployments", "history", "secrets", "exec",
+	"deploy", "rollback", "canary", "autoscaling", "domain", "strategy",
+	"tracking", "verification", "notifications", "predeploy",
+}
+
+// ParseScope parses one "<verb>:<target>" scope.
+func ParseScope(s string) (Scope, error) {
+	verb, target, ok := strings.Cut(strings.TrimSpace(s), ":")
+	role, known := scopeVerbs[verb]
+	if !ok || !known {
+		return Scope{}, fmt.Errorf("invalid scope %q: want <verb>:<target> with verb read, deploy or admin", s)
+	}
+
+	scope := Scope{Role: role}
+	switch {
+	case target == "*":
+	case strings.HasPrefix(target, "project/") && len(target) > len("project/"):
+		scope.Project = strings.TrimPrefix(target, "project/")
+	case strings.HasPrefix(target, "app/") && len(target) > len("app/"):
+		scope.App = strings.TrimPrefix(target, "app/")
+	case slices.Contains(ScopeKinds, target):
+		scope.Kind = target
+	default:
+		return Scope{}, fmt.Errorf("invalid scope %q: target must be *, project/<id>, app/<id> or one of %s", s, strings.Join(ScopeKinds, ", "))
+	}
+	return scope, nil
+}
+
+// ParseScopes parses a list of scopes, rejecting an empty list.
+func ParseScopes(list []string) ([]Scope, error) {
+	if len(list) == 0 {
+		return nil, fmt.Errorf("at least one scope is required (use admin:* for full access)")
+	}
+	scopes := make([]Scope, 0, len(list))
+	for _, s := range list {
+		scope, err := ParseScope(s)
+		if err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
+
+func (s Scope) String() string {
+	verb := "read"
+	for v, role := range scopeVerbs {
+		if role == s.Role {
+			verb = v
+		}
+	}
+	switch {
+	case s.Project != "":
+		return verb + ":project/" + s.Project
+	case s.App != "":
+		return verb + ":app/" + s.App
+	case s.Kind != "":
+		return verb + ":" + s.Kind
+	}
+	return verb + ":*"
+}
+
+// covers reports whether s grants everything c does: the same or a higher
+// verb on the same or a wider target. appProject is the project of c's
+// app, if it has one.
+func (s Scope) covers(c Scope, appProject string) bool {
+	if !s.Role.Includes(c.Role) {
+		return false
+	}
+	switch {
+	case s.Project != "":
+		return c.Project == s.Project || (c.App != "" && appProject == s.Project)
+	case s.App != "":
+		return c.App == s.App
+	case s.Kind != "":
+		return c.Kind == s.Kind
+	}
+	return true
+}
+
+// ScopesWithin checks that every scope in child is granted by one of
+// parent, so a token can't create a token that can do more than it can.
+// appProject looks up the project of an app scope's app ("" if unknown).
+func ScopesWithin(child, parent []Scope, appProject func(appID string) string) error {
+	for _, c := range child {
+		project := ""
+		if c.App != "" {
+			project = appProject(c.App)
+		}
+		if !slices.ContainsFunc(parent, func(p Scope) bool { return p.covers(c, project) }) {
+			return fmt.Errorf("scope %s is not granted by the token used to create it", c)
+		}
+	}
+	return nil
+}
+
+// Target is the resource a request acts on, as far as scopes are concerned.
+// Routes outside any project use the zero Target, which only "*" scopes
+// match.
+type Target struct {
+	ProjectID string
+	AppID     string
+	Kind      string
+}
+
+func (s Scope) matches(t Target) bool {
+	switch {
+	case s.Project != "":
+		return s.Project == t.ProjectID
+	case s.App != "":
+		return t.AppID != "" && s.App == t.AppID
+	case s.Kind != "":
+		return s.Kind == t.Kind
+	}
+	return true
+}
+
+// ScopedRole returns the highest role any of scopes grants on t, or "" if
+// none of them match.
+func ScopedRole(scopes []Scope, t Target) Role {
+	var best Role
+	for _, s := range scopes {
+		if s.matches(t) && s.Role.rank() > best.rank() {
+			best = s.Role
+		}
+	}
+	return best
+}
+
+// ScopeAllows reports whether the request's token scopes (if any) grant
+// required on t. Sessions and unscoped tokens are not limited.
+func ScopeAllows(ctx context.Context, t Target, required Role) bool {
+	scopes, scoped := GetScopes(ctx)
+	return !scoped || ScopedRole(scopes, t).Includes(required)
+}
+
+// RequireGlobalScope rejects scoped tokens that lack a "*" scope with at
+// least the required verb, for routes outside any project such as token
+// management and project creation.
+func RequireGlobalScope(required Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !ScopeAllows(r.Context(), Target{}, required) {
+				writeError(w, "this token's scopes do not allow this action", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// GetScopes returns the scopes of the token that authenticated the request;
+// scoped is false for sessions, legacy API tokens and unscoped user tokens.
+func GetScopes(ctx context.Context) (scopes []Scope, scoped bool) {
+	scopes, scoped = ctx.Value(ScopesContextKey).([]Scope)
+	return scopes, scoped
+}
+
+// capRole limits role to what the request's token scopes grant on t.
+func capRole(ctx context.Context, role Role, t Target) Role {
+	scopes, scoped := GetScopes(ctx)
+	if !scoped {
+		return role
+	}
+	granted := ScopedRole(scopes, t)
+	if granted.rank() < role.rank() {
+		return granted
+	}
+	return role
+}
+
+// requestKind is the app sub-resource a request path addresses, e.g. "logs"
+// for /api/apps/{id}/logs, or "" for the resource itself.
+func requestKind(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 4 || parts[0] != "api" {
+		return ""
+	}
+	return parts[3]
+}
+
+// IPAllowed reports whether ip falls within any of the CIDRs or addresses
+// in allowlist. An empty allowlist allows every address.
+func IPAllowed(allowlist []string, ip string) bool {
+	if len(allowlist) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, entry := range allowlist {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if allowed := net.ParseIP(entry); allowed != nil && allowed.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowlistWithin checks that every address child allows is also allowed
+// by parent. An empty parent allows everything; an empty child allows
+// everything too, so it is only within an empty parent.
+func AllowlistWithin(child, parent []string) error {
+	if len(parent) == 0 {
+		return nil
+	}
+	if len(child) == 0 {
+		return fmt.Errorf("the token used to create it is limited to %s; allowed_ips must be within that", strings.Join(parent, ", "))
+	}
+	for _, entry := range child {
+		network := allowlistNetwork(entry)
+		if network == nil || !slices.ContainsFunc(parent, func(p string) bool { return networkWithin(network, allowlistNetwork(p)) }) {
+			return fmt.Errorf("allowed_ips entry %s is outside the allowlist of the token used to create it (%s)", entry, strings.Join(parent, ", "))
+		}
+	}
+	return nil
+}
+
+// allowlistNetwork parses an allowlist entry, an address being a network
+// of one.
+func allowlistNetwork(entry string) *net.IPNet {
+	if _, network, err := net.ParseCIDR(entry); err == nil {
+		return network
+	}
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// networkWithin reports whether inner is a subnet of outer.
+func networkWithin(inner, outer *net.IPNet) bool {
+	if outer == nil {
+		return false
+	}
+	innerOnes, innerBits := inner.Mask.Size()
+	outerOnes, outerBits := outer.Mask.Size()
+	return innerBits == outerBits && outerOnes <= innerOnes && outer.Contains(inner.IP)
+}
+
+// ValidateAllowlist checks that every entry is an IP address or CIDR.
+func ValidateAllowlist(allowlist []string) error {
+	for _, entry := range allowlist {
+		if _, _, err := net.ParseCIDR(entry); err == nil {
+			continue
+		}
+		if net.ParseIP(entry) == nil {
+			return fmt.Errorf("invalid IP allowlist entry %q: want an address or CIDR", entry)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseScope(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Scope
+	}{
+		{"read:*", Scope{Role: RoleViewer}},
+		{"deploy:app/a1", Scope{Role: RoleDeployer, App: "a1"}},
+		{"admin:project/p1", Scope{Role: RoleAdmin, Project: "p1"}},
+		{"read:logs", Scope{Role: RoleViewer, Kind: "logs"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseScope(tt.in)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.in, got, tt.want)
+		}
+		if got.String() != tt.in {
+			t.Errorf("%s: String() = %q", tt.in, got.String())
+		}
+	}
+
+	for _, bad := range []string{"", "read", "write:*", "deploy:app/", "read:pods", "admin:cluster/c1"} {
+		if _, err := ParseScope(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+	if _, err := ParseScopes(nil); err == nil {
+		t.Error("expected an empty scope list to be rejected")
+	}
+}
+
+func TestScopedRole(t *testing.T) {
+	scopes, err := ParseScopes([]string{"deploy:app/a1", "read:project/p2", "read:logs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		target Target
+		want   Role
+	}{
+		{"scoped app", Target{ProjectID: "p1", AppID: "a1"}, RoleDeployer},
+		{"scoped app sub-resource", Target{ProjectID: "p1", AppID: "a1", Kind: "secrets"}, RoleDeployer},
+		{"other app, logs", Target{ProjectID: "p1", AppID: "a2", Kind: "logs"}, RoleViewer},
+		{"other app", Target{ProjectID: "p1", AppID: "a2"}, ""},
+		{"scoped project", Target{ProjectID: "p2", AppID: "a3"}, RoleViewer},
+		{"project of the scoped app", Target{ProjectID: "p1"}, ""},
+		{"global route", Target{}, ""},
+	}
+	for _, tt := range tests {
+		if got := ScopedRole(scopes, tt.target); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// A token scoped to deploy one app must not reach admin routes of that app,
+// even for a platform admin.
+func TestProjectAccessCapsRoleToScopes(t *testing.T) {
+	scopes, _ := ParseScopes([]string{"deploy:app/a1"})
+	ctx := context.WithValue(context.Background(), AdminContextKey, true)
+	ctx = context.WithValue(ctx, ScopesContextKey, scopes)
+	resolve := func(r *http.Request) (Target, error) { return Target{ProjectID: "p1", AppID: "a1"}, nil }
+
+	tests := []struct {
+		method, path string
+		required     Role
+		want         int
+	}{
+		{"POST", "/api/apps/a1/deploy", RoleDeployer, http.StatusOK},
+		{"GET", "/api/apps/a1/logs", RoleViewer, http.StatusOK},
+		{"POST", "/api/apps/a1/secrets", RoleAdmin, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		h := ProjectAccess(nil, resolve)(RequireRole(tt.required)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil).WithContext(ctx))
+		if w.Code != tt.want {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.path, w.Code, tt.want)
+		}
+	}
+
+	other := func(r *http.Request) (Target, error) { return Target{ProjectID: "p1", AppID: "a2"}, nil }
+	w := httptest.NewRecorder()
+	ProjectAccess(nil, other)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(w, httptest.NewRequest("GET", "/api/apps/a2", nil).WithContext(ctx))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("other app: status = %d, want 403", w.Code)
+	}
+}
+
+func TestIPAllowed(t *testing.T) {
+	allowlist := []string{"10.0.0.0/8", "203.0.113.7"}
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"203.0.113.7", true},
+		{"203.0.113.8", false},
+		{"not-an-ip", false},
+	}
+	for _, tt := range tests {
+		if got := IPAllowed(allowlist, tt.ip); got != tt.want {
+			t.Errorf("IPAllowed(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+	if !IPAllowed(nil, "198.51.100.1") {
+		t.Error("an empty allowlist should allow any address")
+	}
+	if err := ValidateAllowlist([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("expected invalid CIDR to be rejected")
+	}
+}
+
+func TestScopesWithin(t *testing.T) {
+	parse := func(list ...string) []Scope {
+		scopes, err := ParseScopes(list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return scopes
+	}
+	appProject := func(appID string) string {
+		if appID == "a1" {
+			return "p1"
+		}
+		return "p2"
+	}
+	tests := []struct {
+		parent, child []string
+		ok            bool
+	}{
+		{[]string{"admin:*"}, []string{"deploy:app/a9", "read:logs"}, true},
+		{[]string{"admin:project/p1"}, []string{"deploy:project/p1"}, true},
+		{[]string{"admin:project/p1"}, []string{"deploy:app/a1"}, true},
+		{[]string{"admin:project/p1"}, []string{"deploy:app/a2"}, false},
+		{[]string{"admin:project/p1"}, []string{"admin:*"}, false},
+		{[]string{"deploy:app/a1"}, []string{"admin:app/a1"}, false},
+		{[]string{"read:logs", "deploy:app/a1"}, []string{"read:logs", "read:app/a1"}, true},
+		{[]string{"read:logs"}, []string{"read:secrets"}, false},
+	}
+	for _, tt := range tests {
+		err := ScopesWithin(parse(tt.child...), parse(tt.parent...), appProject)
+		if (err == nil) != tt.ok {
+			t.Errorf("child %v of %v: err = %v, want ok=%v", tt.child, tt.parent, err, tt.ok)
+		}
+	}
+}
+
+func TestAllowlistWithin(t *testing.T) {
+	parent := []string{"10.0.0.0/8", "203.0.113.7"}
+	tests := []struct {
+		child []string
+		ok    bool
+	}{
+		{[]string{"10.1.0.0/16", "10.2.3.4", "203.0.113.7"}, true},
+		{[]string{"203.0.113.7/32"}, true},
+		{[]string{"0.0.0.0/0"}, false},
+		{[]string{"10.0.0.0/7"}, false},
+		{[]string{"203.0.113.0/24"}, false},
+		{[]string{"198.51.100.1"}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if err := AllowlistWithin(tt.child, parent); (err == nil) != tt.ok {
+			t.Errorf("AllowlistWithin(%v): err = %v, want ok=%v", tt.child, err, tt.ok)
+		}
+	}
+	if err := AllowlistWithin(nil, nil); err != nil {
+		t.Errorf("a token without an allowlist can create one without: %v", err)
+	}
+}
@@ -14,9 +14,10 @@ type APIToken struct {
 }
 
 type Project struct {
-	ID        string    `db:"id" json:"id"`
-	Name      string    `db:"name" json:"name"`
-	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	ID               string    `db:"id" json:"id"`
+	Name             string    `db:"name" json:"name"`
+	LogRetentionDays int       `db:"log_retention_days" json:"log_retention_days"` // archived logs are kept this long; 0 doesn't archive them
+	CreatedAt        time.Time `db:"created_at" json:"created_at"`
 }
 
 type Cluster struct {
@@ -79,6 +80,43 @@ type App struct {
 	ManagedBy    string  `db:"managed_by" json:"managed_by"`                     // "shipit", "porter", or "observer"
 	PorterAppID  *string `db:"porter_app_id" json:"porter_app_id,omitempty"`     // Porter's internal app ID
 	PorterAppURL *string `db:"porter_app_url" json:"porter_app_url,omitempty"`   // Porter dashboard URL
+
+	// Deploy strategy ("rolling", "canary" or "blue_green"), canary schedule
+	// and blue/green rollback window
+	DeployStrategy     string `db:"deploy_strategy" json:"deploy_strategy"`
+	CanaryStepPercent  int    `db:"canary_step_percent" json:"canary_step_percent"`
+	CanaryStepInterval int    `db:"canary_step_interval" json:"canary_step_interval"` // seconds
+	BlueGreenRetention int    `db:"blue_green_retention" json:"blue_green_retention"` // seconds
+
+	// Live canary state: traffic weight while a canary is in progress, and a
+	// pending operator promote/abort
+	CanaryWeight *int    `db:"canary_weight" json:"canary_weight,omitempty"`
+	CanaryAction *string `db:"canary_action" json:"canary_action,omitempty"`
+
+	// Live blue/green state: the color the Service selects, and the revision
+	// the idle color still runs until it is scaled down
+	ActiveColor     *string    `db:"active_color" json:"active_color,omitempty"`
+	IdleRevision    *int       `db:"idle_revision" json:"idle_revision,omitempty"`
+	IdleScaleDownAt *time.Time `db:"idle_scale_down_at" json:"idle_scale_down_at,omitempty"`
+
+	// Post-rollout HTTP verification: consecutive all-2xx rounds required
+	// (0 disables), extra paths checked besides health_path, and the time
+	// allowed to get there
+	VerifySuccesses int             `db:"verify_successes" json:"verify_successes"`
+	VerifyPaths     json.RawMessage `db:"verify_paths" json:"verify_paths"`
+	VerifyTimeout   int             `db:"verify_timeout" json:"verify_timeout"` // seconds
+
+	// Branch tracking: pushes to tracked_branch of repo_url (normalized
+	// github.com/<owner>/<repo>) deploy the image tagged from
+	// image_tag_template
+	RepoURL          *string    `db:"repo_url" json:"repo_url,omitempty"`
+	TrackedBranch    *string    `db:"tracked_branch" json:"tracked_branch,omitempty"`
+	ImageTagTemplate string     `db:"image_tag_template" json:"image_tag_template"`
+	LastDeployedSHA  *string    `db:"last_deployed_sha" json:"last_deployed_sha,omitempty"`
+	LastDeployedAt   *time.Time `db:"last_deployed_at" json:"last_deployed_at,omitempty"`
+
+	// Last revision number handed out; see AllocateRevisionNumber.
+	RevisionCounter int `db:"revision_counter" json:"-"`
 }
 
 // AppRevision stores a snapshot of app configuration at deploy time
@@ -119,28 +157,56 @@ type AppRevision struct {
 	AppGroup    *string `db:"app_group" json:"app_group,omitempty"`
 	ManagedBy   *string `db:"managed_by" json:"managed_by,omitempty"`
 
+	// Secret snapshot: key -> keyed fingerprint of the value at deploy time.
+	// Never serialized directly; handlers expose the key names only.
+	SecretKeys json.RawMessage `db:"secret_keys" json:"-"`
+
 	// Deployment status
 	DeployStatus  string     `db:"deploy_status" json:"deploy_status"`
 	DeployMessage *string    `db:"deploy_message" json:"deploy_message,omitempty"`
 	DeployedAt    *time.Time `db:"deployed_at" json:"deployed_at,omitempty"`
+
+	// Deploy source, set when a CI system or push webhook deployed a commit
+	CommitSHA *string `db:"commit_sha" json:"commit_sha,omitempty"`
+	CommitRef *string `db:"commit_ref" json:"commit_ref,omitempty"`
+	CIURL     *string `db:"ci_url" json:"ci_url,omitempty"`
+
+	// Manifest digest Image resolved to at deploy time; the revision was
+	// deployed (and rolls back) as Image pinned to it.
+	ImageDigest *string `db:"image_digest" json:"image_digest,omitempty"`
 }
 
 type AppSecret struct {
 	ID             string    `db:"id" json:"id"`
 	AppID          string    `db:"app_id" json:"app_id"`
 	Key            string    `db:"key" json:"key"`
-	ValueEncrypted []byte    `db:"value_encrypted" json:"-"`
+	ValueEncrypted []byte    `db:"value_encrypted" json:"-"` // nil for references
+	Source         string    `db:"source" json:"source"`     // "value", or the reference scheme ("vault", "awssm")
+	Ref            *string   `db:"ref" json:"ref,omitempty"` // e.g. vault://kv/payments/stripe#api_key
 	CreatedAt      time.Time `db:"created_at" json:"created_at"`
 	UpdatedAt      time.Time `db:"updated_at" json:"updated_at"`
 }
 
 // User represents an authenticated user (via Google SSO)
 type User struct {
-	ID          string     `db:"id" json:"id"`
-	Email       string     `db:"email" json:"email"`
-	Name        *string    `db:"name" json:"name,omitempty"`
-	PictureURL  *string    `db:"picture_url" json:"picture_url,omitempty"`
-	GoogleID    *string    `db:"google_id" json:"-"`
+	ID          string          `db:"id" json:"id"`
+	Email       string          `db:"email" json:"email"`
+	Name        *string         `db:"name" json:"name,omitempty"`
+	PictureURL  *string         `db:"picture_url" json:"picture_url,omitempty"`
+	GoogleID    *string         `db:"google_id" json:"-"`
+	CreatedAt   time.Time       `db:"created_at" json:"created_at"`
+	LastLoginAt *time.Time      `db:"last_login_at" json:"last_login_at,omitempty"`
+	Groups      json.RawMessage `db:"groups" json:"groups,omitempty"` // from the last OIDC login
+
+	GroupsExpireAt *time.Time `db:"groups_expire_at" json:"-"` // group roles lapse after this
+}
+
+// UserIdentity links a user to their account at an OIDC provider
+type UserIdentity struct {
+	Provider    string     `db:"provider" json:"provider"`
+	Subject     string     `db:"subject" json:"subject"`
+	UserID      string     `db:"user_id" json:"user_id"`
+	Email       *string    `db:"email" json:"email,omitempty"`
 	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
 	LastLoginAt *time.Time `db:"last_login_at" json:"last_login_at,omitempty"`
 }
@@ -158,11 +224,147 @@ type Session struct {
 
 // UserToken represents a user-generated API token (for CLI)
 type UserToken struct {
-	ID         string     `db:"id" json:"id"`
-	UserID     string     `db:"user_id" json:"user_id"`
-	Name       string     `db:"name" json:"name"`
-	TokenHash  string     `db:"token_hash" json:"-"`
-	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
-	LastUsedAt *time.Time `db:"last_used_at" json:"last_used_at,omitempty"`
-	ExpiresAt  *time.Time `db:"expires_at" json:"expires_at,omitempty"`
+	ID         string          `db:"id" json:"id"`
+	UserID     string          `db:"user_id" json:"user_id"`
+	Name       string          `db:"name" json:"name"`
+	TokenHash  string          `db:"token_hash" json:"-"`
+	CreatedAt  time.Time       `db:"created_at" json:"created_at"`
+	LastUsedAt *time.Time      `db:"last_used_at" json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time      `db:"expires_at" json:"expires_at,omitempty"`
+	Scopes     json.RawMessage `db:"scopes" json:"scopes,omitempty"`           // ["deploy:app/<id>", ...]; null = unscoped (pre-scoping tokens)
+	AllowedIPs json.RawMessage `db:"allowed_ips" json:"allowed_ips,omitempty"` // addresses or CIDRs; null = any
+	LastUsedIP *string         `db:"last_used_ip" json:"last_used_ip,omitempty"`
+}
+
+// CLILogin is a browser sign-in for the CLI (shipit login) in progress.
+// UserID is set once the user has approved it in the browser.
+type CLILogin struct {
+	ID            string    `db:"id" json:"id"`
+	CodeHash      string    `db:"code_hash" json:"-"`
+	UserCode      *string   `db:"user_code" json:"user_code,omitempty"`
+	CodeChallenge *string   `db:"code_challenge" json:"-"`
+	UserID        *string   `db:"user_id" json:"user_id,omitempty"`
+	ExpiresAt     time.Time `db:"expires_at" json:"expires_at"`
+	CreatedAt     time.Time `db:"created_at" json:"created_at"`
+}
+
+// DeployJob is one entry in the durable deploy queue. Rows are claimed by
+// the worker pool with FOR UPDATE SKIP LOCKED so deploys survive a server
+// restart and can be picked up by any replica.
+type DeployJob struct {
+	ID             string     `db:"id" json:"id"`
+	AppID          string     `db:"app_id" json:"app_id"`
+	Kind           string     `db:"kind" json:"kind"`     // deploy, rollback
+	Status         string     `db:"status" json:"status"` // queued, running, succeeded, failed, rolled_back, superseded
+	TargetRevision *int       `db:"target_revision" json:"target_revision,omitempty"`
+	RevisionNumber *int       `db:"revision_number" json:"revision_number,omitempty"`
+	Error          *string    `db:"error" json:"error,omitempty"`
+	SupersededBy   *string    `db:"superseded_by" json:"superseded_by,omitempty"`
+	RequestedBy    *string    `db:"requested_by" json:"requested_by,omitempty"`
+	WorkerID       *string    `db:"worker_id" json:"worker_id,omitempty"`
+	Attempts       int        `db:"attempts" json:"attempts"`
+	CreatedAt      time.Time  `db:"created_at" json:"created_at"`
+	StartedAt      *time.Time `db:"started_at" json:"started_at,omitempty"`
+	HeartbeatAt    *time.Time `db:"heartbeat_at" json:"heartbeat_at,omitempty"`
+	FinishedAt     *time.Time `db:"finished_at" json:"finished_at,omitempty"`
+
+	// Deploy source for CI and push deploys; copied onto the revision
+	Image     *string `db:"image" json:"image,omitempty"`
+	CommitSHA *string `db:"commit_sha" json:"commit_sha,omitempty"`
+	CommitRef *string `db:"commit_ref" json:"commit_ref,omitempty"`
+	CIURL     *string `db:"ci_url" json:"ci_url,omitempty"`
+
+	// RunAfter delays a debounced job (secret changes with apply=true)
+	RunAfter *time.Time `db:"run_after" json:"run_after,omitempty"`
+}
+
+// DeployEvent is one entry in a revision's deploy timeline: a phase
+// starting or ending, or (status ready) one of the new pods becoming ready.
+type DeployEvent struct {
+	ID             string    `db:"id" json:"id"`
+	AppID          string    `db:"app_id" json:"app_id"`
+	RevisionNumber int       `db:"revision_number" json:"revision_number"`
+	Phase          string    `db:"phase" json:"phase"`   // revision, secret_sync, predeploy, canary, apply, verifying, rollback
+	Status         string    `db:"status" json:"status"` // started, succeeded, failed, ready
+	Message        *string   `db:"message" json:"message,omitempty"`
+	Pod            *string   `db:"pod" json:"pod,omitempty"`
+	OccurredAt     time.Time `db:"occurred_at" json:"occurred_at"`
+}
+
+// NotificationSettings configures where deploy events are sent, for a whole
+// project (ProjectID set) or as an override for one app (AppID set). NULL
+// fields on an app row inherit the project's value.
+type NotificationSettings struct {
+	ID                     string          `db:"id" json:"id"`
+	ProjectID              *string         `db:"project_id" json:"project_id,omitempty"`
+	AppID                  *string         `db:"app_id" json:"app_id,omitempty"`
+	SlackWebhookEncrypted  []byte          `db:"slack_webhook_encrypted" json:"-"`
+	WebhookURL             *string         `db:"webhook_url" json:"webhook_url,omitempty"`
+	WebhookSecretEncrypted []byte          `db:"webhook_secret_encrypted" json:"-"`
+	Events                 json.RawMessage `db:"events" json:"events,omitempty"`
+	CreatedAt              time.Time       `db:"created_at" json:"created_at"`
+	UpdatedAt              time.Time       `db:"updated_at" json:"updated_at"`
+}
+
+// AuditLog records one mutating API call: who made it, from where, what it
+// did and, for app changes, a redacted before/after diff.
+type AuditLog struct {
+	ID         string          `db:"id" json:"id"`
+	CreatedAt  time.Time       `db:"created_at" json:"created_at"`
+	ActorType  string          `db:"actor_type" json:"actor_type"` // user, api_token, webhook
+	ActorID    *string         `db:"actor_id" json:"actor_id,omitempty"`
+	Actor      string          `db:"actor" json:"actor"`
+	IPAddress  *string         `db:"ip_address" json:"ip_address,omitempty"`
+	RequestID  *string         `db:"request_id" json:"request_id,omitempty"`
+	Method     string          `db:"method" json:"method"`
+	Path       string          `db:"path" json:"path"`
+	Action     string          `db:"action" json:"action"`
+	AppID      *string         `db:"app_id" json:"app_id,omitempty"`
+	StatusCode int             `db:"status_code" json:"status_code"`
+	Changes    json.RawMessage `db:"changes" json:"changes,omitempty"`
+	Details    json.RawMessage `db:"details" json:"details,omitempty"`
+}
+
+// ProjectMember grants a user a role (viewer, deployer or admin) in a
+// project. Email and Name are joined from users.
+type ProjectMember struct {
+	ProjectID string    `db:"project_id" json:"project_id"`
+	UserID    string    `db:"user_id" json:"user_id"`
+	Role      string    `db:"role" json:"role"`
+	AddedBy   *string   `db:"added_by" json:"added_by,omitempty"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+	Email     string    `db:"email" json:"email"`
+	Name      *string   `db:"name" json:"name,omitempty"`
+}
+
+// ProjectGroupRole grants everyone in an OIDC group a role in a project.
+type ProjectGroupRole struct {
+	ProjectID string    `db:"project_id" json:"project_id"`
+	GroupName string    `db:"group_name" json:"group"`
+	Role      string    `db:"role" json:"role"`
+	AddedBy   *string   `db:"added_by" json:"added_by,omitempty"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// ProjectWithRole is a project together with the caller's role in it.
+type ProjectWithRole struct {
+	Project
+	Role string `db:"role" json:"role"`
+}
+
+// LogChunk indexes one archived chunk of a pod's log lines, stored under
+// ObjectKey in the log archive.
+type LogChunk struct {
+	ID        string    `db:"id" json:"id"`
+	AppID     string    `db:"app_id" json:"app_id"`
+	Pod       string    `db:"pod" json:"pod"`
+	Revision  *int      `db:"revision" json:"revision,omitempty"`
+	FirstAt   time.Time `db:"first_at" json:"first_at"`
+	LastAt    time.Time `db:"last_at" json:"last_at"`
+	Lines     int       `db:"lines" json:"lines"`
+	Bytes     int       `db:"bytes" json:"bytes"`
+	ObjectKey string    `db:"object_key" json:"object_key"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
 }
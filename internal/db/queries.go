@@ -3,8 +3,16 @@ package db
 import (
 	"context"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 // Token operations
@@ -37,6 +45,14 @@ func (db *DB) CreateToken(ctx context.Context, name, token string) (*APIToken, e
 	return &t, err
 }
 
+// CountAPITokens counts legacy API tokens, for the startup warning about
+// them.
+func (db *DB) CountAPITokens(ctx context.Context) (int, error) {
+	var n int
+	err := db.GetContext(ctx, &n, `SELECT COUNT(*) FROM api_tokens`)
+	return n, err
+}
+
 func hashToken(token string) string {
 	h := sha256.Sum256([]byte(token))
 	return hex.EncodeToString(h[:])
@@ -76,6 +92,17 @@ func (db *DB) DeleteProject(ctx context.Context, id string) error {
 	return err
 }
 
+// SetProjectLogRetention sets how many days the project's archived logs
+// are kept.
+func (db *DB) SetProjectLogRetention(ctx context.Context, id string, days int) (*Project, error) {
+	var p Project
+	err := db.GetContext(ctx, &p, `
+		UPDATE projects SET log_retention_days = $2 WHERE id = $1
+		RETURNING *
+	`, id, days)
+	return &p, err
+}
+
 // Cluster operations
 
 func (db *DB) ListClusters(ctx context.Context, projectID string) ([]Cluster, error) {
@@ -214,6 +241,31 @@ func (db *DB) UpdateAppStatus(ctx context.Context, id, status string, message *s
 	return err
 }
 
+// CompareAndSetAppStatus moves an app from one status to another only if it
+// is still in the expected status. Returns false if the row had already
+// moved on (another replica's reconciler, or a deploy worker that claimed a
+// new job in the meantime), in which case the caller must not act.
+func (db *DB) CompareAndSetAppStatus(ctx context.Context, id, from, to string, message *string) (bool, error) {
+	result, err := db.ExecContext(ctx, `
+		UPDATE apps SET status = $1, status_message = $2, updated_at = NOW()
+		WHERE id = $3 AND status = $4
+	`, to, message, id, from)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// ListAppsByStatus returns every app currently in one of the given statuses.
+func (db *DB) ListAppsByStatus(ctx context.Context, statuses []string) ([]App, error) {
+	var apps []App
+	err := db.SelectContext(ctx, &apps, `
+		SELECT * FROM apps WHERE status = ANY($1) ORDER BY updated_at
+	`, pq.Array(statuses))
+	return apps, err
+}
+
 // UpdateAppHPAParams contains HPA configuration for an app
 type UpdateAppHPAParams struct {
 	ID           string
@@ -279,12 +331,151 @@ func (db *DB) UpdateAppPreDeployCommand(ctx context.Context, id string, command
 	return &a, err
 }
 
+// UpdateAppDeployStrategyParams contains deploy strategy configuration for an app
+type UpdateAppDeployStrategyParams struct {
+	ID                 string
+	DeployStrategy     string
+	CanaryStepPercent  int
+	CanaryStepInterval int
+	BlueGreenRetention int
+}
+
+func (db *DB) UpdateAppDeployStrategy(ctx context.Context, p UpdateAppDeployStrategyParams) (*App, error) {
+	var a App
+	err := db.GetContext(ctx, &a, `
+		UPDATE apps SET
+			deploy_strategy = $1,
+			canary_step_percent = $2,
+			canary_step_interval = $3,
+			blue_green_retention = $4,
+			updated_at = NOW()
+		WHERE id = $5 RETURNING *
+	`, p.DeployStrategy, p.CanaryStepPercent, p.CanaryStepInterval, p.BlueGreenRetention, p.ID)
+	return &a, err
+}
+
+// UpdateAppVerificationParams contains post-rollout HTTP verification
+// settings for an app
+type UpdateAppVerificationParams struct {
+	ID              string
+	VerifySuccesses int
+	VerifyPaths     json.RawMessage
+	VerifyTimeout   int
+}
+
+func (db *DB) UpdateAppVerification(ctx context.Context, p UpdateAppVerificationParams) (*App, error) {
+	var a App
+	err := db.GetContext(ctx, &a, `
+		UPDATE apps SET
+			verify_successes = $1,
+			verify_paths = $2,
+			verify_timeout = $3,
+			updated_at = NOW()
+		WHERE id = $4 RETURNING *
+	`, p.VerifySuccesses, p.VerifyPaths, p.VerifyTimeout, p.ID)
+	return &a, err
+}
+
+// UpdateAppTrackingParams contains GitHub branch tracking configuration for
+// an app. A nil RepoURL stops tracking.
+type UpdateAppTrackingParams struct {
+	ID               string
+	RepoURL          *string
+	TrackedBranch    *string
+	ImageTagTemplate string
+}
+
+func (db *DB) UpdateAppTracking(ctx context.Context, p UpdateAppTrackingParams) (*App, error) {
+	var a App
+	err := db.GetContext(ctx, &a, `
+		UPDATE apps SET
+			repo_url = $1,
+			tracked_branch = $2,
+			image_tag_template = $3,
+			updated_at = NOW()
+		WHERE id = $4 RETURNING *
+	`, p.RepoURL, p.TrackedBranch, p.ImageTagTemplate, p.ID)
+	return &a, err
+}
+
+// ListAppsTrackingBranch returns the apps deployed from pushes to branch of
+// repoURL (normalized, see UpdateAppTracking).
+func (db *DB) ListAppsTrackingBranch(ctx context.Context, repoURL, branch string) ([]App, error) {
+	var apps []App
+	err := db.SelectContext(ctx, &apps, `
+		SELECT * FROM apps WHERE repo_url = $1 AND tracked_branch = $2 ORDER BY name
+	`, repoURL, branch)
+	return apps, err
+}
+
+// SetCanaryWeight records the traffic weight of an in-progress canary (nil
+// once it has been promoted or torn down) and clears any pending action.
+func (db *DB) SetCanaryWeight(ctx context.Context, id string, weight *int) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE apps SET canary_weight = $1, canary_action = NULL, updated_at = NOW() WHERE id = $2
+	`, weight, id)
+	return err
+}
+
+// RequestCanaryAction records an operator's promote/abort for the deploy
+// worker stepping the canary. Returns false if the app has no canary in
+// progress.
+func (db *DB) RequestCanaryAction(ctx context.Context, id, action string) (bool, error) {
+	result, err := db.ExecContext(ctx, `
+		UPDATE apps SET canary_action = $1, updated_at = NOW()
+		WHERE id = $2 AND status = 'canary'
+	`, action, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// SetActiveColor records which blue/green color the Service selects, and
+// the revision the other color is left running until idleScaleDownAt (both
+// nil when there is no idle color to roll back to). A nil color clears the
+// blue/green state, e.g. after the app moves back to rolling updates.
+func (db *DB) SetActiveColor(ctx context.Context, id string, color *string, idleRevision *int, idleScaleDownAt *time.Time) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE apps SET active_color = $1, idle_revision = $2, idle_scale_down_at = $3, updated_at = NOW()
+		WHERE id = $4
+	`, color, idleRevision, idleScaleDownAt, id)
+	return err
+}
+
+// ListAppsWithExpiredIdleColor returns blue/green apps whose idle color has
+// outlived its rollback window.
+func (db *DB) ListAppsWithExpiredIdleColor(ctx context.Context) ([]App, error) {
+	var apps []App
+	err := db.SelectContext(ctx, &apps, `
+		SELECT * FROM apps WHERE idle_scale_down_at IS NOT NULL AND idle_scale_down_at <= NOW()
+	`)
+	return apps, err
+}
+
+// ClearExpiredIdleColor forgets an app's idle color if its window is still
+// the expired one the caller saw. Returns false if a deploy or rollback
+// replaced it in the meantime, in which case the caller must not scale it
+// down.
+func (db *DB) ClearExpiredIdleColor(ctx context.Context, id string) (bool, error) {
+	result, err := db.ExecContext(ctx, `
+		UPDATE apps SET idle_revision = NULL, idle_scale_down_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND idle_scale_down_at IS NOT NULL AND idle_scale_down_at <= NOW()
+	`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
 // Secret operations
 
 func (db *DB) ListSecrets(ctx context.Context, appID string) ([]AppSecret, error) {
 	var secrets []AppSecret
 	err := db.SelectContext(ctx, &secrets, `
-		SELECT id, app_id, key, created_at, updated_at
+		SELECT id, app_id, key, source, ref, created_at, updated_at
 		FROM app_secrets WHERE app_id = $1 ORDER BY key
 	`, appID)
 	return secrets, err
@@ -313,17 +504,95 @@ func (db *DB) SetSecret(ctx context.Context, appID, key string, valueEncrypted [
 		VALUES ($1, $2, $3)
 		ON CONFLICT (app_id, key) DO UPDATE SET
 			value_encrypted = EXCLUDED.value_encrypted,
+			source = 'value',
+			ref = NULL,
 			updated_at = NOW()
-		RETURNING id, app_id, key, created_at, updated_at
+		RETURNING id, app_id, key, source, ref, created_at, updated_at
 	`, appID, key, valueEncrypted)
 	return &s, err
 }
 
+// SetSecretRef makes a secret a reference to an external secret, resolved
+// at deploy time. source is the reference's scheme.
+func (db *DB) SetSecretRef(ctx context.Context, appID, key, source, ref string) (*AppSecret, error) {
+	var s AppSecret
+	err := db.GetContext(ctx, &s, `
+		INSERT INTO app_secrets (app_id, key, source, ref)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (app_id, key) DO UPDATE SET
+			value_encrypted = NULL,
+			source = EXCLUDED.source,
+			ref = EXCLUDED.ref,
+			updated_at = NOW()
+		RETURNING id, app_id, key, source, ref, created_at, updated_at
+	`, appID, key, source, ref)
+	return &s, err
+}
+
 func (db *DB) DeleteSecret(ctx context.Context, appID, key string) error {
 	_, err := db.ExecContext(ctx, `DELETE FROM app_secrets WHERE app_id = $1 AND key = $2`, appID, key)
 	return err
 }
 
+// BulkSecretParams is a set of secret changes applied together.
+type BulkSecretParams struct {
+	AppID  string
+	Values map[string][]byte // key -> encrypted value
+	Refs   map[string]SecretRefValue
+	Delete []string
+}
+
+// SecretRefValue is a reference as stored by SetSecretRef.
+type SecretRefValue struct {
+	Source string
+	Ref    string
+}
+
+// ApplySecrets writes a bulk import in one transaction: either every
+// change is applied or none are.
+func (db *DB) ApplySecrets(ctx context.Context, p BulkSecretParams) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for key, encrypted := range p.Values {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO app_secrets (app_id, key, value_encrypted)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (app_id, key) DO UPDATE SET
+				value_encrypted = EXCLUDED.value_encrypted,
+				source = 'value',
+				ref = NULL,
+				updated_at = NOW()
+		`, p.AppID, key, encrypted); err != nil {
+			return err
+		}
+	}
+	for key, ref := range p.Refs {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO app_secrets (app_id, key, source, ref)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (app_id, key) DO UPDATE SET
+				value_encrypted = NULL,
+				source = EXCLUDED.source,
+				ref = EXCLUDED.ref,
+				updated_at = NOW()
+		`, p.AppID, key, ref.Source, ref.Ref); err != nil {
+			return err
+		}
+	}
+	if len(p.Delete) > 0 {
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM app_secrets WHERE app_id = $1 AND key = ANY($2)
+		`, p.AppID, pq.Array(p.Delete)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 // Revision operations
 
 // CreateRevisionParams contains parameters for creating a revision snapshot
@@ -352,21 +621,35 @@ type CreateRevisionParams struct {
 	Domain *string
 	// Pre-deploy hook
 	PreDeployCommand *string
-}
-
+	// Secret snapshot (JSON object: key -> value fingerprint)
+	SecretKeys []byte
+	// Deploy source
+	CommitSHA *string
+	CommitRef *string
+	CIURL     *string
+	// Digest the image tag resolved to
+	ImageDigest *string
+}
+
+// CreateRevision inserts the snapshot with deploy_status='deploying'. The
+// column default is 'success' (migration 007 predates in-flight tracking);
+// writing it explicitly keeps an interrupted deploy distinguishable from a
+// finished one, which the deploy reconciler relies on.
 func (db *DB) CreateRevision(ctx context.Context, p CreateRevisionParams) (*AppRevision, error) {
 	var r AppRevision
 	err := db.GetContext(ctx, &r, `
 		INSERT INTO app_revisions (app_id, revision_number, image, replicas, port, env_vars,
 			cpu_request, cpu_limit, memory_request, memory_limit,
 			health_path, health_port, health_initial_delay, health_period,
-			hpa_enabled, min_replicas, max_replicas, cpu_target, memory_target, domain, pre_deploy_command)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)
+			hpa_enabled, min_replicas, max_replicas, cpu_target, memory_target, domain, pre_deploy_command,
+			secret_keys, commit_sha, commit_ref, ci_url, image_digest, deploy_status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, 'deploying')
 		RETURNING *
 	`, p.AppID, p.RevisionNumber, p.Image, p.Replicas, p.Port, p.EnvVars,
 		p.CPURequest, p.CPULimit, p.MemRequest, p.MemLimit,
 		p.HealthPath, p.HealthPort, p.HealthDelay, p.HealthPeriod,
-		p.HPAEnabled, p.MinReplicas, p.MaxReplicas, p.CPUTarget, p.MemoryTarget, p.Domain, p.PreDeployCommand)
+		p.HPAEnabled, p.MinReplicas, p.MaxReplicas, p.CPUTarget, p.MemoryTarget, p.Domain, p.PreDeployCommand,
+		p.SecretKeys, p.CommitSHA, p.CommitRef, p.CIURL, p.ImageDigest)
 	return &r, err
 }
 
@@ -403,18 +686,28 @@ func (db *DB) GetLatestRevision(ctx context.Context, appID string) (*AppRevision
 	return &r, err
 }
 
-// GetNextRevisionNumber returns MAX(revision_number)+1 for the given app, or
-// 1 if the app has no revisions yet. Used instead of app.CurrentRevision+1
-// because CurrentRevision tracks the last SUCCESSFUL deploy — after an
-// auto-rollback, CurrentRevision regresses to the prior success, so adding
-// one would collide with the rolled_back revision that still exists under
-// the UNIQUE(app_id, revision_number) constraint.
-func (db *DB) GetNextRevisionNumber(ctx context.Context, appID string) (int, error) {
+// allocateRevisionSQL bumps the app's revision counter and returns the new
+// value. The counter never goes below MAX(revision_number), so it is
+// correct even for revisions written before the counter existed.
+const allocateRevisionSQL = `
+	UPDATE apps SET revision_counter = GREATEST(revision_counter,
+		(SELECT COALESCE(MAX(revision_number), 0) FROM app_revisions WHERE app_id = $1)) + 1
+	WHERE id = $1
+	RETURNING revision_counter
+`
+
+// AllocateRevisionNumber hands out the app's next revision number. Used
+// instead of app.CurrentRevision+1 because CurrentRevision tracks the last
+// SUCCESSFUL deploy — after an auto-rollback, CurrentRevision regresses to
+// the prior success, so adding one would collide with the rolled_back
+// revision that still exists under the UNIQUE(app_id, revision_number)
+// constraint. A counter rather than MAX+1 because CI deploys reserve their
+// number at enqueue time (EnqueueDeployJob), before the revision row exists;
+// the row lock on apps keeps the two allocation paths from handing out the
+// same number.
+func (db *DB) AllocateRevisionNumber(ctx context.Context, appID string) (int, error) {
 	var n int
-	err := db.GetContext(ctx, &n, `
-		SELECT COALESCE(MAX(revision_number), 0) + 1
-		FROM app_revisions WHERE app_id = $1
-	`, appID)
+	err := db.GetContext(ctx, &n, allocateRevisionSQL, appID)
 	return n, err
 }
 
@@ -446,6 +739,20 @@ func (db *DB) UpdateAppRevision(ctx context.Context, appID string, revision int)
 	return err
 }
 
+// RecordDeployedCommit marks a revision's commit as the app's last deployed
+// one (NULL for an image deployed without a commit). Push deploys dedupe
+// against it, so it is only written once the revision is live.
+func (db *DB) RecordDeployedCommit(ctx context.Context, appID string, revision int) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE apps SET
+			last_deployed_sha = (SELECT commit_sha FROM app_revisions WHERE app_id = $1 AND revision_number = $2),
+			last_deployed_at = NOW(),
+			updated_at = NOW()
+		WHERE id = $1
+	`, appID, revision)
+	return err
+}
+
 func (db *DB) DeleteOldRevisions(ctx context.Context, appID string, keepCount int) error {
 	if keepCount <= 0 {
 		keepCount = 10 // Default keep last 10
@@ -519,6 +826,48 @@ func (db *DB) CreateUser(ctx context.Context, email, name, pictureURL, googleID
 	return &u, err
 }
 
+// CreateExternalUser creates a user who signed in through an OIDC provider
+// rather than Google.
+func (db *DB) CreateExternalUser(ctx context.Context, email, name, pictureURL string) (*User, error) {
+	var u User
+	err := db.GetContext(ctx, &u, `
+		INSERT INTO users (email, name, picture_url)
+		VALUES ($1, $2, $3)
+		RETURNING *
+	`, email, name, pictureURL)
+	return &u, err
+}
+
+// GetUserByIdentity returns the user linked to a provider's subject.
+func (db *DB) GetUserByIdentity(ctx context.Context, provider, subject string) (*User, error) {
+	var u User
+	err := db.GetContext(ctx, &u, `
+		SELECT u.* FROM users u
+		JOIN user_identities i ON i.user_id = u.id
+		WHERE i.provider = $1 AND i.subject = $2
+	`, provider, subject)
+	return &u, err
+}
+
+// LinkUserIdentity links a provider's subject to userID, recording the login.
+func (db *DB) LinkUserIdentity(ctx context.Context, provider, subject, userID, email string) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO user_identities (provider, subject, user_id, email, last_login_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (provider, subject) DO UPDATE SET
+			email = EXCLUDED.email,
+			last_login_at = NOW()
+	`, provider, subject, userID, email)
+	return err
+}
+
+// UpdateUserGroups replaces the groups a user's last OIDC login asserted.
+// Roles granted through them lapse at expiresAt.
+func (db *DB) UpdateUserGroups(ctx context.Context, id string, groups []byte, expiresAt time.Time) error {
+	_, err := db.ExecContext(ctx, `UPDATE users SET groups = $1, groups_expire_at = $2 WHERE id = $3`, groups, expiresAt, id)
+	return err
+}
+
 func (db *DB) UpdateUserLastLogin(ctx context.Context, id string) error {
 	_, err := db.ExecContext(ctx, `UPDATE users SET last_login_at = NOW() WHERE id = $1`, id)
 	return err
@@ -574,36 +923,51 @@ func (db *DB) DeleteExpiredSessions(ctx context.Context) (int64, error) {
 // User Token operations (CLI auth)
 // ============================================================================
 
-func (db *DB) CreateUserToken(ctx context.Context, userID, name, tokenHash string, expiresAt *time.Time) (*UserToken, error) {
+// CreateUserTokenParams contains all parameters for creating a user token
+type CreateUserTokenParams struct {
+	UserID     string
+	Name       string
+	TokenHash  string
+	ExpiresAt  *time.Time
+	Scopes     []byte // JSON array of scope strings
+	AllowedIPs []byte // JSON array of addresses/CIDRs, nil for any
+}
+
+func (db *DB) CreateUserToken(ctx context.Context, p CreateUserTokenParams) (*UserToken, error) {
 	var t UserToken
 	err := db.GetContext(ctx, &t, `
-		INSERT INTO user_tokens (user_id, name, token_hash, expires_at)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO user_tokens (user_id, name, token_hash, expires_at, scopes, allowed_ips)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING *
-	`, userID, name, tokenHash, expiresAt)
+	`, p.UserID, p.Name, p.TokenHash, p.ExpiresAt, p.Scopes, p.AllowedIPs)
 	return &t, err
 }
 
+// ValidateUserToken looks up an unexpired token by hash; a token without an
+// expiry counts as expired. The caller checks its IP allowlist and scopes,
+// then records the use with TouchUserToken.
 func (db *DB) ValidateUserToken(ctx context.Context, tokenHash string) (*UserToken, error) {
 	var t UserToken
 	err := db.GetContext(ctx, &t, `
 		SELECT * FROM user_tokens
-		WHERE token_hash = $1 AND (expires_at IS NULL OR expires_at > NOW())
+		WHERE token_hash = $1 AND expires_at IS NOT NULL AND expires_at > NOW()
 	`, tokenHash)
 	if err != nil {
 		return nil, err
 	}
-
-	// Update last used timestamp in background
-	go db.Exec(`UPDATE user_tokens SET last_used_at = $1 WHERE id = $2`, time.Now(), t.ID)
-
 	return &t, nil
 }
 
+// TouchUserToken records when and from which IP a token was accepted, in
+// the background.
+func (db *DB) TouchUserToken(tokenID, ip string) {
+	go db.Exec(`UPDATE user_tokens SET last_used_at = $1, last_used_ip = $2 WHERE id = $3`, time.Now(), ip, tokenID)
+}
+
 func (db *DB) ListUserTokens(ctx context.Context, userID string) ([]UserToken, error) {
 	var tokens []UserToken
 	err := db.SelectContext(ctx, &tokens, `
-		SELECT id, user_id, name, created_at, last_used_at, expires_at
+		SELECT id, user_id, name, created_at, last_used_at, expires_at, scopes, allowed_ips, last_used_ip
 		FROM user_tokens WHERE user_id = $1 ORDER BY created_at DESC
 	`, userID)
 	return tokens, err
@@ -620,6 +984,65 @@ func (db *DB) GetUserTokenByID(ctx context.Context, tokenID string) (*UserToken,
 	return &t, err
 }
 
+// ============================================================================
+// CLI login operations (shipit login)
+// ============================================================================
+
+type CreateCLILoginParams struct {
+	CodeHash      string
+	UserCode      *string // device flow
+	CodeChallenge *string // loopback flow
+	UserID        *string // loopback logins are approved when created
+	ExpiresAt     time.Time
+}
+
+// CreateCLILogin records a CLI sign-in, clearing out expired ones.
+func (db *DB) CreateCLILogin(ctx context.Context, p CreateCLILoginParams) (*CLILogin, error) {
+	if _, err := db.ExecContext(ctx, `DELETE FROM cli_logins WHERE expires_at < NOW()`); err != nil {
+		return nil, err
+	}
+	var l CLILogin
+	err := db.GetContext(ctx, &l, `
+		INSERT INTO cli_logins (code_hash, user_code, code_challenge, user_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING *
+	`, p.CodeHash, p.UserCode, p.CodeChallenge, p.UserID, p.ExpiresAt)
+	return &l, err
+}
+
+// ApproveCLILogin approves the pending device login with userCode on behalf
+// of userID, and reports whether there was one.
+func (db *DB) ApproveCLILogin(ctx context.Context, userCode, userID string) (bool, error) {
+	result, err := db.ExecContext(ctx, `
+		UPDATE cli_logins SET user_id = $2
+		WHERE user_code = $1 AND user_id IS NULL AND expires_at > NOW()
+	`, userCode, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (db *DB) GetCLILogin(ctx context.Context, codeHash string) (*CLILogin, error) {
+	var l CLILogin
+	err := db.GetContext(ctx, &l, `SELECT * FROM cli_logins WHERE code_hash = $1`, codeHash)
+	return &l, err
+}
+
+// ConsumeCLILogin deletes and returns the approved, unexpired login with
+// codeHash, so each code is exchanged at most once. It returns
+// sql.ErrNoRows if there is none.
+func (db *DB) ConsumeCLILogin(ctx context.Context, codeHash string) (*CLILogin, error) {
+	var l CLILogin
+	err := db.GetContext(ctx, &l, `
+		DELETE FROM cli_logins
+		WHERE code_hash = $1 AND user_id IS NOT NULL AND expires_at > NOW()
+		RETURNING *
+	`, codeHash)
+	return &l, err
+}
+
 // ============================================================================
 // Porter Migration operations
 // ============================================================================
@@ -743,3 +1166,907 @@ func (db *DB) LinkAppToPorter(ctx context.Context, appID, porterAppID string, po
 	`, porterAppID, porterAppURL, appID)
 	return err
 }
+
+// ============================================================================
+// Deploy Job operations (durable deploy queue)
+// ============================================================================
+
+type EnqueueDeployJobParams struct {
+	AppID          string
+	Kind           string // deploy, rollback
+	TargetRevision *int
+	RequestedBy    *string
+	// Deploy source (CI and push deploys). Image, when set, is written to
+	// the app row in the same transaction, and the job reserves its
+	// revision number up front, returned in the job's RevisionNumber.
+	Image     *string
+	CommitSHA *string
+	CommitRef *string
+	CIURL     *string
+	// SkipDuplicateCommit makes the enqueue fail with ErrDuplicateCommit,
+	// changing nothing, if CommitSHA is already queued or running for the
+	// app or is the commit it runs. GitHub redelivers pushes, sometimes
+	// concurrently.
+	SkipDuplicateCommit bool
+	// Delay debounces the job: it isn't claimed for this long, and a newer
+	// delayed job for the app supersedes it and starts the wait again.
+	Delay time.Duration
+}
+
+// ErrDuplicateCommit is returned by EnqueueDeployJob for a commit that is
+// already being deployed, or already deployed (SkipDuplicateCommit).
+var ErrDuplicateCommit = errors.New("commit is already deployed or queued")
+
+// EnqueueDeployJob inserts a queued job and coalesces any older queued jobs
+// for the same app into it: they are marked superseded (pointing at the new
+// job) because the worker always deploys the app row as it exists when the
+// job is claimed, so running the older entries first would only redeploy a
+// state nobody asked for anymore. A job that is already running is left
+// alone — the new one waits behind it.
+//
+// The per-app advisory lock serializes concurrent enqueues for the same app
+// across replicas; without it two transactions could each miss the other's
+// uncommitted insert and leave two queued rows behind.
+//
+// A plain deploy that supersedes a queued CI deploy takes over its reserved
+// revision number and source: it deploys the same app row, so the number
+// the CI caller was given and the commit still describe what ships. The
+// worker drops the source again if the image was changed in between
+// (DeployJob.Image no longer matches). Any other queued job with a reserved
+// revision is not superseded: its caller was promised that revision, so it
+// still runs, ahead of the new job. Nor is a queued rollback, except by
+// another rollback: the rollback rewrites the app row when it runs, which a
+// deploy queued after it would otherwise never see.
+//
+// A delayed job that supersedes a queued immediate one runs immediately
+// too: whoever queued that deploy is waiting for it, and it would have
+// picked up the same change.
+func (db *DB) EnqueueDeployJob(ctx context.Context, p EnqueueDeployJobParams) (*DeployJob, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, "deploy_jobs:"+p.AppID); err != nil {
+		return nil, err
+	}
+
+	if p.SkipDuplicateCommit && p.CommitSHA != nil {
+		var duplicate bool
+		if err := tx.GetContext(ctx, &duplicate, `
+			SELECT EXISTS (
+				SELECT 1 FROM deploy_jobs
+				WHERE app_id = $1 AND commit_sha = $2 AND status IN ('queued', 'running')
+			) OR EXISTS (
+				SELECT 1 FROM apps WHERE id = $1 AND last_deployed_sha = $2
+			)
+		`, p.AppID, *p.CommitSHA); err != nil {
+			return nil, err
+		}
+		if duplicate {
+			return nil, ErrDuplicateCommit
+		}
+	}
+
+	if p.Image != nil {
+		result, err := tx.ExecContext(ctx, `
+			UPDATE apps SET image = $2, updated_at = NOW() WHERE id = $1
+		`, p.AppID, *p.Image)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return nil, sql.ErrNoRows
+		}
+	}
+
+	var revision *int
+	if p.Image != nil {
+		var n int
+		if err := tx.GetContext(ctx, &n, allocateRevisionSQL, p.AppID); err != nil {
+			return nil, err
+		}
+		revision = &n
+	}
+
+	delay := p.Delay.Seconds()
+	if delay > 0 {
+		var queuedNow bool
+		if err := tx.GetContext(ctx, &queuedNow, `
+			SELECT EXISTS (SELECT 1 FROM deploy_jobs WHERE app_id = $1 AND status = 'queued' AND run_after IS NULL)
+		`, p.AppID); err != nil {
+			return nil, err
+		}
+		if queuedNow {
+			delay = 0
+		}
+	}
+
+	var j DeployJob
+	if err := tx.GetContext(ctx, &j, `
+		INSERT INTO deploy_jobs (app_id, kind, target_revision, requested_by,
+			revision_number, image, commit_sha, commit_ref, ci_url, run_after)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9,
+			CASE WHEN $10::float8 > 0 THEN NOW() + make_interval(secs => $10::float8) END)
+		RETURNING *
+	`, p.AppID, p.Kind, p.TargetRevision, p.RequestedBy,
+		revision, p.Image, p.CommitSHA, p.CommitRef, p.CIURL, delay); err != nil {
+		return nil, err
+	}
+
+	if p.Kind == "deploy" && p.Image == nil {
+		var inherited DeployJob
+		err := tx.GetContext(ctx, &inherited, `
+			UPDATE deploy_jobs j SET revision_number = o.revision_number, image = o.image,
+				commit_sha = o.commit_sha, commit_ref = o.commit_ref, ci_url = o.ci_url
+			FROM (
+				SELECT * FROM deploy_jobs
+				WHERE app_id = $2 AND status = 'queued' AND kind = 'deploy'
+					AND id <> $1 AND revision_number IS NOT NULL
+				ORDER BY created_at DESC
+				LIMIT 1
+			) o
+			WHERE j.id = $1
+			RETURNING j.*
+		`, j.ID, p.AppID)
+		switch {
+		case err == nil:
+			j = inherited
+		case !errors.Is(err, sql.ErrNoRows):
+			return nil, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE deploy_jobs SET status = 'superseded', superseded_by = $1, finished_at = NOW()
+		WHERE app_id = $2 AND status = 'queued' AND id <> $1
+			AND (revision_number IS NULL OR revision_number = $3)
+			AND (kind <> 'rollback' OR $4 = 'rollback')
+	`, j.ID, p.AppID, j.RevisionNumber, p.Kind); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// ErrDeployInFlight is returned by WithDeployLock when a deploy job for
+// the app is queued or running.
+var ErrDeployInFlight = errors.New("a deploy is queued or running")
+
+// WithDeployLock runs fn while holding the app's deploy queue lock, the
+// advisory lock EnqueueDeployJob takes, and only if no deploy job for the
+// app is queued or running (ErrDeployInFlight otherwise). No job can be
+// queued or claimed for the app until fn returns, on any replica, so fn
+// can change what is deployed without a worker racing it.
+func (db *DB) WithDeployLock(ctx context.Context, appID string, fn func() error) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, "deploy_jobs:"+appID); err != nil {
+		return err
+	}
+
+	var inFlight bool
+	if err := tx.GetContext(ctx, &inFlight, `
+		SELECT EXISTS (SELECT 1 FROM deploy_jobs WHERE app_id = $1 AND status IN ('queued', 'running'))
+	`, appID); err != nil {
+		return err
+	}
+	if inFlight {
+		return ErrDeployInFlight
+	}
+
+	if err := fn(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ClaimDeployJob atomically moves the oldest claimable queued job to
+// running and returns it. A job is claimable when its run_after has passed
+// and no other job for the same app is running, which gives per-app FIFO
+// ordering while different apps deploy in parallel. SKIP LOCKED lets
+// several workers poll concurrently without blocking on each other's
+// candidate rows.
+//
+// Returns (nil, nil) when there is nothing to claim, including the case
+// where another worker won the race for the same app (the one-running-per-
+// app unique index rejects our UPDATE).
+func (db *DB) ClaimDeployJob(ctx context.Context, workerID string) (*DeployJob, error) {
+	var j DeployJob
+	err := db.GetContext(ctx, &j, `
+		UPDATE deploy_jobs
+		SET status = 'running', worker_id = $1, attempts = attempts + 1,
+			started_at = NOW(), heartbeat_at = NOW()
+		WHERE id = (
+			SELECT q.id FROM deploy_jobs q
+			WHERE q.status = 'queued'
+			AND (q.run_after IS NULL OR q.run_after <= NOW())
+			AND NOT EXISTS (
+				SELECT 1 FROM deploy_jobs r WHERE r.app_id = q.app_id AND r.status = 'running'
+			)
+			ORDER BY q.created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *
+	`, workerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// HeartbeatDeployJob records that the worker holding the job is still
+// alive. Scoped to worker_id so a worker whose job was already requeued by
+// RequeueStaleDeployJobs can't resurrect it.
+func (db *DB) HeartbeatDeployJob(ctx context.Context, id, workerID string) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE deploy_jobs SET heartbeat_at = NOW()
+		WHERE id = $1 AND worker_id = $2 AND status = 'running'
+	`, id, workerID)
+	return err
+}
+
+// FinishDeployJob records the terminal status of a job.
+func (db *DB) FinishDeployJob(ctx context.Context, id, status string, revisionNumber *int, message *string) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE deploy_jobs SET status = $1, revision_number = $2, error = $3, finished_at = NOW()
+		WHERE id = $4
+	`, status, revisionNumber, message, id)
+	return err
+}
+
+// SetDeployJobRevision records the revision a running job's deploy
+// created, so the job can be followed before it finishes.
+func (db *DB) SetDeployJobRevision(ctx context.Context, id string, revisionNumber int) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE deploy_jobs SET revision_number = $1 WHERE id = $2
+	`, revisionNumber, id)
+	return err
+}
+
+// RequeueStaleDeployJobs recovers jobs whose worker stopped heartbeating
+// (process crash, node loss, restart mid-deploy). Each stale job is put
+// back in the queue unless a newer job for the app is already queued and
+// the stale one has no reserved revision (then it is superseded, matching
+// EnqueueDeployJob's coalescing) or it has used up maxAttempts (then it is
+// failed). Returns the number of rows touched.
+//
+// Jobs that got as far as allocating a revision are skipped: their changes
+// may already be on the cluster, so re-running the whole pipeline (pre-deploy
+// hook included) is wrong. The deploy reconciler takes those over instead
+// and settles them against the live Deployment.
+func (db *DB) RequeueStaleDeployJobs(ctx context.Context, staleAfter time.Duration, maxAttempts int) (int64, error) {
+	result, err := db.ExecContext(ctx, `
+		UPDATE deploy_jobs j SET
+			status = CASE
+				WHEN j.revision_number IS NULL AND EXISTS (SELECT 1 FROM deploy_jobs n WHERE n.app_id = j.app_id AND n.status = 'queued') THEN 'superseded'
+				WHEN j.attempts >= $2 THEN 'failed'
+				ELSE 'queued'
+			END,
+			error = CASE
+				WHEN j.attempts >= $2 THEN 'worker lost heartbeat; giving up after ' || j.attempts || ' attempts'
+				ELSE 'worker lost heartbeat; requeued'
+			END,
+			finished_at = CASE
+				WHEN j.attempts >= $2 OR (j.revision_number IS NULL AND EXISTS (SELECT 1 FROM deploy_jobs n WHERE n.app_id = j.app_id AND n.status = 'queued')) THEN NOW()
+				ELSE NULL
+			END,
+			worker_id = NULL
+		WHERE j.status = 'running' AND j.heartbeat_at < NOW() - make_interval(secs => $1)
+		AND NOT EXISTS (
+			SELECT 1 FROM app_revisions r
+			WHERE r.app_id = j.app_id AND r.deploy_status = 'deploying' AND r.created_at >= j.started_at
+		)
+	`, staleAfter.Seconds(), maxAttempts)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (db *DB) GetDeployJob(ctx context.Context, id string) (*DeployJob, error) {
+	var j DeployJob
+	err := db.GetContext(ctx, &j, `SELECT * FROM deploy_jobs WHERE id = $1`, id)
+	return &j, err
+}
+
+// ListActiveDeployJobs returns the queued and running jobs for an app.
+func (db *DB) ListActiveDeployJobs(ctx context.Context, appID string) ([]DeployJob, error) {
+	var jobs []DeployJob
+	err := db.SelectContext(ctx, &jobs, `
+		SELECT * FROM deploy_jobs
+		WHERE app_id = $1 AND status IN ('queued', 'running')
+		ORDER BY created_at
+	`, appID)
+	return jobs, err
+}
+
+// TakeOverStaleDeployJob transfers a running job whose worker stopped
+// heartbeating to workerID. Returns false if the job is no longer stale
+// (its worker recovered, or another replica took it over first).
+func (db *DB) TakeOverStaleDeployJob(ctx context.Context, id, workerID string, staleAfter time.Duration) (bool, error) {
+	result, err := db.ExecContext(ctx, `
+		UPDATE deploy_jobs SET worker_id = $1, heartbeat_at = NOW()
+		WHERE id = $2 AND status = 'running' AND heartbeat_at < NOW() - make_interval(secs => $3)
+	`, workerID, id, staleAfter.Seconds())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// ============================================================================
+// Deploy events (per-revision deploy timeline)
+// ============================================================================
+
+// CreateDeployEventParams is one phase transition of a revision's deploy.
+// OccurredAt defaults to now; ready rows carry the time kube saw the pod
+// become ready.
+type CreateDeployEventParams struct {
+	AppID          string
+	RevisionNumber int
+	Phase          string
+	Status         string
+	Message        *string
+	Pod            *string
+	OccurredAt     time.Time
+}
+
+// CreateDeployEvent appends a row to a revision's deploy timeline.
+func (db *DB) CreateDeployEvent(ctx context.Context, p CreateDeployEventParams) error {
+	at := p.OccurredAt
+	if at.IsZero() {
+		at = time.Now()
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO deploy_events (app_id, revision_number, phase, status, message, pod, occurred_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, p.AppID, p.RevisionNumber, p.Phase, p.Status, p.Message, p.Pod, at)
+	return err
+}
+
+// ListDeployEvents returns a revision's deploy events, oldest first.
+func (db *DB) ListDeployEvents(ctx context.Context, appID string, revisionNumber int) ([]DeployEvent, error) {
+	var events []DeployEvent
+	err := db.SelectContext(ctx, &events, `
+		SELECT * FROM deploy_events
+		WHERE app_id = $1 AND revision_number = $2
+		ORDER BY occurred_at, id
+	`, appID, revisionNumber)
+	return events, err
+}
+
+// Notification settings
+
+// GetAppNotificationSettings returns the app-level override row, or
+// sql.ErrNoRows if the app has none.
+func (db *DB) GetAppNotificationSettings(ctx context.Context, appID string) (*NotificationSettings, error) {
+	var n NotificationSettings
+	err := db.GetContext(ctx, &n, `SELECT * FROM notification_settings WHERE app_id = $1`, appID)
+	return &n, err
+}
+
+// GetProjectNotificationSettings returns the project-level defaults, or
+// sql.ErrNoRows if none are configured.
+func (db *DB) GetProjectNotificationSettings(ctx context.Context, projectID string) (*NotificationSettings, error) {
+	var n NotificationSettings
+	err := db.GetContext(ctx, &n, `SELECT * FROM notification_settings WHERE project_id = $1`, projectID)
+	return &n, err
+}
+
+// GetNotificationSettingsForApp returns the settings rows that apply to an
+// app: its project's defaults and its own override, either of which may be
+// nil.
+func (db *DB) GetNotificationSettingsForApp(ctx context.Context, appID string) (project, app *NotificationSettings, err error) {
+	var rows []NotificationSettings
+	err = db.SelectContext(ctx, &rows, `
+		SELECT n.* FROM notification_settings n
+		WHERE n.app_id = $1
+		   OR n.project_id = (
+				SELECT c.project_id FROM apps a JOIN clusters c ON c.id = a.cluster_id
+				WHERE a.id = $1)
+	`, appID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range rows {
+		if rows[i].AppID != nil {
+			app = &rows[i]
+		} else {
+			project = &rows[i]
+		}
+	}
+	return project, app, nil
+}
+
+// UpsertNotificationSettingsParams contains the full notification settings
+// for one scope; exactly one of ProjectID and AppID is set.
+type UpsertNotificationSettingsParams struct {
+	ProjectID              *string
+	AppID                  *string
+	SlackWebhookEncrypted  []byte
+	WebhookURL             *string
+	WebhookSecretEncrypted []byte
+	Events                 []byte // JSON array of event types, nil for NULL
+}
+
+// UpsertNotificationSettings creates or replaces the settings row for a
+// project or an app.
+func (db *DB) UpsertNotificationSettings(ctx context.Context, p UpsertNotificationSettingsParams) (*NotificationSettings, error) {
+	conflict := "(project_id) WHERE project_id IS NOT NULL"
+	if p.AppID != nil {
+		conflict = "(app_id) WHERE app_id IS NOT NULL"
+	}
+	var n NotificationSettings
+	err := db.GetContext(ctx, &n, `
+		INSERT INTO notification_settings (project_id, app_id, slack_webhook_encrypted, webhook_url, webhook_secret_encrypted, events)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT `+conflict+` DO UPDATE SET
+			slack_webhook_encrypted = EXCLUDED.slack_webhook_encrypted,
+			webhook_url = EXCLUDED.webhook_url,
+			webhook_secret_encrypted = EXCLUDED.webhook_secret_encrypted,
+			events = EXCLUDED.events,
+			updated_at = NOW()
+		RETURNING *
+	`, p.ProjectID, p.AppID, p.SlackWebhookEncrypted, p.WebhookURL, p.WebhookSecretEncrypted, p.Events)
+	return &n, err
+}
+
+// Audit log
+
+// CreateAuditLogParams contains one audit entry. Changes and Details are
+// JSON objects, nil for NULL.
+type CreateAuditLogParams struct {
+	ActorType  string
+	ActorID    *string
+	Actor      string
+	IPAddress  *string
+	RequestID  *string
+	Method     string
+	Path       string
+	Action     string
+	AppID      *string
+	StatusCode int
+	Changes    []byte
+	Details    []byte
+}
+
+func (db *DB) CreateAuditLog(ctx context.Context, p CreateAuditLogParams) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO audit_logs (actor_type, actor_id, actor, ip_address, request_id, method, path,
+			action, app_id, status_code, changes, details)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`, p.ActorType, p.ActorID, p.Actor, p.IPAddress, p.RequestID, p.Method, p.Path,
+		p.Action, p.AppID, p.StatusCode, p.Changes, p.Details)
+	return err
+}
+
+// AuditLogFilter narrows ListAuditLogs. Zero fields don't filter. An
+// Action ending in ".*" matches by prefix (e.g. "secret.*").
+type AuditLogFilter struct {
+	AppID  string
+	Actor  string
+	Action string
+	Since  *time.Time
+	Until  *time.Time
+	Before *time.Time // pagination cursor: entries strictly older than this
+	Limit  int
+}
+
+// ListAuditLogs returns audit entries matching f, newest first.
+func (db *DB) ListAuditLogs(ctx context.Context, f AuditLogFilter) ([]AuditLog, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, strings.ReplaceAll(cond, "?", "$"+strconv.Itoa(len(args))))
+	}
+	if f.AppID != "" {
+		add("app_id = ?", f.AppID)
+	}
+	if f.Actor != "" {
+		add("actor = ?", f.Actor)
+	}
+	if prefix, ok := strings.CutSuffix(f.Action, ".*"); ok {
+		add("action LIKE ?", prefix+".%")
+	} else if f.Action != "" {
+		add("action = ?", f.Action)
+	}
+	if f.Since != nil {
+		add("created_at >= ?", *f.Since)
+	}
+	if f.Until != nil {
+		add("created_at < ?", *f.Until)
+	}
+	if f.Before != nil {
+		add("created_at < ?", *f.Before)
+	}
+
+	query := `SELECT * FROM audit_logs`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	args = append(args, f.Limit)
+	query += ` ORDER BY created_at DESC LIMIT $` + strconv.Itoa(len(args))
+
+	var entries []AuditLog
+	err := db.SelectContext(ctx, &entries, query, args...)
+	return entries, err
+}
+
+// DeleteAuditLogsBefore removes audit entries older than cutoff and
+// returns how many were deleted.
+func (db *DB) DeleteAuditLogsBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	result, err := db.ExecContext(ctx, `DELETE FROM audit_logs WHERE created_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ============================================================================
+// Log archive
+// ============================================================================
+
+// AcquireLogCollectorLease takes or renews holder's lease on collecting
+// clusterID's logs for ttl. It fails (false) while another holder's lease
+// is unexpired.
+func (db *DB) AcquireLogCollectorLease(ctx context.Context, clusterID, holder string, ttl time.Duration) (bool, error) {
+	var got string
+	err := db.GetContext(ctx, &got, `
+		INSERT INTO log_collector_leases (cluster_id, holder, expires_at)
+		VALUES ($1, $2, NOW() + make_interval(secs => $3::float8))
+		ON CONFLICT (cluster_id) DO UPDATE
+		SET holder = EXCLUDED.holder, expires_at = EXCLUDED.expires_at
+		WHERE log_collector_leases.holder = EXCLUDED.holder
+		   OR log_collector_leases.expires_at < NOW()
+		RETURNING holder
+	`, clusterID, holder, ttl.Seconds())
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ListAppsToArchiveLogs returns the apps on clusterID whose project keeps
+// archived logs (log_retention_days > 0).
+func (db *DB) ListAppsToArchiveLogs(ctx context.Context, clusterID string) ([]App, error) {
+	var apps []App
+	err := db.SelectContext(ctx, &apps, `
+		SELECT a.* FROM apps a
+		JOIN clusters c ON c.id = a.cluster_id
+		JOIN projects p ON p.id = c.project_id
+		WHERE a.cluster_id = $1 AND p.log_retention_days > 0
+		ORDER BY a.created_at
+	`, clusterID)
+	return apps, err
+}
+
+func (db *DB) CreateLogChunk(ctx context.Context, chunk *LogChunk) error {
+	return db.GetContext(ctx, chunk, `
+		INSERT INTO log_chunks (app_id, pod, revision, first_at, last_at, lines, bytes, object_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING *
+	`, chunk.AppID, chunk.Pod, chunk.Revision, chunk.FirstAt, chunk.LastAt, chunk.Lines, chunk.Bytes, chunk.ObjectKey)
+}
+
+// LastLogChunkAt returns the newest archived line's time for appID, or nil
+// if nothing has been archived; the collector resumes from there.
+func (db *DB) LastLogChunkAt(ctx context.Context, appID string) (*time.Time, error) {
+	var last *time.Time
+	err := db.GetContext(ctx, &last, `SELECT MAX(last_at) FROM log_chunks WHERE app_id = $1`, appID)
+	return last, err
+}
+
+// LogChunkFilter narrows a log archive search. Zero fields don't filter.
+type LogChunkFilter struct {
+	AppID    string
+	From     *time.Time
+	To       *time.Time
+	Revision *int
+	Pod      string
+	Limit    int
+}
+
+// ListLogChunks returns the chunks of f.AppID that overlap [f.From, f.To],
+// oldest first.
+func (db *DB) ListLogChunks(ctx context.Context, f LogChunkFilter) ([]LogChunk, error) {
+	var chunks []LogChunk
+	err := db.SelectContext(ctx, &chunks, `
+		SELECT * FROM log_chunks
+		WHERE app_id = $1
+		  AND ($2::timestamptz IS NULL OR last_at >= $2)
+		  AND ($3::timestamptz IS NULL OR first_at <= $3)
+		  AND ($4::int IS NULL OR revision = $4)
+		  AND ($5 = '' OR pod = $5)
+		ORDER BY first_at, id
+		LIMIT $6
+	`, f.AppID, f.From, f.To, f.Revision, f.Pod, f.Limit)
+	return chunks, err
+}
+
+// ListExpiredLogChunks returns up to limit chunks past their project's
+// log_retention_days, or whose app (or project's archiving) is gone.
+func (db *DB) ListExpiredLogChunks(ctx context.Context, limit int) ([]LogChunk, error) {
+	var chunks []LogChunk
+	err := db.SelectContext(ctx, &chunks, `
+		SELECT l.* FROM log_chunks l
+		LEFT JOIN apps a ON a.id = l.app_id
+		LEFT JOIN clusters c ON c.id = a.cluster_id
+		LEFT JOIN projects p ON p.id = c.project_id
+		WHERE p.id IS NULL
+		   OR p.log_retention_days <= 0
+		   OR l.last_at < NOW() - make_interval(days => p.log_retention_days)
+		ORDER BY l.last_at
+		LIMIT $1
+	`, limit)
+	return chunks, err
+}
+
+func (db *DB) DeleteLogChunk(ctx context.Context, id string) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM log_chunks WHERE id = $1`, id)
+	return err
+}
+
+// ============================================================================
+// Project membership operations (RBAC)
+// ============================================================================
+
+// userRolesSQL selects (project_id, role) for every role user $1 holds,
+// directly or through one of the groups their last login asserted, until
+// those groups expire.
+const userRolesSQL = `
+	SELECT project_id, role FROM project_members WHERE user_id = $1
+	UNION ALL
+	SELECT g.project_id, g.role
+	FROM project_group_roles g
+	JOIN users u ON u.groups ? g.group_name
+	WHERE u.id = $1 AND u.groups_expire_at > NOW()
+`
+
+// roleRankSQL orders roles from most to least privileged.
+const roleRankSQL = `CASE role WHEN 'admin' THEN 3 WHEN 'deployer' THEN 2 ELSE 1 END DESC`
+
+// ListProjectsForUser returns the projects userID has a role in, directly
+// or through a group, with the user's highest role in each.
+func (db *DB) ListProjectsForUser(ctx context.Context, userID string) ([]ProjectWithRole, error) {
+	var projects []ProjectWithRole
+	err := db.SelectContext(ctx, &projects, `
+		SELECT * FROM (
+			SELECT DISTINCT ON (p.id) p.*, r.role
+			FROM projects p
+			JOIN (`+userRolesSQL+`) r ON r.project_id = p.id
+			ORDER BY p.id, `+roleRankSQL+`
+		) projects
+		ORDER BY created_at DESC
+	`, userID)
+	return projects, err
+}
+
+// GetEffectiveProjectRole returns userID's highest role in projectID, from
+// their membership or their groups, or sql.ErrNoRows if they have none.
+func (db *DB) GetEffectiveProjectRole(ctx context.Context, projectID, userID string) (string, error) {
+	var role string
+	err := db.GetContext(ctx, &role, `
+		SELECT role FROM (`+userRolesSQL+`) r
+		WHERE project_id = $2
+		ORDER BY `+roleRankSQL+`
+		LIMIT 1
+	`, userID, projectID)
+	return role, err
+}
+
+// GetProjectMemberRole returns userID's role in projectID, or sql.ErrNoRows
+// if the user is not a member.
+func (db *DB) GetProjectMemberRole(ctx context.Context, projectID, userID string) (string, error) {
+	var role string
+	err := db.GetContext(ctx, &role, `
+		SELECT role FROM project_members WHERE project_id = $1 AND user_id = $2
+	`, projectID, userID)
+	return role, err
+}
+
+// CountProjectsWithoutMembers counts projects nobody is a member of, which
+// only platform admins can reach.
+func (db *DB) CountProjectsWithoutMembers(ctx context.Context) (int, error) {
+	var n int
+	err := db.GetContext(ctx, &n, `
+		SELECT COUNT(*) FROM projects p
+		WHERE NOT EXISTS (SELECT 1 FROM project_members m WHERE m.project_id = p.id)
+	`)
+	return n, err
+}
+
+// GetAppProjectID returns the project that owns an app's cluster.
+func (db *DB) GetAppProjectID(ctx context.Context, appID string) (string, error) {
+	var projectID string
+	err := db.GetContext(ctx, &projectID, `
+		SELECT c.project_id FROM apps a JOIN clusters c ON c.id = a.cluster_id WHERE a.id = $1
+	`, appID)
+	return projectID, err
+}
+
+// GetAppProject returns the project that owns an app's cluster.
+func (db *DB) GetAppProject(ctx context.Context, appID string) (*Project, error) {
+	var p Project
+	err := db.GetContext(ctx, &p, `
+		SELECT p.* FROM apps a
+		JOIN clusters c ON c.id = a.cluster_id
+		JOIN projects p ON p.id = c.project_id
+		WHERE a.id = $1
+	`, appID)
+	return &p, err
+}
+
+func (db *DB) ListProjectMembers(ctx context.Context, projectID string) ([]ProjectMember, error) {
+	var members []ProjectMember
+	err := db.SelectContext(ctx, &members, `
+		SELECT m.*, u.email, u.name
+		FROM project_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.project_id = $1
+		ORDER BY u.email
+	`, projectID)
+	return members, err
+}
+
+func (db *DB) GetProjectMember(ctx context.Context, projectID, userID string) (*ProjectMember, error) {
+	var m ProjectMember
+	err := db.GetContext(ctx, &m, `
+		SELECT m.*, u.email, u.name
+		FROM project_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.project_id = $1 AND m.user_id = $2
+	`, projectID, userID)
+	return &m, err
+}
+
+// UpsertProjectMember adds userID to projectID with role, or changes the
+// role of an existing member.
+func (db *DB) UpsertProjectMember(ctx context.Context, projectID, userID, role string, addedBy *string) (*ProjectMember, error) {
+	var m ProjectMember
+	err := db.GetContext(ctx, &m, `
+		WITH m AS (
+			INSERT INTO project_members (project_id, user_id, role, added_by)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (project_id, user_id) DO UPDATE SET
+				role = EXCLUDED.role,
+				added_by = EXCLUDED.added_by,
+				updated_at = NOW()
+			RETURNING *
+		)
+		SELECT m.*, u.email, u.name FROM m JOIN users u ON u.id = m.user_id
+	`, projectID, userID, role, addedBy)
+	return &m, err
+}
+
+// RemoveProjectMember deletes a membership and reports whether one existed.
+func (db *DB) RemoveProjectMember(ctx context.Context, projectID, userID string) (bool, error) {
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM project_members WHERE project_id = $1 AND user_id = $2
+	`, projectID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (db *DB) ListProjectGroupRoles(ctx context.Context, projectID string) ([]ProjectGroupRole, error) {
+	var roles []ProjectGroupRole
+	err := db.SelectContext(ctx, &roles, `
+		SELECT * FROM project_group_roles WHERE project_id = $1 ORDER BY group_name
+	`, projectID)
+	return roles, err
+}
+
+// UpsertProjectGroupRole grants everyone in group role in projectID, or
+// changes the group's existing role.
+func (db *DB) UpsertProjectGroupRole(ctx context.Context, projectID, group, role string, addedBy *string) (*ProjectGroupRole, error) {
+	var g ProjectGroupRole
+	err := db.GetContext(ctx, &g, `
+		INSERT INTO project_group_roles (project_id, group_name, role, added_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (project_id, group_name) DO UPDATE SET
+			role = EXCLUDED.role,
+			added_by = EXCLUDED.added_by,
+			updated_at = NOW()
+		RETURNING *
+	`, projectID, group, role, addedBy)
+	return &g, err
+}
+
+// RemoveProjectGroupRole revokes a group's role and reports whether it had one.
+func (db *DB) RemoveProjectGroupRole(ctx context.Context, projectID, group string) (bool, error) {
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM project_group_roles WHERE project_id = $1 AND group_name = $2
+	`, projectID, group)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (db *DB) CountProjectAdmins(ctx context.Context, projectID string) (int, error) {
+	var n int
+	err := db.GetContext(ctx, &n, `
+		SELECT COUNT(*) FROM project_members WHERE project_id = $1 AND role = 'admin'
+	`, projectID)
+	return n, err
+}
+
+// ============================================================================
+// Encryption key rotation (shipit-server rotate-keys)
+// ============================================================================
+
+// EncryptedColumn is a column of auth.Keyring ciphertexts in a table keyed
+// by a UUID id.
+type EncryptedColumn struct {
+	Table  string
+	Column string
+}
+
+// EncryptedColumns lists every column rotate-keys re-encrypts.
+var EncryptedColumns = []EncryptedColumn{
+	{"clusters", "kubeconfig_encrypted"},
+	{"app_secrets", "value_encrypted"},
+	{"notification_settings", "slack_webhook_encrypted"},
+	{"notification_settings", "webhook_secret_encrypted"},
+}
+
+func (c EncryptedColumn) String() string {
+	return c.Table + "." + c.Column
+}
+
+// EncryptedValue is one row's ciphertext from an EncryptedColumn.
+type EncryptedValue struct {
+	ID    string `db:"id"`
+	Value []byte `db:"value"`
+}
+
+// ListEncryptedValues returns up to limit non-NULL values of col with ids
+// after afterID (all when empty), in id order, for paging through a table.
+func (db *DB) ListEncryptedValues(ctx context.Context, col EncryptedColumn, afterID string, limit int) ([]EncryptedValue, error) {
+	if afterID == "" {
+		afterID = "00000000-0000-0000-0000-000000000000"
+	}
+	var values []EncryptedValue
+	err := db.SelectContext(ctx, &values, fmt.Sprintf(`
+		SELECT id, %[2]s AS value FROM %[1]s
+		WHERE %[2]s IS NOT NULL AND id > $1
+		ORDER BY id
+		LIMIT $2
+	`, col.Table, col.Column), afterID, limit)
+	return values, err
+}
+
+// ReplaceEncryptedValue swaps a row's ciphertext if it still holds old, so a
+// value written concurrently isn't overwritten. It reports whether it did.
+func (db *DB) ReplaceEncryptedValue(ctx context.Context, col EncryptedColumn, id string, old, new []byte) (bool, error) {
+	result, err := db.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %[1]s SET %[2]s = $3 WHERE id = $1 AND %[2]s = $2
+	`, col.Table, col.Column), id, old, new)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
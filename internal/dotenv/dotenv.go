@@ -0,0 +1,117 @@
+// Package dotenv parses .env files: KEY=value lines with
+// optional "export " prefixes, # comments and single- or double-quoted
+// values (double quotes support \n, \", \\ and may span lines).
+package dotenv
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// keyPattern is what Kubernetes accepts as a Secret key.
+var keyPattern = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+// ValidKey reports whether key can be a secret key.
+func ValidKey(key string) bool {
+	return len(key) <= 253 && keyPattern.MatchString(key)
+}
+
+// Parse reads KEY=value pairs. Errors carry the line number; a key set
+// twice is an error rather than silently taking the last value.
+func Parse(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	firstLine := make(map[string]int)
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, rest, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected KEY=value", lineNo)
+		}
+		if !ValidKey(key) {
+			return nil, fmt.Errorf("line %d: invalid key %q (use letters, digits, '_', '-' and '.')", lineNo, key)
+		}
+		if first, dup := firstLine[key]; dup {
+			return nil, fmt.Errorf("line %d: %s is already set on line %d", lineNo, key, first)
+		}
+
+		rest = strings.TrimLeft(rest, " \t")
+		var value string
+		switch {
+		case strings.HasPrefix(rest, "'"):
+			end := strings.Index(rest[1:], "'")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated single quote", lineNo)
+			}
+			value = rest[1 : 1+end]
+		case strings.HasPrefix(rest, `"`):
+			// A double-quoted value may continue on the following lines.
+			quoted := rest[1:]
+			for {
+				v, closed := unquote(quoted)
+				if closed {
+					value = v
+					break
+				}
+				if i+1 >= len(lines) {
+					return nil, fmt.Errorf("line %d: unterminated double quote", lineNo)
+				}
+				i++
+				quoted += "\n" + lines[i]
+			}
+		default:
+			// Unquoted: an inline comment starts at " #".
+			if idx := strings.Index(rest, " #"); idx >= 0 {
+				rest = rest[:idx]
+			}
+			value = strings.TrimSpace(rest)
+		}
+
+		values[key] = value
+		firstLine[key] = lineNo
+	}
+	return values, nil
+}
+
+// unquote decodes a double-quoted value up to its closing quote, reporting
+// whether the quote was closed.
+func unquote(s string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), true
+		case '\\':
+			if i+1 >= len(s) {
+				b.WriteByte(c)
+				continue
+			}
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\', '$':
+				b.WriteByte(s[i])
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", false
+}
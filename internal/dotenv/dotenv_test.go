@@ -0,0 +1,57 @@
+package dotenv
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	input := strings.Join([]string{
+		"# database",
+		"DATABASE_URL=postgres://db:5432/app",
+		"export API_KEY = abc123 # trailing comment",
+		"",
+		"SINGLE='literal $HOME \\n'",
+		`DOUBLE="line1\nline2 \"quoted\""`,
+		`MULTI="first`,
+		`second"`,
+		"EMPTY=",
+		"HASH=a#b",
+		"WINDOWS=crlf\r",
+	}, "\n")
+
+	got, err := Parse([]byte(input))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := map[string]string{
+		"DATABASE_URL": "postgres://db:5432/app",
+		"API_KEY":      "abc123",
+		"SINGLE":       `literal $HOME \n`,
+		"DOUBLE":       "line1\nline2 \"quoted\"",
+		"MULTI":        "first\nsecond",
+		"EMPTY":        "",
+		"HASH":         "a#b",
+		"WINDOWS":      "crlf",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse = %#v\nwant %#v", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"NO_EQUALS":           "line 1: expected KEY=value",
+		"BAD KEY=x":           "line 1: invalid key",
+		"A=1\nA=2":            "line 2: A is already set on line 1",
+		"A='open":             "line 1: unterminated single quote",
+		"A=1\nB=\"open\nmore": "line 2: unterminated double quote",
+	}
+	for input, want := range tests {
+		_, err := Parse([]byte(input))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Parse(%q) error = %v, want %q", input, err, want)
+		}
+	}
+}
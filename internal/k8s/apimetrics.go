@@ -0,0 +1,87 @@
+package k8s
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vigneshsubbiah/shipit/internal/metrics"
+	"k8s.io/client-go/rest"
+)
+
+// InstrumentConfig makes clients built from config count failed API
+// requests in metrics.K8sAPIErrorsTotal.
+func InstrumentConfig(config *rest.Config) {
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return apiErrorCounter{next: rt}
+	})
+}
+
+type apiErrorCounter struct {
+	next http.RoundTripper
+}
+
+func (c apiErrorCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := c.next.RoundTrip(req)
+	switch {
+	case err != nil:
+		metrics.K8sAPIErrorsTotal.WithLabelValues(apiVerb(req), "error").Inc()
+	case apiFailure(resp.StatusCode):
+		metrics.K8sAPIErrorsTotal.WithLabelValues(apiVerb(req), strconv.Itoa(resp.StatusCode)).Inc()
+	}
+	return resp, err
+}
+
+// apiFailure reports whether an API response status is a failure. NotFound
+// and Conflict aren't: they are how existence checks and create-or-update
+// work here.
+func apiFailure(code int) bool {
+	return code >= 400 && code != http.StatusNotFound && code != http.StatusConflict
+}
+
+// apiVerb maps a request to the Kubernetes API verb it performs (get, list,
+// watch, create, update, patch, delete, deletecollection), from the method
+// and whether the path names a single object or a collection.
+func apiVerb(req *http.Request) string {
+	switch req.Method {
+	case http.MethodPost:
+		return "create"
+	case http.MethodPut:
+		return "update"
+	case http.MethodPatch:
+		return "patch"
+	case http.MethodDelete:
+		if isCollectionPath(req.URL.Path) {
+			return "deletecollection"
+		}
+		return "delete"
+	case http.MethodGet:
+		if w := req.URL.Query().Get("watch"); w == "true" || w == "1" {
+			return "watch"
+		}
+		if isCollectionPath(req.URL.Path) {
+			return "list"
+		}
+		return "get"
+	}
+	return strings.ToLower(req.Method)
+}
+
+// isCollectionPath reports whether an API path names a collection:
+// /api/v1/pods, /apis/apps/v1/namespaces/ns/deployments. Paths outside
+// /api and /apis (discovery, /version) count as objects.
+func isCollectionPath(path string) bool {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(parts) >= 2 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) >= 3 && parts[0] == "apis":
+		parts = parts[3:]
+	default:
+		return false
+	}
+	if len(parts) >= 3 && parts[0] == "namespaces" {
+		parts = parts[2:]
+	}
+	return len(parts) == 1
+}
@@ -0,0 +1,73 @@
+package k8s
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vigneshsubbiah/shipit/internal/metrics"
+)
+
+func TestAPIVerb(t *testing.T) {
+	for _, tc := range []struct {
+		method, url, want string
+	}{
+		{"GET", "/api/v1/namespaces/default/pods", "list"},
+		{"GET", "/api/v1/namespaces/default/pods/web-a", "get"},
+		{"GET", "/api/v1/namespaces/default/pods/web-a/log", "get"},
+		{"GET", "/apis/apps/v1/namespaces/default/deployments?watch=true", "watch"},
+		{"GET", "/api/v1/namespaces", "list"},
+		{"GET", "/api/v1/namespaces/default", "get"},
+		{"GET", "/api/v1/nodes", "list"},
+		{"GET", "/version", "get"},
+		{"POST", "/apis/batch/v1/namespaces/default/jobs", "create"},
+		{"PUT", "/apis/apps/v1/namespaces/default/deployments/web", "update"},
+		{"PATCH", "/apis/apps/v1/namespaces/default/deployments/web", "patch"},
+		{"DELETE", "/api/v1/namespaces/default/pods/web-a", "delete"},
+		{"DELETE", "/api/v1/namespaces/default/pods", "deletecollection"},
+	} {
+		req := httptest.NewRequest(tc.method, tc.url, nil)
+		if got := apiVerb(req); got != tc.want {
+			t.Errorf("apiVerb(%s %s) = %q, want %q", tc.method, tc.url, got, tc.want)
+		}
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestAPIErrorCounter(t *testing.T) {
+	respond := func(code int, err error) apiErrorCounter {
+		return apiErrorCounter{next: roundTripFunc(func(*http.Request) (*http.Response, error) {
+			if err != nil {
+				return nil, err
+			}
+			return &http.Response{StatusCode: code, Body: http.NoBody}, nil
+		})}
+	}
+	patch := httptest.NewRequest("PATCH", "/apis/apps/v1/namespaces/default/deployments/countertest", nil)
+	get := httptest.NewRequest("GET", "/apis/apps/v1/namespaces/default/deployments/countertest", nil)
+
+	respond(http.StatusForbidden, nil).RoundTrip(patch)
+	respond(http.StatusForbidden, nil).RoundTrip(patch)
+	respond(0, errors.New("connection refused")).RoundTrip(patch)
+	respond(http.StatusNotFound, nil).RoundTrip(get)
+	respond(http.StatusOK, nil).RoundTrip(get)
+
+	for _, tc := range []struct {
+		verb, code string
+		want       float64
+	}{
+		{"patch", "403", 2},
+		{"patch", "error", 1},
+		{"get", "404", 0}, // NotFound is an answer, not an error
+		{"get", "200", 0},
+	} {
+		if got := testutil.ToFloat64(metrics.K8sAPIErrorsTotal.WithLabelValues(tc.verb, tc.code)); got != tc.want {
+			t.Errorf("shipit_k8s_api_errors_total{verb=%q,code=%q} = %v, want %v", tc.verb, tc.code, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Blue/green colors. A blue/green app runs up to two complete Deployments,
+// <name>-blue and <name>-green, whose pods carry app=<name> plus a color
+// label. The Service selects exactly one color; switching traffic is a
+// single Service update.
+const (
+	ColorBlue  = "blue"
+	ColorGreen = "green"
+)
+
+// DeploymentName returns the Deployment name for an app and blue/green
+// color; an empty color is the plain rolling-update Deployment.
+func DeploymentName(name, color string) string {
+	if color == "" {
+		return name
+	}
+	return name + "-" + color
+}
+
+// OtherColor returns the color a blue/green deploy should go to next.
+func OtherColor(color string) string {
+	if color == ColorBlue {
+		return ColorGreen
+	}
+	return ColorBlue
+}
+
+// podLabelsFor is the pod label set (and Deployment/Service selector) for
+// an app and color. app=<name> stays on every color so logs, exec and the
+// PDB keep finding the app's pods.
+func podLabelsFor(name, color string) map[string]string {
+	labels := map[string]string{"app": name}
+	if color != "" {
+		labels["color"] = color
+	}
+	return labels
+}
+
+// SwitchServiceColor points the app's Service at the given color's pods.
+// The selector change is one Service update, so traffic moves from one
+// color to the other at once rather than pod by pod.
+func (c *Client) SwitchServiceColor(req DeployRequest, color string) error {
+	if req.Port == nil {
+		return fmt.Errorf("blue/green switchover needs a port")
+	}
+	req.Color = color
+	if err := c.ensureService(req); err != nil {
+		return fmt.Errorf("failed to switch service to %s: %w", color, err)
+	}
+	return nil
+}
+
+// AdoptIntoColor moves an app deployed with the rolling strategy onto a
+// blue/green color. The pre-existing <name> Deployment's pods have no
+// color label, so a Service selecting app=<name> would also pick up the
+// first new color's pods as soon as they turn ready — exactly the mixed
+// versions blue/green exists to avoid. Instead, the <name> Deployment's
+// pod template is copied verbatim into <name>-<color>, the Service is
+// switched to it once ready, and the old Deployment is deleted. Returns
+// false (and does nothing) when there is no <name> Deployment to adopt.
+func (c *Client) AdoptIntoColor(ctx context.Context, req DeployRequest, color string) (bool, error) {
+	deploymentsClient := c.clientset.AppsV1().Deployments(req.Namespace)
+
+	legacy, err := deploymentsClient.Get(ctx, req.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get deployment: %w", err)
+	}
+
+	name := DeploymentName(req.Name, color)
+	labels := map[string]string{"managed-by": "shipit"}
+	for k, v := range podLabelsFor(req.Name, color) {
+		labels[k] = v
+	}
+	adopted := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: req.Namespace,
+			Labels:    labels,
+		},
+		Spec: *legacy.Spec.DeepCopy(),
+	}
+	adopted.Spec.Selector = &metav1.LabelSelector{MatchLabels: podLabelsFor(req.Name, color)}
+	adopted.Spec.Template.Labels = podLabelsFor(req.Name, color)
+
+	existing, err := deploymentsClient.Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		if _, err := deploymentsClient.Create(ctx, adopted, metav1.CreateOptions{}); err != nil {
+			return false, fmt.Errorf("failed to create %s deployment: %w", color, err)
+		}
+	case err != nil:
+		return false, fmt.Errorf("failed to get %s deployment: %w", color, err)
+	default:
+		adopted.ResourceVersion = existing.ResourceVersion
+		if _, err := deploymentsClient.Update(ctx, adopted, metav1.UpdateOptions{}); err != nil {
+			return false, fmt.Errorf("failed to update %s deployment: %w", color, err)
+		}
+	}
+
+	watchCtx, cancel := context.WithTimeout(ctx, progressDeadline(adopted)+10*time.Second)
+	err = c.WatchRollout(watchCtx, name, req.Namespace)
+	cancel()
+	if err != nil {
+		return false, fmt.Errorf("%s copy of the current deployment did not become ready: %w", color, err)
+	}
+
+	if err := c.SwitchServiceColor(req, color); err != nil {
+		return false, err
+	}
+	if err := deploymentsClient.Delete(ctx, req.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return false, fmt.Errorf("failed to delete adopted deployment: %w", err)
+	}
+	return true, nil
+}
+
+// ScaleDeployment sets a Deployment's replica count. Used to park the idle
+// blue/green color at zero once its rollback window has passed, and to stop
+// a color that failed to come up.
+func (c *Client) ScaleDeployment(ctx context.Context, name, namespace string, replicas int32) error {
+	deploymentsClient := c.clientset.AppsV1().Deployments(namespace)
+	dep, err := deploymentsClient.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	dep.Spec.Replicas = &replicas
+	_, err = deploymentsClient.Update(ctx, dep, metav1.UpdateOptions{})
+	return err
+}
+
+// DeleteColors removes both blue/green color Deployments. Missing ones are
+// ignored.
+func (c *Client) DeleteColors(ctx context.Context, name, namespace string) error {
+	deploymentsClient := c.clientset.AppsV1().Deployments(namespace)
+	for _, color := range []string{ColorBlue, ColorGreen} {
+		err := deploymentsClient.Delete(ctx, DeploymentName(name, color), metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete %s deployment: %w", color, err)
+		}
+	}
+	return nil
+}
+
+// ServiceColor returns the color the app's Service currently selects, or ""
+// if it selects all of the app's pods.
+func (c *Client) ServiceColor(ctx context.Context, name, namespace string) (string, error) {
+	svc, err := c.clientset.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	return svc.Spec.Selector["color"], nil
+}
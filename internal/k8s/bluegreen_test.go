@@ -0,0 +1,196 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func blueGreenRequest(color string) DeployRequest {
+	port := 8080
+	return DeployRequest{
+		Name:      "svc",
+		Namespace: "default",
+		Image:     "r/app:" + color,
+		Replicas:  3,
+		Port:      &port,
+		Color:     color,
+	}
+}
+
+func TestDeploymentNameAndOtherColor(t *testing.T) {
+	if got := DeploymentName("svc", ""); got != "svc" {
+		t.Errorf("DeploymentName without color = %q, want svc", got)
+	}
+	if got := DeploymentName("svc", ColorGreen); got != "svc-green" {
+		t.Errorf("DeploymentName green = %q, want svc-green", got)
+	}
+	if OtherColor("") != ColorBlue || OtherColor(ColorBlue) != ColorGreen || OtherColor(ColorGreen) != ColorBlue {
+		t.Error("OtherColor must go blue first, then alternate")
+	}
+}
+
+func TestDeployApp_ColorLeavesServiceOnActiveColor(t *testing.T) {
+	c := newTestClient()
+	ctx := context.Background()
+
+	if err := c.DeployApp(blueGreenRequest(ColorBlue)); err != nil {
+		t.Fatalf("DeployApp blue: %v", err)
+	}
+	if err := c.SwitchServiceColor(blueGreenRequest(ColorBlue), ColorBlue); err != nil {
+		t.Fatalf("SwitchServiceColor blue: %v", err)
+	}
+	if err := c.DeployApp(blueGreenRequest(ColorGreen)); err != nil {
+		t.Fatalf("DeployApp green: %v", err)
+	}
+
+	green, err := c.clientset.AppsV1().Deployments("default").Get(ctx, "svc-green", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get green deployment: %v", err)
+	}
+	labels := green.Spec.Template.Labels
+	if labels["app"] != "svc" || labels["color"] != ColorGreen {
+		t.Errorf("green pod labels = %v, want app=svc color=green", labels)
+	}
+	if got := green.Spec.Selector.MatchLabels["color"]; got != ColorGreen {
+		t.Errorf("green selector color = %q", got)
+	}
+	if _, err := c.clientset.AppsV1().Deployments("default").Get(ctx, "svc-blue", metav1.GetOptions{}); err != nil {
+		t.Errorf("blue deployment must keep running: %v", err)
+	}
+
+	svc, err := c.clientset.CoreV1().Services("default").Get(ctx, "svc", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get service: %v", err)
+	}
+	if got := svc.Spec.Selector["color"]; got != ColorBlue {
+		t.Errorf("service color = %q, want blue until the switchover", got)
+	}
+
+	if err := c.SwitchServiceColor(blueGreenRequest(ColorGreen), ColorGreen); err != nil {
+		t.Fatalf("SwitchServiceColor green: %v", err)
+	}
+	if got, _ := c.ServiceColor(ctx, "svc", "default"); got != ColorGreen {
+		t.Errorf("service color after switch = %q, want green", got)
+	}
+}
+
+func TestSwitchServiceColor_RequiresPort(t *testing.T) {
+	c := newTestClient()
+	req := blueGreenRequest(ColorBlue)
+	req.Port = nil
+	if err := c.SwitchServiceColor(req, ColorBlue); err == nil {
+		t.Fatal("expected error without a port")
+	}
+}
+
+func TestAdoptIntoColor_MovesRollingDeploymentToBlue(t *testing.T) {
+	c := newTestClient()
+	ctx := context.Background()
+
+	// The fake clientset runs no controllers; report every new Deployment
+	// as fully rolled out so WatchRollout returns on its first read.
+	c.clientset.(*fake.Clientset).PrependReactor("create", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		dep := action.(k8stesting.CreateAction).GetObject().(*appsv1.Deployment)
+		replicas := *dep.Spec.Replicas
+		dep.Status = appsv1.DeploymentStatus{
+			Replicas:          replicas,
+			UpdatedReplicas:   replicas,
+			ReadyReplicas:     replicas,
+			AvailableReplicas: replicas,
+		}
+		return false, nil, nil
+	})
+
+	rolling := blueGreenRequest("")
+	rolling.Image = "r/app:v1"
+	if err := c.DeployApp(rolling); err != nil {
+		t.Fatalf("DeployApp rolling: %v", err)
+	}
+
+	watchCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	adopted, err := c.AdoptIntoColor(watchCtx, rolling, ColorBlue)
+	if err != nil {
+		t.Fatalf("AdoptIntoColor: %v", err)
+	}
+	if !adopted {
+		t.Fatal("expected the rolling deployment to be adopted")
+	}
+
+	blue, err := c.clientset.AppsV1().Deployments("default").Get(ctx, "svc-blue", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get blue deployment: %v", err)
+	}
+	if got := blue.Spec.Template.Spec.Containers[0].Image; got != "r/app:v1" {
+		t.Errorf("blue image = %q, want the adopted r/app:v1", got)
+	}
+	if got := blue.Spec.Template.Labels["color"]; got != ColorBlue {
+		t.Errorf("blue pod color label = %q", got)
+	}
+	if _, err := c.clientset.AppsV1().Deployments("default").Get(ctx, "svc", metav1.GetOptions{}); err == nil {
+		t.Error("legacy deployment should be deleted after adoption")
+	}
+	if got, _ := c.ServiceColor(ctx, "svc", "default"); got != ColorBlue {
+		t.Errorf("service color = %q, want blue", got)
+	}
+
+	// Nothing left to adopt the second time.
+	adopted, err = c.AdoptIntoColor(watchCtx, rolling, ColorBlue)
+	if err != nil || adopted {
+		t.Errorf("second AdoptIntoColor = %v, %v; want false, nil", adopted, err)
+	}
+}
+
+func TestScaleDeploymentAndDeleteColors(t *testing.T) {
+	c := newTestClient(readyDeployment("svc-blue", "default", 3), readyDeployment("svc-green", "default", 3))
+	ctx := context.Background()
+
+	if err := c.ScaleDeployment(ctx, "svc-blue", "default", 0); err != nil {
+		t.Fatalf("ScaleDeployment: %v", err)
+	}
+	blue, _ := c.clientset.AppsV1().Deployments("default").Get(ctx, "svc-blue", metav1.GetOptions{})
+	if *blue.Spec.Replicas != 0 {
+		t.Errorf("blue replicas = %d, want 0", *blue.Spec.Replicas)
+	}
+
+	if err := c.DeleteColors(ctx, "svc", "default"); err != nil {
+		t.Fatalf("DeleteColors: %v", err)
+	}
+	for _, name := range []string{"svc-blue", "svc-green"} {
+		if _, err := c.clientset.AppsV1().Deployments("default").Get(ctx, name, metav1.GetOptions{}); err == nil {
+			t.Errorf("%s still exists", name)
+		}
+	}
+	if err := c.DeleteColors(ctx, "svc", "default"); err != nil {
+		t.Errorf("second DeleteColors: %v", err)
+	}
+}
+
+func TestGetEnhancedDeploymentStatus_ColorSelector(t *testing.T) {
+	dep := readyDeployment("svc-green", "default", 1)
+	dep.Spec.Selector = &metav1.LabelSelector{MatchLabels: podLabelsFor("svc", ColorGreen)}
+	pod := func(name, color string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    podLabelsFor("svc", color),
+		}}
+	}
+	c := newTestClient(dep, pod("svc-green-a", ColorGreen), pod("svc-blue-a", ColorBlue))
+
+	status, err := c.GetEnhancedDeploymentStatus("svc-green", "default")
+	if err != nil {
+		t.Fatalf("GetEnhancedDeploymentStatus: %v", err)
+	}
+	if len(status.Pods) != 1 || status.Pods[0].Name != "svc-green-a" {
+		t.Errorf("pods = %+v, want only the green pod", status.Pods)
+	}
+}
@@ -0,0 +1,166 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ingress-nginx canary annotations. A second Ingress for the same host and
+// path carrying these gets canary-weight percent of the primary's traffic.
+// See https://kubernetes.github.io/ingress-nginx/user-guide/nginx-configuration/annotations/#canary
+const (
+	canaryAnnotation       = "nginx.ingress.kubernetes.io/canary"
+	canaryWeightAnnotation = "nginx.ingress.kubernetes.io/canary-weight"
+)
+
+// CanaryName is the name shared by an app's canary Deployment, Service and
+// Ingress. The canary pods are labelled app=<name>-canary so the primary
+// Service (selector app=<name>) never routes to them directly; all canary
+// traffic goes through the weighted Ingress.
+func CanaryName(name string) string {
+	return name + "-canary"
+}
+
+// canaryReplicas sizes the canary Deployment for the share of traffic it is
+// about to receive: weight percent of the fleet, rounded up, never below one
+// pod.
+func canaryReplicas(fleet int32, weight int) int32 {
+	n := (int64(fleet)*int64(weight) + 99) / 100
+	if n < 1 {
+		n = 1
+	}
+	return int32(n)
+}
+
+// DeployCanary creates or updates the <name>-canary Deployment and Service
+// running req's pod spec, sized for weight percent of the primary fleet. It
+// does not touch traffic; SetCanaryWeight does that once the canary pods
+// are ready. Re-applying with only a new weight rescales the canary without
+// restarting its pods.
+func (c *Client) DeployCanary(req DeployRequest, weight int) error {
+	ctx := context.Background()
+	name := CanaryName(req.Name)
+	deploymentsClient := c.clientset.AppsV1().Deployments(req.Namespace)
+
+	primary, err := deploymentsClient.Get(ctx, req.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get primary deployment: %w", err)
+	}
+	replicas := canaryReplicas(effectiveFleet(req, primary), weight)
+
+	terminationGrace := int64(30)
+	progressDeadline := int32(600)
+	historyLimit := int32(2)
+	labels := map[string]string{"app": name, "track": "canary"}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   req.Namespace,
+			Labels:      map[string]string{"app": name, "track": "canary", "managed-by": "shipit"},
+			Annotations: deploymentAnnotationsFor(req),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": name},
+			},
+			ProgressDeadlineSeconds: &progressDeadline,
+			RevisionHistoryLimit:    &historyLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels, Annotations: podAnnotationsFor(req)},
+				Spec: corev1.PodSpec{
+					Containers:                    []corev1.Container{buildAppContainer(req)},
+					TerminationGracePeriodSeconds: &terminationGrace,
+					TopologySpreadConstraints:     topologySpreadFor(name),
+				},
+			},
+		},
+	}
+
+	existing, err := deploymentsClient.Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		if _, err := deploymentsClient.Create(ctx, deployment, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create canary deployment: %w", err)
+		}
+	case err != nil:
+		return fmt.Errorf("failed to get canary deployment: %w", err)
+	default:
+		deployment.ResourceVersion = existing.ResourceVersion
+		if _, err := deploymentsClient.Update(ctx, deployment, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update canary deployment: %w", err)
+		}
+	}
+
+	if req.Port != nil {
+		canaryReq := req
+		canaryReq.Name = name
+		if err := c.ensureService(canaryReq); err != nil {
+			return fmt.Errorf("failed to ensure canary service: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetCanaryWeight points weight percent of the app's default-URL traffic at
+// the canary Service. The canary Ingress mirrors the one ensureIngress
+// builds (same host and path) minus TLS: the primary Ingress owns the
+// certificate and ingress-nginx serves it for both.
+func (c *Client) SetCanaryWeight(req DeployRequest, weight int) error {
+	if req.BaseDomain == "" || req.Port == nil {
+		return fmt.Errorf("canary traffic shifting needs a base domain and a port")
+	}
+	name := CanaryName(req.Name)
+
+	ingress := appIngress(req)
+	ingress.Name = name
+	ingress.Labels = map[string]string{"app": name, "track": "canary", "managed-by": "shipit"}
+	ingress.Annotations = map[string]string{
+		canaryAnnotation:       "true",
+		canaryWeightAnnotation: strconv.Itoa(weight),
+	}
+	ingress.Spec.TLS = nil
+	for _, rule := range ingress.Spec.Rules {
+		for i := range rule.HTTP.Paths {
+			rule.HTTP.Paths[i].Backend.Service.Name = name
+		}
+	}
+
+	if err := c.applyIngress(context.Background(), ingress); err != nil {
+		return fmt.Errorf("failed to set canary weight: %w", err)
+	}
+	return nil
+}
+
+// DeleteCanary removes the canary Ingress, Service and Deployment, in that
+// order so traffic is withdrawn before the pods go away. Missing objects are
+// not an error: promote, abort and the reconciler may all race to clean up.
+func (c *Client) DeleteCanary(name, namespace string) error {
+	ctx := context.Background()
+	canary := CanaryName(name)
+
+	deletes := []func() error{
+		func() error {
+			return c.clientset.NetworkingV1().Ingresses(namespace).Delete(ctx, canary, metav1.DeleteOptions{})
+		},
+		func() error {
+			return c.clientset.CoreV1().Services(namespace).Delete(ctx, canary, metav1.DeleteOptions{})
+		},
+		func() error {
+			return c.clientset.AppsV1().Deployments(namespace).Delete(ctx, canary, metav1.DeleteOptions{})
+		},
+	}
+	for _, del := range deletes {
+		if err := del(); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete canary: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCanaryReplicas(t *testing.T) {
+	cases := []struct {
+		fleet  int32
+		weight int
+		want   int32
+	}{
+		{fleet: 10, weight: 20, want: 2},
+		{fleet: 10, weight: 25, want: 3}, // rounds up
+		{fleet: 3, weight: 10, want: 1},
+		{fleet: 1, weight: 0, want: 1}, // never below one pod
+		{fleet: 4, weight: 100, want: 4},
+	}
+	for _, tc := range cases {
+		if got := canaryReplicas(tc.fleet, tc.weight); got != tc.want {
+			t.Errorf("canaryReplicas(%d, %d) = %d, want %d", tc.fleet, tc.weight, got, tc.want)
+		}
+	}
+}
+
+func canaryRequest() DeployRequest {
+	port := 8080
+	return DeployRequest{
+		Name:       "svc",
+		Namespace:  "default",
+		Image:      "r/app:new",
+		Replicas:   10,
+		Port:       &port,
+		BaseDomain: "apps.example.com",
+	}
+}
+
+func TestDeployCanary_RunsParallelDeployment(t *testing.T) {
+	c := newTestClient(readyDeployment("svc", "default", 10))
+	req := canaryRequest()
+
+	if err := c.DeployCanary(req, 20); err != nil {
+		t.Fatalf("DeployCanary: %v", err)
+	}
+
+	ctx := context.Background()
+	dep, err := c.clientset.AppsV1().Deployments("default").Get(ctx, "svc-canary", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get canary deployment: %v", err)
+	}
+	if got := *dep.Spec.Replicas; got != 2 {
+		t.Errorf("canary replicas = %d, want 2 (20%% of 10)", got)
+	}
+	if got := dep.Spec.Template.Labels["app"]; got != "svc-canary" {
+		t.Errorf("canary pod label app=%q, want svc-canary (must not match the primary Service)", got)
+	}
+	if got := dep.Spec.Template.Spec.Containers[0].Image; got != "r/app:new" {
+		t.Errorf("canary image = %q", got)
+	}
+
+	svc, err := c.clientset.CoreV1().Services("default").Get(ctx, "svc-canary", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get canary service: %v", err)
+	}
+	if got := svc.Spec.Selector["app"]; got != "svc-canary" {
+		t.Errorf("canary service selector app=%q, want svc-canary", got)
+	}
+
+	primary, _ := c.clientset.AppsV1().Deployments("default").Get(ctx, "svc", metav1.GetOptions{})
+	if *primary.Spec.Replicas != 10 {
+		t.Errorf("primary replicas changed to %d", *primary.Spec.Replicas)
+	}
+
+	// Re-applying at a higher weight rescales in place.
+	if err := c.DeployCanary(req, 50); err != nil {
+		t.Fatalf("DeployCanary rescale: %v", err)
+	}
+	dep, _ = c.clientset.AppsV1().Deployments("default").Get(ctx, "svc-canary", metav1.GetOptions{})
+	if got := *dep.Spec.Replicas; got != 5 {
+		t.Errorf("canary replicas after rescale = %d, want 5", got)
+	}
+}
+
+func TestDeployCanary_RequiresPrimary(t *testing.T) {
+	c := newTestClient()
+	if err := c.DeployCanary(canaryRequest(), 20); err == nil {
+		t.Fatal("expected error when the primary deployment does not exist")
+	}
+}
+
+func TestSetCanaryWeight_AnnotatesCanaryIngress(t *testing.T) {
+	c := newTestClient()
+	req := canaryRequest()
+	if err := c.ensureIngress(req); err != nil {
+		t.Fatalf("ensureIngress: %v", err)
+	}
+
+	if err := c.SetCanaryWeight(req, 20); err != nil {
+		t.Fatalf("SetCanaryWeight: %v", err)
+	}
+	if err := c.SetCanaryWeight(req, 40); err != nil {
+		t.Fatalf("SetCanaryWeight update: %v", err)
+	}
+
+	ctx := context.Background()
+	ing, err := c.clientset.NetworkingV1().Ingresses("default").Get(ctx, "svc-canary", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get canary ingress: %v", err)
+	}
+	if ing.Annotations[canaryAnnotation] != "true" {
+		t.Error("canary annotation missing")
+	}
+	if got := ing.Annotations[canaryWeightAnnotation]; got != "40" {
+		t.Errorf("canary weight = %q, want 40", got)
+	}
+	if len(ing.Spec.TLS) != 0 {
+		t.Error("canary ingress must not request its own certificate")
+	}
+	rule := ing.Spec.Rules[0]
+	if rule.Host != "svc.apps.example.com" {
+		t.Errorf("canary host = %q, want the primary's host", rule.Host)
+	}
+	if got := rule.HTTP.Paths[0].Backend.Service.Name; got != "svc-canary" {
+		t.Errorf("canary backend = %q, want svc-canary", got)
+	}
+
+	primary, err := c.clientset.NetworkingV1().Ingresses("default").Get(ctx, "svc", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get primary ingress: %v", err)
+	}
+	if _, ok := primary.Annotations[canaryAnnotation]; ok {
+		t.Error("primary ingress must not carry the canary annotation")
+	}
+	if got := primary.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Name; got != "svc" {
+		t.Errorf("primary backend = %q, want svc", got)
+	}
+}
+
+func TestSetCanaryWeight_RequiresIngress(t *testing.T) {
+	c := newTestClient()
+	req := canaryRequest()
+	req.BaseDomain = ""
+	if err := c.SetCanaryWeight(req, 20); err == nil {
+		t.Fatal("expected error without a base domain")
+	}
+}
+
+func TestDeleteCanary(t *testing.T) {
+	c := newTestClient(readyDeployment("svc", "default", 10))
+	req := canaryRequest()
+	if err := c.DeployCanary(req, 20); err != nil {
+		t.Fatalf("DeployCanary: %v", err)
+	}
+	if err := c.SetCanaryWeight(req, 20); err != nil {
+		t.Fatalf("SetCanaryWeight: %v", err)
+	}
+
+	if err := c.DeleteCanary("svc", "default"); err != nil {
+		t.Fatalf("DeleteCanary: %v", err)
+	}
+	ctx := context.Background()
+	if _, err := c.clientset.AppsV1().Deployments("default").Get(ctx, "svc-canary", metav1.GetOptions{}); err == nil {
+		t.Error("canary deployment still exists")
+	}
+	if _, err := c.clientset.NetworkingV1().Ingresses("default").Get(ctx, "svc-canary", metav1.GetOptions{}); err == nil {
+		t.Error("canary ingress still exists")
+	}
+	if _, err := c.clientset.AppsV1().Deployments("default").Get(ctx, "svc", metav1.GetOptions{}); err != nil {
+		t.Errorf("primary deployment deleted: %v", err)
+	}
+
+	// Idempotent: a second delete finds nothing and succeeds.
+	if err := c.DeleteCanary("svc", "default"); err != nil {
+		t.Errorf("second DeleteCanary: %v", err)
+	}
+}
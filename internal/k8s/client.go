@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -72,6 +73,22 @@ type DeployRequest struct {
 
 	// Default ingress hostname (auto-generated URL)
 	BaseDomain string // e.g., "apps.shipit.unboundsec.dev" - if set, creates ingress at <name>.apps.shipit.unboundsec.dev
+
+	// Blue/green color. When set, DeployApp applies the <name>-<color>
+	// Deployment and leaves the Service selector alone; SwitchServiceColor
+	// moves traffic once the new color is ready.
+	Color string
+
+	// SecretChecksum of SecretName's data (see SecretChecksum). It is set
+	// as a pod template annotation, so a change to the secrets alone still
+	// rolls the pods; env from a Secret is only read at container start.
+	SecretChecksum string
+
+	// Revision is the shipit revision being deployed, recorded on the
+	// Deployment (RevisionAnnotation) so each pod's logs can be attributed
+	// to the revision it ran. It stays off the pod template, where it would
+	// restart every pod on every deploy.
+	Revision int
 }
 
 type DeploymentStatus struct {
@@ -81,6 +98,8 @@ type DeploymentStatus struct {
 	DesiredReplicas int32       `json:"desired_replicas"`
 	Status          string      `json:"status"`
 	Pods            []PodStatus `json:"pods"`
+	// Diagnosis explains pods that aren't ready; nil when all are.
+	Diagnosis *Diagnosis `json:"diagnosis,omitempty"`
 }
 
 type PodStatus struct {
@@ -130,6 +149,7 @@ func NewClient(kubeconfig []byte) (*Client, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse kubeconfig: %w", err)
 	}
+	InstrumentConfig(config)
 
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
@@ -175,6 +195,127 @@ func (c *Client) DeployApp(req DeployRequest) error {
 		return fmt.Errorf("failed to ensure namespace: %w", err)
 	}
 
+	container := buildAppContainer(req)
+	deploymentName := DeploymentName(req.Name, req.Color)
+	podLabels := podLabelsFor(req.Name, req.Color)
+
+	deploymentsClient := c.clientset.AppsV1().Deployments(req.Namespace)
+
+	// Fetch first so both the rolling-update budget and the HPA replica-
+	// preservation logic see the same, current cluster state. apierrors.IsNotFound
+	// marks this as a Create; any other error is fatal (e.g. permission denied —
+	// silently treating it as Create would stomp an unknown-state object).
+	existing, getErr := deploymentsClient.Get(ctx, deploymentName, metav1.GetOptions{})
+	if getErr != nil && !apierrors.IsNotFound(getErr) {
+		return fmt.Errorf("failed to get existing deployment: %w", getErr)
+	}
+
+	// Rolling-update budget should reflect the deployment's *actual* fleet size,
+	// not just req.Replicas. For HPA-scaled apps, Status.Replicas is the number
+	// of pods the controller will be cycling through; computing the budget from
+	// req.Replicas=3 on a 15-pod fleet means 1-pod-at-a-time rollouts even
+	// though 25%/25% would be safe and ~4× faster. Mirrors the PDB's effective-
+	// fleet logic for consistency (see ensurePodDisruptionBudget).
+	maxSurge, maxUnavailable := rollingUpdateBudget(effectiveFleet(req, existing))
+	terminationGrace := int64(30)
+	progressDeadline := int32(600)
+	historyLimit := int32(10)
+
+	deploymentLabels := map[string]string{"managed-by": "shipit"}
+	for k, v := range podLabels {
+		deploymentLabels[k] = v
+	}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        deploymentName,
+			Namespace:   req.Namespace,
+			Labels:      deploymentLabels,
+			Annotations: deploymentAnnotationsFor(req),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &req.Replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: podLabelsFor(req.Name, req.Color),
+			},
+			Strategy: appsv1.DeploymentStrategy{
+				Type: appsv1.RollingUpdateDeploymentStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateDeployment{
+					MaxSurge:       &maxSurge,
+					MaxUnavailable: &maxUnavailable,
+				},
+			},
+			ProgressDeadlineSeconds: &progressDeadline,
+			RevisionHistoryLimit:    &historyLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      podLabels,
+					Annotations: podAnnotationsFor(req),
+				},
+				Spec: corev1.PodSpec{
+					Containers:                    []corev1.Container{container},
+					TerminationGracePeriodSeconds: &terminationGrace,
+					TopologySpreadConstraints:     topologySpreadFor(req.Name),
+				},
+			},
+		},
+	}
+
+	if apierrors.IsNotFound(getErr) {
+		if _, err := deploymentsClient.Create(ctx, deployment, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create deployment: %w", err)
+		}
+	} else {
+		// When HPA owns the replica count, preserve whatever the HPA last set.
+		// Writing req.Replicas (the static DB value) on every deploy would fight
+		// the HPA controller: a 4→12 scale-up would bounce back to 4 on the
+		// next redeploy. See https://kubernetes.io/docs/tasks/run-application/horizontal-pod-autoscale/
+		if req.HPAEnabled && existing.Spec.Replicas != nil {
+			deployment.Spec.Replicas = existing.Spec.Replicas
+		}
+		deployment.ResourceVersion = existing.ResourceVersion
+		if _, err := deploymentsClient.Update(ctx, deployment, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update deployment: %w", err)
+		}
+	}
+
+	// Create service if port is specified. Blue/green deploys switch the
+	// Service separately, only once the new color is ready.
+	if req.Port != nil && req.Color == "" {
+		if err := c.ensureService(req); err != nil {
+			return err
+		}
+	}
+
+	// Create ingress for default URL if base domain is specified
+	if req.BaseDomain != "" && req.Port != nil {
+		if err := c.ensureIngress(req); err != nil {
+			return fmt.Errorf("failed to create ingress: %w", err)
+		}
+	}
+
+	// PDB: without this a node drain can evict every replica at once.
+	// Only meaningful for replicas >= 2; for single-replica apps a PDB of
+	// minAvailable=1 blocks all voluntary disruptions which is worse than
+	// accepting that a single-replica app is inherently non-HA.
+	if err := c.ensurePodDisruptionBudget(ctx, req); err != nil {
+		return fmt.Errorf("failed to reconcile poddisruptionbudget: %w", err)
+	}
+
+	// HPA: reconciled from the same app record every deploy so the cluster
+	// never drifts from the UI/DB. CreateOrUpdateHPA deletes the HPA when
+	// Enabled=false, so this one call handles enable/update/disable.
+	if err := c.reconcileHPA(req); err != nil {
+		return fmt.Errorf("failed to reconcile hpa: %w", err)
+	}
+
+	return nil
+}
+
+// buildAppContainer builds the app container (env, secrets, resources,
+// probes) from a DeployRequest. Shared by DeployApp and DeployCanary so the
+// canary runs exactly the pod spec a full rollout would.
+func buildAppContainer(req DeployRequest) corev1.Container {
 	// Build env vars
 	var envVars []corev1.EnvVar
 	for k, v := range req.EnvVars {
@@ -302,109 +443,7 @@ func (c *Client) DeployApp(req DeployRequest) error {
 		}
 	}
 
-	deploymentsClient := c.clientset.AppsV1().Deployments(req.Namespace)
-
-	// Fetch first so both the rolling-update budget and the HPA replica-
-	// preservation logic see the same, current cluster state. apierrors.IsNotFound
-	// marks this as a Create; any other error is fatal (e.g. permission denied —
-	// silently treating it as Create would stomp an unknown-state object).
-	existing, getErr := deploymentsClient.Get(ctx, req.Name, metav1.GetOptions{})
-	if getErr != nil && !apierrors.IsNotFound(getErr) {
-		return fmt.Errorf("failed to get existing deployment: %w", getErr)
-	}
-
-	// Rolling-update budget should reflect the deployment's *actual* fleet size,
-	// not just req.Replicas. For HPA-scaled apps, Status.Replicas is the number
-	// of pods the controller will be cycling through; computing the budget from
-	// req.Replicas=3 on a 15-pod fleet means 1-pod-at-a-time rollouts even
-	// though 25%/25% would be safe and ~4× faster. Mirrors the PDB's effective-
-	// fleet logic for consistency (see ensurePodDisruptionBudget).
-	maxSurge, maxUnavailable := rollingUpdateBudget(effectiveFleet(req, existing))
-	terminationGrace := int64(30)
-	progressDeadline := int32(600)
-	historyLimit := int32(10)
-
-	deployment := &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      req.Name,
-			Namespace: req.Namespace,
-			Labels:    map[string]string{"app": req.Name, "managed-by": "shipit"},
-		},
-		Spec: appsv1.DeploymentSpec{
-			Replicas: &req.Replicas,
-			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{"app": req.Name},
-			},
-			Strategy: appsv1.DeploymentStrategy{
-				Type: appsv1.RollingUpdateDeploymentStrategyType,
-				RollingUpdate: &appsv1.RollingUpdateDeployment{
-					MaxSurge:       &maxSurge,
-					MaxUnavailable: &maxUnavailable,
-				},
-			},
-			ProgressDeadlineSeconds: &progressDeadline,
-			RevisionHistoryLimit:    &historyLimit,
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{"app": req.Name},
-				},
-				Spec: corev1.PodSpec{
-					Containers:                    []corev1.Container{container},
-					TerminationGracePeriodSeconds: &terminationGrace,
-					TopologySpreadConstraints:     topologySpreadFor(req.Name),
-				},
-			},
-		},
-	}
-
-	if apierrors.IsNotFound(getErr) {
-		if _, err := deploymentsClient.Create(ctx, deployment, metav1.CreateOptions{}); err != nil {
-			return fmt.Errorf("failed to create deployment: %w", err)
-		}
-	} else {
-		// When HPA owns the replica count, preserve whatever the HPA last set.
-		// Writing req.Replicas (the static DB value) on every deploy would fight
-		// the HPA controller: a 4→12 scale-up would bounce back to 4 on the
-		// next redeploy. See https://kubernetes.io/docs/tasks/run-application/horizontal-pod-autoscale/
-		if req.HPAEnabled && existing.Spec.Replicas != nil {
-			deployment.Spec.Replicas = existing.Spec.Replicas
-		}
-		deployment.ResourceVersion = existing.ResourceVersion
-		if _, err := deploymentsClient.Update(ctx, deployment, metav1.UpdateOptions{}); err != nil {
-			return fmt.Errorf("failed to update deployment: %w", err)
-		}
-	}
-
-	// Create service if port is specified
-	if req.Port != nil {
-		if err := c.ensureService(req); err != nil {
-			return err
-		}
-	}
-
-	// Create ingress for default URL if base domain is specified
-	if req.BaseDomain != "" && req.Port != nil {
-		if err := c.ensureIngress(req); err != nil {
-			return fmt.Errorf("failed to create ingress: %w", err)
-		}
-	}
-
-	// PDB: without this a node drain can evict every replica at once.
-	// Only meaningful for replicas >= 2; for single-replica apps a PDB of
-	// minAvailable=1 blocks all voluntary disruptions which is worse than
-	// accepting that a single-replica app is inherently non-HA.
-	if err := c.ensurePodDisruptionBudget(ctx, req); err != nil {
-		return fmt.Errorf("failed to reconcile poddisruptionbudget: %w", err)
-	}
-
-	// HPA: reconciled from the same app record every deploy so the cluster
-	// never drifts from the UI/DB. CreateOrUpdateHPA deletes the HPA when
-	// Enabled=false, so this one call handles enable/update/disable.
-	if err := c.reconcileHPA(req); err != nil {
-		return fmt.Errorf("failed to reconcile hpa: %w", err)
-	}
-
-	return nil
+	return container
 }
 
 // minHPAReplicas is the floor enforced when HPA is enabled. A single-replica
@@ -640,7 +679,7 @@ func (c *Client) ensureService(req DeployRequest) error {
 			Labels:    map[string]string{"app": req.Name, "managed-by": "shipit"},
 		},
 		Spec: corev1.ServiceSpec{
-			Selector: map[string]string{"app": req.Name},
+			Selector: podLabelsFor(req.Name, req.Color),
 			Ports: []corev1.ServicePort{{
 				Port:       int32(*req.Port),
 				TargetPort: intstr.FromInt(*req.Port),
@@ -666,10 +705,12 @@ func (c *Client) ensureIngress(req DeployRequest) error {
 	if req.BaseDomain == "" || req.Port == nil {
 		return nil // No base domain or port, skip ingress
 	}
+	return c.applyIngress(context.Background(), appIngress(req))
+}
 
-	ctx := context.Background()
-	ingressClient := c.clientset.NetworkingV1().Ingresses(req.Namespace)
-
+// appIngress builds the default-URL Ingress for the app. Callers must
+// check BaseDomain and Port are set.
+func appIngress(req DeployRequest) *networkingv1.Ingress {
 	// Construct hostname: <app-name>.<base-domain>
 	hostname := req.Name + "." + req.BaseDomain
 	pathType := networkingv1.PathTypePrefix
@@ -712,7 +753,14 @@ func (c *Client) ensureIngress(req DeployRequest) error {
 		},
 	}
 
-	existing, err := ingressClient.Get(ctx, req.Name, metav1.GetOptions{})
+	return ingress
+}
+
+// applyIngress creates the Ingress or updates it in place.
+func (c *Client) applyIngress(ctx context.Context, ingress *networkingv1.Ingress) error {
+	ingressClient := c.clientset.NetworkingV1().Ingresses(ingress.Namespace)
+
+	existing, err := ingressClient.Get(ctx, ingress.Name, metav1.GetOptions{})
 	if err != nil {
 		_, err = ingressClient.Create(ctx, ingress, metav1.CreateOptions{})
 		return err
@@ -742,9 +790,61 @@ func (c *Client) DeleteApp(name, namespace string) error {
 	// Delete secret (if exists)
 	c.clientset.CoreV1().Secrets(namespace).Delete(ctx, name+"-secrets", metav1.DeleteOptions{})
 
+	// Delete canary resources left by an in-progress canary deploy
+	c.DeleteCanary(name, namespace)
+
+	// Delete blue/green color deployments (if any)
+	c.DeleteColors(ctx, name, namespace)
+
 	return nil
 }
 
+// SecretChecksumAnnotation is the pod template annotation holding
+// DeployRequest.SecretChecksum.
+const SecretChecksumAnnotation = "shipit.dev/secret-checksum"
+
+// RevisionAnnotation is the Deployment annotation holding the shipit
+// revision number it rolls out. The deployment controller copies it to the
+// ReplicaSet running that revision, so a pod's revision is its
+// ReplicaSet's; redeploying an unchanged pod template only relabels the
+// current ReplicaSet.
+const RevisionAnnotation = "shipit.dev/revision"
+
+// SecretChecksum returns fingerprint (a keyed hash, such as
+// auth.Keyring.Fingerprint) over a Secret's data, independent of map
+// order. It ends up in the pod template, readable by anyone who can read
+// the Deployment; an unkeyed hash there would let them test guesses of
+// low-entropy values offline.
+func SecretChecksum(data map[string]string, fingerprint func([]byte) string) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b bytes.Buffer
+	for _, k := range keys {
+		// Length-prefix both parts so "a"+"bc" and "ab"+"c" differ.
+		fmt.Fprintf(&b, "%d:%s%d:%s", len(k), k, len(data[k]), data[k])
+	}
+	return fingerprint(b.Bytes())
+}
+
+// podAnnotationsFor returns the pod template annotations for a deploy.
+func podAnnotationsFor(req DeployRequest) map[string]string {
+	if req.SecretChecksum == "" {
+		return nil
+	}
+	return map[string]string{SecretChecksumAnnotation: req.SecretChecksum}
+}
+
+// deploymentAnnotationsFor returns the Deployment annotations for a deploy.
+func deploymentAnnotationsFor(req DeployRequest) map[string]string {
+	if req.Revision <= 0 {
+		return nil
+	}
+	return map[string]string{RevisionAnnotation: strconv.Itoa(req.Revision)}
+}
+
 // CreateOrUpdateSecret creates or updates a K8s Secret with the given key-value pairs
 func (c *Client) CreateOrUpdateSecret(name, namespace string, data map[string]string) error {
 	ctx := context.Background()
@@ -860,36 +960,6 @@ func formatDuration(d time.Duration) string {
 	return fmt.Sprintf("%dd", int(d.Hours()/24))
 }
 
-func (c *Client) GetLogs(appName, namespace string, follow bool, tail string) (io.ReadCloser, error) {
-	// Get pods for this app
-	pods, err := c.clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("app=%s", appName),
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	if len(pods.Items) == 0 {
-		return nil, fmt.Errorf("no pods found for app %s", appName)
-	}
-
-	// Get logs from first pod (simplification for V1)
-	podName := pods.Items[0].Name
-
-	opts := &corev1.PodLogOptions{
-		Follow: follow,
-	}
-
-	if tail != "" {
-		if lines, err := strconv.ParseInt(tail, 10, 64); err == nil {
-			opts.TailLines = &lines
-		}
-	}
-
-	req := c.clientset.CoreV1().Pods(namespace).GetLogs(podName, opts)
-	return req.Stream(context.Background())
-}
-
 // CreateOrUpdateHPA creates or updates a Horizontal Pod Autoscaler for a deployment
 func (c *Client) CreateOrUpdateHPA(name, namespace string, config HPAConfig) error {
 	ctx := context.Background()
@@ -1284,16 +1354,22 @@ func (c *Client) GetEnhancedDeploymentStatus(name, namespace string) (*Deploymen
 		status = "pending"
 	}
 
-	// Get pods for this deployment
+	// Get pods for this deployment. Select with the Deployment's own
+	// selector rather than app=<name>: a blue/green color Deployment's name
+	// differs from its pods' app label.
+	selector := fmt.Sprintf("app=%s", name)
+	if deployment.Spec.Selector != nil {
+		selector = metav1.FormatLabelSelector(deployment.Spec.Selector)
+	}
 	pods, err := c.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("app=%s", name),
+		LabelSelector: selector,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list pods: %w", err)
 	}
 
 	// Try to get pod metrics (may fail if metrics-server not available)
-	podMetrics, _ := c.GetPodMetrics(namespace, fmt.Sprintf("app=%s", name))
+	podMetrics, _ := c.GetPodMetrics(namespace, selector)
 
 	var podStatuses []PodStatus
 	for _, pod := range pods.Items {
@@ -1365,14 +1441,23 @@ func (c *Client) GetEnhancedDeploymentStatus(name, namespace string) (*Deploymen
 		podStatuses = append(podStatuses, podStatus)
 	}
 
-	return &DeploymentStatus{
+	result := &DeploymentStatus{
 		Name:            name,
 		Replicas:        *deployment.Spec.Replicas,
 		ReadyReplicas:   deployment.Status.ReadyReplicas,
 		DesiredReplicas: *deployment.Spec.Replicas,
 		Status:          status,
 		Pods:            podStatuses,
-	}, nil
+	}
+
+	// Explain unready pods from events and container states. Best effort,
+	// and without previous logs: the status is polled.
+	if status != "running" {
+		if diag, err := c.diagnose(ctx, namespace, selector, DiagnoseOptions{}); err == nil {
+			result.Diagnosis = diag
+		}
+	}
+	return result, nil
 }
 
 // PreDeployJobRequest contains parameters for running a pre-deploy job
@@ -3,6 +3,7 @@ package k8s
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -597,3 +598,37 @@ func TestDeletePod_NonExistentPod(t *testing.T) {
 		t.Fatal("expected error when deleting non-existent pod, got nil")
 	}
 }
+
+func TestPodAnnotationsFor(t *testing.T) {
+	if got := podAnnotationsFor(DeployRequest{Revision: 4}); got != nil {
+		t.Errorf("no checksum: got %v, want nil", got)
+	}
+	got := podAnnotationsFor(DeployRequest{SecretChecksum: "abc", Revision: 4})
+	if got[SecretChecksumAnnotation] != "abc" || len(got) != 1 {
+		t.Errorf("annotations = %v, want only the secret checksum", got)
+	}
+}
+
+// The revision goes on the Deployment, so redeploying an unchanged app
+// leaves the pod template, and its pods, alone.
+func TestDeployApp_RevisionOnDeploymentOnly(t *testing.T) {
+	c := newTestClient()
+	ctx := context.Background()
+	req := DeployRequest{Name: "web", Namespace: "default", Image: "nginx:1", Replicas: 1, Revision: 3}
+	if err := c.DeployApp(req); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := c.clientset.AppsV1().Deployments("default").Get(ctx, "web", metav1.GetOptions{})
+
+	req.Revision = 4
+	if err := c.DeployApp(req); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := c.clientset.AppsV1().Deployments("default").Get(ctx, "web", metav1.GetOptions{})
+	if got := second.Annotations[RevisionAnnotation]; got != "4" {
+		t.Errorf("deployment revision = %q, want 4", got)
+	}
+	if !reflect.DeepEqual(first.Spec.Template, second.Spec.Template) {
+		t.Error("pod template changed between deploys of the same app")
+	}
+}
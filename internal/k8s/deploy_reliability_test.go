@@ -2,6 +2,9 @@ package k8s
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"testing"
 
@@ -589,3 +592,50 @@ func TestDeployApp_NonNotFoundGetErrorSurfaces(t *testing.T) {
 		t.Fatal("expected error from non-NotFound Get, got nil")
 	}
 }
+
+func TestSecretChecksum(t *testing.T) {
+	keyed := func(key string) func([]byte) string {
+		return func(b []byte) string {
+			mac := hmac.New(sha256.New, []byte(key))
+			mac.Write(b)
+			return hex.EncodeToString(mac.Sum(nil))
+		}
+	}
+	fingerprint := keyed("k1")
+	a := SecretChecksum(map[string]string{"A": "1", "B": "2"}, fingerprint)
+	if a != SecretChecksum(map[string]string{"B": "2", "A": "1"}, fingerprint) {
+		t.Error("checksum should not depend on map order")
+	}
+	if a == SecretChecksum(map[string]string{"A": "1", "B": "3"}, fingerprint) {
+		t.Error("checksum should change with a value")
+	}
+	if SecretChecksum(map[string]string{"A": "bc"}, fingerprint) == SecretChecksum(map[string]string{"Ab": "c"}, fingerprint) {
+		t.Error("checksum should separate keys from values")
+	}
+	if a == SecretChecksum(map[string]string{"A": "1", "B": "2"}, keyed("k2")) {
+		t.Error("checksum should depend on the fingerprint key")
+	}
+}
+
+func TestDeployApp_SecretChecksumRollsPods(t *testing.T) {
+	c := newTestClient()
+	req := DeployRequest{
+		Name:           "svc",
+		Namespace:      "default",
+		Image:          "r/app:v1",
+		Replicas:       2,
+		SecretName:     "svc-secrets",
+		SecretChecksum: "abc",
+	}
+	if err := c.DeployApp(req); err != nil {
+		t.Fatalf("DeployApp: %v", err)
+	}
+	req.SecretChecksum = "def"
+	if err := c.DeployApp(req); err != nil {
+		t.Fatalf("DeployApp: %v", err)
+	}
+	dep, _ := c.clientset.AppsV1().Deployments("default").Get(context.Background(), "svc", metav1.GetOptions{})
+	if got := dep.Spec.Template.Annotations[SecretChecksumAnnotation]; got != "def" {
+		t.Errorf("pod template %s = %q, want %q", SecretChecksumAnnotation, got, "def")
+	}
+}
@@ -0,0 +1,353 @@
+package k8s
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// diagnoseEventWindow bounds how old a Warning event may be to count:
+	// kube keeps events for an hour by default, but one from a rollout two
+	// deploys ago says nothing about this one.
+	diagnoseEventWindow = time.Hour
+	maxDiagnoseEvents   = 20
+	// maxDiagnoseLogFetches caps the previous-log reads per diagnosis; one
+	// crashed container's tail usually explains its siblings.
+	maxDiagnoseLogFetches = 3
+	maxDiagnoseMessageLen = 240
+)
+
+// DiagnoseOptions tunes Diagnose.
+type DiagnoseOptions struct {
+	// LogLines is how many lines of each crashed container's previous log
+	// to include; 0 skips the log reads.
+	LogLines int64
+}
+
+// Diagnosis explains why an app's pods aren't healthy. Summary has one
+// line per distinct finding, container problems first; it is empty when
+// nothing looks wrong.
+type Diagnosis struct {
+	Summary    []string         `json:"summary"`
+	Containers []ContainerIssue `json:"containers"`
+	Events     []WarningEvent   `json:"events"`
+}
+
+// ContainerIssue is a container stuck waiting (ImagePullBackOff,
+// CrashLoopBackOff, CreateContainerConfigError, ...) or whose last run
+// ended badly (OOMKilled, a non-zero exit).
+type ContainerIssue struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Reason    string `json:"reason"`
+	Message   string `json:"message,omitempty"`
+	// LastState is why the previous instance terminated, e.g. OOMKilled
+	// or Error, and ExitCode its exit code.
+	LastState    string   `json:"last_state,omitempty"`
+	ExitCode     *int32   `json:"exit_code,omitempty"`
+	Restarts     int32    `json:"restarts"`
+	MemoryLimit  string   `json:"memory_limit,omitempty"`
+	PreviousLogs []string `json:"previous_logs,omitempty"`
+}
+
+// WarningEvent is a Warning Event on one of the app's Deployments,
+// ReplicaSets or pods.
+type WarningEvent struct {
+	Object   string    `json:"object"` // Kind/name
+	Reason   string    `json:"reason"`
+	Message  string    `json:"message"`
+	Count    int32     `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// String is the diagnosis on one line, for a deploy_message: the summary
+// followed by the last previous-log line of the first crashed container.
+func (d *Diagnosis) String() string {
+	if d == nil || len(d.Summary) == 0 {
+		return ""
+	}
+	s := strings.Join(d.Summary, "; ")
+	for _, issue := range d.Containers {
+		if n := len(issue.PreviousLogs); n > 0 {
+			s += " | last log: " + truncateMessage(issue.PreviousLogs[n-1])
+			break
+		}
+	}
+	return s
+}
+
+// Diagnose collects the root-cause signals for an app's pods (label
+// app=<name>, so every blue/green color): recent Warning events on its
+// Deployments, ReplicaSets and pods, containers that are stuck or crashed,
+// and, with opts.LogLines, the tail of each crashed container's previous
+// log.
+func (c *Client) Diagnose(ctx context.Context, appName, namespace string, opts DiagnoseOptions) (*Diagnosis, error) {
+	return c.diagnose(ctx, namespace, fmt.Sprintf("app=%s", appName), opts)
+}
+
+// diagnose is Diagnose for the pods, ReplicaSets and Deployments matching
+// selector. Deployments carry their pods' labels, so one selector finds all
+// three.
+func (c *Client) diagnose(ctx context.Context, namespace, selector string, opts DiagnoseOptions) (*Diagnosis, error) {
+	listOpts := metav1.ListOptions{LabelSelector: selector}
+	involved := make(map[string]bool)
+
+	deployments, err := c.clientset.AppsV1().Deployments(namespace).List(ctx, listOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments: %w", err)
+	}
+	for _, d := range deployments.Items {
+		involved["Deployment/"+d.Name] = true
+	}
+	replicaSets, err := c.clientset.AppsV1().ReplicaSets(namespace).List(ctx, listOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list replicasets: %w", err)
+	}
+	for _, rs := range replicaSets.Items {
+		involved["ReplicaSet/"+rs.Name] = true
+	}
+	pods, err := c.clientset.CoreV1().Pods(namespace).List(ctx, listOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+	for _, pod := range pods.Items {
+		involved["Pod/"+pod.Name] = true
+	}
+
+	diag := &Diagnosis{
+		Containers: containerIssues(pods.Items),
+		Events:     []WarningEvent{},
+	}
+
+	events, err := c.clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{FieldSelector: "type=Warning"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events: %w", err)
+	}
+	cutoff := time.Now().Add(-diagnoseEventWindow)
+	for _, e := range events.Items {
+		object := e.InvolvedObject.Kind + "/" + e.InvolvedObject.Name
+		if e.Type != corev1.EventTypeWarning || !involved[object] {
+			continue
+		}
+		seen := eventLastSeen(e)
+		if seen.Before(cutoff) {
+			continue
+		}
+		count := e.Count
+		if e.Series != nil && e.Series.Count > count {
+			count = e.Series.Count
+		}
+		if count == 0 {
+			count = 1
+		}
+		diag.Events = append(diag.Events, WarningEvent{
+			Object:   object,
+			Reason:   e.Reason,
+			Message:  strings.TrimSpace(e.Message),
+			Count:    count,
+			LastSeen: seen,
+		})
+	}
+	sort.SliceStable(diag.Events, func(i, j int) bool { return diag.Events[i].LastSeen.After(diag.Events[j].LastSeen) })
+	if len(diag.Events) > maxDiagnoseEvents {
+		diag.Events = diag.Events[:maxDiagnoseEvents]
+	}
+
+	if opts.LogLines > 0 {
+		fetched := make(map[string]bool)
+		for i := range diag.Containers {
+			issue := &diag.Containers[i]
+			if issue.Restarts == 0 || fetched[issue.Container] || len(fetched) >= maxDiagnoseLogFetches {
+				continue
+			}
+			fetched[issue.Container] = true
+			issue.PreviousLogs = c.previousLogTail(ctx, namespace, issue.Pod, issue.Container, opts.LogLines)
+		}
+	}
+
+	diag.Summary = summarize(diag)
+	return diag, nil
+}
+
+// containerIssues lists the containers of pods that are stuck waiting for
+// something other than a normal start, or whose previous run was killed or
+// failed.
+func containerIssues(pods []corev1.Pod) []ContainerIssue {
+	issues := []ContainerIssue{}
+	for _, pod := range pods {
+		limits := make(map[string]string)
+		for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+			for _, ct := range containers {
+				if mem, ok := ct.Resources.Limits[corev1.ResourceMemory]; ok {
+					limits[ct.Name] = mem.String()
+				}
+			}
+		}
+		var statuses []corev1.ContainerStatus
+		statuses = append(statuses, pod.Status.InitContainerStatuses...)
+		statuses = append(statuses, pod.Status.ContainerStatuses...)
+		for _, cs := range statuses {
+			issue := ContainerIssue{Pod: pod.Name, Container: cs.Name, Restarts: cs.RestartCount}
+			switch {
+			case cs.State.Waiting != nil && !normalWaitingReason(cs.State.Waiting.Reason):
+				issue.Reason = cs.State.Waiting.Reason
+				issue.Message = truncateMessage(cs.State.Waiting.Message)
+			case cs.State.Terminated != nil && cs.State.Terminated.ExitCode != 0:
+				issue.Reason = cs.State.Terminated.Reason
+				if issue.Reason == "" {
+					issue.Reason = "Error"
+				}
+				issue.Message = truncateMessage(cs.State.Terminated.Message)
+				code := cs.State.Terminated.ExitCode
+				issue.ExitCode = &code
+			}
+			if last := cs.LastTerminationState.Terminated; last != nil && (last.ExitCode != 0 || last.Reason == "OOMKilled") {
+				issue.LastState = last.Reason
+				if issue.ExitCode == nil {
+					code := last.ExitCode
+					issue.ExitCode = &code
+				}
+				if issue.Reason == "" && last.Reason == "OOMKilled" {
+					// Running again, but it was killed for memory.
+					issue.Reason = "OOMKilled"
+				}
+			}
+			if issue.Reason == "" {
+				continue
+			}
+			if issue.Reason == "OOMKilled" || issue.LastState == "OOMKilled" {
+				issue.MemoryLimit = limits[cs.Name]
+			}
+			issues = append(issues, issue)
+		}
+	}
+	return issues
+}
+
+// normalWaitingReason reports whether a waiting reason is part of every
+// container's start rather than a problem.
+func normalWaitingReason(reason string) bool {
+	return reason == "" || reason == "ContainerCreating" || reason == "PodInitializing"
+}
+
+// summarize condenses a diagnosis to one line per finding: container
+// issues grouped by reason and container, then distinct event reasons.
+func summarize(d *Diagnosis) []string {
+	type group struct {
+		issue ContainerIssue
+		pods  int
+	}
+	var order []string
+	groups := make(map[string]*group)
+	for _, issue := range d.Containers {
+		reason := issue.Reason
+		if issue.LastState == "OOMKilled" {
+			// CrashLoopBackOff is the symptom; the OOM kill is the cause.
+			reason = "OOMKilled"
+		}
+		key := reason + "/" + issue.Container
+		g, ok := groups[key]
+		if !ok {
+			g = &group{issue: issue}
+			g.issue.Reason = reason
+			groups[key] = g
+			order = append(order, key)
+		}
+		g.pods++
+	}
+
+	summary := []string{}
+	for _, key := range order {
+		g := groups[key]
+		line := fmt.Sprintf("%s: container %s, %d %s", g.issue.Reason, g.issue.Container, g.pods, plural(g.pods, "pod", "pods"))
+		switch {
+		case g.issue.Reason == "OOMKilled" && g.issue.MemoryLimit != "":
+			line += " (memory limit " + g.issue.MemoryLimit + ")"
+		case g.issue.ExitCode != nil:
+			line += fmt.Sprintf(" (last exit code %d)", *g.issue.ExitCode)
+		case g.issue.Message != "":
+			line += " (" + g.issue.Message + ")"
+		}
+		summary = append(summary, line)
+	}
+
+	seen := make(map[string]bool)
+	for _, e := range d.Events {
+		// The kubelet's BackOff events restate CrashLoopBackOff and
+		// ImagePullBackOff, already reported from the container state.
+		if e.Reason == "BackOff" && len(d.Containers) > 0 {
+			continue
+		}
+		key := e.Reason + "\x00" + e.Message
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		line := fmt.Sprintf("%s on %s: %s", e.Reason, e.Object, truncateMessage(e.Message))
+		if e.Count > 1 {
+			line += fmt.Sprintf(" (x%d)", e.Count)
+		}
+		summary = append(summary, line)
+	}
+	return summary
+}
+
+// previousLogTail returns the last lines of a container's previous
+// instance's log, or nil if it can't be read.
+func (c *Client) previousLogTail(ctx context.Context, namespace, pod, container string, lines int64) []string {
+	stream, err := c.clientset.CoreV1().Pods(namespace).GetLogs(pod, &corev1.PodLogOptions{
+		Container: container,
+		Previous:  true,
+		TailLines: &lines,
+	}).Stream(ctx)
+	if err != nil {
+		return nil
+	}
+	defer stream.Close()
+
+	var tail []string
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		tail = append(tail, scanner.Text())
+	}
+	return tail
+}
+
+// eventLastSeen is when an event last occurred. Events written through the
+// events.k8s.io API set EventTime and Series rather than LastTimestamp.
+func eventLastSeen(e corev1.Event) time.Time {
+	switch {
+	case e.Series != nil && !e.Series.LastObservedTime.IsZero():
+		return e.Series.LastObservedTime.Time
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	case !e.FirstTimestamp.IsZero():
+		return e.FirstTimestamp.Time
+	}
+	return e.CreationTimestamp.Time
+}
+
+func truncateMessage(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > maxDiagnoseMessageLen {
+		return string(r[:maxDiagnoseMessageLen]) + "..."
+	}
+	return s
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
@@ -0,0 +1,163 @@
+package k8s
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func warningEvent(name, kind, object, reason, message string, age time.Duration) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "default"},
+		InvolvedObject: corev1.ObjectReference{Kind: kind, Name: object, Namespace: "default"},
+		Type:           corev1.EventTypeWarning,
+		Reason:         reason,
+		Message:        message,
+		Count:          3,
+		LastTimestamp:  metav1.NewTime(time.Now().Add(-age)),
+	}
+}
+
+func crashingPod(name string) *corev1.Pod {
+	pod := logTestPod(name, 4, "web")
+	pod.Spec.Containers[0].Resources.Limits = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")}
+	pod.Status.ContainerStatuses[0].State = corev1.ContainerState{
+		Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff", Message: "back-off 5m0s restarting failed container"},
+	}
+	pod.Status.ContainerStatuses[0].LastTerminationState = corev1.ContainerState{
+		Terminated: &corev1.ContainerStateTerminated{Reason: "Error", ExitCode: 1},
+	}
+	return pod
+}
+
+func TestDiagnose_CrashLoop(t *testing.T) {
+	other := logTestPod("other-a", 0, "web")
+	other.Labels = map[string]string{"app": "other"}
+	c := newTestClient(
+		crashingPod("web-a"),
+		crashingPod("web-b"),
+		other,
+		warningEvent("e1", "Pod", "web-a", "BackOff", "Back-off restarting failed container", time.Minute),
+		warningEvent("e2", "Pod", "other-a", "Unhealthy", "Readiness probe failed", time.Minute),
+	)
+
+	diag, err := c.Diagnose(context.Background(), "web", "default", DiagnoseOptions{LogLines: 10})
+	if err != nil {
+		t.Fatalf("Diagnose: %v", err)
+	}
+	if len(diag.Containers) != 2 {
+		t.Fatalf("containers = %+v, want both crashing pods", diag.Containers)
+	}
+	if len(diag.Summary) != 1 || diag.Summary[0] != "CrashLoopBackOff: container web, 2 pods (last exit code 1)" {
+		t.Errorf("summary = %q", diag.Summary)
+	}
+	// Events for pods of other apps are ignored.
+	if len(diag.Events) != 1 || diag.Events[0].Object != "Pod/web-a" {
+		t.Errorf("events = %+v, want only web-a's", diag.Events)
+	}
+	// One previous-log read per container name, not per pod.
+	if len(diag.Containers[0].PreviousLogs) == 0 || len(diag.Containers[1].PreviousLogs) != 0 {
+		t.Errorf("previous logs = %q / %q, want the first pod's only", diag.Containers[0].PreviousLogs, diag.Containers[1].PreviousLogs)
+	}
+	if s := diag.String(); !strings.Contains(s, "CrashLoopBackOff") || !strings.Contains(s, "| last log: ") {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestDiagnose_OOMKilledAndImagePull(t *testing.T) {
+	oom := crashingPod("web-a")
+	oom.Status.ContainerStatuses[0].LastTerminationState.Terminated = &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}
+	pull := logTestPod("web-b", 0, "web")
+	pull.Status.ContainerStatuses[0].State = corev1.ContainerState{
+		Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: `Back-off pulling image "web:missing"`},
+	}
+	starting := logTestPod("web-c", 0, "web")
+	starting.Status.ContainerStatuses[0].State = corev1.ContainerState{
+		Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"},
+	}
+	c := newTestClient(oom, pull, starting)
+
+	diag, err := c.Diagnose(context.Background(), "web", "default", DiagnoseOptions{})
+	if err != nil {
+		t.Fatalf("Diagnose: %v", err)
+	}
+	want := []string{
+		"OOMKilled: container web, 1 pod (memory limit 256Mi)",
+		`ImagePullBackOff: container web, 1 pod (Back-off pulling image "web:missing")`,
+	}
+	if strings.Join(diag.Summary, "\n") != strings.Join(want, "\n") {
+		t.Errorf("summary = %q, want %q", diag.Summary, want)
+	}
+	for _, issue := range diag.Containers {
+		if len(issue.PreviousLogs) != 0 {
+			t.Errorf("read previous logs with LogLines 0: %+v", issue)
+		}
+	}
+}
+
+func TestDiagnose_Events(t *testing.T) {
+	dep := readyDeployment("web", "default", 1)
+	dep.Labels = map[string]string{"app": "web"}
+	c := newTestClient(
+		dep,
+		warningEvent("old", "Deployment", "web", "FailedCreate", "quota exceeded", 2*time.Hour),
+		warningEvent("new", "Deployment", "web", "FailedCreate", "quota exceeded", time.Minute),
+		warningEvent("dup", "Deployment", "web", "FailedCreate", "quota exceeded", 2*time.Minute),
+	)
+
+	diag, err := c.Diagnose(context.Background(), "web", "default", DiagnoseOptions{})
+	if err != nil {
+		t.Fatalf("Diagnose: %v", err)
+	}
+	if len(diag.Events) != 2 || diag.Events[0].LastSeen.Before(diag.Events[1].LastSeen) {
+		t.Errorf("events = %+v, want the two recent ones, newest first", diag.Events)
+	}
+	if len(diag.Summary) != 1 || diag.Summary[0] != "FailedCreate on Deployment/web: quota exceeded (x3)" {
+		t.Errorf("summary = %q", diag.Summary)
+	}
+}
+
+func TestDiagnose_Healthy(t *testing.T) {
+	c := newTestClient(logTestPod("web-a", 0, "web"))
+
+	diag, err := c.Diagnose(context.Background(), "web", "default", DiagnoseOptions{LogLines: 10})
+	if err != nil {
+		t.Fatalf("Diagnose: %v", err)
+	}
+	if len(diag.Summary) != 0 || diag.String() != "" {
+		t.Errorf("healthy app diagnosed with %q", diag.Summary)
+	}
+}
+
+func TestGetEnhancedDeploymentStatus_Diagnosis(t *testing.T) {
+	dep := readyDeployment("web", "default", 2)
+	dep.Status.ReadyReplicas = 1
+	dep.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+	c := newTestClient(dep, logTestPod("web-a", 0, "web"), crashingPod("web-b"))
+
+	status, err := c.GetEnhancedDeploymentStatus("web", "default")
+	if err != nil {
+		t.Fatalf("GetEnhancedDeploymentStatus: %v", err)
+	}
+	if status.Diagnosis == nil || len(status.Diagnosis.Summary) != 1 {
+		t.Fatalf("diagnosis = %+v, want the crash loop", status.Diagnosis)
+	}
+	if len(status.Diagnosis.Containers[0].PreviousLogs) != 0 {
+		t.Error("status read previous logs")
+	}
+
+	dep.Status.ReadyReplicas = 2
+	c = newTestClient(dep, logTestPod("web-a", 0, "web"))
+	status, err = c.GetEnhancedDeploymentStatus("web", "default")
+	if err != nil {
+		t.Fatalf("GetEnhancedDeploymentStatus: %v", err)
+	}
+	if status.Diagnosis != nil {
+		t.Errorf("running deployment has diagnosis %+v", status.Diagnosis)
+	}
+}
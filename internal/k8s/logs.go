@@ -0,0 +1,343 @@
+package k8s
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// logPodPollInterval is how often a followed log stream looks for pods that
+// appeared since it started (new replicas during a rollout or scale-up) and
+// for containers that restarted.
+const logPodPollInterval = 2 * time.Second
+
+// LogOptions selects which of an app's logs StreamLogs returns.
+type LogOptions struct {
+	Pod       string // only this pod; empty for every pod of the app
+	Container string // only this container; empty for every container
+	Follow    bool
+	TailLines *int64 // per container, for pods running when the stream starts
+	Since     time.Time
+	Previous  bool           // the previous (crashed) instance of each container; implies !Follow
+	Grep      *regexp.Regexp // only lines whose message matches
+}
+
+// LogLine is one log line and where it came from. Revision is the shipit
+// revision the pod runs (RevisionAnnotation on its ReplicaSet), 0 if it
+// isn't known.
+type LogLine struct {
+	Pod       string    `json:"pod"`
+	Container string    `json:"container"`
+	Revision  int       `json:"revision,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Message   string    `json:"message"`
+}
+
+// logSource is one container instance whose logs are streamed. restarts
+// tells instances of the same container apart: a restarted container is a
+// new source, its predecessor's stream having ended.
+type logSource struct {
+	pod       string
+	container string
+	restarts  int32
+	revision  int
+}
+
+// StreamLogs fans in the logs of every pod of an app (label app=<name>, so
+// every blue/green color) into one channel, closed when all streams end
+// or, when following, when ctx is done. Following picks up pods that start
+// later. Without Follow, lines are sorted by timestamp across pods.
+//
+// The pods to read are checked before StreamLogs returns, so "no pods" and
+// unknown pod or container names are errors rather than an empty stream.
+func (c *Client) StreamLogs(ctx context.Context, appName, namespace string, opts LogOptions) (<-chan LogLine, error) {
+	if opts.Previous {
+		opts.Follow = false
+	}
+	sources, err := c.logSources(ctx, appName, namespace, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(sources) == 0 {
+		if opts.Previous {
+			return nil, fmt.Errorf("no restarted containers for app %s, so there are no previous logs", appName)
+		}
+		return nil, fmt.Errorf("no pods found for app %s", appName)
+	}
+
+	out := make(chan LogLine, 256)
+	var wg sync.WaitGroup
+	started := make(map[logSource]bool)
+	start := func(src logSource, tail *int64) {
+		started[src] = true
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.streamContainerLogs(ctx, namespace, src, opts, tail, out); err != nil && ctx.Err() == nil {
+				log.Printf("logs: stream failed pod=%s container=%s err=%v", src.pod, src.container, err)
+			}
+		}()
+	}
+	for _, src := range sources {
+		start(src, opts.TailLines)
+	}
+
+	if !opts.Follow {
+		sorted := make(chan LogLine, 256)
+		go func() {
+			defer close(sorted)
+			go func() {
+				wg.Wait()
+				close(out)
+			}()
+			var lines []LogLine
+			for line := range out {
+				lines = append(lines, line)
+			}
+			sort.SliceStable(lines, func(i, j int) bool { return lines[i].Timestamp.Before(lines[j].Timestamp) })
+			for _, line := range lines {
+				select {
+				case sorted <- line:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return sorted, nil
+	}
+
+	go func() {
+		ticker := time.NewTicker(logPodPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				wg.Wait()
+				close(out)
+				return
+			case <-ticker.C:
+			}
+			current, err := c.logSources(ctx, appName, namespace, opts)
+			if err != nil {
+				continue
+			}
+			for _, src := range current {
+				// Pods and container instances that started after the
+				// stream did are read from their beginning.
+				if !started[src] {
+					start(src, nil)
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// logSources lists the running containers (or, for Previous, restarted
+// ones) of an app's pods that match opts.
+func (c *Client) logSources(ctx context.Context, appName, namespace string, opts LogOptions) ([]logSource, error) {
+	listOpts := metav1.ListOptions{LabelSelector: fmt.Sprintf("app=%s", appName)}
+	pods, err := c.clientset.CoreV1().Pods(namespace).List(ctx, listOpts)
+	if err != nil {
+		return nil, err
+	}
+	replicaSets, err := c.clientset.AppsV1().ReplicaSets(namespace).List(ctx, listOpts)
+	if err != nil {
+		return nil, err
+	}
+	revisions := make(map[string]int)
+	for _, rs := range replicaSets.Items {
+		revisions[rs.Name], _ = strconv.Atoi(rs.Annotations[RevisionAnnotation])
+	}
+
+	var podNames, containerNames []string
+	var sources []logSource
+	for _, pod := range pods.Items {
+		podNames = append(podNames, pod.Name)
+		if opts.Pod != "" && pod.Name != opts.Pod {
+			continue
+		}
+		revision := podRevision(&pod, revisions)
+		restarts := make(map[string]int32)
+		running := make(map[string]bool)
+		for _, cs := range pod.Status.ContainerStatuses {
+			restarts[cs.Name] = cs.RestartCount
+			running[cs.Name] = cs.State.Running != nil
+		}
+		for _, ct := range pod.Spec.Containers {
+			containerNames = append(containerNames, ct.Name)
+			if opts.Container != "" && ct.Name != opts.Container {
+				continue
+			}
+			switch {
+			case opts.Previous:
+				if restarts[ct.Name] == 0 {
+					continue
+				}
+			case pod.Status.Phase == corev1.PodPending && !running[ct.Name]:
+				// No logs until the container starts; a followed stream
+				// picks it up on a later poll.
+				continue
+			}
+			sources = append(sources, logSource{pod: pod.Name, container: ct.Name, restarts: restarts[ct.Name], revision: revision})
+		}
+	}
+
+	if opts.Pod != "" && !contains(podNames, opts.Pod) {
+		return nil, fmt.Errorf("pod %s not found for app %s (pods: %s)", opts.Pod, appName, strings.Join(podNames, ", "))
+	}
+	if opts.Container != "" && len(pods.Items) > 0 && !contains(containerNames, opts.Container) {
+		return nil, fmt.Errorf("container %s not found (containers: %s)", opts.Container, strings.Join(uniqueSorted(containerNames), ", "))
+	}
+	return sources, nil
+}
+
+// podRevision returns the revision of the ReplicaSet that owns pod, from
+// revisions by ReplicaSet name. Pods started before the revision moved off
+// the pod template still carry it themselves.
+func podRevision(pod *corev1.Pod, revisions map[string]int) int {
+	if owner := metav1.GetControllerOf(pod); owner != nil && owner.Kind == "ReplicaSet" && revisions[owner.Name] > 0 {
+		return revisions[owner.Name]
+	}
+	revision, _ := strconv.Atoi(pod.Annotations[RevisionAnnotation])
+	return revision
+}
+
+// streamContainerLogs copies one container's log into out until the log
+// ends (the container stopped, or Follow is off) or ctx is done.
+func (c *Client) streamContainerLogs(ctx context.Context, namespace string, src logSource, opts LogOptions, tail *int64, out chan<- LogLine) error {
+	podOpts := &corev1.PodLogOptions{
+		Container:  src.container,
+		Follow:     opts.Follow,
+		Previous:   opts.Previous,
+		Timestamps: true,
+		TailLines:  tail,
+	}
+	if !opts.Since.IsZero() {
+		podOpts.SinceTime = &metav1.Time{Time: opts.Since}
+	}
+
+	stream, err := c.clientset.CoreV1().Pods(namespace).GetLogs(src.pod, podOpts).Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := LogLine{Pod: src.pod, Container: src.container, Revision: src.revision}
+		line.Timestamp, line.Message = splitLogTimestamp(scanner.Text())
+		if opts.Grep != nil && !opts.Grep.MatchString(line.Message) {
+			continue
+		}
+		select {
+		case out <- line:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// FollowPreDeployLogs follows the log of an app's pre-deploy hook: the
+// newest pre-deploy Job pod created at or after since, once its container
+// has started. The channel closes when the hook exits or ctx is done.
+func (c *Client) FollowPreDeployLogs(ctx context.Context, appName, namespace string, since time.Time) <-chan LogLine {
+	out := make(chan LogLine, 256)
+	go func() {
+		defer close(out)
+		ticker := time.NewTicker(logPodPollInterval)
+		defer ticker.Stop()
+		for {
+			if pod := c.preDeployPod(ctx, appName, namespace, since); pod != "" {
+				src := logSource{pod: pod, container: "predeploy"}
+				if err := c.streamContainerLogs(ctx, namespace, src, LogOptions{Follow: true}, nil, out); err != nil && ctx.Err() == nil {
+					log.Printf("logs: pre-deploy stream failed pod=%s err=%v", pod, err)
+				}
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return out
+}
+
+// preDeployPod returns the newest pre-deploy Job pod of an app created at
+// or after since whose container has started, or "" if there is none yet.
+func (c *Client) preDeployPod(ctx context.Context, appName, namespace string, since time.Time) string {
+	pods, err := c.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("app=%s,job-name", appName),
+	})
+	if err != nil {
+		return ""
+	}
+	// Creation timestamps have second precision.
+	since = since.Truncate(time.Second)
+	var newest *corev1.Pod
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.CreationTimestamp.Time.Before(since) {
+			continue
+		}
+		if newest == nil || pod.CreationTimestamp.After(newest.CreationTimestamp.Time) {
+			newest = pod
+		}
+	}
+	if newest == nil {
+		return ""
+	}
+	for _, cs := range newest.Status.ContainerStatuses {
+		if cs.State.Running != nil || cs.State.Terminated != nil {
+			return newest.Name
+		}
+	}
+	return ""
+}
+
+// splitLogTimestamp splits the RFC 3339 timestamp the kubelet prefixes
+// each line with (PodLogOptions.Timestamps) from the message.
+func splitLogTimestamp(raw string) (time.Time, string) {
+	if ts, msg, ok := strings.Cut(raw, " "); ok {
+		if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			return t, msg
+		}
+	}
+	return time.Now().UTC(), raw
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func uniqueSorted(list []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, v := range list {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,220 @@
+package k8s
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func logTestPod(name string, restarts int32, containers ...string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": "web"}},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	for _, c := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: c})
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{
+			Name:         c,
+			RestartCount: restarts,
+			State:        corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+		})
+	}
+	return pod
+}
+
+// collectLogs drains a log stream, failing the test if it doesn't end.
+func collectLogs(t *testing.T, lines <-chan LogLine) []LogLine {
+	t.Helper()
+	var got []LogLine
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return got
+			}
+			got = append(got, line)
+		case <-timeout:
+			t.Fatal("log stream did not end")
+		}
+	}
+}
+
+func logPods(lines []LogLine) string {
+	var pods []string
+	for _, l := range lines {
+		pods = append(pods, l.Pod+"/"+l.Container)
+	}
+	return strings.Join(uniqueSorted(pods), ",")
+}
+
+func TestStreamLogs_FansInEveryPod(t *testing.T) {
+	c := newTestClient(logTestPod("web-a", 0, "web"), logTestPod("web-b", 0, "web", "proxy"))
+
+	lines, err := c.StreamLogs(context.Background(), "web", "default", LogOptions{})
+	if err != nil {
+		t.Fatalf("StreamLogs: %v", err)
+	}
+	got := collectLogs(t, lines)
+	if pods := logPods(got); pods != "web-a/web,web-b/proxy,web-b/web" {
+		t.Errorf("streamed from %s, want every container of every pod", pods)
+	}
+	for _, l := range got {
+		if l.Message == "" || l.Timestamp.IsZero() {
+			t.Errorf("line missing message or timestamp: %+v", l)
+		}
+	}
+}
+
+func TestStreamLogs_Filters(t *testing.T) {
+	c := newTestClient(logTestPod("web-a", 0, "web"), logTestPod("web-b", 1, "web", "proxy"))
+	ctx := context.Background()
+
+	lines, err := c.StreamLogs(ctx, "web", "default", LogOptions{Pod: "web-b", Container: "proxy"})
+	if err != nil {
+		t.Fatalf("StreamLogs: %v", err)
+	}
+	if pods := logPods(collectLogs(t, lines)); pods != "web-b/proxy" {
+		t.Errorf("pod+container filter streamed from %s", pods)
+	}
+
+	lines, err = c.StreamLogs(ctx, "web", "default", LogOptions{Grep: regexp.MustCompile("^no such line$")})
+	if err != nil {
+		t.Fatalf("StreamLogs: %v", err)
+	}
+	if got := collectLogs(t, lines); len(got) != 0 {
+		t.Errorf("grep should drop non-matching lines, got %+v", got)
+	}
+
+	// Only web-b has restarted, so only it has previous logs.
+	lines, err = c.StreamLogs(ctx, "web", "default", LogOptions{Previous: true, Follow: true})
+	if err != nil {
+		t.Fatalf("StreamLogs: %v", err)
+	}
+	if pods := logPods(collectLogs(t, lines)); pods != "web-b/proxy,web-b/web" {
+		t.Errorf("previous streamed from %s", pods)
+	}
+}
+
+func TestStreamLogs_Errors(t *testing.T) {
+	ctx := context.Background()
+	if _, err := newTestClient().StreamLogs(ctx, "web", "default", LogOptions{}); err == nil || !strings.Contains(err.Error(), "no pods") {
+		t.Errorf("no pods: err = %v", err)
+	}
+
+	c := newTestClient(logTestPod("web-a", 0, "web"))
+	if _, err := c.StreamLogs(ctx, "web", "default", LogOptions{Pod: "web-z"}); err == nil || !strings.Contains(err.Error(), "pods: web-a") {
+		t.Errorf("unknown pod: err = %v", err)
+	}
+	if _, err := c.StreamLogs(ctx, "web", "default", LogOptions{Container: "db"}); err == nil || !strings.Contains(err.Error(), "containers: web") {
+		t.Errorf("unknown container: err = %v", err)
+	}
+	if _, err := c.StreamLogs(ctx, "web", "default", LogOptions{Previous: true}); err == nil || !strings.Contains(err.Error(), "no restarted containers") {
+		t.Errorf("previous without restarts: err = %v", err)
+	}
+}
+
+func TestStreamLogs_FollowPicksUpNewPods(t *testing.T) {
+	c := newTestClient(logTestPod("web-a", 0, "web"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lines, err := c.StreamLogs(ctx, "web", "default", LogOptions{Follow: true})
+	if err != nil {
+		t.Fatalf("StreamLogs: %v", err)
+	}
+	if _, err := c.clientset.CoreV1().Pods("default").Create(ctx, logTestPod("web-b", 0, "web"), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]bool{}
+	timeout := time.After(3 * logPodPollInterval)
+	for !seen["web-a"] || !seen["web-b"] {
+		select {
+		case line := <-lines:
+			seen[line.Pod] = true
+		case <-timeout:
+			t.Fatalf("followed stream saw pods %v, want web-a and web-b", seen)
+		}
+	}
+
+	cancel()
+	for range lines {
+	}
+}
+
+func TestSplitLogTimestamp(t *testing.T) {
+	ts, msg := splitLogTimestamp("2026-03-01T10:00:00.123456789Z GET /health 200")
+	if msg != "GET /health 200" || !ts.Equal(time.Date(2026, 3, 1, 10, 0, 0, 123456789, time.UTC)) {
+		t.Errorf("got %v %q", ts, msg)
+	}
+	if _, msg := splitLogTimestamp("no timestamp here"); msg != "no timestamp here" {
+		t.Errorf("line without timestamp: %q", msg)
+	}
+}
+
+func TestStreamLogs_CarriesRevision(t *testing.T) {
+	rs := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name:        "web-5d8f",
+		Namespace:   "default",
+		Labels:      map[string]string{"app": "web"},
+		Annotations: map[string]string{RevisionAnnotation: "12"},
+	}}
+	controller := true
+	owned := logTestPod("web-5d8f-a", 0, "web")
+	owned.OwnerReferences = []metav1.OwnerReference{{Kind: "ReplicaSet", Name: rs.Name, Controller: &controller}}
+	// Started before the revision moved to the Deployment.
+	legacy := logTestPod("web-old-b", 0, "web")
+	legacy.Annotations = map[string]string{RevisionAnnotation: "11"}
+	c := newTestClient(rs, owned, legacy)
+
+	lines, err := c.StreamLogs(context.Background(), "web", "default", LogOptions{})
+	if err != nil {
+		t.Fatalf("StreamLogs: %v", err)
+	}
+	want := map[string]int{owned.Name: 12, legacy.Name: 11}
+	for _, l := range collectLogs(t, lines) {
+		if l.Revision != want[l.Pod] {
+			t.Errorf("%s: line revision = %d, want %d", l.Pod, l.Revision, want[l.Pod])
+		}
+	}
+}
+
+func preDeployTestPod(name string, created time.Time) *corev1.Pod {
+	pod := logTestPod(name, 0, "predeploy")
+	pod.Labels["job-name"] = name
+	pod.CreationTimestamp = metav1.NewTime(created)
+	return pod
+}
+
+func TestFollowPreDeployLogs_NewestHookPod(t *testing.T) {
+	now := time.Now()
+	c := newTestClient(
+		logTestPod("web-a", 0, "web"),
+		preDeployTestPod("web-predeploy-1", now.Add(-time.Hour)),
+		preDeployTestPod("web-predeploy-2", now),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	got := collectLogs(t, c.FollowPreDeployLogs(ctx, "web", "default", now.Add(-time.Minute)))
+	if pods := logPods(got); pods != "web-predeploy-2/predeploy" {
+		t.Errorf("followed %s, want only the hook pod created since the phase started", pods)
+	}
+}
+
+func TestFollowPreDeployLogs_WaitsForPod(t *testing.T) {
+	c := newTestClient(preDeployTestPod("web-predeploy-1", time.Now().Add(-time.Hour)))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if got := collectLogs(t, c.FollowPreDeployLogs(ctx, "web", "default", time.Now())); len(got) != 0 {
+		t.Errorf("read %d lines before the hook pod existed", len(got))
+	}
+}
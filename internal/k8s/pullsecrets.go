@@ -0,0 +1,43 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ImagePullSecretConfigs returns the docker config of every imagePullSecret
+// on the namespace's default ServiceAccount. App pods don't set their own
+// imagePullSecrets, so these are the credentials the kubelet pulls the
+// app's image with. Secrets that are missing or of another type are
+// skipped; a namespace without a default ServiceAccount yields none.
+func (c *Client) ImagePullSecretConfigs(ctx context.Context, namespace string) ([][]byte, error) {
+	sa, err := c.clientset.CoreV1().ServiceAccounts(namespace).Get(ctx, "default", metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read default service account: %w", err)
+	}
+
+	var configs [][]byte
+	for _, ref := range sa.ImagePullSecrets {
+		secret, err := c.clientset.CoreV1().Secrets(namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image pull secret %s: %w", ref.Name, err)
+		}
+		switch secret.Type {
+		case corev1.SecretTypeDockerConfigJson:
+			configs = append(configs, secret.Data[corev1.DockerConfigJsonKey])
+		case corev1.SecretTypeDockercfg:
+			configs = append(configs, secret.Data[corev1.DockerConfigKey])
+		}
+	}
+	return configs, nil
+}
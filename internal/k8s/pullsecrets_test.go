@@ -0,0 +1,43 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestImagePullSecretConfigs(t *testing.T) {
+	sa := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "apps"},
+		ImagePullSecrets: []corev1.LocalObjectReference{
+			{Name: "ghcr"}, {Name: "legacy"}, {Name: "opaque"}, {Name: "deleted"},
+		},
+	}
+	secret := func(name string, typ corev1.SecretType, key, value string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "apps"},
+			Type:       typ,
+			Data:       map[string][]byte{key: []byte(value)},
+		}
+	}
+	c := newTestClient(sa,
+		secret("ghcr", corev1.SecretTypeDockerConfigJson, corev1.DockerConfigJsonKey, `{"auths":{}}`),
+		secret("legacy", corev1.SecretTypeDockercfg, corev1.DockerConfigKey, `{}`),
+		secret("opaque", corev1.SecretTypeOpaque, "token", "x"),
+	)
+
+	configs, err := c.ImagePullSecretConfigs(context.Background(), "apps")
+	if err != nil {
+		t.Fatalf("ImagePullSecretConfigs: %v", err)
+	}
+	if len(configs) != 2 || string(configs[0]) != `{"auths":{}}` || string(configs[1]) != `{}` {
+		t.Errorf("configs = %q, want the dockerconfigjson and dockercfg secrets only", configs)
+	}
+
+	configs, err = c.ImagePullSecretConfigs(context.Background(), "empty")
+	if err != nil || configs != nil {
+		t.Errorf("namespace without a default service account = %q, %v", configs, err)
+	}
+}
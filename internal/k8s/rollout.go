@@ -4,9 +4,12 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sort"
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -22,6 +25,13 @@ const defaultProgressDeadline = 600 * time.Second
 // not emit Modify events for Status updates made via Update(). Polling keeps
 // the implementation identical in tests and production.
 func (c *Client) WatchRollout(ctx context.Context, name, namespace string) error {
+	return c.WatchRolloutProgress(ctx, name, namespace, nil)
+}
+
+// WatchRolloutProgress is WatchRollout that also reports the rollout's
+// progress to fn after every successful poll, including the last one
+// before it returns. fn runs on the watching goroutine.
+func (c *Client) WatchRolloutProgress(ctx context.Context, name, namespace string, fn func(RolloutProgress)) error {
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
 
@@ -41,6 +51,9 @@ func (c *Client) WatchRollout(ctx context.Context, name, namespace string) error
 			// behavior for an unreachable control plane.
 			log.Printf("rollout: transient get error (retrying) app=%s ns=%s err=%v", name, namespace, err)
 		} else {
+			if fn != nil {
+				fn(c.rolloutProgress(ctx, dep))
+			}
 			if rolloutReady(dep) {
 				return nil
 			}
@@ -57,6 +70,151 @@ func (c *Client) WatchRollout(ctx context.Context, name, namespace string) error
 	}
 }
 
+// RolloutProgress is a rollout seen from its new ReplicaSet, the one
+// running the Deployment's current pod template.
+type RolloutProgress struct {
+	Desired int32 `json:"desired"` // replicas the Deployment wants
+	Updated int32 `json:"updated"` // pods on the current template
+	Ready   int32 `json:"ready"`   // ready pods of the new ReplicaSet
+	// ReadyPods are the new ReplicaSet's ready pods, in the order they
+	// became ready.
+	ReadyPods []PodReady `json:"ready_pods,omitempty"`
+}
+
+// PodReady is when a pod last became ready.
+type PodReady struct {
+	Pod     string    `json:"pod"`
+	ReadyAt time.Time `json:"ready_at"`
+}
+
+// GetRolloutProgress is the single-shot counterpart of
+// WatchRolloutProgress, for following a rollout another process is
+// watching.
+func (c *Client) GetRolloutProgress(ctx context.Context, name, namespace string) (RolloutProgress, error) {
+	dep, err := c.clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return RolloutProgress{}, err
+	}
+	return c.rolloutProgress(ctx, dep), nil
+}
+
+// revisionAnnotation is the rollout number the Deployment controller puts
+// on a Deployment and each of its ReplicaSets; the new ReplicaSet carries
+// the Deployment's.
+const revisionAnnotation = "deployment.kubernetes.io/revision"
+
+// rolloutProgress reads the new ReplicaSet and its pods for dep. Before
+// the controller has created that ReplicaSet (or if listing fails, which
+// is logged) only the Deployment's own counts are filled in.
+func (c *Client) rolloutProgress(ctx context.Context, dep *appsv1.Deployment) RolloutProgress {
+	p := RolloutProgress{Updated: dep.Status.UpdatedReplicas}
+	if dep.Spec.Replicas != nil {
+		p.Desired = *dep.Spec.Replicas
+	}
+	rs, err := c.newReplicaSet(ctx, dep)
+	if err != nil {
+		log.Printf("rollout: failed to read new replicaset app=%s ns=%s err=%v", dep.Name, dep.Namespace, err)
+		return p
+	}
+	if rs == nil {
+		return p
+	}
+	p.Ready = rs.Status.ReadyReplicas
+	if rs.Spec.Selector == nil {
+		return p
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(rs.Spec.Selector)
+	if err != nil {
+		return p
+	}
+	pods, err := c.clientset.CoreV1().Pods(dep.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		log.Printf("rollout: failed to list pods app=%s ns=%s err=%v", dep.Name, dep.Namespace, err)
+		return p
+	}
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue {
+				p.ReadyPods = append(p.ReadyPods, PodReady{Pod: pod.Name, ReadyAt: cond.LastTransitionTime.Time})
+			}
+		}
+	}
+	sort.Slice(p.ReadyPods, func(i, j int) bool {
+		if !p.ReadyPods[i].ReadyAt.Equal(p.ReadyPods[j].ReadyAt) {
+			return p.ReadyPods[i].ReadyAt.Before(p.ReadyPods[j].ReadyAt)
+		}
+		return p.ReadyPods[i].Pod < p.ReadyPods[j].Pod
+	})
+	return p
+}
+
+// newReplicaSet returns dep's ReplicaSet for its current revision, or nil
+// if the controller hasn't created it yet.
+func (c *Client) newReplicaSet(ctx context.Context, dep *appsv1.Deployment) (*appsv1.ReplicaSet, error) {
+	revision := dep.Annotations[revisionAnnotation]
+	if revision == "" {
+		return nil, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(dep.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	replicaSets, err := c.clientset.AppsV1().ReplicaSets(dep.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	for i := range replicaSets.Items {
+		rs := &replicaSets.Items[i]
+		if rs.Annotations[revisionAnnotation] != revision {
+			continue
+		}
+		for _, owner := range rs.OwnerReferences {
+			if owner.Kind == "Deployment" && owner.Name == dep.Name {
+				return rs, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// RolloutState is the point-in-time result of CheckRollout.
+type RolloutState string
+
+const (
+	RolloutProgressing RolloutState = "progressing"
+	RolloutReady       RolloutState = "ready"
+	RolloutFailed      RolloutState = "failed"
+	RolloutMissing     RolloutState = "missing"
+)
+
+// CheckRollout is the single-shot counterpart of WatchRollout: it reads the
+// Deployment once and classifies it with the same rolloutReady /
+// rolloutFailed predicates. Used by the deploy reconciler to settle apps
+// whose deploy goroutine died (server restart) without re-running the
+// deploy. The returned string carries kube's failure message for
+// RolloutFailed. API errors other than NotFound are returned as-is so the
+// caller can retry on its next pass instead of acting on a transient blip.
+func (c *Client) CheckRollout(ctx context.Context, name, namespace string) (RolloutState, string, error) {
+	dep, err := c.clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return RolloutMissing, "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if rolloutReady(dep) {
+		return RolloutReady, "", nil
+	}
+	if reason, failed := rolloutFailed(dep); failed {
+		return RolloutFailed, reason, nil
+	}
+	return RolloutProgressing, "", nil
+}
+
 // rolloutReady mirrors `kubectl rollout status`: new ReplicaSet has been
 // observed by the controller and every desired replica is updated, ready,
 // and available.
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/fake"
@@ -176,6 +177,82 @@ func TestWatchRollout_TransientGetErrorRecovers(t *testing.T) {
 	}
 }
 
+// readyPod is a pod of the ReplicaSet with the given pod-template-hash that
+// became ready at readyAt.
+func readyPod(name, hash string, readyAt time.Time) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": "svc", "pod-template-hash": hash}},
+		Status: corev1.PodStatus{Conditions: []corev1.PodCondition{
+			{Type: corev1.PodReady, Status: corev1.ConditionTrue, LastTransitionTime: metav1.NewTime(readyAt)},
+		}},
+	}
+}
+
+func replicaSet(hash, revision string, ready int32) *appsv1.ReplicaSet {
+	labels := map[string]string{"app": "svc", "pod-template-hash": hash}
+	return &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "svc-" + hash,
+			Namespace:       "default",
+			Labels:          labels,
+			Annotations:     map[string]string{revisionAnnotation: revision},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "svc"}},
+		},
+		Spec:   appsv1.ReplicaSetSpec{Selector: &metav1.LabelSelector{MatchLabels: labels}},
+		Status: appsv1.ReplicaSetStatus{ReadyReplicas: ready},
+	}
+}
+
+func TestWatchRolloutProgress_ReportsNewReplicaSet(t *testing.T) {
+	dep := readyDeployment("svc", "default", 2)
+	dep.Annotations = map[string]string{revisionAnnotation: "2"}
+	dep.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "svc"}}
+	now := time.Now().Truncate(time.Second)
+	c := newTestClient(
+		dep,
+		replicaSet("old", "1", 2),
+		replicaSet("new", "2", 2),
+		readyPod("svc-old-a", "old", now.Add(-time.Hour)),
+		readyPod("svc-new-b", "new", now),
+		readyPod("svc-new-a", "new", now.Add(-time.Minute)),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var reports []RolloutProgress
+	if err := c.WatchRolloutProgress(ctx, "svc", "default", func(p RolloutProgress) {
+		reports = append(reports, p)
+	}); err != nil {
+		t.Fatalf("WatchRolloutProgress: %v", err)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("got %d reports, want one for the final poll", len(reports))
+	}
+	p := reports[0]
+	if p.Desired != 2 || p.Updated != 2 || p.Ready != 2 {
+		t.Errorf("progress = %+v, want 2/2/2", p)
+	}
+	if len(p.ReadyPods) != 2 || p.ReadyPods[0].Pod != "svc-new-a" || p.ReadyPods[1].Pod != "svc-new-b" {
+		t.Errorf("ready pods = %+v, want the new ReplicaSet's, oldest first", p.ReadyPods)
+	}
+	if !p.ReadyPods[1].ReadyAt.Equal(now) {
+		t.Errorf("ready at = %v, want %v", p.ReadyPods[1].ReadyAt, now)
+	}
+}
+
+func TestWatchRolloutProgress_BeforeReplicaSetExists(t *testing.T) {
+	dep := laggingDeployment("svc", "default", 2)
+	c := newTestClient(dep, replicaSet("old", "1", 2))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	var got RolloutProgress
+	c.WatchRolloutProgress(ctx, "svc", "default", func(p RolloutProgress) { got = p })
+	if got.Desired != 2 || got.Ready != 0 || len(got.ReadyPods) != 0 {
+		t.Errorf("progress = %+v, want only the desired count", got)
+	}
+}
+
 func TestProgressDeadline_UsesDefaultWhenUnset(t *testing.T) {
 	d := &appsv1.Deployment{}
 	if got := progressDeadline(d); got != defaultProgressDeadline {
@@ -190,3 +267,45 @@ func TestProgressDeadline_ReadsSpecValue(t *testing.T) {
 		t.Errorf("got %v, want 120s", got)
 	}
 }
+
+func TestCheckRollout_States(t *testing.T) {
+	c := newTestClient(
+		readyDeployment("ready", "default", 2),
+		laggingDeployment("lagging", "default", 2),
+		failedDeployment("broken", "default", 2, "pod stuck ImagePullBackOff"),
+	)
+	ctx := context.Background()
+
+	cases := []struct {
+		name       string
+		wantState  RolloutState
+		wantReason string
+	}{
+		{"ready", RolloutReady, ""},
+		{"lagging", RolloutProgressing, ""},
+		{"broken", RolloutFailed, "pod stuck ImagePullBackOff"},
+		{"absent", RolloutMissing, ""},
+	}
+	for _, tc := range cases {
+		state, reason, err := c.CheckRollout(ctx, tc.name, "default")
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tc.name, err)
+		}
+		if state != tc.wantState || reason != tc.wantReason {
+			t.Errorf("%s: want (%s, %q), got (%s, %q)", tc.name, tc.wantState, tc.wantReason, state, reason)
+		}
+	}
+}
+
+// A transient API error must surface as an error, not as a state the
+// reconciler would act on (e.g. RolloutMissing → mark failed).
+func TestCheckRollout_GetErrorIsReturned(t *testing.T) {
+	c := newTestClient(readyDeployment("svc", "default", 1))
+	c.clientset.(*fake.Clientset).PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("etcdserver: leader changed")
+	})
+
+	if _, _, err := c.CheckRollout(context.Background(), "svc", "default"); err == nil {
+		t.Fatal("expected transient get error to be returned")
+	}
+}
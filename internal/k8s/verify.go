@@ -0,0 +1,31 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// ServiceGet issues GET path against the app's Service through the API
+// server's service proxy, so the request takes the same in-cluster route
+// (Service → ready endpoints) as real traffic without shipit needing
+// network access to the cluster. Returns the HTTP status code and response
+// body. A non-2xx response is not an error; err is only set when no
+// response came back at all (apiserver unreachable, proxy dial failure).
+func (c *Client) ServiceGet(ctx context.Context, name, namespace string, port int, path string) (int, []byte, error) {
+	body, err := c.clientset.CoreV1().Services(namespace).
+		ProxyGet("http", name, strconv.Itoa(port), path, nil).
+		DoRaw(ctx)
+	if err == nil {
+		return 200, body, nil
+	}
+	// client-go turns a non-2xx proxied response into a StatusError
+	// carrying the upstream status code; the body is still returned.
+	var status apierrors.APIStatus
+	if errors.As(err, &status) && status.Status().Code != 0 {
+		return int(status.Status().Code), body, nil
+	}
+	return 0, nil, err
+}
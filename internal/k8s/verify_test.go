@@ -0,0 +1,67 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	restclient "k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// proxyResponse is a canned service-proxy response for the fake clientset.
+type proxyResponse struct {
+	body []byte
+	err  error
+}
+
+func (p proxyResponse) DoRaw(context.Context) ([]byte, error) { return p.body, p.err }
+
+func (p proxyResponse) Stream(context.Context) (io.ReadCloser, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestServiceGet(t *testing.T) {
+	cases := []struct {
+		name     string
+		resp     proxyResponse
+		wantCode int
+		wantBody string
+		wantErr  bool
+	}{
+		{name: "ok", resp: proxyResponse{body: []byte("ok")}, wantCode: 200, wantBody: "ok"},
+		{
+			name:     "upstream 500",
+			resp:     proxyResponse{body: []byte("boom"), err: apierrors.NewGenericServerResponse(500, "get", schema.GroupResource{Resource: "services"}, "", "boom", 0, true)},
+			wantCode: 500,
+			wantBody: "boom",
+		},
+		{name: "no response", resp: proxyResponse{err: errors.New("dial tcp: connection refused")}, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestClient()
+			var gotPath, gotPort string
+			c.clientset.(*fake.Clientset).PrependProxyReactor("services", func(action k8stesting.Action) (bool, restclient.ResponseWrapper, error) {
+				get := action.(k8stesting.ProxyGetAction)
+				gotPath, gotPort = get.GetPath(), get.GetPort()
+				return true, tc.resp, nil
+			})
+
+			code, body, err := c.ServiceGet(context.Background(), "svc", "default", 8080, "/healthz")
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tc.wantErr)
+			}
+			if code != tc.wantCode || string(body) != tc.wantBody {
+				t.Errorf("ServiceGet = %d %q, want %d %q", code, body, tc.wantCode, tc.wantBody)
+			}
+			if gotPath != "/healthz" || gotPort != "8080" {
+				t.Errorf("proxied to port %q path %q", gotPort, gotPath)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+// Package logarchive stores app logs beyond the life of their pods. The
+// server's collectors write log lines in gzipped chunks of JSON lines to a
+// Store (a local directory or an S3-compatible bucket); the log_chunks
+// table indexes them by app, pod, revision and time range.
+package logarchive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/vigneshsubbiah/shipit/internal/config"
+	"github.com/vigneshsubbiah/shipit/internal/k8s"
+)
+
+// ErrNotFound is returned by Store.Get for a key that doesn't exist.
+var ErrNotFound = errors.New("log chunk not found")
+
+// Store holds archived chunks by key. Keys are slash-separated paths.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Delete removes key; deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// FromConfig returns the store LOG_ARCHIVE_URL names, or nil when the log
+// archive is off:
+//
+//	file:///var/lib/shipit/logs   a local (or mounted) directory
+//	s3://bucket/prefix            an S3 or S3-compatible bucket
+func FromConfig(cfg *config.Config) (Store, error) {
+	if cfg.LogArchiveURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(cfg.LogArchiveURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LOG_ARCHIVE_URL: %w", err)
+	}
+	switch u.Scheme {
+	case "file":
+		if u.Path == "" {
+			return nil, errors.New("LOG_ARCHIVE_URL: file:// needs an absolute path, e.g. file:///var/lib/shipit/logs")
+		}
+		return NewFileStore(u.Path)
+	case "s3":
+		if u.Host == "" {
+			return nil, errors.New("LOG_ARCHIVE_URL: s3:// needs a bucket, e.g. s3://my-bucket/shipit-logs")
+		}
+		return NewS3Store(S3Config{
+			Endpoint:        cfg.LogArchiveS3Endpoint,
+			Region:          cfg.LogArchiveS3Region,
+			Bucket:          u.Host,
+			Prefix:          strings.Trim(u.Path, "/"),
+			AccessKeyID:     cfg.LogArchiveS3AccessKeyID,
+			SecretAccessKey: cfg.LogArchiveS3SecretAccessKey,
+			SessionToken:    cfg.LogArchiveS3SessionToken,
+		})
+	default:
+		return nil, fmt.Errorf("LOG_ARCHIVE_URL: unsupported scheme %q (want file or s3)", u.Scheme)
+	}
+}
+
+// ChunkKey returns a new key for a chunk of appID's pod whose first line
+// is at firstNano (Unix nanoseconds). Keys sort by time within a pod.
+func ChunkKey(appID, pod string, firstNano int64) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%s/%s/%019d-%s.ndjson.gz", appID, pod, firstNano, hex.EncodeToString(b))
+}
+
+// EncodeChunk gzips lines as one JSON object per line.
+func EncodeChunk(lines []k8s.LogLine) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	for _, line := range lines {
+		if err := enc.Encode(line); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeChunk reads a chunk written by EncodeChunk.
+func DecodeChunk(data []byte) ([]k8s.LogLine, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("corrupt log chunk: %w", err)
+	}
+	defer zr.Close()
+
+	var lines []k8s.LogLine
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 64*1024), 2*1024*1024)
+	for scanner.Scan() {
+		var line k8s.LogLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return nil, fmt.Errorf("corrupt log chunk: %w", err)
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("corrupt log chunk: %w", err)
+	}
+	return lines, nil
+}
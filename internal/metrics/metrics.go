@@ -0,0 +1,89 @@
+// Package metrics instruments the shipit server with Prometheus client
+// metrics, served at /metrics. The server's metrics are the package-level
+// variables below, registered in Registry.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry is the registry the server's metrics live in and /metrics
+// serves, alongside the Go runtime and process collectors.
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// DeployBuckets are histogram buckets for deploy phases, which run from
+// under a second (secret sync) to the rollout's progress deadline.
+var DeployBuckets = []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1200}
+
+// The server's metrics.
+var (
+	factory = promauto.With(Registry)
+
+	DeploysTotal = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "shipit_deploys_total",
+		Help: "Deploy and rollback jobs finished, by kind (deploy, rollback) and outcome (success, failed, rolled_back).",
+	}, []string{"kind", "outcome"})
+	DeployPhaseSeconds = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "shipit_deploy_phase_duration_seconds",
+		Help:    "Time deploys spend in each phase (secret_sync, predeploy, apply, rollout_watch).",
+		Buckets: DeployBuckets,
+	}, []string{"phase"})
+	AutoRollbacksTotal = factory.NewCounter(prometheus.CounterOpts{
+		Name: "shipit_auto_rollbacks_total",
+		Help: "Deploys rolled back automatically after their rollout or post-rollout checks failed.",
+	})
+	PorterSyncSeconds = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "shipit_porter_sync_duration_seconds",
+		Help:    "Duration of Porter discovery syncs, by cluster and result (success, error).",
+		Buckets: DeployBuckets,
+	}, []string{"cluster", "result"})
+	K8sAPIErrorsTotal = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "shipit_k8s_api_errors_total",
+		Help: "Kubernetes API requests that failed, by verb and HTTP status code (\"error\" when no response came back).",
+	}, []string{"verb", "code"})
+	ExecSessionsTotal = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "shipit_exec_sessions_total",
+		Help: "Exec sessions started, by mode (command, interactive).",
+	}, []string{"mode"})
+	ExecSessionsActive = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "shipit_exec_sessions_active",
+		Help: "Exec sessions in progress, by mode.",
+	}, []string{"mode"})
+	HTTPRequestSeconds = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "shipit_http_request_duration_seconds",
+		Help:    "HTTP request latency by method, route pattern and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "code"})
+)
+
+// Handler serves Registry in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveSince records the time elapsed since start, in seconds.
+func ObserveSince(o prometheus.Observer, start time.Time) {
+	o.Observe(time.Since(start).Seconds())
+}
+
+// ExecSession counts an exec session in mode as started and in progress;
+// call the returned func when it ends.
+func ExecSession(mode string) (done func()) {
+	ExecSessionsTotal.WithLabelValues(mode).Inc()
+	active := ExecSessionsActive.WithLabelValues(mode)
+	active.Inc()
+	return active.Dec
+}
@@ -0,0 +1,124 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/vigneshsubbiah/shipit/internal/config"
+)
+
+// FromConfig returns a Notifier that may deliver to the internal networks
+// listed in WEBHOOK_ALLOWED_NETWORKS, and to no other internal address.
+func FromConfig(cfg *config.Config) (*Notifier, error) {
+	allowed, err := parseNetworks(cfg.WebhookAllowedNetworks)
+	if err != nil {
+		return nil, fmt.Errorf("WEBHOOK_ALLOWED_NETWORKS: %w", err)
+	}
+	return New(allowed), nil
+}
+
+// parseNetworks parses a list of addresses and CIDRs. A bare address is a
+// network of one.
+func parseNetworks(entries []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range entries {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			networks = append(networks, network)
+			continue
+		}
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR", entry)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return networks, nil
+}
+
+// internalAddress reports whether ip is loopback, link-local, private or
+// unspecified: somewhere a webhook URL could reach the server's own
+// network (the cloud metadata endpoint, the database, the cluster API).
+func internalAddress(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsPrivate() || ip.IsUnspecified()
+}
+
+// addressAllowed reports whether deliveries may go to ip.
+func (n *Notifier) addressAllowed(ip net.IP) bool {
+	if !internalAddress(ip) {
+		return true
+	}
+	for _, network := range n.AllowedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckURL resolves a webhook URL's host and returns an error if any of
+// its addresses is internal and not allowed. Deliveries check the address
+// again when they connect, as the name can resolve differently by then.
+func (n *Notifier) CheckURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		return fmt.Errorf("must be an absolute http(s) URL")
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if !n.addressAllowed(ip) {
+			return fmt.Errorf("%s is an internal address", host)
+		}
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("cannot resolve %s", host)
+	}
+	for _, addr := range addrs {
+		if !n.addressAllowed(addr.IP) {
+			return fmt.Errorf("%s resolves to internal address %s", host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// dialControl refuses connections to addresses deliveries may not reach.
+// It runs on the address actually dialed, after name resolution, so a
+// host that passed CheckURL and was then repointed is still refused.
+func (n *Notifier) dialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !n.addressAllowed(ip) {
+		return fmt.Errorf("refusing to connect to internal address %s", host)
+	}
+	return nil
+}
+
+// deliveryClient returns an HTTP client whose connections pass
+// dialControl. It ignores HTTP(S)_PROXY: a proxy would make the dial
+// check apply to the proxy rather than the webhook.
+func (n *Notifier) deliveryClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   n.dialControl,
+	}).DialContext
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}
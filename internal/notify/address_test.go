@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckURL(t *testing.T) {
+	allowed, err := parseNetworks([]string{"10.20.0.0/16", "192.168.1.5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://203.0.113.7/hook", true},
+		{"https://[2001:db8::1]/hook", true},
+		{"http://127.0.0.1:8090/api", false},
+		{"http://[::1]/", false},
+		{"http://169.254.169.254/latest/meta-data/", false},
+		{"http://[fe80::1]/", false},
+		{"http://10.0.0.5/", false},
+		{"http://172.16.3.4/", false},
+		{"http://[fd00::1]/", false},
+		{"http://0.0.0.0/", false},
+		{"http://[::ffff:127.0.0.1]/", false},
+		{"http://10.20.3.4/hook", true},
+		{"http://192.168.1.5/hook", true},
+		{"http://192.168.1.6/hook", false},
+	}
+	n := New(allowed)
+	for _, tt := range tests {
+		err := n.CheckURL(t.Context(), tt.url)
+		if (err == nil) != tt.allowed {
+			t.Errorf("CheckURL(%s) = %v, want allowed %v", tt.url, err, tt.allowed)
+		}
+	}
+}
+
+func TestParseNetworksRejectsGarbage(t *testing.T) {
+	if _, err := parseNetworks([]string{"10.0.0.0/8", "intranet"}); err == nil {
+		t.Error("expected an error for a non-address entry")
+	}
+}
+
+// A URL that passed CheckURL can resolve to another address by the time
+// it is delivered to; the connection itself must be refused.
+func TestDeliveryRefusesInternalAddress(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer srv.Close()
+
+	n := New(nil)
+	n.MaxAttempts, n.Backoff = 1, time.Millisecond
+	if err := n.deliver(t.Context(), srv.URL, []byte(`{}`), nil); err == nil {
+		t.Error("expected delivery to a loopback address to fail")
+	}
+	if calls != 0 {
+		t.Errorf("server received %d requests, want 0", calls)
+	}
+
+	allowed, _ := parseNetworks([]string{"127.0.0.0/8"})
+	n = New(allowed)
+	n.MaxAttempts, n.Backoff = 1, time.Millisecond
+	if err := n.deliver(t.Context(), srv.URL, []byte(`{}`), nil); err != nil {
+		t.Errorf("delivery to an allowed network failed: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("server received %d requests, want 1", calls)
+	}
+}
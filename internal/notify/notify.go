@@ -0,0 +1,306 @@
+// Package notify delivers deploy lifecycle events to Slack incoming
+// webhooks and generic signed JSON webhooks.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventType identifies a deploy lifecycle event.
+type EventType string
+
+const (
+	EventDeployStarted   EventType = "deploy_started"
+	EventPredeployFailed EventType = "predeploy_failed"
+	EventDeploySucceeded EventType = "deploy_succeeded"
+	EventDeployFailed    EventType = "deploy_failed"
+	EventAutoRolledBack  EventType = "auto_rolled_back"
+)
+
+// EventTypes lists every event, in lifecycle order. Targets without an
+// explicit event list receive all of them.
+var EventTypes = []EventType{
+	EventDeployStarted,
+	EventPredeployFailed,
+	EventDeploySucceeded,
+	EventDeployFailed,
+	EventAutoRolledBack,
+}
+
+// ValidEventType reports whether s names a known event.
+func ValidEventType(s string) bool {
+	for _, t := range EventTypes {
+		if string(t) == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is the payload of a notification. Generic webhooks receive it as
+// JSON; Slack gets a one-line summary (SlackText).
+type Event struct {
+	ID        string    `json:"id"`
+	Type      EventType `json:"type"`
+	Time      time.Time `json:"time"`
+	AppID     string    `json:"app_id"`
+	App       string    `json:"app"`
+	Namespace string    `json:"namespace"`
+	Revision  int       `json:"revision,omitempty"`
+	Image     string    `json:"image,omitempty"`
+	CommitSHA string    `json:"commit_sha,omitempty"`
+	CIURL     string    `json:"ci_url,omitempty"`
+	// RollbackTo is the target revision of a manual rollback, or the
+	// revision restored by an auto-rollback.
+	RollbackTo  int    `json:"rollback_to,omitempty"`
+	RequestedBy string `json:"requested_by,omitempty"`
+	Message     string `json:"message,omitempty"`
+}
+
+// Target is where an app's events go. Either URL may be empty.
+type Target struct {
+	SlackWebhookURL string
+	WebhookURL      string
+	// WebhookSecret signs generic webhook bodies (X-Shipit-Signature-256,
+	// the same scheme as GitHub's X-Hub-Signature-256).
+	WebhookSecret string
+	Events        []EventType
+}
+
+// Wants reports whether the target has somewhere to deliver e and is
+// subscribed to it.
+func (t Target) Wants(e EventType) bool {
+	if t.SlackWebhookURL == "" && t.WebhookURL == "" {
+		return false
+	}
+	for _, want := range t.Events {
+		if want == e {
+			return true
+		}
+	}
+	return false
+}
+
+// Notifier sends events in the background, retrying failed deliveries
+// with exponential backoff. Deliveries are best-effort and in-memory: a
+// restart drops the ones still retrying.
+type Notifier struct {
+	Client      *http.Client
+	MaxAttempts int
+	Backoff     time.Duration // first retry delay; doubles per attempt
+	// AllowedNetworks are the internal networks (loopback, link-local,
+	// private) webhook URLs may point into; see CheckURL.
+	AllowedNetworks []*net.IPNet
+
+	wg sync.WaitGroup
+}
+
+// New returns a Notifier that tries each delivery up to 5 times over
+// roughly half a minute, and refuses to connect to internal addresses
+// outside allowed.
+func New(allowed []*net.IPNet) *Notifier {
+	n := &Notifier{
+		MaxAttempts:     5,
+		Backoff:         2 * time.Second,
+		AllowedNetworks: allowed,
+	}
+	n.Client = n.deliveryClient()
+	return n
+}
+
+// Send delivers e to every channel of target that is subscribed to it and
+// returns immediately.
+func (n *Notifier) Send(target Target, e Event) {
+	if !target.Wants(e.Type) {
+		return
+	}
+	if e.ID == "" {
+		e.ID = newEventID()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	if target.SlackWebhookURL != "" {
+		body, _ := json.Marshal(map[string]string{"text": SlackText(e)})
+		n.deliverAsync("slack", target.SlackWebhookURL, body, nil, e)
+	}
+	if target.WebhookURL != "" {
+		body, _ := json.Marshal(e)
+		headers := map[string]string{
+			"X-Shipit-Event":    string(e.Type),
+			"X-Shipit-Delivery": e.ID,
+		}
+		if target.WebhookSecret != "" {
+			headers["X-Shipit-Signature-256"] = Sign(target.WebhookSecret, body)
+		}
+		n.deliverAsync("webhook", target.WebhookURL, body, headers, e)
+	}
+}
+
+// Wait blocks until every delivery in flight has finished or given up.
+func (n *Notifier) Wait() {
+	n.wg.Wait()
+}
+
+func (n *Notifier) deliverAsync(channel, url string, body []byte, headers map[string]string, e Event) {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		if err := n.deliver(context.Background(), url, body, headers); err != nil {
+			log.Printf("notify: %s delivery failed app=%s event=%s id=%s err=%v", channel, e.AppID, e.Type, e.ID, err)
+		}
+	}()
+}
+
+// deliver POSTs body, retrying network errors, 429s and 5xx responses.
+// Other 4xx responses mean the request itself is wrong (revoked Slack
+// webhook, bad URL) and are not retried.
+func (n *Notifier) deliver(ctx context.Context, url string, body []byte, headers map[string]string) error {
+	attempts := n.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := n.Backoff
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		retry, err := n.post(ctx, url, body, headers)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry || attempt == attempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	return lastErr
+}
+
+func (n *Notifier) post(ctx context.Context, url string, body []byte, headers map[string]string) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "shipit-notify")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("%s returned %d", redactURL(url), resp.StatusCode)
+}
+
+// Sign returns the X-Shipit-Signature-256 value for body: "sha256=" and
+// the hex HMAC-SHA256 of the body keyed by secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SlackText renders an event as a Slack message.
+func SlackText(e Event) string {
+	what := "Deploy"
+	if e.RollbackTo > 0 && e.Type != EventAutoRolledBack {
+		what = "Rollback to revision " + strconv.Itoa(e.RollbackTo)
+	}
+	subject := fmt.Sprintf("*%s* (%s)", e.App, e.Namespace)
+	if e.Revision > 0 {
+		subject += fmt.Sprintf(" revision %d", e.Revision)
+	}
+
+	var line string
+	switch e.Type {
+	case EventDeployStarted:
+		line = fmt.Sprintf(":rocket: %s of %s started", what, subject)
+	case EventPredeployFailed:
+		line = fmt.Sprintf(":x: Pre-deploy hook failed for %s", subject)
+	case EventDeploySucceeded:
+		line = fmt.Sprintf(":white_check_mark: %s of %s succeeded", what, subject)
+	case EventDeployFailed:
+		line = fmt.Sprintf(":x: %s of %s failed", what, subject)
+	case EventAutoRolledBack:
+		line = fmt.Sprintf(":leftwards_arrow_with_hook: %s failed and was rolled back to revision %d", subject, e.RollbackTo)
+	default:
+		line = fmt.Sprintf("%s: %s", e.Type, subject)
+	}
+
+	var details []string
+	if e.Image != "" {
+		details = append(details, "`"+e.Image+"`")
+	}
+	if e.CommitSHA != "" {
+		sha := e.CommitSHA
+		if len(sha) > 7 {
+			sha = sha[:7]
+		}
+		if e.CIURL != "" {
+			sha = "<" + e.CIURL + "|" + sha + ">"
+		}
+		details = append(details, "commit "+sha)
+	}
+	if e.RequestedBy != "" {
+		details = append(details, "by "+e.RequestedBy)
+	}
+	if len(details) > 0 {
+		line += " (" + strings.Join(details, ", ") + ")"
+	}
+	if e.Message != "" {
+		msg := e.Message
+		if len(msg) > 500 {
+			msg = msg[:500] + "..."
+		}
+		line += "\n> " + strings.ReplaceAll(msg, "\n", "\n> ")
+	}
+	return line
+}
+
+// redactURL keeps webhook paths (which carry the Slack token) out of logs.
+func redactURL(url string) string {
+	if i := strings.Index(url, "://"); i >= 0 {
+		if j := strings.Index(url[i+3:], "/"); j >= 0 {
+			return url[:i+3+j] + "/..."
+		}
+	}
+	return url
+}
+
+func newEventID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
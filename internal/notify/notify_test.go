@@ -0,0 +1,148 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testNotifier() *Notifier {
+	return &Notifier{Client: http.DefaultClient, MaxAttempts: 3, Backoff: time.Millisecond}
+}
+
+func TestSendSignedWebhook(t *testing.T) {
+	var gotBody []byte
+	var gotHeader http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		gotHeader = r.Header
+	}))
+	defer srv.Close()
+
+	n := testNotifier()
+	n.Send(Target{
+		WebhookURL:    srv.URL + "/hook",
+		WebhookSecret: "s3cret",
+		Events:        EventTypes,
+	}, Event{Type: EventDeploySucceeded, AppID: "app-1", App: "web", Namespace: "prod", Revision: 4})
+	n.Wait()
+
+	if gotHeader.Get("X-Shipit-Event") != "deploy_succeeded" {
+		t.Errorf("X-Shipit-Event = %q", gotHeader.Get("X-Shipit-Event"))
+	}
+	if gotHeader.Get("X-Shipit-Delivery") == "" {
+		t.Error("missing X-Shipit-Delivery")
+	}
+	if want := Sign("s3cret", gotBody); gotHeader.Get("X-Shipit-Signature-256") != want {
+		t.Errorf("signature = %q, want %q", gotHeader.Get("X-Shipit-Signature-256"), want)
+	}
+	var e Event
+	if err := json.Unmarshal(gotBody, &e); err != nil {
+		t.Fatalf("body is not an event: %v", err)
+	}
+	if e.AppID != "app-1" || e.Revision != 4 || e.Time.IsZero() {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+func TestSendSlack(t *testing.T) {
+	var payload map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Shipit-Signature-256") != "" {
+			t.Error("slack deliveries should not be signed")
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	defer srv.Close()
+
+	n := testNotifier()
+	n.Send(Target{SlackWebhookURL: srv.URL, Events: EventTypes}, Event{
+		Type:      EventDeployFailed,
+		App:       "web",
+		Namespace: "prod",
+		Revision:  7,
+		CommitSHA: "0123456789abcdef",
+		Message:   "rollout timed out",
+	})
+	n.Wait()
+
+	text := payload["text"]
+	for _, want := range []string{"*web* (prod) revision 7", "failed", "commit 0123456", "> rollout timed out"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("slack text %q missing %q", text, want)
+		}
+	}
+}
+
+func TestSendSkipsUnsubscribedEvents(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer srv.Close()
+
+	n := testNotifier()
+	target := Target{WebhookURL: srv.URL, Events: []EventType{EventDeployFailed}}
+	n.Send(target, Event{Type: EventDeployStarted})
+	n.Send(Target{Events: EventTypes}, Event{Type: EventDeployStarted})
+	n.Wait()
+
+	if calls != 0 {
+		t.Errorf("got %d deliveries, want 0", calls)
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int
+		wantCalls int32
+		wantErr   bool
+	}{
+		{"succeeds after 5xx", []int{503, 502, 200}, 3, false},
+		{"retries 429", []int{429, 200}, 2, false},
+		{"gives up after max attempts", []int{500, 500, 500, 500}, 3, true},
+		{"does not retry 4xx", []int{404, 200}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				i := atomic.AddInt32(&calls, 1) - 1
+				w.WriteHeader(tt.statuses[i])
+			}))
+			defer srv.Close()
+
+			err := testNotifier().deliver(t.Context(), srv.URL, []byte(`{}`), nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestSlackTextRollback(t *testing.T) {
+	text := SlackText(Event{Type: EventAutoRolledBack, App: "web", Namespace: "prod", Revision: 9, RollbackTo: 8})
+	if !strings.Contains(text, "rolled back to revision 8") {
+		t.Errorf("unexpected text %q", text)
+	}
+	text = SlackText(Event{Type: EventDeploySucceeded, App: "web", Namespace: "prod", RollbackTo: 3, RequestedBy: "ana@example.com"})
+	if !strings.Contains(text, "Rollback to revision 3 of *web*") || !strings.Contains(text, "by ana@example.com") {
+		t.Errorf("unexpected text %q", text)
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	got := redactURL("https://hooks.slack.com/services/T000/B000/XXXX")
+	if got != "https://hooks.slack.com/..." {
+		t.Errorf("redactURL = %q", got)
+	}
+}
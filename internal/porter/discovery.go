@@ -11,6 +11,8 @@ import (
 	"time"
 
 	"github.com/vigneshsubbiah/shipit/internal/db"
+	"github.com/vigneshsubbiah/shipit/internal/k8s"
+	"github.com/vigneshsubbiah/shipit/internal/metrics"
 	appsv1 "k8s.io/api/apps/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -137,14 +139,23 @@ func (s *DiscoveryService) syncAllClusters(ctx context.Context) {
 }
 
 // SyncCluster discovers Porter apps in a specific cluster and syncs to database
-func (s *DiscoveryService) SyncCluster(ctx context.Context, clusterID string, kubeconfig []byte) error {
+func (s *DiscoveryService) SyncCluster(ctx context.Context, clusterID string, kubeconfig []byte) (err error) {
 	log.Printf("[Porter Discovery] Syncing cluster %s", clusterID)
+	start := time.Now()
+	defer func() {
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+		metrics.ObserveSince(metrics.PorterSyncSeconds.WithLabelValues(clusterID, result), start)
+	}()
 
 	// Create K8s client
 	config, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
 	if err != nil {
 		return fmt.Errorf("failed to parse kubeconfig: %w", err)
 	}
+	k8s.InstrumentConfig(config)
 
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
@@ -0,0 +1,115 @@
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Auth is a username/password for one registry.
+type Auth struct {
+	Username string
+	Password string
+}
+
+// Keychain maps registry hosts to credentials.
+type Keychain map[string]Auth
+
+// ParseDockerConfig reads credentials from a docker config: the
+// {"auths": {...}} layout of ~/.docker/config.json and
+// kubernetes.io/dockerconfigjson Secrets, or the bare host map of legacy
+// kubernetes.io/dockercfg Secrets. Entries carry either "auth"
+// (base64 "user:password") or "username" and "password".
+func ParseDockerConfig(data []byte) (Keychain, error) {
+	type entry struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	var cfg struct {
+		Auths map[string]entry `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid docker config: %w", err)
+	}
+	if cfg.Auths == nil {
+		if err := json.Unmarshal(data, &cfg.Auths); err != nil {
+			return nil, fmt.Errorf("invalid docker config: %w", err)
+		}
+	}
+
+	keychain := Keychain{}
+	for host, e := range cfg.Auths {
+		auth := Auth{Username: e.Username, Password: e.Password}
+		if e.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(e.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth for %s in docker config", host)
+			}
+			user, pass, ok := strings.Cut(string(decoded), ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid auth for %s in docker config", host)
+			}
+			auth = Auth{Username: user, Password: pass}
+		}
+		if auth.Username == "" && auth.Password == "" {
+			continue
+		}
+		keychain[normalizeHost(host)] = auth
+	}
+	return keychain, nil
+}
+
+// LoadDockerConfig reads a docker config file. An empty path is no
+// credentials.
+func LoadDockerConfig(path string) (Keychain, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDockerConfig(data)
+}
+
+// Merge returns a keychain with k's entries, falling back to other's for
+// hosts k has no credentials for.
+func (k Keychain) Merge(other Keychain) Keychain {
+	merged := Keychain{}
+	for host, auth := range other {
+		merged[host] = auth
+	}
+	for host, auth := range k {
+		merged[host] = auth
+	}
+	return merged
+}
+
+// lookup returns the credentials for a registry host.
+func (k Keychain) lookup(host string) (Auth, bool) {
+	auth, ok := k[normalizeHost(host)]
+	return auth, ok
+}
+
+// normalizeHost reduces a docker config key ("https://index.docker.io/v1/",
+// "ghcr.io") to the registry host, folding Docker Hub's aliases together.
+func normalizeHost(host string) string {
+	if strings.Contains(host, "://") {
+		if u, err := url.Parse(host); err == nil {
+			host = u.Host
+		}
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "/"))
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	switch host {
+	case dockerHubDomain, "index.docker.io", dockerHubRegistry:
+		return dockerHubRegistry
+	}
+	return host
+}
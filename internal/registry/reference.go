@@ -0,0 +1,82 @@
+// Package registry resolves container image tags to content digests using
+// the OCI distribution (registry v2) API.
+package registry
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	dockerHubDomain   = "docker.io"
+	dockerHubRegistry = "registry-1.docker.io"
+)
+
+// Reference is a parsed image reference.
+type Reference struct {
+	// Name is the image as written without its tag or digest, e.g.
+	// "nginx" or "ghcr.io/org/web". Pinned images keep it as-is.
+	Name string
+	// Registry is the host serving the v2 API ("registry-1.docker.io" for
+	// Docker Hub images).
+	Registry string
+	// Repository is the path within the registry, with Docker Hub's
+	// implicit "library/" prefix filled in.
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference splits an image into registry, repository, tag and digest.
+// A missing tag means "latest", as it does for the container runtime.
+func ParseReference(image string) (Reference, error) {
+	if image == "" || strings.ContainsAny(image, " \t\r\n") {
+		return Reference{}, fmt.Errorf("invalid image reference %q", image)
+	}
+	ref := Reference{}
+	name := image
+	if at := strings.Index(name, "@"); at >= 0 {
+		name, ref.Digest = name[:at], name[at+1:]
+		if !strings.HasPrefix(ref.Digest, "sha256:") {
+			return Reference{}, fmt.Errorf("invalid digest in image reference %q", image)
+		}
+	}
+	// The tag is after the last colon that follows the last slash, so a
+	// registry port (registry:5000/repo) is not mistaken for one.
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Tag = name[:i], name[i+1:]
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+	ref.Name = name
+
+	domain, path := dockerHubDomain, name
+	if i := strings.Index(name, "/"); i >= 0 {
+		first := name[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			domain, path = first, name[i+1:]
+		}
+	}
+	if path == "" || strings.HasSuffix(path, "/") {
+		return Reference{}, fmt.Errorf("invalid image reference %q", image)
+	}
+	if domain == dockerHubDomain || domain == "index.docker.io" {
+		domain = dockerHubRegistry
+		if !strings.Contains(path, "/") {
+			path = "library/" + path
+		}
+	}
+	ref.Registry = domain
+	ref.Repository = path
+	return ref, nil
+}
+
+// Pinned returns the image reference by digest, e.g. "nginx@sha256:...".
+func Pinned(image, digest string) (string, error) {
+	ref, err := ParseReference(image)
+	if err != nil {
+		return "", err
+	}
+	return ref.Name + "@" + digest, nil
+}
@@ -0,0 +1,229 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseReference(t *testing.T) {
+	cases := []struct {
+		image                           string
+		name, registry, repo, tag, dgst string
+	}{
+		{"nginx", "nginx", "registry-1.docker.io", "library/nginx", "latest", ""},
+		{"bitnami/redis:7.2", "bitnami/redis", "registry-1.docker.io", "bitnami/redis", "7.2", ""},
+		{"ghcr.io/org/web:9c2d1b8", "ghcr.io/org/web", "ghcr.io", "org/web", "9c2d1b8", ""},
+		{"registry.local:5000/team/app", "registry.local:5000/team/app", "registry.local:5000", "team/app", "latest", ""},
+		{"localhost/app:dev", "localhost/app", "localhost", "app", "dev", ""},
+		{"ghcr.io/org/web:v1@sha256:abc", "ghcr.io/org/web", "ghcr.io", "org/web", "v1", "sha256:abc"},
+	}
+	for _, tc := range cases {
+		ref, err := ParseReference(tc.image)
+		if err != nil {
+			t.Fatalf("ParseReference(%q): %v", tc.image, err)
+		}
+		want := Reference{Name: tc.name, Registry: tc.registry, Repository: tc.repo, Tag: tc.tag, Digest: tc.dgst}
+		if ref != want {
+			t.Errorf("ParseReference(%q) = %+v, want %+v", tc.image, ref, want)
+		}
+	}
+	for _, bad := range []string{"", "r/app :v1", "ghcr.io/", "app@md5:abc"} {
+		if _, err := ParseReference(bad); err == nil {
+			t.Errorf("ParseReference(%q) should fail", bad)
+		}
+	}
+
+	pinned, err := Pinned("ghcr.io/org/web:latest", "sha256:abc")
+	if err != nil || pinned != "ghcr.io/org/web@sha256:abc" {
+		t.Errorf("Pinned = %q, %v", pinned, err)
+	}
+}
+
+func TestParseDockerConfig(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("ci-bot:s3cr:et"))
+	modern := `{"auths": {
+		"https://index.docker.io/v1/": {"auth": "` + auth + `"},
+		"ghcr.io": {"username": "octocat", "password": "ghp_token"},
+		"quay.io": {}
+	}}`
+	keychain, err := ParseDockerConfig([]byte(modern))
+	if err != nil {
+		t.Fatalf("ParseDockerConfig: %v", err)
+	}
+	if got, ok := keychain.lookup("registry-1.docker.io"); !ok || got != (Auth{"ci-bot", "s3cr:et"}) {
+		t.Errorf("docker hub auth = %+v, %v", got, ok)
+	}
+	if got, _ := keychain.lookup("GHCR.io"); got != (Auth{"octocat", "ghp_token"}) {
+		t.Errorf("ghcr auth = %+v", got)
+	}
+	if _, ok := keychain.lookup("quay.io"); ok {
+		t.Error("entry without credentials should be skipped")
+	}
+
+	legacy, err := ParseDockerConfig([]byte(`{"registry.local:5000": {"auth": "` + auth + `"}}`))
+	if err != nil {
+		t.Fatalf("ParseDockerConfig legacy: %v", err)
+	}
+	if _, ok := legacy.lookup("registry.local:5000"); !ok {
+		t.Error("legacy .dockercfg entry not found")
+	}
+
+	merged := Keychain{"ghcr.io": {"cluster", "x"}}.Merge(keychain)
+	if merged["ghcr.io"].Username != "cluster" || merged[dockerHubRegistry].Username != "ci-bot" {
+		t.Errorf("merged = %+v", merged)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull"`)
+	if scheme != "Bearer" || params["realm"] != "https://auth.docker.io/token" ||
+		params["service"] != "registry.docker.io" || params["scope"] != "repository:library/nginx:pull" {
+		t.Errorf("parseChallenge = %s %v", scheme, params)
+	}
+}
+
+// fakeRegistry serves one manifest behind token auth, the way Docker Hub
+// and GHCR do.
+type fakeRegistry struct {
+	manifest    []byte
+	digest      string
+	omitDigest  bool   // HEAD/GET without Docker-Content-Digest
+	basicOnly   bool   // Basic challenge instead of Bearer
+	requireAuth string // "user:password" the token endpoint insists on, if any
+	heads, gets int
+}
+
+func (f *fakeRegistry) handler(t *testing.T, srvURL func() string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("scope") != "repository:team/app:pull" {
+			t.Errorf("token scope = %q", r.URL.Query().Get("scope"))
+		}
+		if f.requireAuth != "" {
+			user, pass, _ := r.BasicAuth()
+			if user+":"+pass != f.requireAuth {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "tok"})
+	})
+	mux.HandleFunc("/v2/team/app/manifests/", func(w http.ResponseWriter, r *http.Request) {
+		authorized := r.Header.Get("Authorization") == "Bearer tok"
+		if f.basicOnly {
+			user, pass, _ := r.BasicAuth()
+			authorized = user+":"+pass == f.requireAuth
+		}
+		if !authorized {
+			if f.basicOnly {
+				w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+srvURL()+`/token",service="test",scope="repository:team/app:pull"`)
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !strings.HasSuffix(r.URL.Path, "/v1") {
+			http.NotFound(w, r)
+			return
+		}
+		if !strings.Contains(r.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json") {
+			t.Errorf("Accept = %q", r.Header.Get("Accept"))
+		}
+		if r.Method == http.MethodHead {
+			f.heads++
+		} else {
+			f.gets++
+		}
+		if !f.omitDigest {
+			w.Header().Set("Docker-Content-Digest", f.digest)
+		}
+		w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+		if r.Method == http.MethodGet {
+			w.Write(f.manifest)
+		}
+	})
+	return mux
+}
+
+func newFakeRegistry(t *testing.T, f *fakeRegistry) (*Resolver, string) {
+	t.Helper()
+	f.manifest = []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`)
+	sum := sha256.Sum256(f.manifest)
+	f.digest = "sha256:" + hex.EncodeToString(sum[:])
+
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(f.handler(t, func() string { return srv.URL }))
+	t.Cleanup(srv.Close)
+	return &Resolver{Client: srv.Client()}, strings.TrimPrefix(srv.URL, "https://")
+}
+
+func TestResolve_BearerTokenWithCredentials(t *testing.T) {
+	f := &fakeRegistry{requireAuth: "ci-bot:secret"}
+	resolver, host := newFakeRegistry(t, f)
+
+	keychain := Keychain{host: {"ci-bot", "secret"}}
+	digest, err := resolver.Resolve(context.Background(), host+"/team/app:v1", keychain)
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if digest != f.digest {
+		t.Errorf("digest = %s, want %s", digest, f.digest)
+	}
+	if f.heads != 1 || f.gets != 0 {
+		t.Errorf("heads=%d gets=%d; HEAD with Docker-Content-Digest should be enough", f.heads, f.gets)
+	}
+
+	if _, err := resolver.Resolve(context.Background(), host+"/team/app:v1", nil); err == nil {
+		t.Error("expected the token endpoint to refuse anonymous access")
+	}
+}
+
+func TestResolve_ComputesDigestWithoutHeader(t *testing.T) {
+	f := &fakeRegistry{omitDigest: true}
+	resolver, host := newFakeRegistry(t, f)
+
+	digest, err := resolver.Resolve(context.Background(), host+"/team/app:v1", nil)
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if digest != f.digest || f.gets != 1 {
+		t.Errorf("digest = %s (gets=%d), want %s from hashing the manifest", digest, f.gets, f.digest)
+	}
+}
+
+func TestResolve_BasicChallenge(t *testing.T) {
+	f := &fakeRegistry{basicOnly: true, requireAuth: "admin:pw"}
+	resolver, host := newFakeRegistry(t, f)
+
+	digest, err := resolver.Resolve(context.Background(), host+"/team/app:v1", Keychain{host: {"admin", "pw"}})
+	if err != nil || digest != f.digest {
+		t.Fatalf("Resolve = %s, %v", digest, err)
+	}
+	if _, err := resolver.Resolve(context.Background(), host+"/team/app:v1", nil); err == nil {
+		t.Error("expected basic challenge without credentials to fail")
+	}
+}
+
+func TestResolve_Errors(t *testing.T) {
+	resolver, host := newFakeRegistry(t, &fakeRegistry{})
+
+	_, err := resolver.Resolve(context.Background(), host+"/team/app:missing", nil)
+	if err == nil || !strings.Contains(err.Error(), "manifest not found") {
+		t.Errorf("missing tag err = %v", err)
+	}
+
+	// Already pinned: returned without asking the registry.
+	pinned := "sha256:" + strings.Repeat("a", 64)
+	digest, err := (&Resolver{}).Resolve(context.Background(), "unreachable.invalid/app@"+pinned, nil)
+	if err != nil || digest != pinned {
+		t.Errorf("pinned image = %s, %v", digest, err)
+	}
+}
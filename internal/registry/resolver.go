@@ -0,0 +1,234 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// manifestMediaTypes are the manifest formats a runtime may pull. Asking
+// for the index/list types first returns the digest of the multi-arch
+// manifest, which is what a pull by tag resolves to as well.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+var digestPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// maxManifestSize bounds the body read when a registry doesn't return
+// Docker-Content-Digest and the digest has to be computed.
+const maxManifestSize = 4 << 20
+
+// Resolver looks up manifest digests from registries.
+type Resolver struct {
+	Client *http.Client
+}
+
+// NewResolver returns a Resolver with a bounded request timeout.
+func NewResolver() *Resolver {
+	return &Resolver{Client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+// Resolve returns the digest ("sha256:...") the image's tag currently
+// points to. Images already pinned by digest are returned as-is without a
+// request. Credentials for the registry are taken from keychain, if any.
+func (r *Resolver) Resolve(ctx context.Context, image string, keychain Keychain) (string, error) {
+	ref, err := ParseReference(image)
+	if err != nil {
+		return "", err
+	}
+	if ref.Digest != "" {
+		return ref.Digest, nil
+	}
+	auth, hasAuth := keychain.lookup(ref.Registry)
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", ref.Registry, ref.Repository, ref.Tag)
+
+	// HEAD is enough when the registry returns Docker-Content-Digest (and
+	// doesn't count against Docker Hub's pull rate limit); fall back to
+	// GET and hash the manifest otherwise.
+	authorization := ""
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		resp, err := r.fetchManifest(ctx, method, manifestURL, authorization)
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && authorization == "" {
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+			authorization, err = r.authorize(ctx, challenge, auth, hasAuth)
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", ref.Registry, err)
+			}
+			if resp, err = r.fetchManifest(ctx, method, manifestURL, authorization); err != nil {
+				return "", err
+			}
+		}
+
+		digest, err := manifestDigest(method, resp)
+		resp.Body.Close()
+		if err != nil {
+			return "", fmt.Errorf("%s:%s: %w", ref.Name, ref.Tag, err)
+		}
+		if digest != "" {
+			return digest, nil
+		}
+	}
+	return "", fmt.Errorf("%s:%s: registry returned no digest", ref.Name, ref.Tag)
+}
+
+func (r *Resolver) fetchManifest(ctx context.Context, method, manifestURL, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return r.client().Do(req)
+}
+
+// manifestDigest reads the digest off a manifest response. Returns "" with
+// no error when a HEAD response simply lacked the header.
+func manifestDigest(method string, resp *http.Response) (string, error) {
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "", fmt.Errorf("registry denied access (%d)", resp.StatusCode)
+	case http.StatusNotFound:
+		return "", errors.New("manifest not found")
+	default:
+		return "", fmt.Errorf("registry returned %d", resp.StatusCode)
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		if !digestPattern.MatchString(digest) {
+			return "", fmt.Errorf("unsupported digest %q", digest)
+		}
+		return digest, nil
+	}
+	if method == http.MethodHead {
+		return "", nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(body) > maxManifestSize {
+		return "", errors.New("manifest too large")
+	}
+	sum := sha256.Sum256(body)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// authorize answers a 401 challenge: Basic sends the credentials directly,
+// Bearer exchanges them (or nothing, for public images) for a token at the
+// challenge's realm.
+func (r *Resolver) authorize(ctx context.Context, challenge string, auth Auth, hasAuth bool) (string, error) {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if !hasAuth {
+			return "", errors.New("registry requires credentials")
+		}
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(auth.Username, auth.Password)
+		return req.Header.Get("Authorization"), nil
+	case "bearer":
+	default:
+		return "", fmt.Errorf("unsupported auth challenge %q", challenge)
+	}
+
+	realm := params["realm"]
+	if realm == "" {
+		return "", errors.New("bearer challenge without realm")
+	}
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return "", fmt.Errorf("invalid token realm %q", realm)
+	}
+	q := tokenURL.Query()
+	for _, key := range []string{"service", "scope"} {
+		if v := params[key]; v != "" {
+			q.Set(key, v)
+		}
+	}
+	tokenURL.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if hasAuth {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	}
+	resp, err := r.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request returned %d", resp.StatusCode)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid token response: %w", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return "", errors.New("token response without a token")
+	}
+	return "Bearer " + token.Token, nil
+}
+
+// parseChallenge splits a WWW-Authenticate header such as
+// `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`
+// into its scheme and parameters.
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := map[string]string{}
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				params[key] = value[1:]
+				break
+			}
+			params[key] = value[1 : end+1]
+			rest = value[end+2:]
+		} else {
+			value, rest, _ = strings.Cut(value, ",")
+			params[key] = strings.TrimSpace(value)
+		}
+	}
+	return scheme, params
+}
+
+func (r *Resolver) client() *http.Client {
+	if r.Client != nil {
+		return r.Client
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,113 @@
+// Package secretexport seals exported app secrets with a passphrase, so
+// an export file can be kept or moved without exposing the values. The
+// server seals exports; the CLI opens them again on import.
+package secretexport
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Format identifies sealed export files.
+const Format = "shipit-secrets/v1"
+
+// MinPassphraseLength is the shortest passphrase Seal accepts.
+const MinPassphraseLength = 12
+
+const iterations = 600_000 // OWASP's recommendation for PBKDF2-HMAC-SHA256
+
+// Secrets is the content of an export: stored values and external
+// references, by key. It is also the JSON body of a bulk secrets import.
+type Secrets struct {
+	Secrets map[string]string `json:"secrets"`
+	Refs    map[string]string `json:"refs,omitempty"`
+}
+
+// File is a sealed export.
+type File struct {
+	Format     string `json:"format"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Seal encrypts secrets with a key derived from passphrase and returns the
+// export file.
+func Seal(secrets Secrets, passphrase string) ([]byte, error) {
+	if len(passphrase) < MinPassphraseLength {
+		return nil, fmt.Errorf("passphrase must be at least %d characters", MinPassphraseLength)
+	}
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	f := File{Format: Format, KDF: "pbkdf2-sha256", Iterations: iterations, Salt: make([]byte, 16)}
+	if _, err := rand.Read(f.Salt); err != nil {
+		return nil, err
+	}
+	gcm, err := f.cipher(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	f.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(f.Nonce); err != nil {
+		return nil, err
+	}
+	f.Ciphertext = gcm.Seal(nil, f.Nonce, plaintext, []byte(Format))
+	return json.MarshalIndent(f, "", "  ")
+}
+
+// IsSealed reports whether data looks like an export file.
+func IsSealed(data []byte) bool {
+	var f struct {
+		Format string `json:"format"`
+	}
+	return json.Unmarshal(bytes.TrimSpace(data), &f) == nil && f.Format == Format
+}
+
+// Open decrypts an export file.
+func Open(data []byte, passphrase string) (Secrets, error) {
+	var f File
+	if err := json.Unmarshal(data, &f); err != nil || f.Format != Format {
+		return Secrets{}, errors.New("not a shipit secrets export")
+	}
+	if f.KDF != "pbkdf2-sha256" || f.Iterations <= 0 {
+		return Secrets{}, fmt.Errorf("unsupported key derivation %q", f.KDF)
+	}
+	gcm, err := f.cipher(passphrase)
+	if err != nil {
+		return Secrets{}, err
+	}
+	if len(f.Nonce) != gcm.NonceSize() {
+		return Secrets{}, errors.New("corrupt export file")
+	}
+	plaintext, err := gcm.Open(nil, f.Nonce, f.Ciphertext, []byte(Format))
+	if err != nil {
+		return Secrets{}, errors.New("wrong passphrase or corrupt export file")
+	}
+	var secrets Secrets
+	err = json.Unmarshal(plaintext, &secrets)
+	return secrets, err
+}
+
+func (f *File) cipher(passphrase string) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, f.Salt, f.Iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
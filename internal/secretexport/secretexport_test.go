@@ -0,0 +1,44 @@
+package secretexport
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	secrets := Secrets{
+		Secrets: map[string]string{"DATABASE_URL": "postgres://db/app"},
+		Refs:    map[string]string{"STRIPE_KEY": "vault://kv/payments#api_key"},
+	}
+	sealed, err := Seal(secrets, "correct horse battery")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if bytes.Contains(sealed, []byte("postgres://")) || bytes.Contains(sealed, []byte("vault://")) {
+		t.Fatal("export contains plaintext")
+	}
+	if !IsSealed(sealed) {
+		t.Error("IsSealed(export) = false")
+	}
+	if IsSealed([]byte("A=1\n")) || IsSealed([]byte(`{"secrets": {}}`)) {
+		t.Error("IsSealed should be false for .env and plain JSON")
+	}
+
+	got, err := Open(sealed, "correct horse battery")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if !reflect.DeepEqual(got, secrets) {
+		t.Errorf("Open = %#v, want %#v", got, secrets)
+	}
+	if _, err := Open(sealed, "wrong horse battery"); err == nil {
+		t.Error("Open with the wrong passphrase succeeded")
+	}
+}
+
+func TestSealRejectsShortPassphrase(t *testing.T) {
+	if _, err := Seal(Secrets{}, "short"); err == nil {
+		t.Error("expected an error for a short passphrase")
+	}
+}
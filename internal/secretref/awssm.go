@@ -0,0 +1,70 @@
+package secretref
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// AWSSecretsManager reads secrets from AWS Secrets Manager through the aws
+// CLI, so it picks up the same credentials (IRSA on EKS) as cluster
+// authentication. In awssm://prod/payments#api_key, "prod/payments" is the
+// secret's name or ARN and "api_key" a key of its JSON value; without a
+// field the whole SecretString is used.
+type AWSSecretsManager struct {
+	region string
+
+	// run executes the aws CLI; replaced in tests.
+	run func(ctx context.Context, args ...string) ([]byte, error)
+}
+
+// NewAWSSecretsManager returns a provider for region (the CLI's default
+// region when empty).
+func NewAWSSecretsManager(region string) *AWSSecretsManager {
+	return &AWSSecretsManager{region: region, run: runAWS}
+}
+
+// Resolve implements Provider.
+func (a *AWSSecretsManager) Resolve(ctx context.Context, ref Ref) (string, error) {
+	args := []string{"secretsmanager", "get-secret-value", "--secret-id", ref.Path, "--query", "SecretString", "--output", "text"}
+	if a.region != "" {
+		args = append(args, "--region", a.region)
+	}
+	out, err := a.run(ctx, args...)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimSuffix(string(out), "\n")
+	if ref.Field == "" {
+		return secret, nil
+	}
+
+	var data map[string]any
+	if err := json.Unmarshal([]byte(secret), &data); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object, so #%s can't be selected", ref.Field)
+	}
+	return pickField(data, ref.Field)
+}
+
+func runAWS(ctx context.Context, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "aws", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, errors.New("the aws CLI is not installed on the server")
+	}
+	if err != nil {
+		// The CLI's error names the failing call and secret, e.g.
+		// ResourceNotFoundException or AccessDeniedException.
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.New(msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,268 @@
+// Package secretref resolves references to secrets kept outside shipit,
+// such as vault://kv/payments/stripe#api_key or
+// awssm://prod/payments#api_key, to their current values. App secrets can
+// hold a reference instead of a value; it is resolved each time the app's
+// Kubernetes Secret is written.
+//
+// The server's credentials can usually read far more than any one project
+// should, so each scheme only resolves paths under the prefixes configured
+// for it (VAULT_PATH_PREFIXES, AWS_SECRETS_PATH_PREFIXES), which may name
+// the app's project with {project} or {project_id}.
+package secretref
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vigneshsubbiah/shipit/internal/config"
+)
+
+// Ref is a parsed secret reference: <scheme>://<path>[#<field>].
+type Ref struct {
+	Scheme string // provider, e.g. "vault" or "awssm"
+	Path   string // provider-specific location of the secret
+	Field  string // key within the secret; optional for single-valued secrets
+}
+
+// Parse parses a reference. The path is everything between "://" and the
+// last "#", so it may contain ':' (AWS ARNs) and '/'.
+func Parse(s string) (Ref, error) {
+	scheme, rest, ok := strings.Cut(s, "://")
+	if !ok || scheme == "" {
+		return Ref{}, fmt.Errorf("invalid secret reference %q: want <scheme>://<path>[#<field>]", s)
+	}
+	ref := Ref{Scheme: scheme, Path: rest}
+	if i := strings.LastIndex(rest, "#"); i >= 0 {
+		ref.Path, ref.Field = rest[:i], rest[i+1:]
+		if ref.Field == "" {
+			return Ref{}, fmt.Errorf("invalid secret reference %q: empty field after #", s)
+		}
+	}
+	if strings.Trim(ref.Path, "/") == "" {
+		return Ref{}, fmt.Errorf("invalid secret reference %q: missing path", s)
+	}
+	return ref, nil
+}
+
+func (r Ref) String() string {
+	s := r.Scheme + "://" + r.Path
+	if r.Field != "" {
+		s += "#" + r.Field
+	}
+	return s
+}
+
+// Project is the project whose app holds a reference, for the {project}
+// and {project_id} placeholders in path prefixes.
+type Project struct {
+	ID   string
+	Name string
+}
+
+// Provider fetches secrets for one reference scheme.
+type Provider interface {
+	// Resolve returns the value ref points to.
+	Resolve(ctx context.Context, ref Ref) (string, error)
+}
+
+// Resolver resolves references through the provider registered for their
+// scheme, caching values for a while so a burst of deploys doesn't hit the
+// backing store once per secret per deploy.
+type Resolver struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	providers map[string]Provider
+	prefixes  map[string][]string // scheme -> allowed path prefixes
+	cache     map[string]cachedValue
+}
+
+type cachedValue struct {
+	value   string
+	expires time.Time
+}
+
+// NewResolver returns a resolver with no providers that caches values for
+// ttl (0 disables caching).
+func NewResolver(ttl time.Duration) *Resolver {
+	return &Resolver{
+		ttl:       ttl,
+		providers: make(map[string]Provider),
+		prefixes:  make(map[string][]string),
+		cache:     make(map[string]cachedValue),
+	}
+}
+
+// FromConfig returns a resolver with the providers configured in cfg:
+// Vault when VAULT_ADDR is set, and AWS Secrets Manager (through the aws
+// CLI, like EKS authentication) always.
+func FromConfig(cfg *config.Config) *Resolver {
+	r := NewResolver(time.Duration(cfg.SecretRefCacheSeconds) * time.Second)
+	if cfg.VaultAddr != "" {
+		r.Register("vault", NewVault(cfg.VaultAddr, cfg.VaultToken, cfg.VaultNamespace))
+	}
+	r.Register("awssm", NewAWSSecretsManager(cfg.AWSSecretsRegion))
+	r.AllowPrefixes("vault", cfg.VaultPathPrefixes)
+	r.AllowPrefixes("awssm", cfg.AWSSecretsPathPrefixes)
+	return r
+}
+
+// Register sets the provider for a scheme.
+func (r *Resolver) Register(scheme string, p Provider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.providers[scheme] = p
+}
+
+// AllowPrefixes sets the path prefixes a scheme's references must fall
+// under. A scheme without any resolves nothing.
+func (r *Resolver) AllowPrefixes(scheme string, prefixes []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prefixes[scheme] = prefixes
+}
+
+// Check reports whether an app in project may use ref: that it parses, a
+// provider handles its scheme, and its path is under one of the scheme's
+// prefixes. It doesn't contact the provider.
+func (r *Resolver) Check(s string, project Project) (Ref, error) {
+	ref, err := Parse(s)
+	if err != nil {
+		return ref, err
+	}
+	if _, err := r.provider(ref.Scheme); err != nil {
+		return ref, err
+	}
+	if err := r.checkPath(ref, project); err != nil {
+		return ref, err
+	}
+	return ref, nil
+}
+
+// checkPath matches ref's path against its scheme's prefixes, whole
+// segments at a time, so kv/shipit/pay doesn't admit kv/shipit/payments.
+// Paths with empty, "." or ".." segments are refused outright, as the
+// provider might resolve them to somewhere else.
+func (r *Resolver) checkPath(ref Ref, project Project) error {
+	path := strings.Trim(ref.Path, "/")
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("invalid secret reference %s: path has an empty, . or .. segment", ref)
+		}
+	}
+
+	r.mu.Lock()
+	prefixes := r.prefixes[ref.Scheme]
+	r.mu.Unlock()
+	if len(prefixes) == 0 {
+		return fmt.Errorf("%s:// references need allowed path prefixes configured on the server (%s)", ref.Scheme, prefixesVar(ref.Scheme))
+	}
+
+	var allowed []string
+	for _, p := range prefixes {
+		prefix, ok := expandPrefix(p, project)
+		if !ok {
+			continue
+		}
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return nil
+		}
+		allowed = append(allowed, prefix+"/")
+	}
+	if len(allowed) == 0 {
+		return fmt.Errorf("%s:// references are not allowed in this project", ref.Scheme)
+	}
+	return fmt.Errorf("secret reference %s is outside this project's allowed paths (%s)", ref, strings.Join(allowed, ", "))
+}
+
+// expandPrefix fills in a prefix's placeholders. A project name that isn't
+// a single plain path segment can't be placed in a path, so the prefix
+// doesn't apply.
+func expandPrefix(prefix string, project Project) (string, bool) {
+	if strings.Contains(prefix, "{project}") {
+		name := project.Name
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/#") {
+			return "", false
+		}
+		prefix = strings.ReplaceAll(prefix, "{project}", name)
+	}
+	if strings.Contains(prefix, "{project_id}") {
+		if project.ID == "" {
+			return "", false
+		}
+		prefix = strings.ReplaceAll(prefix, "{project_id}", project.ID)
+	}
+	prefix = strings.Trim(prefix, "/")
+	return prefix, prefix != ""
+}
+
+func prefixesVar(scheme string) string {
+	switch scheme {
+	case "vault":
+		return "VAULT_PATH_PREFIXES"
+	case "awssm":
+		return "AWS_SECRETS_PATH_PREFIXES"
+	}
+	return "path prefixes"
+}
+
+// Resolve returns the value ref points to. Errors name the reference and
+// never include secret material.
+func (r *Resolver) Resolve(ctx context.Context, ref Ref) (string, error) {
+	key := ref.String()
+	r.mu.Lock()
+	if c, ok := r.cache[key]; ok && time.Now().Before(c.expires) {
+		r.mu.Unlock()
+		return c.value, nil
+	}
+	r.mu.Unlock()
+
+	p, err := r.provider(ref.Scheme)
+	if err != nil {
+		return "", err
+	}
+	value, err := p.Resolve(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %w", key, err)
+	}
+
+	if r.ttl > 0 {
+		r.mu.Lock()
+		r.cache[key] = cachedValue{value: value, expires: time.Now().Add(r.ttl)}
+		r.mu.Unlock()
+	}
+	return value, nil
+}
+
+func (r *Resolver) provider(scheme string) (Provider, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p, ok := r.providers[scheme]; ok {
+		return p, nil
+	}
+	if scheme == "vault" {
+		return nil, fmt.Errorf("vault:// references need VAULT_ADDR (and VAULT_TOKEN) set on the server")
+	}
+	schemes := make([]string, 0, len(r.providers))
+	for s := range r.providers {
+		schemes = append(schemes, s+"://")
+	}
+	sort.Strings(schemes)
+	return nil, fmt.Errorf("unsupported secret reference scheme %q (supported: %s)", scheme, strings.Join(schemes, ", "))
+}
+
+// Static is a Provider serving fixed values by reference string, for tests
+// and local development.
+type Static map[string]string
+
+// Resolve implements Provider.
+func (s Static) Resolve(ctx context.Context, ref Ref) (string, error) {
+	if v, ok := s[ref.String()]; ok {
+		return v, nil
+	}
+	return "", fmt.Errorf("not found")
+}
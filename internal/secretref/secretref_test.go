@@ -0,0 +1,217 @@
+package secretref
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Ref
+	}{
+		{"vault://kv/payments/stripe#api_key", Ref{"vault", "kv/payments/stripe", "api_key"}},
+		{"awssm://prod/payments", Ref{"awssm", "prod/payments", ""}},
+		{"awssm://arn:aws:secretsmanager:us-west-2:123456789012:secret:prod/db-AbCdEf#password",
+			Ref{"awssm", "arn:aws:secretsmanager:us-west-2:123456789012:secret:prod/db-AbCdEf", "password"}},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("Parse(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+		}
+		if got.String() != tt.in {
+			t.Errorf("String() = %q, want %q", got.String(), tt.in)
+		}
+	}
+	for _, bad := range []string{"", "kv/payments", "://x", "vault://", "vault://kv/x#"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q): expected error", bad)
+		}
+	}
+}
+
+type countingProvider struct {
+	calls int
+	value string
+	err   error
+}
+
+func (p *countingProvider) Resolve(ctx context.Context, ref Ref) (string, error) {
+	p.calls++
+	return p.value, p.err
+}
+
+func TestResolverCachesValues(t *testing.T) {
+	p := &countingProvider{value: "s3cr3t"}
+	r := NewResolver(time.Minute)
+	r.Register("fake", p)
+	r.AllowPrefixes("fake", []string{"a"})
+	ref, err := r.Check("fake://a/b#c", Project{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if v, err := r.Resolve(context.Background(), ref); err != nil || v != "s3cr3t" {
+			t.Fatalf("Resolve = %q, %v", v, err)
+		}
+	}
+	if p.calls != 1 {
+		t.Errorf("provider called %d times, want 1", p.calls)
+	}
+
+	// Failures aren't cached and name the reference.
+	failing := &countingProvider{err: errors.New("permission denied")}
+	r.Register("down", failing)
+	ref, _ = Parse("down://x#y")
+	for range 2 {
+		_, err := r.Resolve(context.Background(), ref)
+		if err == nil || !strings.Contains(err.Error(), "down://x#y") || !strings.Contains(err.Error(), "permission denied") {
+			t.Errorf("err = %v", err)
+		}
+	}
+	if failing.calls != 2 {
+		t.Errorf("failing provider called %d times, want 2", failing.calls)
+	}
+}
+
+func TestResolverUnknownScheme(t *testing.T) {
+	r := NewResolver(0)
+	r.Register("awssm", Static{})
+	if _, err := r.Check("vault://kv/a#b", Project{}); err == nil || !strings.Contains(err.Error(), "VAULT_ADDR") {
+		t.Errorf("vault without VAULT_ADDR: err = %v", err)
+	}
+	if _, err := r.Check("gcpsm://a", Project{}); err == nil || !strings.Contains(err.Error(), "awssm://") {
+		t.Errorf("unknown scheme: err = %v", err)
+	}
+}
+
+func TestResolverPathPrefixes(t *testing.T) {
+	r := NewResolver(0)
+	r.Register("vault", Static{})
+	r.Register("awssm", Static{})
+	r.AllowPrefixes("vault", []string{"kv/shipit/{project}/", "kv/ids/{project_id}"})
+	payments := Project{ID: "p-1", Name: "payments"}
+
+	allowed := []string{
+		"vault://kv/shipit/payments/stripe#api_key",
+		"vault://kv/shipit/payments#api_key",
+		"vault://kv/ids/p-1/db#password",
+	}
+	for _, s := range allowed {
+		if _, err := r.Check(s, payments); err != nil {
+			t.Errorf("Check(%s): %v", s, err)
+		}
+	}
+
+	rejected := []string{
+		"vault://kv/shipit/billing/stripe#api_key",  // another project's secrets
+		"vault://kv/shipit/payments-old/x#y",        // not on a segment boundary
+		"vault://kv/shipit/payments/../billing/x#y", // escapes the prefix
+		"vault://kv/shipit/payments//x#y",           // empty segment
+		"vault://kv/ids/p-2/db#password",            // another project's ID
+		"vault://secret/platform/root#token",        // outside every prefix
+		"awssm://shipit/payments/db#password",       // no prefixes for awssm
+	}
+	for _, s := range rejected {
+		if _, err := r.Check(s, payments); err == nil {
+			t.Errorf("Check(%s): expected rejection", s)
+		}
+	}
+
+	// A project name that isn't a single path segment matches no
+	// {project} prefix.
+	if _, err := r.Check("vault://kv/shipit/payments/x/stripe#k", Project{ID: "p-3", Name: "payments/x"}); err == nil {
+		t.Error("project name with a slash matched a {project} prefix")
+	}
+}
+
+// fakeVault serves KV v2 reads like Vault's HTTP API.
+func fakeVault(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/kv/data/payments/stripe":
+			w.Write([]byte(`{"data": {"data": {"api_key": "sk_live_1", "webhook_secret": "whsec_1"}, "metadata": {"version": 3}}}`))
+		case "/v1/kv/data/single":
+			w.Write([]byte(`{"data": {"data": {"token": "t0k3n"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestVault(t *testing.T) {
+	server := fakeVault(t)
+	defer server.Close()
+	v := NewVault(server.URL, "root", "")
+	ctx := context.Background()
+
+	tests := []struct {
+		ref, want, wantErr string
+	}{
+		{ref: "vault://kv/payments/stripe#api_key", want: "sk_live_1"},
+		{ref: "vault://kv/single", want: "t0k3n"},
+		{ref: "vault://kv/payments/stripe", wantErr: "add #<field>"},
+		{ref: "vault://kv/payments/stripe#nope", wantErr: `no field "nope" in secret (fields: api_key, webhook_secret)`},
+		{ref: "vault://kv/missing#x", wantErr: "no secret at kv/missing"},
+		{ref: "vault://kv#x", wantErr: "want vault://<mount>/<path>#<field>"},
+	}
+	for _, tt := range tests {
+		ref, _ := Parse(tt.ref)
+		got, err := v.Resolve(ctx, ref)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: err = %v, want %q", tt.ref, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %q, %v; want %q", tt.ref, got, err, tt.want)
+		}
+	}
+
+	ref, _ := Parse("vault://kv/payments/stripe#api_key")
+	if _, err := NewVault(server.URL, "wrong", "").Resolve(ctx, ref); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("bad token: err = %v", err)
+	}
+}
+
+func TestAWSSecretsManager(t *testing.T) {
+	a := NewAWSSecretsManager("us-west-2")
+	var gotArgs []string
+	a.run = func(ctx context.Context, args ...string) ([]byte, error) {
+		gotArgs = args
+		return []byte(`{"username": "app", "password": "hunter2", "port": 5432}` + "\n"), nil
+	}
+
+	ref, _ := Parse("awssm://prod/db#password")
+	if v, err := a.Resolve(context.Background(), ref); err != nil || v != "hunter2" {
+		t.Errorf("Resolve = %q, %v", v, err)
+	}
+	if want := "secretsmanager get-secret-value --secret-id prod/db --query SecretString --output text --region us-west-2"; strings.Join(gotArgs, " ") != want {
+		t.Errorf("aws args = %v", gotArgs)
+	}
+	ref, _ = Parse("awssm://prod/db#port")
+	if v, _ := a.Resolve(context.Background(), ref); v != "5432" {
+		t.Errorf("numeric field = %q", v)
+	}
+	ref, _ = Parse("awssm://prod/db")
+	if v, _ := a.Resolve(context.Background(), ref); !strings.HasPrefix(v, "{") || strings.HasSuffix(v, "\n") {
+		t.Errorf("whole secret = %q", v)
+	}
+
+	a.run = func(ctx context.Context, args ...string) ([]byte, error) { return []byte("plain\n"), nil }
+	ref, _ = Parse("awssm://prod/plain#key")
+	if _, err := a.Resolve(context.Background(), ref); err == nil || !strings.Contains(err.Error(), "not a JSON object") {
+		t.Errorf("field of non-JSON secret: err = %v", err)
+	}
+}
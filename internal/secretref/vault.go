@@ -0,0 +1,116 @@
+package secretref
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Vault reads secrets from a HashiCorp Vault KV version 2 engine. In
+// vault://kv/payments/stripe#api_key, "kv" is the engine's mount path and
+// "payments/stripe" the secret's path within it.
+type Vault struct {
+	addr      string
+	token     string
+	namespace string
+	client    *http.Client
+}
+
+// NewVault returns a provider for the Vault server at addr, authenticating
+// with token. namespace is only needed on Vault Enterprise.
+func NewVault(addr, token, namespace string) *Vault {
+	return &Vault{
+		addr:      strings.TrimSuffix(addr, "/"),
+		token:     token,
+		namespace: namespace,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Resolve implements Provider.
+func (v *Vault) Resolve(ctx context.Context, ref Ref) (string, error) {
+	mount, path, ok := strings.Cut(strings.Trim(ref.Path, "/"), "/")
+	if !ok || path == "" {
+		return "", fmt.Errorf("want vault://<mount>/<path>#<field>")
+	}
+
+	endpoint := v.addr + "/v1/" + escapePath(mount) + "/data/" + escapePath(path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", fmt.Errorf("no secret at %s/%s", mount, path)
+	case http.StatusForbidden:
+		return "", fmt.Errorf("permission denied: the server's VAULT_TOKEN can't read %s/%s", mount, path)
+	default:
+		return "", fmt.Errorf("vault returned %s", resp.Status)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decoding vault response: %w", err)
+	}
+	return pickField(body.Data.Data, ref.Field)
+}
+
+// pickField returns field from a secret's key/value data. Without a field
+// the secret must hold exactly one value.
+func pickField(data map[string]any, field string) (string, error) {
+	if field == "" {
+		if len(data) != 1 {
+			return "", fmt.Errorf("secret has %d fields (%s); add #<field> to the reference", len(data), strings.Join(fieldNames(data), ", "))
+		}
+		for name := range data {
+			field = name
+		}
+	}
+	value, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("no field %q in secret (fields: %s)", field, strings.Join(fieldNames(data), ", "))
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
+
+func fieldNames(data map[string]any) []string {
+	names := make([]string, 0, len(data))
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func escapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
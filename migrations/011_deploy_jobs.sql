@@ -0,0 +1,30 @@
+-- Durable deploy queue
+-- Replaces the fire-and-forget goroutine in DeployApp. Every deploy/rollback
+-- request becomes a row here; a worker pool (on any shipit replica) claims
+-- rows with SELECT ... FOR UPDATE SKIP LOCKED and runs the deploy pipeline.
+
+CREATE TABLE deploy_jobs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    app_id UUID NOT NULL REFERENCES apps(id) ON DELETE CASCADE,
+    kind VARCHAR(50) NOT NULL DEFAULT 'deploy',      -- deploy, rollback
+    status VARCHAR(50) NOT NULL DEFAULT 'queued',    -- queued, running, succeeded, failed, rolled_back, superseded
+    target_revision INTEGER,                         -- rollback target (informational)
+    revision_number INTEGER,                         -- revision allocated by the deploy pipeline
+    error TEXT,
+    superseded_by UUID REFERENCES deploy_jobs(id) ON DELETE SET NULL,
+    requested_by VARCHAR(255),
+    worker_id VARCHAR(255),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    started_at TIMESTAMP WITH TIME ZONE,
+    heartbeat_at TIMESTAMP WITH TIME ZONE,
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_deploy_jobs_app_id ON deploy_jobs(app_id, created_at DESC);
+CREATE INDEX idx_deploy_jobs_queued ON deploy_jobs(created_at) WHERE status = 'queued';
+
+-- At most one running job per app, across every shipit replica. Two workers
+-- racing to claim jobs for the same app: the loser's UPDATE fails with a
+-- unique violation and it simply polls again.
+CREATE UNIQUE INDEX idx_deploy_jobs_one_running_per_app ON deploy_jobs(app_id) WHERE status = 'running';
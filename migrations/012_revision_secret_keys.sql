@@ -0,0 +1,7 @@
+-- Versioned secret key snapshots on revisions
+-- Secret values stay out of app_revisions; we record which keys existed at
+-- deploy time plus a keyed fingerprint of each value ({"KEY": "<hmac>"}) so
+-- a rollback can detect deleted or rotated secrets before applying anything.
+-- NULL for revisions created before this migration (preflight is skipped).
+
+ALTER TABLE app_revisions ADD COLUMN IF NOT EXISTS secret_keys JSONB;
@@ -0,0 +1,17 @@
+-- Canary deploy strategy
+-- deploy_strategy is opt-in per app: 'rolling' keeps the existing single
+-- RollingUpdate Deployment; 'canary' runs the new revision as a parallel
+-- <name>-canary Deployment behind an ingress-nginx canary Ingress and steps
+-- its traffic weight up by canary_step_percent every canary_step_interval
+-- seconds before promoting.
+
+ALTER TABLE apps ADD COLUMN deploy_strategy VARCHAR(50) NOT NULL DEFAULT 'rolling'; -- rolling, canary
+ALTER TABLE apps ADD COLUMN canary_step_percent INTEGER NOT NULL DEFAULT 20;
+ALTER TABLE apps ADD COLUMN canary_step_interval INTEGER NOT NULL DEFAULT 60;     -- seconds
+
+-- Live canary state. canary_weight is the traffic percentage the canary is
+-- currently receiving (NULL when no canary is in progress); canary_action is
+-- an operator's pending promote/abort, picked up by the deploy worker
+-- stepping the canary.
+ALTER TABLE apps ADD COLUMN canary_weight INTEGER;
+ALTER TABLE apps ADD COLUMN canary_action VARCHAR(20); -- promote, abort
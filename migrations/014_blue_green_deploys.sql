@@ -0,0 +1,17 @@
+-- Blue/green deploy strategy
+-- deploy_strategy gains 'blue_green': each deploy stands up a complete
+-- <name>-blue or <name>-green Deployment and flips the Service selector to
+-- it once ready. The previous color stays scaled up for
+-- blue_green_retention seconds so a rollback to it is a selector flip.
+
+ALTER TABLE apps ADD COLUMN blue_green_retention INTEGER NOT NULL DEFAULT 900; -- seconds
+
+-- Live blue/green state. active_color is the color the Service selects
+-- (NULL for rolling/canary apps). idle_revision is the revision the other
+-- color still runs, until the deploy reconciler scales it to zero at
+-- idle_scale_down_at.
+ALTER TABLE apps ADD COLUMN active_color VARCHAR(10); -- blue, green
+ALTER TABLE apps ADD COLUMN idle_revision INTEGER;
+ALTER TABLE apps ADD COLUMN idle_scale_down_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_apps_idle_scale_down_at ON apps(idle_scale_down_at) WHERE idle_scale_down_at IS NOT NULL;
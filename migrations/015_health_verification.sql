@@ -0,0 +1,10 @@
+-- Post-rollout HTTP health verification
+-- After a rollout's pods are ready, shipit GETs the app's health_path and
+-- any verify_paths through the in-cluster Service (via the API server's
+-- service proxy) and requires verify_successes consecutive rounds of 2xx
+-- responses within verify_timeout seconds before marking the deploy
+-- successful; otherwise the deploy is auto-rolled back. 0 disables the gate.
+
+ALTER TABLE apps ADD COLUMN verify_successes INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE apps ADD COLUMN verify_paths JSONB NOT NULL DEFAULT '[]'; -- extra paths besides health_path
+ALTER TABLE apps ADD COLUMN verify_timeout INTEGER NOT NULL DEFAULT 120; -- seconds
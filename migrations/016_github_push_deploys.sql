@@ -0,0 +1,16 @@
+-- GitHub push deploys
+-- An app tracks a branch of a GitHub repository: a push to it (received on
+-- POST /api/webhooks/github) sets the app's image tag from
+-- image_tag_template and queues a deploy. repo_url is stored normalized as
+-- github.com/<owner>/<repo> so webhook lookups are an exact match.
+
+ALTER TABLE apps ADD COLUMN repo_url VARCHAR(255);
+ALTER TABLE apps ADD COLUMN tracked_branch VARCHAR(255);
+ALTER TABLE apps ADD COLUMN image_tag_template VARCHAR(128) NOT NULL DEFAULT '{sha}'; -- {sha}, {short_sha}, {branch}
+
+-- The commit the app was last deployed from by a push; a redelivered or
+-- repeated push of the same commit is skipped.
+ALTER TABLE apps ADD COLUMN last_deployed_sha VARCHAR(40);
+ALTER TABLE apps ADD COLUMN last_deployed_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_apps_tracked_branch ON apps(repo_url, tracked_branch) WHERE repo_url IS NOT NULL;
@@ -0,0 +1,22 @@
+-- Deploy source: which commit a revision shipped
+-- CI systems deploy with POST /api/apps/{id}/deploy {image, sha, ref, ci_url};
+-- the commit and build link travel on the queued job and are copied onto
+-- the revision it creates.
+
+ALTER TABLE app_revisions ADD COLUMN commit_sha VARCHAR(40);
+ALTER TABLE app_revisions ADD COLUMN commit_ref VARCHAR(255);
+ALTER TABLE app_revisions ADD COLUMN ci_url VARCHAR(1024);
+
+ALTER TABLE deploy_jobs ADD COLUMN image VARCHAR(512); -- image the job was queued for
+ALTER TABLE deploy_jobs ADD COLUMN commit_sha VARCHAR(40);
+ALTER TABLE deploy_jobs ADD COLUMN commit_ref VARCHAR(255);
+ALTER TABLE deploy_jobs ADD COLUMN ci_url VARCHAR(1024);
+
+-- Revision numbers are handed out from a per-app counter instead of
+-- MAX(revision_number)+1 so a deploy can reserve its number when it is
+-- queued (the API returns it to the caller) without a concurrently running
+-- deploy allocating the same one. Numbers are never reused; a reserved
+-- number whose job is superseded leaves a gap.
+ALTER TABLE apps ADD COLUMN revision_counter INTEGER NOT NULL DEFAULT 0;
+UPDATE apps a SET revision_counter = COALESCE(
+    (SELECT MAX(r.revision_number) FROM app_revisions r WHERE r.app_id = a.id), 0);
@@ -0,0 +1,8 @@
+-- Image digests
+-- Each deploy resolves the app's image tag to the manifest digest it points
+-- to and deploys <image>@<digest>, so every pod of a revision (and any
+-- later rollback to it) runs the same image even if the tag is moved.
+-- image keeps the tag as configured; image_digest is NULL for revisions
+-- whose tag could not be resolved (deployed by tag, as before).
+
+ALTER TABLE app_revisions ADD COLUMN image_digest VARCHAR(71); -- sha256:<64 hex>
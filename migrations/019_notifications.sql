@@ -0,0 +1,23 @@
+-- Deploy notifications
+-- Where deploy lifecycle events (deploy_started, predeploy_failed,
+-- deploy_succeeded, deploy_failed, auto_rolled_back) are delivered. A
+-- project-level row is the default for every app in the project; an
+-- app-level row overrides it field by field. At app scope a NULL column
+-- inherits the project value and an empty one (encrypted "" or an empty
+-- events list) turns it off.
+
+CREATE TABLE notification_settings (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    project_id UUID REFERENCES projects(id) ON DELETE CASCADE,
+    app_id UUID REFERENCES apps(id) ON DELETE CASCADE,
+    slack_webhook_encrypted BYTEA,              -- Slack incoming webhook URL (carries a token)
+    webhook_url VARCHAR(1024),                  -- generic JSON webhook
+    webhook_secret_encrypted BYTEA,             -- HMAC key for X-Shipit-Signature-256
+    events JSONB,                               -- subscribed event types; NULL = all (project) / inherit (app)
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CHECK ((project_id IS NULL) <> (app_id IS NULL))
+);
+
+CREATE UNIQUE INDEX idx_notification_settings_project ON notification_settings(project_id) WHERE project_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_notification_settings_app ON notification_settings(app_id) WHERE app_id IS NOT NULL;
@@ -0,0 +1,27 @@
+-- Audit log
+-- One row per mutating API call: who (actor), from where (ip_address),
+-- what (action, method, path), whether it worked (status_code) and, for
+-- app changes, a before/after diff with secret-looking values redacted.
+-- app_id has no foreign key so an app's history outlives the app.
+-- Rows older than AUDIT_RETENTION_DAYS are deleted by a sweeper.
+
+CREATE TABLE audit_logs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    actor_type VARCHAR(20) NOT NULL,     -- user, api_token, webhook
+    actor_id VARCHAR(255),
+    actor VARCHAR(255) NOT NULL,         -- user email, API token name, "github"
+    ip_address VARCHAR(64),
+    request_id VARCHAR(255),
+    method VARCHAR(10) NOT NULL,
+    path VARCHAR(1024) NOT NULL,
+    action VARCHAR(100) NOT NULL,        -- e.g. app.update, secret.set, app.rollback
+    app_id UUID,
+    status_code INTEGER NOT NULL,
+    changes JSONB,                       -- {"field": {"before": ..., "after": ...}}
+    details JSONB
+);
+
+CREATE INDEX idx_audit_logs_created_at ON audit_logs(created_at DESC);
+CREATE INDEX idx_audit_logs_app_id ON audit_logs(app_id, created_at DESC) WHERE app_id IS NOT NULL;
+CREATE INDEX idx_audit_logs_actor ON audit_logs(actor, created_at DESC);
@@ -0,0 +1,25 @@
+-- Project memberships (role-based access control)
+-- A user's role in a project applies to the project's clusters and apps:
+--   viewer   - read-only access (apps, status, logs, revisions, history)
+--   deployer - viewer, plus deploy, rollback and app configuration
+--   admin    - deployer, plus secrets, exec, deletes and managing members
+-- Users in ADMIN_EMAILS are admins of every project.
+
+CREATE TABLE project_members (
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('viewer', 'deployer', 'admin')),
+    added_by VARCHAR(255),               -- email or token name of whoever granted the role
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (project_id, user_id)
+);
+
+CREATE INDEX idx_project_members_user_id ON project_members(user_id);
+
+-- Before memberships every signed-in user could do anything in every
+-- project. Keep that for the users and projects that exist now, so nobody
+-- loses access on upgrade; project admins narrow the roles afterwards.
+INSERT INTO project_members (project_id, user_id, role, added_by)
+SELECT p.id, u.id, 'admin', 'migration'
+FROM projects p CROSS JOIN users u;
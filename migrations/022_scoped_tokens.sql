@@ -0,0 +1,14 @@
+-- Scoped, expiring user tokens
+-- scopes limits a token to verbs on projects/apps (e.g. "deploy:app/<id>",
+-- "read:logs"); NULL marks tokens created before scoping, which keep the
+-- user's full roles. allowed_ips optionally restricts the client addresses
+-- (IPs or CIDRs) the token is accepted from.
+
+ALTER TABLE user_tokens
+    ADD COLUMN scopes JSONB,
+    ADD COLUMN allowed_ips JSONB,
+    ADD COLUMN last_used_ip VARCHAR(64);
+
+-- Expiry is required from now on. Tokens created without one get 90 days
+-- to be replaced; a token with no expiry is never accepted.
+UPDATE user_tokens SET expires_at = NOW() + INTERVAL '90 days' WHERE expires_at IS NULL;
@@ -0,0 +1,30 @@
+-- Generic OIDC login (Okta, Keycloak, GitHub, ...) alongside Google SSO
+-- user_identities links a provider's subject to a shipit user; users are
+-- matched across providers by verified email. users.groups holds the group
+-- claims from the user's last OIDC login, and project_group_roles grants
+-- project roles to everyone in a group (the higher of a user's direct and
+-- group roles applies).
+
+CREATE TABLE user_identities (
+    provider VARCHAR(64) NOT NULL,       -- name from OIDC_PROVIDERS
+    subject VARCHAR(255) NOT NULL,       -- the provider's "sub" (or GitHub user ID)
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_login_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
+
+ALTER TABLE users ADD COLUMN groups JSONB;
+
+CREATE TABLE project_group_roles (
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    group_name VARCHAR(255) NOT NULL,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('viewer', 'deployer', 'admin')),
+    added_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (project_id, group_name)
+);
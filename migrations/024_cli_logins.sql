@@ -0,0 +1,16 @@
+-- Browser-based CLI login (shipit login)
+-- Each row is one sign-in in progress. Loopback logins are inserted once the
+-- user approves in the browser, holding a one-time code bound to the CLI's
+-- PKCE challenge. Device logins are inserted when the CLI starts and
+-- approved when the user enters user_code at /auth/device. Either kind is
+-- exchanged once for an expiring user token and then deleted.
+
+CREATE TABLE cli_logins (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    code_hash VARCHAR(64) NOT NULL UNIQUE,               -- SHA-256 of the authorization or device code
+    user_code VARCHAR(16) UNIQUE,                        -- device flow: the code shown in the terminal
+    code_challenge VARCHAR(128),                         -- loopback flow: PKCE S256 challenge
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE, -- set once approved
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
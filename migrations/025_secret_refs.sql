@@ -0,0 +1,11 @@
+-- External secret references
+-- An app secret holds either an encrypted value or a reference to a secret
+-- kept elsewhere (vault://kv/payments/stripe#api_key,
+-- awssm://prod/payments#api_key), resolved whenever the app's Kubernetes
+-- Secret is written. source is the reference scheme, or 'value'.
+
+ALTER TABLE app_secrets ALTER COLUMN value_encrypted DROP NOT NULL;
+ALTER TABLE app_secrets ADD COLUMN source VARCHAR(20) NOT NULL DEFAULT 'value';
+ALTER TABLE app_secrets ADD COLUMN ref TEXT;
+ALTER TABLE app_secrets ADD CONSTRAINT app_secrets_value_or_ref
+    CHECK ((value_encrypted IS NULL) <> (ref IS NULL));
@@ -0,0 +1,7 @@
+-- Debounced deploys
+-- Secret changes made with apply=true queue a redeploy that waits a few
+-- seconds (run_after) so a burst of changes ships as one rollout: each new
+-- change supersedes the queued job and pushes run_after out again. Workers
+-- don't claim a job before its run_after; NULL means immediately.
+
+ALTER TABLE deploy_jobs ADD COLUMN run_after TIMESTAMP WITH TIME ZONE;
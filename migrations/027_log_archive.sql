@@ -0,0 +1,36 @@
+-- Log archive
+-- When LOG_ARCHIVE_URL is set, a collector per cluster tails the pods of
+-- every app and writes gzipped chunks of log lines to the archive store
+-- (a directory or an S3-compatible bucket). log_chunks indexes them by app,
+-- pod, revision and time range so a search only fetches the chunks that
+-- can match. app_id has no foreign key: the sweeper deletes a removed
+-- app's chunks from the store before their rows.
+--
+-- Chunks are kept for the owning project's log_retention_days; 0 turns
+-- archiving off for the project.
+
+ALTER TABLE projects ADD COLUMN log_retention_days INTEGER NOT NULL DEFAULT 7;
+
+CREATE TABLE log_chunks (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    app_id UUID NOT NULL,
+    pod VARCHAR(255) NOT NULL,
+    revision INTEGER,                    -- from the pod's shipit.dev/revision annotation
+    first_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    lines INTEGER NOT NULL,
+    bytes INTEGER NOT NULL,              -- compressed size
+    object_key TEXT NOT NULL UNIQUE,     -- key in the archive store
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_log_chunks_app_time ON log_chunks(app_id, last_at DESC);
+CREATE INDEX idx_log_chunks_app_revision ON log_chunks(app_id, revision);
+
+-- One collector per cluster across server replicas: a replica holds the
+-- lease while it renews it, and another takes over once it expires.
+CREATE TABLE log_collector_leases (
+    cluster_id UUID PRIMARY KEY REFERENCES clusters(id) ON DELETE CASCADE,
+    holder VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
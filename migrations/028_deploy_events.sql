@@ -0,0 +1,22 @@
+-- Deploy timeline
+-- deployApp records a row each time a revision's deploy enters or leaves a
+-- phase (revision, secret_sync, predeploy, canary, apply, verifying,
+-- rollback), with the error text of a failed phase, plus a verifying/ready
+-- row for each of the new ReplicaSet's pods, stamped with the time kube saw
+-- it become ready. Rows go with their revision when old revisions are
+-- pruned.
+
+CREATE TABLE deploy_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    app_id UUID NOT NULL,
+    revision_number INTEGER NOT NULL,
+    phase VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL,         -- started, succeeded, failed, skipped, ready
+    message TEXT,
+    pod VARCHAR(255),                    -- ready rows only
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (app_id, revision_number)
+        REFERENCES app_revisions(app_id, revision_number) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_deploy_events_revision ON deploy_events(app_id, revision_number, occurred_at);
@@ -0,0 +1,10 @@
+-- Provider-qualified SSO groups
+-- users.groups and project_group_roles.group_name now name the provider a
+-- group came from ("okta:eng", "github:acme/sre"), so a group asserted by
+-- one provider can't match a role granted to another provider's group of
+-- the same name. Groups stored before this carry no provider: drop them
+-- (each user's are written again, qualified, at their next login).
+-- Existing group roles without a provider no longer match anyone and have
+-- to be granted again as <provider>:<group>.
+
+UPDATE users SET groups = NULL WHERE groups IS NOT NULL;
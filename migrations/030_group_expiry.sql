@@ -0,0 +1,11 @@
+-- Group roles expire
+-- users.groups is only rewritten when the user next signs in through their
+-- provider, so someone removed from an IdP group would otherwise keep the
+-- group's project roles through existing sessions and API tokens. Each
+-- login now stamps how long its groups count for (GROUP_ROLES_MAX_AGE,
+-- default SESSION_MAX_AGE); after that the user holds only their direct
+-- memberships until they sign in again. Groups already stored get a day.
+
+ALTER TABLE users ADD COLUMN groups_expire_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE users SET groups_expire_at = NOW() + INTERVAL '1 day' WHERE groups IS NOT NULL;
@@ -1,9 +1,15 @@
 import type {
   Project,
+  ProjectMember,
+  ProjectGroup,
+  ProjectRole,
   Cluster,
   App,
   AppRevision,
   AppSecret,
+  DeployJob,
+  CIDeployRequest,
+  DeployQueuedResponse,
   AppStatus,
   CreateAppRequest,
   UpdateAppRequest,
@@ -13,7 +19,19 @@ import type {
   DomainConfig,
   PreDeployHookStatus,
   PreDeployHookConfig,
+  DeployStrategy,
+  DeployStrategyConfig,
+  VerificationSettings,
+  VerificationConfig,
+  TrackingSettings,
+  TrackingConfig,
+  AuditPage,
+  AuditQuery,
+  AppNotifications,
+  ProjectNotifications,
+  NotificationConfig,
   User,
+  LoginProvider,
   UserToken,
   CreateTokenRequest,
   CreateTokenResponse,
@@ -37,9 +55,18 @@ export function clearToken(): void {
   localStorage.removeItem('shipit_token');
 }
 
-// Login - redirect to Google OAuth
-export function login(): void {
-  window.location.href = '/auth/login';
+// Login - redirect to a provider's sign-in (Google by default)
+export function login(loginUrl = '/auth/login'): void {
+  window.location.href = loginUrl;
+}
+
+// Sign-in options configured on the server
+export async function listLoginProviders(): Promise<LoginProvider[]> {
+  const response = await fetch('/auth/providers');
+  if (!response.ok) {
+    throw new Error(`Failed to load login providers: ${response.status}`);
+  }
+  return response.json();
 }
 
 // Logout - clear session
@@ -105,6 +132,36 @@ export async function deleteProject(id: string): Promise<void> {
   return request(`/projects/${id}`, { method: 'DELETE' });
 }
 
+export async function listProjectMembers(projectId: string): Promise<ProjectMember[]> {
+  return request<ProjectMember[]>(`/projects/${projectId}/members`);
+}
+
+export async function addProjectMember(projectId: string, email: string, role: ProjectRole): Promise<ProjectMember> {
+  return request<ProjectMember>(`/projects/${projectId}/members`, {
+    method: 'POST',
+    body: JSON.stringify({ email, role }),
+  });
+}
+
+export async function removeProjectMember(projectId: string, member: string): Promise<void> {
+  return request(`/projects/${projectId}/members/${encodeURIComponent(member)}`, { method: 'DELETE' });
+}
+
+export async function listProjectGroups(projectId: string): Promise<ProjectGroup[]> {
+  return request<ProjectGroup[]>(`/projects/${projectId}/groups`);
+}
+
+export async function addProjectGroup(projectId: string, group: string, role: ProjectRole): Promise<ProjectGroup> {
+  return request<ProjectGroup>(`/projects/${projectId}/groups`, {
+    method: 'POST',
+    body: JSON.stringify({ group, role }),
+  });
+}
+
+export async function removeProjectGroup(projectId: string, group: string): Promise<void> {
+  return request(`/projects/${projectId}/groups/${encodeURIComponent(group)}`, { method: 'DELETE' });
+}
+
 // Clusters
 export async function listClusters(projectId: string): Promise<Cluster[]> {
   return request<Cluster[]>(`/projects/${projectId}/clusters`);
@@ -155,8 +212,15 @@ export async function updateApp(
   });
 }
 
-export async function deployApp(id: string): Promise<App> {
-  return request<App>(`/apps/${id}/deploy`, { method: 'POST' });
+export async function deployApp(id: string, source?: CIDeployRequest): Promise<DeployQueuedResponse> {
+  return request<DeployQueuedResponse>(`/apps/${id}/deploy`, {
+    method: 'POST',
+    body: source ? JSON.stringify(source) : undefined,
+  });
+}
+
+export async function getDeployJob(id: string): Promise<DeployJob> {
+  return request<DeployJob>(`/deploys/${id}`);
 }
 
 export async function getAppStatus(id: string): Promise<AppStatus> {
@@ -202,19 +266,29 @@ export async function listSecrets(appId: string): Promise<AppSecret[]> {
   return request<AppSecret[]>(`/apps/${appId}/secrets`);
 }
 
+// apply=true queues a redeploy, debounced with other secret changes.
 export async function setSecret(
   appId: string,
   key: string,
-  value: string
+  value: string,
+  apply = false
 ): Promise<void> {
-  return request(`/apps/${appId}/secrets`, {
+  return request(`/apps/${appId}/secrets${apply ? '?apply=true' : ''}`, {
     method: 'POST',
     body: JSON.stringify({ key, value }),
   });
 }
 
-export async function deleteSecret(appId: string, key: string): Promise<void> {
-  return request(`/apps/${appId}/secrets/${key}`, { method: 'DELETE' });
+// Points a secret at an external one, e.g. vault://kv/payments/stripe#api_key
+export async function setSecretRef(appId: string, key: string, ref: string): Promise<void> {
+  return request(`/apps/${appId}/secrets`, {
+    method: 'POST',
+    body: JSON.stringify({ key, ref }),
+  });
+}
+
+export async function deleteSecret(appId: string, key: string, apply = false): Promise<void> {
+  return request(`/apps/${appId}/secrets/${key}${apply ? '?apply=true' : ''}`, { method: 'DELETE' });
 }
 
 // Logs (returns EventSource for streaming)
@@ -276,6 +350,117 @@ export async function setPreDeployHook(
   });
 }
 
+// Deploy Strategy
+
+export async function getDeployStrategy(appId: string): Promise<DeployStrategy> {
+  return request<DeployStrategy>(`/apps/${appId}/strategy`);
+}
+
+export async function setDeployStrategy(
+  appId: string,
+  config: DeployStrategyConfig
+): Promise<DeployStrategy> {
+  return request<DeployStrategy>(`/apps/${appId}/strategy`, {
+    method: 'PUT',
+    body: JSON.stringify(config),
+  });
+}
+
+export async function promoteCanary(appId: string): Promise<{ status: string }> {
+  return request<{ status: string }>(`/apps/${appId}/canary/promote`, {
+    method: 'POST',
+  });
+}
+
+export async function abortCanary(appId: string): Promise<{ status: string }> {
+  return request<{ status: string }>(`/apps/${appId}/canary/abort`, {
+    method: 'POST',
+  });
+}
+
+// Post-rollout Verification
+
+export async function getVerification(appId: string): Promise<VerificationSettings> {
+  return request<VerificationSettings>(`/apps/${appId}/verification`);
+}
+
+export async function setVerification(
+  appId: string,
+  config: VerificationConfig
+): Promise<VerificationSettings> {
+  return request<VerificationSettings>(`/apps/${appId}/verification`, {
+    method: 'PUT',
+    body: JSON.stringify(config),
+  });
+}
+
+// Push-to-deploy Tracking
+
+export async function getTracking(appId: string): Promise<TrackingSettings> {
+  return request<TrackingSettings>(`/apps/${appId}/tracking`);
+}
+
+export async function setTracking(
+  appId: string,
+  config: TrackingConfig
+): Promise<TrackingSettings> {
+  return request<TrackingSettings>(`/apps/${appId}/tracking`, {
+    method: 'PUT',
+    body: JSON.stringify(config),
+  });
+}
+
+// Audit Log
+
+function auditQueryString(query: AuditQuery = {}): string {
+  const params = new URLSearchParams();
+  for (const [key, value] of Object.entries(query)) {
+    if (value !== undefined && value !== '') {
+      params.set(key, String(value));
+    }
+  }
+  const qs = params.toString();
+  return qs ? `?${qs}` : '';
+}
+
+export async function getAppHistory(appId: string, query?: AuditQuery): Promise<AuditPage> {
+  return request<AuditPage>(`/apps/${appId}/history${auditQueryString(query)}`);
+}
+
+export async function listAuditLog(query?: AuditQuery): Promise<AuditPage> {
+  return request<AuditPage>(`/audit${auditQueryString(query)}`);
+}
+
+// Deploy Notifications
+
+export async function getAppNotifications(appId: string): Promise<AppNotifications> {
+  return request<AppNotifications>(`/apps/${appId}/notifications`);
+}
+
+export async function setAppNotifications(
+  appId: string,
+  config: NotificationConfig
+): Promise<AppNotifications> {
+  return request<AppNotifications>(`/apps/${appId}/notifications`, {
+    method: 'PUT',
+    body: JSON.stringify(config),
+  });
+}
+
+export async function getProjectNotifications(projectId: string): Promise<ProjectNotifications> {
+  return request<ProjectNotifications>(`/projects/${projectId}/notifications`);
+}
+
+export async function setProjectNotifications(
+  projectId: string,
+  config: NotificationConfig
+): Promise<ProjectNotifications> {
+  return request<ProjectNotifications>(`/projects/${projectId}/notifications`, {
+    method: 'PUT',
+    body: JSON.stringify(config),
+  });
+}
+
 // User Profile
 export async function getMe(): Promise<User> {
   return request<User>('/me');
@@ -23,7 +23,7 @@ import {
   getClusterIngress,
   switchAppManagement,
 } from '../api/client';
-import type { AppRevision, AppSecret, UpdateAppRequest, HPAConfig, DomainConfig, PreDeployHookConfig } from '../types';
+import type { AppRevision, AppSecret, LogLine, UpdateAppRequest, HPAConfig, DomainConfig, PreDeployHookConfig } from '../types';
 import { Button } from '../components/ui/Button';
 import { Card } from '../components/ui/Card';
 import { StatusBadge } from '../components/ui/Badge';
@@ -51,10 +51,11 @@ export default function AppDetail() {
   const navigate = useNavigate();
   const queryClient = useQueryClient();
   const [activeTab, setActiveTab] = useState<'overview' | 'env' | 'secrets' | 'autoscaling' | 'domain' | 'hooks' | 'revisions' | 'monitoring' | 'logs'>('overview');
-  const [logs, setLogs] = useState<string[]>([]);
+  const [logs, setLogs] = useState<LogLine[]>([]);
   const [showSecretModal, setShowSecretModal] = useState(false);
   const [newSecretKey, setNewSecretKey] = useState('');
   const [newSecretValue, setNewSecretValue] = useState('');
+  const [applySecrets, setApplySecrets] = useState(true);
   const [deleteConfirm, setDeleteConfirm] = useState(false);
   const [rollbackConfirm, setRollbackConfirm] = useState<AppRevision | null>(null);
   const logsEndRef = useRef<HTMLDivElement>(null);
@@ -155,9 +156,10 @@ export default function AppDetail() {
   });
 
   const setSecretMutation = useMutation({
-    mutationFn: ({ key, value }: { key: string; value: string }) => setSecret(appId!, key, value),
+    mutationFn: ({ key, value }: { key: string; value: string }) => setSecret(appId!, key, value, applySecrets),
     onSuccess: () => {
       queryClient.invalidateQueries({ queryKey: ['secrets', appId] });
+      queryClient.invalidateQueries({ queryKey: ['app', appId] });
       setShowSecretModal(false);
       setNewSecretKey('');
       setNewSecretValue('');
@@ -165,9 +167,10 @@ export default function AppDetail() {
   });
 
   const deleteSecretMutation = useMutation({
-    mutationFn: (key: string) => deleteSecret(appId!, key),
+    mutationFn: (key: string) => deleteSecret(appId!, key, applySecrets),
     onSuccess: () => {
       queryClient.invalidateQueries({ queryKey: ['secrets', appId] });
+      queryClient.invalidateQueries({ queryKey: ['app', appId] });
     },
   });
 
@@ -216,7 +219,13 @@ export default function AppDetail() {
       eventSourceRef.current = es;
 
       es.onmessage = (event) => {
-        setLogs((prev) => [...prev.slice(-500), event.data]);
+        let line: LogLine;
+        try {
+          line = JSON.parse(event.data);
+        } catch {
+          line = { pod: '', container: '', timestamp: '', message: event.data };
+        }
+        setLogs((prev) => [...prev.slice(-500), line]);
       };
 
       es.onerror = () => {
@@ -387,6 +396,15 @@ export default function AppDetail() {
                 ))}
               </div>
             )}
+            {status.diagnosis && status.diagnosis.summary.length > 0 && (
+              <ul className="mt-2 space-y-1">
+                {status.diagnosis.summary.map((line) => (
+                  <li key={line} className="text-xs text-warning">
+                    {line}
+                  </li>
+                ))}
+              </ul>
+            )}
           </div>
         )}
       </Card>
@@ -835,6 +853,7 @@ export default function AppDetail() {
               <thead className="bg-surface-hover">
                 <tr>
                   <th className="px-6 py-3 text-left text-xs font-medium text-text-secondary uppercase">Key</th>
+                  <th className="px-6 py-3 text-left text-xs font-medium text-text-secondary uppercase">Source</th>
                   <th className="px-6 py-3 text-left text-xs font-medium text-text-secondary uppercase">Updated</th>
                   <th className="px-6 py-3 text-right text-xs font-medium text-text-secondary uppercase">Actions</th>
                 </tr>
@@ -843,6 +862,9 @@ export default function AppDetail() {
                 {secrets?.map((secret: AppSecret) => (
                   <tr key={secret.key}>
                     <td className="px-6 py-4 font-mono text-sm text-text-primary">{secret.key}</td>
+                    <td className="px-6 py-4 text-sm text-text-secondary">
+                      {secret.ref ? <span className="font-mono" title={secret.ref}>{secret.ref}</span> : 'value'}
+                    </td>
                     <td className="px-6 py-4 text-sm text-text-secondary">
                       {new Date(secret.updated_at).toLocaleDateString()}
                     </td>
@@ -859,9 +881,19 @@ export default function AppDetail() {
               </tbody>
             </table>
           )}
-          <p className="p-4 text-sm text-text-muted border-t border-border">
-            Redeploy the app after adding or updating secrets for changes to take effect.
-          </p>
+          <div className="flex items-center gap-3 p-4 border-t border-border">
+            <input
+              type="checkbox"
+              id="apply-secrets"
+              checked={applySecrets}
+              onChange={(e) => setApplySecrets(e.target.checked)}
+              className="h-4 w-4 rounded border-border text-accent focus:ring-accent"
+            />
+            <label htmlFor="apply-secrets" className="text-sm text-text-muted">
+              Redeploy automatically when secrets change (changes made within a few seconds ship together).
+              Otherwise redeploy the app for changes to take effect.
+            </label>
+          </div>
         </Card>
       )}
 
@@ -1203,7 +1235,12 @@ export default function AppDetail() {
           ) : (
             logs.map((line, i) => (
               <div key={i} className="text-success whitespace-pre-wrap">
-                {line}
+                {line.pod && (
+                  <span className="text-text-muted mr-2" title={`${line.container} ${line.timestamp}`}>
+                    {line.pod}
+                  </span>
+                )}
+                {line.message}
               </div>
             ))
           )}
@@ -1,13 +1,19 @@
-import { useEffect } from 'react';
+import { useEffect, useState } from 'react';
 import { useNavigate, useSearchParams } from 'react-router-dom';
 import { useAuth } from '../context/AuthContext';
+import { listLoginProviders } from '../api/client';
+import type { LoginProvider } from '../types';
 import { Card } from '../components/ui/Card';
 import { Button } from '../components/ui/Button';
 
 const ERROR_MESSAGES: Record<string, string> = {
   unauthorized_domain: 'Your account is not authorized',
-  exchange_failed: 'Failed to authenticate with Google',
-  userinfo_failed: 'Failed to get user information from Google',
+  unauthorized_group: 'Your account is not in a group allowed to sign in',
+  email_unverified: 'Your account has no verified email address',
+  account_exists: 'This email already belongs to an account that signs in another way',
+  provider_unavailable: 'The sign-in provider is unavailable',
+  exchange_failed: 'Failed to authenticate with the sign-in provider',
+  userinfo_failed: 'Failed to get user information from the sign-in provider',
   user_failed: 'Failed to create or find user account',
   session_failed: 'Failed to create session',
 };
@@ -17,15 +23,38 @@ export default function Login() {
   const error = searchParams.get('error');
   const errorMessage = error ? ERROR_MESSAGES[error] || `Authentication error: ${error}` : null;
 
+  // Where to go after signing in, e.g. the CLI login approval page. Only
+  // same-site paths are honoured (the server checks this too).
+  const nextParam = searchParams.get('next');
+  const next = nextParam && /^\/(?![/\\])/.test(nextParam) ? nextParam : null;
+
   const { user, loading, login } = useAuth();
   const navigate = useNavigate();
 
+  // Fall back to Google alone if the provider list can't be loaded
+  const [providers, setProviders] = useState<LoginProvider[]>([
+    { name: 'google', display_name: 'Google', login_url: '/auth/login' },
+  ]);
+
+  useEffect(() => {
+    listLoginProviders()
+      .then((list) => {
+        if (list.length > 0) setProviders(list);
+      })
+      .catch(() => {});
+  }, []);
+
   // Redirect if already logged in
   useEffect(() => {
     if (user && !loading) {
-      navigate('/');
+      if (next) {
+        // May be a server-rendered page rather than a dashboard route
+        window.location.href = next;
+      } else {
+        navigate('/');
+      }
     }
-  }, [user, loading, navigate]);
+  }, [user, loading, navigate, next]);
 
   if (loading) {
     return (
@@ -77,33 +106,40 @@ export default function Login() {
         )}
 
         {/* Login card */}
-        <Card padding="lg" className="text-center">
-          <Button
-            onClick={login}
-            variant="secondary"
-            size="lg"
-            className="w-full"
-          >
-            <svg className="w-5 h-5" viewBox="0 0 24 24">
-              <path
-                fill="#4285F4"
-                d="M22.56 12.25c0-.78-.07-1.53-.2-2.25H12v4.26h5.92c-.26 1.37-1.04 2.53-2.21 3.31v2.77h3.57c2.08-1.92 3.28-4.74 3.28-8.09z"
-              />
-              <path
-                fill="#34A853"
-                d="M12 23c2.97 0 5.46-.98 7.28-2.66l-3.57-2.77c-.98.66-2.23 1.06-3.71 1.06-2.86 0-5.29-1.93-6.16-4.53H2.18v2.84C3.99 20.53 7.7 23 12 23z"
-              />
-              <path
-                fill="#FBBC05"
-                d="M5.84 14.09c-.22-.66-.35-1.36-.35-2.09s.13-1.43.35-2.09V7.07H2.18C1.43 8.55 1 10.22 1 12s.43 3.45 1.18 4.93l2.85-2.22.81-.62z"
-              />
-              <path
-                fill="#EA4335"
-                d="M12 5.38c1.62 0 3.06.56 4.21 1.64l3.15-3.15C17.45 2.09 14.97 1 12 1 7.7 1 3.99 3.47 2.18 7.07l3.66 2.84c.87-2.6 3.3-4.53 6.16-4.53z"
-              />
-            </svg>
-            Sign in with Google
-          </Button>
+        <Card padding="lg" className="text-center space-y-3">
+          {providers.map((provider) => (
+            <Button
+              key={provider.name}
+              onClick={() =>
+                login(next ? `${provider.login_url}?next=${encodeURIComponent(next)}` : provider.login_url)
+              }
+              variant="secondary"
+              size="lg"
+              className="w-full"
+            >
+              {provider.name === 'google' && (
+                <svg className="w-5 h-5" viewBox="0 0 24 24">
+                  <path
+                    fill="#4285F4"
+                    d="M22.56 12.25c0-.78-.07-1.53-.2-2.25H12v4.26h5.92c-.26 1.37-1.04 2.53-2.21 3.31v2.77h3.57c2.08-1.92 3.28-4.74 3.28-8.09z"
+                  />
+                  <path
+                    fill="#34A853"
+                    d="M12 23c2.97 0 5.46-.98 7.28-2.66l-3.57-2.77c-.98.66-2.23 1.06-3.71 1.06-2.86 0-5.29-1.93-6.16-4.53H2.18v2.84C3.99 20.53 7.7 23 12 23z"
+                  />
+                  <path
+                    fill="#FBBC05"
+                    d="M5.84 14.09c-.22-.66-.35-1.36-.35-2.09s.13-1.43.35-2.09V7.07H2.18C1.43 8.55 1 10.22 1 12s.43 3.45 1.18 4.93l2.85-2.22.81-.62z"
+                  />
+                  <path
+                    fill="#EA4335"
+                    d="M12 5.38c1.62 0 3.06.56 4.21 1.64l3.15-3.15C17.45 2.09 14.97 1 12 1 7.7 1 3.99 3.47 2.18 7.07l3.66 2.84c.87-2.6 3.3-4.53 6.16-4.53z"
+                  />
+                </svg>
+              )}
+              Sign in with {provider.display_name}
+            </Button>
+          ))}
 
           <p className="mt-4 text-xs text-text-muted">
             By signing in, you agree to our terms of service
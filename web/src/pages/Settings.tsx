@@ -16,7 +16,9 @@ export default function Settings() {
   // Create token form
   const [showCreate, setShowCreate] = useState(false);
   const [newTokenName, setNewTokenName] = useState('');
-  const [newTokenExpiry, setNewTokenExpiry] = useState<number | ''>('');
+  const [newTokenExpiry, setNewTokenExpiry] = useState<number | ''>(30);
+  const [newTokenScopes, setNewTokenScopes] = useState('admin:*');
+  const [newTokenIPs, setNewTokenIPs] = useState('');
   const [creating, setCreating] = useState(false);
 
   // Delete confirmation
@@ -45,21 +47,29 @@ export default function Settings() {
 
   const handleCreate = async (e: React.FormEvent) => {
     e.preventDefault();
-    if (!newTokenName.trim()) return;
+    if (!newTokenName.trim() || !newTokenExpiry) return;
+
+    const splitList = (value: string) =>
+      value.split(/[\s,]+/).filter(Boolean);
 
     try {
       setCreating(true);
+      const ips = splitList(newTokenIPs);
       const token = await createToken({
         name: newTokenName.trim(),
-        expires_in: newTokenExpiry ? Number(newTokenExpiry) : undefined,
+        expires_in: Number(newTokenExpiry),
+        scopes: splitList(newTokenScopes),
+        allowed_ips: ips.length > 0 ? ips : undefined,
       });
       setNewToken(token);
       setNewTokenName('');
-      setNewTokenExpiry('');
+      setNewTokenExpiry(30);
+      setNewTokenScopes('admin:*');
+      setNewTokenIPs('');
       setShowCreate(false);
       loadTokens();
-    } catch {
-      setError('Failed to create token');
+    } catch (err) {
+      setError(err instanceof Error ? err.message : 'Failed to create token');
     } finally {
       setCreating(false);
     }
@@ -179,9 +189,23 @@ export default function Settings() {
                 type="number"
                 value={newTokenExpiry}
                 onChange={(e) => setNewTokenExpiry(e.target.value ? Number(e.target.value) : '')}
-                placeholder="Leave empty for no expiration"
                 min={1}
-                helperText="Token will expire after this many days"
+                max={365}
+                helperText="Token will expire after this many days (at most 365)"
+              />
+              <Input
+                label="Scopes"
+                value={newTokenScopes}
+                onChange={(e) => setNewTokenScopes(e.target.value)}
+                placeholder="e.g., deploy:app/<app-id>, read:logs"
+                helperText="Comma-separated <verb>:<target>; verbs are read, deploy, admin. admin:* allows everything your roles allow"
+              />
+              <Input
+                label="Allowed IPs"
+                value={newTokenIPs}
+                onChange={(e) => setNewTokenIPs(e.target.value)}
+                placeholder="Leave empty to allow any address"
+                helperText="Comma-separated addresses or CIDRs, e.g., 203.0.113.0/24"
               />
               <div className="flex justify-end gap-3 pt-4">
                 <Button variant="ghost" type="button" onClick={() => setShowCreate(false)}>
@@ -189,7 +213,7 @@ export default function Settings() {
                 </Button>
                 <Button
                   type="submit"
-                  disabled={creating || !newTokenName.trim()}
+                  disabled={creating || !newTokenName.trim() || !newTokenExpiry || !newTokenScopes.trim()}
                   loading={creating}
                 >
                   Create
@@ -292,7 +316,10 @@ export default function Settings() {
                       {token.last_used_at && (
                         <>
                           <span className="text-border">•</span>
-                          <span>Last used {formatDate(token.last_used_at)}</span>
+                          <span>
+                            Last used {formatDate(token.last_used_at)}
+                            {token.last_used_ip && ` from ${token.last_used_ip}`}
+                          </span>
                         </>
                       )}
                       {token.expires_at && (
@@ -302,6 +329,10 @@ export default function Settings() {
                         </>
                       )}
                     </div>
+                    <p className="text-xs font-mono text-text-muted mt-1">
+                      {token.scopes ? token.scopes.join(', ') : 'unscoped (full access)'}
+                      {token.allowed_ips && ` · from ${token.allowed_ips.join(', ')}`}
+                    </p>
                   </div>
                   <Button
                     variant="ghost"
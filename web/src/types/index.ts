@@ -1,7 +1,33 @@
+export type ProjectRole = 'viewer' | 'deployer' | 'admin';
+
 export interface Project {
   id: string;
   name: string;
+  log_retention_days: number; // days archived logs are kept; 0 doesn't archive them
+  created_at: string;
+  role?: ProjectRole; // the current user's role, set by listProjects
+}
+
+export interface ProjectMember {
+  project_id: string;
+  user_id: string;
+  email: string;
+  name?: string;
+  role: ProjectRole;
+  added_by?: string;
   created_at: string;
+  updated_at: string;
+}
+
+// A role granted to everyone in an SSO group (e.g. an Okta group or a
+// GitHub org/team)
+export interface ProjectGroup {
+  project_id: string;
+  group: string;
+  role: ProjectRole;
+  added_by?: string;
+  created_at: string;
+  updated_at: string;
 }
 
 export interface Cluster {
@@ -50,6 +76,26 @@ export interface App {
   managed_by: 'shipit' | 'porter';
   porter_app_id?: string;
   porter_app_url?: string;
+  // Deploy strategy
+  deploy_strategy: 'rolling' | 'canary' | 'blue_green';
+  canary_step_percent: number;
+  canary_step_interval: number;
+  canary_weight?: number;
+  canary_action?: 'promote' | 'abort';
+  blue_green_retention: number;
+  active_color?: 'blue' | 'green';
+  idle_revision?: number;
+  idle_scale_down_at?: string;
+  // Post-rollout verification
+  verify_successes: number;
+  verify_paths: string[];
+  verify_timeout: number;
+  // Push-to-deploy
+  repo_url?: string;
+  tracked_branch?: string;
+  image_tag_template: string;
+  last_deployed_sha?: string;
+  last_deployed_at?: string;
 }
 
 export interface AppRevision {
@@ -76,20 +122,102 @@ export interface AppRevision {
   deployed_at?: string;
   // Pre-deploy hook
   pre_deploy_command?: string;
+  // Secret key names snapshotted at deploy time (GET revision only)
+  secret_keys?: string[] | null;
+  // Deploy source (CI and push deploys)
+  commit_sha?: string;
+  commit_ref?: string;
+  ci_url?: string;
+  // Digest the image tag resolved to; the revision runs image@digest
+  image_digest?: string;
+}
+
+export interface DeployJob {
+  id: string;
+  app_id: string;
+  kind: 'deploy' | 'rollback';
+  status: 'queued' | 'running' | 'succeeded' | 'failed' | 'rolled_back' | 'superseded';
+  target_revision?: number;
+  revision_number?: number;
+  error?: string;
+  superseded_by?: string;
+  requested_by?: string;
+  attempts: number;
+  created_at: string;
+  started_at?: string;
+  finished_at?: string;
+  image?: string;
+  commit_sha?: string;
+  commit_ref?: string;
+  ci_url?: string;
+  run_after?: string; // debounced redeploys after secret changes
+}
+
+export interface DeployQueuedResponse {
+  status: string;
+  job_id: string;
+  revision?: number; // reserved when deploying an image
+}
+
+export interface CIDeployRequest {
+  image: string;
+  sha?: string;
+  ref?: string;
+  ci_url?: string;
 }
 
 export interface AppSecret {
   key: string;
+  // "value", or the scheme of an external reference ("vault", "awssm")
+  source: string;
+  ref?: string;
   created_at: string;
   updated_at: string;
 }
 
+// One event of the logs stream, from any of the app's pods
+export interface LogLine {
+  pod: string;
+  container: string;
+  revision?: number;
+  timestamp: string;
+  message: string;
+}
+
 export interface AppStatus {
   app_id: string;
   deployment_status: string;
   ready_replicas: number;
   desired_replicas: number;
   pods: PodStatus[];
+  // Why pods aren't ready; only present while some aren't.
+  diagnosis?: Diagnosis;
+}
+
+export interface Diagnosis {
+  summary: string[];
+  containers: ContainerIssue[];
+  events: WarningEvent[];
+}
+
+export interface ContainerIssue {
+  pod: string;
+  container: string;
+  reason: string;
+  message?: string;
+  last_state?: string;
+  exit_code?: number;
+  restarts: number;
+  memory_limit?: string;
+  previous_logs?: string[];
+}
+
+export interface WarningEvent {
+  object: string; // Kind/name
+  reason: string;
+  message: string;
+  count: number;
+  last_seen: string;
 }
 
 export interface PodStatus {
@@ -182,6 +310,129 @@ export interface PreDeployHookConfig {
   command?: string;
 }
 
+// Deploy strategy types
+export interface DeployStrategy {
+  strategy: 'rolling' | 'canary' | 'blue_green';
+  canary_step_percent: number;
+  canary_step_interval: number;
+  canary_weight?: number | null;
+  blue_green_retention: number;
+  active_color?: 'blue' | 'green' | null;
+  idle_revision?: number | null;
+  idle_scale_down_at?: string | null;
+}
+
+export interface DeployStrategyConfig {
+  strategy?: 'rolling' | 'canary' | 'blue_green';
+  canary_step_percent?: number;
+  canary_step_interval?: number;
+  blue_green_retention?: number;
+}
+
+// Post-rollout verification types
+export interface VerificationSettings {
+  verify_successes: number;
+  verify_paths: string[];
+  verify_timeout: number;
+  health_path?: string | null;
+  active: boolean;
+}
+
+export interface VerificationConfig {
+  verify_successes?: number;
+  verify_paths?: string[];
+  verify_timeout?: number;
+}
+
+// Push-to-deploy tracking types
+export interface TrackingSettings {
+  repo_url: string | null;
+  tracked_branch: string | null;
+  image_tag_template: string;
+  last_deployed_sha: string | null;
+  last_deployed_at: string | null;
+}
+
+export interface TrackingConfig {
+  repo_url?: string;
+  tracked_branch?: string;
+  image_tag_template?: string;
+}
+
+// Audit log
+export interface AuditChange {
+  before?: unknown;
+  after?: unknown;
+}
+
+export interface AuditEntry {
+  id: string;
+  created_at: string;
+  actor_type: 'user' | 'api_token' | 'webhook' | 'anonymous';
+  actor_id?: string;
+  actor: string;
+  ip_address?: string;
+  request_id?: string;
+  method: string;
+  path: string;
+  action: string;
+  app_id?: string;
+  status_code: number;
+  changes?: Record<string, AuditChange>;
+  details?: Record<string, unknown>;
+}
+
+export interface AuditPage {
+  entries: AuditEntry[];
+  next_before?: string;
+}
+
+export interface AuditQuery {
+  actor?: string;
+  action?: string;
+  app_id?: string;
+  since?: string;
+  until?: string;
+  before?: string;
+  limit?: number;
+}
+
+// Deploy notifications
+export type NotificationEvent =
+  | 'deploy_started'
+  | 'predeploy_failed'
+  | 'deploy_succeeded'
+  | 'deploy_failed'
+  | 'auto_rolled_back';
+
+// Secrets come back masked. At app scope null means "inherit from the project".
+export interface NotificationSettings {
+  slack_webhook: string | null;
+  webhook_url: string | null;
+  webhook_secret: string | null;
+  events: NotificationEvent[] | null;
+}
+
+export interface AppNotifications {
+  app_id: string;
+  overrides: NotificationSettings;
+  effective: NotificationSettings;
+}
+
+export interface ProjectNotifications {
+  project_id: string;
+  settings: NotificationSettings;
+  effective: NotificationSettings;
+}
+
+// Omitted fields are left unchanged; null resets a field, "" or [] turns it off.
+export interface NotificationConfig {
+  slack_webhook?: string | null;
+  webhook_url?: string | null;
+  webhook_secret?: string | null;
+  events?: NotificationEvent[] | null;
+}
+
 // User types (SSO)
 export interface User {
   id: string;
@@ -190,6 +441,15 @@ export interface User {
   picture_url?: string;
   created_at: string;
   last_login_at?: string;
+  groups?: string[]; // from the last OIDC login, as <provider>:<group>
+  platform_admin?: boolean;
+}
+
+// A sign-in option on the login page, from /auth/providers
+export interface LoginProvider {
+  name: string;
+  display_name: string;
+  login_url: string;
 }
 
 export interface UserToken {
@@ -198,12 +458,18 @@ export interface UserToken {
   name: string;
   created_at: string;
   last_used_at?: string;
+  last_used_ip?: string;
   expires_at?: string;
+  scopes?: string[]; // e.g. "deploy:app/<id>", "read:logs"; absent on tokens created before scoping
+  allowed_ips?: string[];
 }
 
 export interface CreateTokenRequest {
   name: string;
-  expires_in?: number; // days
+  expires_in?: number; // days; this or expires_at is required
+  expires_at?: string;
+  scopes: string[];
+  allowed_ips?: string[];
 }
 
 export interface CreateTokenResponse {
@@ -211,7 +477,9 @@ export interface CreateTokenResponse {
   name: string;
   token: string; // Only shown once
   created_at: string;
-  expires_at?: string;
+  expires_at: string;
+  scopes: string[];
+  allowed_ips?: string[];
 }
 
 // Cluster ingress controller info